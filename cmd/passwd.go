@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/spf13/cobra"
+)
+
+var passwdPlugin string
+
+// passwdCmd 生成前端用户的密码 hash
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "生成前端用户的密码 hash",
+	Long: `从标准输入读取密码, 输出密码 hash, 用于密码 hash 文件或者 MySQL 用户表, 配置中不需要保存明文密码.
+mysql_native_password 的 hash 和 mysql.user 中的 authentication_string 相同.
+Example:
+echo -n '123456' | ./dal passwd --plugin=caching_sha2_password
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(password) == 0 {
+			fmt.Printf("读取密码出错. %s\n", err.Error())
+			os.Exit(1)
+		}
+		password = strings.TrimRight(password, "\r\n")
+
+		switch passwdPlugin {
+		case mysql.AUTH_NATIVE_PASSWORD:
+			fmt.Println(server.NativePasswordHash(password))
+		case mysql.AUTH_CACHING_SHA2_PASSWORD:
+			fmt.Println(server.CachingSha2PasswordHash(password))
+		default:
+			fmt.Printf("不支持的认证方式 %s, 只支持 %s, %s\n", passwdPlugin,
+				mysql.AUTH_NATIVE_PASSWORD, mysql.AUTH_CACHING_SHA2_PASSWORD)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(passwdCmd)
+
+	passwdCmd.Flags().StringVar(&passwdPlugin, "plugin", mysql.AUTH_CACHING_SHA2_PASSWORD, "认证方式: mysql_native_password, caching_sha2_password")
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/server/capture"
+	"github.com/spf13/cobra"
+)
+
+var replayCfg = new(capture.ReplayConfig)
+var replayFile string
+
+// replayCmd 回放抓包文件
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "回放抓包文件",
+	Long: `回放通过抓包模式记录的前端链接命令, 按照原始的并发和时间间隔发送到目标MySQL(或dal), 并输出耗时和错误的对比.
+Example:
+./dal replay --file=./dal.cap --addr=127.0.0.1:3306 --user=root --password=123 --speed=1
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer seelog.Flush()
+
+		f, err := os.Open(replayFile)
+		if err != nil {
+			fmt.Printf("打开抓包文件出错. %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		r, err := capture.NewReader(f)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		report, err := capture.Replay(r, *replayCfg)
+		if err != nil {
+			fmt.Printf("回放出错. %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(report.String())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().StringVar(&replayFile, "file", "./dal.cap", "抓包文件")
+	replayCmd.Flags().StringVar(&replayCfg.Addr, "addr", "127.0.0.1:3306", "回放的目标地址")
+	replayCmd.Flags().StringVar(&replayCfg.User, "user", "root", "回放使用的用户")
+	replayCmd.Flags().StringVar(&replayCfg.Password, "password", "", "回放使用的密码")
+	replayCmd.Flags().StringVar(&replayCfg.DB, "db", "", "链接时使用的数据库")
+	replayCmd.Flags().Float64Var(&replayCfg.Speed, "speed", 1, "回放速度, 2代表两倍速, 0代表不等待尽快回放")
+}
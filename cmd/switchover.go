@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/daiguadaidai/dal/web"
+	"github.com/spf13/cobra"
+)
+
+var switchoverReq = new(web.SwitchoverRequest)
+var switchoverAdminAddr string
+var switchoverPlanned bool
+
+// switchoverCmd 集群蓝绿切换
+var switchoverCmd = &cobra.Command{
+	Use:   "switchover",
+	Short: "集群蓝绿切换",
+	Long: `通过 dal 的管理接口把逻辑集群的流量切换到新的后端, 切换期间暂停新的事务, 等待执行中的事务结束(以及新 master 追上旧 master 的 GTID)后切换, 应用不需要重启.
+Example:
+./dal switchover --admin=127.0.0.1:8080 --cluster=order --master=10.0.1.1:3306 --slaves=10.0.1.2:3306 --wait-gtid
+
+使用 --planned 进行集群内的计划内主从切换: 旧 master 设置只读, 提升 slave(--master 为空时选择数据最新的 slave), 其他 slave 和旧 master 指向新 master.
+Example:
+./dal switchover --admin=127.0.0.1:8080 --cluster=order --planned --master=10.0.0.2:3306
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var body []byte
+		var err error
+		path := "/cluster/switchover"
+		if switchoverPlanned {
+			path = "/cluster/planned_switchover"
+			body, err = json.Marshal(&web.PlannedSwitchoverRequest{Cluster: switchoverReq.Cluster, Master: switchoverReq.Master})
+		} else {
+			body, err = json.Marshal(switchoverReq)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		// 切换最多需要等待事务结束和 GTID 的时间
+		timeout := time.Duration(switchoverReq.DrainTimeoutMS+switchoverReq.GTIDTimeoutMS)*time.Millisecond + time.Minute
+		httpClient := &http.Client{Timeout: timeout}
+		resp, err := httpClient.Post(fmt.Sprintf("http://%s%s", switchoverAdminAddr, path),
+			"application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Printf("请求管理接口出错. %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		fmt.Println(string(data))
+		if resp.StatusCode != http.StatusOK {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(switchoverCmd)
+
+	switchoverCmd.Flags().StringVar(&switchoverAdminAddr, "admin", "127.0.0.1:8080", "dal 管理接口地址")
+	switchoverCmd.Flags().BoolVar(&switchoverPlanned, "planned", false, "集群内的计划内主从切换")
+	switchoverCmd.Flags().StringVar(&switchoverReq.Cluster, "cluster", "", "逻辑集群名")
+	switchoverCmd.Flags().StringVar(&switchoverReq.Master, "master", "", "新的 master 地址")
+	switchoverCmd.Flags().StringSliceVar(&switchoverReq.Slaves, "slaves", nil, "新的 slave 地址, 多个使用逗号分隔")
+	switchoverCmd.Flags().BoolVar(&switchoverReq.WaitGTID, "wait-gtid", false, "是否等待新 master 的 GTID 包含旧 master 的")
+	switchoverCmd.Flags().Int64Var(&switchoverReq.DrainTimeoutMS, "drain-timeout-ms", 0, "等待执行中的事务结束的时间, 默认 3000")
+	switchoverCmd.Flags().Int64Var(&switchoverReq.GTIDTimeoutMS, "gtid-timeout-ms", 0, "等待 GTID 的时间, 默认 10000")
+}
@@ -5,6 +5,10 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 
 	. "github.com/daiguadaidai/dal/go-mysql/mysql"
 	"github.com/daiguadaidai/dal/go-mysql/packet"
@@ -16,6 +20,15 @@ const defaultAuthPluginName = AUTH_NATIVE_PASSWORD
 // defines the supported auth plugins
 var supportedAuthPlugins = []string{AUTH_NATIVE_PASSWORD, AUTH_SHA256_PASSWORD, AUTH_CACHING_SHA2_PASSWORD}
 
+// the connection attributes sent by default, see SetAttributes
+var defaultAttributes = map[string]string{
+	"_client_name": "go-mysql",
+	"_pid":         strconv.Itoa(os.Getpid()),
+	"_os":          runtime.GOOS,
+	"_platform":    runtime.GOARCH,
+	"program_name": filepath.Base(os.Args[0]),
+}
+
 // helper function to determine what auth methods are allowed by this client
 func authPluginAllowed(pluginName string) bool {
 	for _, p := range supportedAuthPlugins {
@@ -149,10 +162,49 @@ func (c *Conn) writeAuthHandshake() error {
 		capability |= CLIENT_SSL
 	}
 
+	// Multiple results are needed by the stored procedures, multiple statements only when requested
+	capability |= c.capability & (CLIENT_MULTI_RESULTS | CLIENT_PS_MULTI_RESULTS)
+	if c.multiStatements {
+		capability |= c.capability & CLIENT_MULTI_STATEMENTS
+	}
+
+	// Session state changes in the OK packets, see Result.SessionState
+	capability |= c.capability & CLIENT_SESSION_TRACK
+
+	// The result sets end with an OK packet instead of the EOF packet like MySQL 8
+	capability |= c.capability & CLIENT_DEPRECATE_EOF
+
+	// Connection attributes following the auth plugin name
+	var attrs []byte
+	if c.capability&CLIENT_CONNECT_ATTRS != 0 {
+		capability |= CLIENT_CONNECT_ATTRS
+		attrs = c.dumpAttributes()
+	}
+
+	// LOAD DATA LOCAL INFILE, the files are sent only when allowed, see AllowLocalFiles
+	capability |= c.capability & CLIENT_LOCAL_FILES
+
+	// Compressed protocol, only when the server supports the requested algorithm
+	c.compression = ""
+	switch {
+	case c.requestedCompression == COMPRESSION_ZLIB && c.capability&CLIENT_COMPRESS != 0:
+		capability |= CLIENT_COMPRESS
+		c.compression = COMPRESSION_ZLIB
+	case c.requestedCompression == COMPRESSION_ZSTD && c.capability&CLIENT_ZSTD_COMPRESSION_ALGORITHM != 0:
+		capability |= CLIENT_ZSTD_COMPRESSION_ALGORITHM
+		c.compression = COMPRESSION_ZSTD
+	}
+
 	auth, addNull, err := c.genAuthResponse(c.salt)
 	if err != nil {
 		return err
 	}
+	// the trailing \NUL of the cleartext password is a part of the auth data, so the server finds
+	// the fields following it, the \NUL of an empty password is skipped by the server
+	if addNull && len(auth) > 0 {
+		auth = append(auth, 0x00)
+		addNull = false
+	}
 
 	// encode length of the auth plugin data
 	// here we use the Length-Encoded-Integer(LEI) as the data length may not fit into one byte
@@ -182,6 +234,11 @@ func (c *Conn) writeAuthHandshake() error {
 		capability |= CLIENT_CONNECT_WITH_DB
 		length += len(c.db) + 1
 	}
+	length += len(attrs)
+	// zstd compression level
+	if c.compression == COMPRESSION_ZSTD {
+		length++
+	}
 
 	data := make([]byte, length+4)
 
@@ -251,6 +308,71 @@ func (c *Conn) writeAuthHandshake() error {
 	// Assume native client during response
 	pos += copy(data[pos:], c.authPluginName)
 	data[pos] = 0x00
+	pos++
+
+	// connection attributes [length encoded key-value pairs]
+	pos += copy(data[pos:], attrs)
+
+	// zstd compression level [1 byte]
+	if c.compression == COMPRESSION_ZSTD {
+		data[pos] = byte(c.compressionLevel)
+		pos++
+	}
+
+	return c.WritePacket(data[:pos])
+}
 
+// See: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_change_user.html
+func (c *Conn) writeChangeUser() error {
+	if !authPluginAllowed(c.authPluginName) {
+		return fmt.Errorf("unknow auth plugin name '%s'", c.authPluginName)
+	}
+
+	auth, addNull, err := c.genAuthResponse(c.salt)
+	if err != nil {
+		return err
+	}
+	if addNull {
+		auth = append(auth, 0x00)
+	}
+	if len(auth) > 255 {
+		return errors.Errorf("auth data of COM_CHANGE_USER is too long: %d", len(auth))
+	}
+
+	data := make([]byte, 4, 4+1+len(c.user)+1+1+len(auth)+len(c.db)+1+2+len(c.authPluginName)+1)
+	data = append(data, COM_CHANGE_USER)
+	data = append(data, c.user...)
+	data = append(data, 0x00)
+	data = append(data, byte(len(auth)))
+	data = append(data, auth...)
+	data = append(data, c.db...)
+	data = append(data, 0x00)
+	data = append(data, DEFAULT_COLLATION_ID, 0x00)
+	data = append(data, c.authPluginName...)
+	data = append(data, 0x00)
+	if c.capability&CLIENT_CONNECT_ATTRS != 0 {
+		data = append(data, c.dumpAttributes()...)
+	}
+
+	c.ResetSequence()
 	return c.WritePacket(data)
 }
+
+// dumpAttributes returns the length of the connection attributes followed by the key-value pairs,
+// the user-defined attributes override the default ones
+func (c *Conn) dumpAttributes() []byte {
+	attrs := make(map[string]string, len(defaultAttributes)+len(c.attributes))
+	for k, v := range defaultAttributes {
+		attrs[k] = v
+	}
+	for k, v := range c.attributes {
+		attrs[k] = v
+	}
+
+	var data []byte
+	for k, v := range attrs {
+		data = append(data, PutLengthEncodedString([]byte(k))...)
+		data = append(data, PutLengthEncodedString([]byte(v))...)
+	}
+	return append(PutLengthEncodedInt(uint64(len(data))), data...)
+}
@@ -1,16 +1,21 @@
 package client
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/daiguadaidai/dal/go-mysql/test_util/test_keys"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
 
 	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
 )
 
 var testHost = flag.String("host", "127.0.0.1", "MySQL server host")
@@ -396,3 +401,165 @@ func (s *clientTestSuite) TestStmt_Trans(c *C) {
 	str, _ = r.GetString(0, 0)
 	c.Assert(str, Equals, `abc`)
 }
+
+// startTestServer starts a fake server with the user root/123, the address is returned
+func startTestServer(t *testing.T, h server.Handler) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	p := server.NewInMemoryProvider()
+	p.AddUser("root", "123")
+	serverConf := server.NewDefaultServer()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewCustomizedConn(conn, serverConf, p, h)
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// sleepHandler blocks "sleep" until the query is killed or a second passes
+type sleepHandler struct {
+	server.EmptyHandler
+	kills  chan string
+	killed chan struct{}
+}
+
+func (h sleepHandler) HandleQuery(query string) (*mysql.Result, error) {
+	switch query {
+	case "sleep":
+		select {
+		case <-h.killed:
+			return nil, mysql.NewDefaultError(mysql.ER_QUERY_INTERRUPTED)
+		case <-time.After(time.Second):
+		}
+	case "select 1":
+	default:
+		h.kills <- query
+		h.killed <- struct{}{}
+	}
+	return nil, nil
+}
+
+func TestExecuteContext(t *testing.T) {
+	h := sleepHandler{kills: make(chan string, 10), killed: make(chan struct{}, 10)}
+	addr := startTestServer(t, h)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ConnectContext(canceled, addr, "root", "123", ""); err != context.Canceled {
+		t.Fatalf("expect context canceled, got %v", err)
+	}
+
+	conn, err := ConnectContext(context.Background(), addr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err = conn.ExecuteContext(ctx, "select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the query is killed when the context is done, the connection can not be used then
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = conn.ExecuteContext(ctx, "sleep"); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 900*time.Millisecond {
+		t.Fatal("the query should return when the context is done")
+	}
+	if kill := <-h.kills; kill != fmt.Sprintf("KILL QUERY %d", conn.GetConnectionID()) {
+		t.Fatalf("unexpected kill %s", kill)
+	}
+	if err = conn.Ping(); err == nil {
+		t.Fatal("the connection should not be usable")
+	}
+}
+
+func TestConnectContextClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server never sends the handshake, or sends a broken one
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	// the dialed connection is closed when the handshake fails or ctx is done
+	waitClosed := func(conn net.Conn) {
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.Copy(io.Discard, conn); err != nil {
+			t.Errorf("the client should close the connection, got %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = ConnectContext(ctx, l.Addr().String(), "root", "123", ""); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	waitClosed(<-accepted)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn := <-accepted
+		conn.Write([]byte{1, 0, 0, 0, 0xff})
+		waitClosed(conn)
+	}()
+	if _, err = ConnectContext(context.Background(), l.Addr().String(), "root", "123", ""); err == nil {
+		t.Fatal("the broken handshake should fail")
+	}
+	<-done
+}
+
+func TestReadTimeout(t *testing.T) {
+	h := sleepHandler{kills: make(chan string, 10), killed: make(chan struct{}, 10)}
+	addr := startTestServer(t, h)
+
+	conn, err := Connect(addr, "root", "123", "", func(c *Conn) {
+		c.SetReadTimeout(200 * time.Millisecond)
+		c.SetWriteTimeout(200 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Execute("sleep"); err == nil {
+		t.Fatal("the read should time out")
+	}
+}
@@ -1,8 +1,10 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"time"
@@ -15,6 +17,10 @@ import (
 type Conn struct {
 	*packet.Conn
 
+	// the network connection with the read and write timeouts, interrupted when the context is done
+	deadlineConn *deadlineConn
+
+	addr      string
 	user      string
 	password  string
 	db        string
@@ -31,6 +37,20 @@ type Conn struct {
 	authPluginName string
 
 	connectionID uint32
+
+	// compressed protocol requested by UseCompression, and the one negotiated with the server
+	requestedCompression string
+	compressionLevel     int
+	compression          string
+
+	multiStatements bool
+
+	// the user-defined connection attributes, see SetAttributes
+	attributes map[string]string
+
+	// files and readers allowed to be sent by LOAD DATA LOCAL INFILE
+	localFiles         []string
+	localInfileReaders map[string]func() (io.Reader, error)
 }
 
 func getNetProto(addr string) string {
@@ -44,17 +64,32 @@ func getNetProto(addr string) string {
 // Connect to a MySQL server, addr can be ip:port, or a unix socket domain like /var/sock.
 // Accepts a series of configuration functions as a variadic argument.
 func Connect(addr string, user string, password string, dbName string, options ...func(*Conn)) (*Conn, error) {
+	return ConnectContext(context.Background(), addr, user, password, dbName, options...)
+}
+
+// ConnectContext is like Connect, the dialing and the handshake are canceled when ctx is done.
+// The dialing is limited to 10 seconds when ctx has no deadline.
+func ConnectContext(ctx context.Context, addr string, user string, password string, dbName string, options ...func(*Conn)) (*Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	proto := getNetProto(addr)
 
 	c := new(Conn)
 
-	var err error
-	conn, err := net.DialTimeout(proto, addr, 10*time.Second)
+	dialer := net.Dialer{}
+	if _, ok := ctx.Deadline(); !ok {
+		dialer.Timeout = 10 * time.Second
+	}
+	conn, err := dialer.DialContext(ctx, proto, addr)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	c.Conn = packet.NewConn(conn)
+	c.deadlineConn = &deadlineConn{Conn: conn}
+	c.Conn = packet.NewConn(c.deadlineConn)
+	c.addr = addr
 	c.user = user
 	c.password = password
 	c.db = dbName
@@ -68,31 +103,41 @@ func Connect(addr string, user string, password string, dbName string, options .
 		options[i](c)
 	}
 
-	if err = c.handshake(); err != nil {
+	stop := c.watchContext(ctx)
+	err = c.handshake()
+	if stop() && err != nil {
+		c.Conn.Close()
+		return nil, ctx.Err()
+	} else if err != nil {
+		c.Conn.Close()
 		return nil, errors.Trace(err)
 	}
 
 	return c, nil
 }
 
+// handshake does not close the connection on error, ConnectContext closes it
 func (c *Conn) handshake() error {
 	var err error
 	if err = c.readInitialHandshake(); err != nil {
-		c.Close()
 		return errors.Trace(err)
 	}
 
 	if err := c.writeAuthHandshake(); err != nil {
-		c.Close()
-
 		return errors.Trace(err)
 	}
 
 	if err := c.handleAuthResult(); err != nil {
-		c.Close()
 		return errors.Trace(err)
 	}
 
+	// both sides switch to the compressed protocol after the OK packet
+	if c.compression != "" {
+		if err := c.SetCompression(c.compression, c.compressionLevel); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	return nil
 }
 
@@ -106,6 +151,40 @@ func (c *Conn) Close() error {
 	return nil
 }
 
+// ChangeUser re-authenticates the connection as another user with COM_CHANGE_USER and uses dbName.
+// The server resets the session like ResetConnection, the prepared statements are closed.
+// The server closes the connection when the authentication fails.
+func (c *Conn) ChangeUser(user string, password string, dbName string) error {
+	c.user = user
+	c.password = password
+	c.db = dbName
+
+	if err := c.writeChangeUser(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.handleAuthResult(); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.charset = DEFAULT_CHARSET
+	return nil
+}
+
+// ResetConnection resets the session with COM_RESET_CONNECTION without re-authentication:
+// the transaction is rolled back, the prepared statements are closed and the session variables are reset.
+func (c *Conn) ResetConnection() error {
+	if err := c.writeCommand(COM_RESET_CONNECTION); err != nil {
+		return errors.Trace(err)
+	}
+
+	if _, err := c.readOK(); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.charset = DEFAULT_CHARSET
+	return nil
+}
+
 func (c *Conn) Ping() error {
 	if err := c.writeCommand(COM_PING); err != nil {
 		return errors.Trace(err)
@@ -130,6 +209,30 @@ func (c *Conn) SetTLSConfig(config *tls.Config) {
 	c.tlsConfig = config
 }
 
+// UseCompression: use the compressed protocol if the server supports it, algorithm is "zlib" or "zstd",
+// level is the zstd compression level (1-22), 0 to use the default.
+// pass to options when connect
+func (c *Conn) UseCompression(algorithm string, level int) {
+	c.requestedCompression = algorithm
+	c.compressionLevel = level
+	if algorithm == COMPRESSION_ZSTD && level <= 0 {
+		c.compressionLevel = DEFAULT_ZSTD_COMPRESSION_LEVEL
+	}
+}
+
+// SetAttributes: the connection attributes sent to the server with _client_name, _pid and program_name,
+// they are shown by performance_schema.session_connect_attrs, a proxy can pass the attributes of its clients.
+// pass to options when connect
+func (c *Conn) SetAttributes(attributes map[string]string) {
+	c.attributes = attributes
+}
+
+// UseMultiStatements: allow several statements separated by ';' in one query, see ExecuteMulti.
+// pass to options when connect
+func (c *Conn) UseMultiStatements() {
+	c.multiStatements = true
+}
+
 func (c *Conn) UseDB(dbName string) error {
 	if c.db == dbName {
 		return nil
@@ -166,6 +269,17 @@ func (c *Conn) Execute(command string, args ...interface{}) (*Result, error) {
 	}
 }
 
+// ExecuteMulti returns all the results of the query, like the statements of a multiple statements query
+// (see UseMultiStatements) or the result sets of a CALL followed by the status of the procedure.
+// When a statement fails, the results before it are returned with the error.
+func (c *Conn) ExecuteMulti(command string) ([]*Result, error) {
+	if err := c.writeCommandStr(COM_QUERY, command); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return c.readResults(false)
+}
+
 func (c *Conn) Begin() error {
 	_, err := c.exec("BEGIN")
 	return errors.Trace(err)
@@ -215,7 +329,7 @@ func (c *Conn) FieldList(table string, wildcard string) ([]*Field, error) {
 			}
 
 			// EOF Packet
-			if c.isEOFPacket(data) {
+			if c.isResultsetEnd(data) {
 				return fs, nil
 			}
 
@@ -275,5 +389,5 @@ func (c *Conn) exec(query string) (*Result, error) {
 		return nil, errors.Trace(err)
 	}
 
-	return c.readResult(false)
+	return c.readFirstResult(false)
 }
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// the timeout of the connection killing the query of a canceled context
+const killQueryTimeout = 10 * time.Second
+
+var errInterrupted = errors.New("connection interrupted by the context")
+
+// deadlineConn sets the deadline of every read and write by the timeouts, the reads and the writes
+// are interrupted when the context of the command is done.
+type deadlineConn struct {
+	net.Conn
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	mu          sync.Mutex
+	interrupted bool
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.setDeadline(c.readTimeout, c.Conn.SetReadDeadline); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.setDeadline(c.writeTimeout, c.Conn.SetWriteDeadline); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *deadlineConn) setDeadline(timeout time.Duration, set func(time.Time) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.interrupted {
+		return errInterrupted
+	}
+	if timeout > 0 {
+		return set(time.Now().Add(timeout))
+	}
+	return nil
+}
+
+// interrupt makes the blocked and the following reads and writes return at once
+func (c *deadlineConn) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interrupted = true
+	c.Conn.SetDeadline(time.Unix(1, 0))
+}
+
+func (c *deadlineConn) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interrupted = false
+	c.Conn.SetDeadline(time.Time{})
+}
+
+// SetReadTimeout: the reads fail when no data is received in d, 0 for no timeout
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	c.deadlineConn.readTimeout = d
+}
+
+// SetWriteTimeout: the writes fail when not done in d, 0 for no timeout
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.deadlineConn.writeTimeout = d
+}
+
+// ExecuteContext is like Execute, the query is killed by KILL QUERY through another connection when ctx is done
+// before the result is read, and ctx.Err() is returned. The connection is closed then and can not be used again.
+func (c *Conn) ExecuteContext(ctx context.Context, command string, args ...interface{}) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stop := c.watchContext(ctx)
+	r, err := c.Execute(command, args...)
+	if stop() && err != nil {
+		// the connection is in an unknown state, the result may be partially read
+		c.killQuery()
+		c.Conn.Close()
+		return nil, ctx.Err()
+	}
+	return r, err
+}
+
+// watchContext interrupts the connection when ctx is done, the returned function stops watching
+// and returns whether the connection is interrupted
+func (c *Conn) watchContext(ctx context.Context) func() bool {
+	done := ctx.Done()
+	if done == nil {
+		return func() bool { return false }
+	}
+
+	finished := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-done:
+			c.deadlineConn.interrupt()
+			interrupted <- true
+		case <-finished:
+			interrupted <- false
+		}
+	}()
+
+	return func() bool {
+		close(finished)
+		if <-interrupted {
+			c.deadlineConn.resume()
+			return true
+		}
+		return false
+	}
+}
+
+// killQuery kills the running query of the connection with KILL QUERY through another connection
+func (c *Conn) killQuery() error {
+	ctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+
+	conn, err := ConnectContext(ctx, c.addr, c.user, c.password, "", func(conn *Conn) {
+		conn.SetTLSConfig(c.tlsConfig)
+		conn.SetReadTimeout(killQueryTimeout)
+		conn.SetWriteTimeout(killQueryTimeout)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Execute(fmt.Sprintf("KILL QUERY %d", c.connectionID))
+	return errors.Trace(err)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// the max size of the packets sending the file of LOAD DATA LOCAL INFILE
+const localInfilePacketSize = 16 * 1024
+
+// AllowLocalFiles: allow LOAD DATA LOCAL INFILE to send the files, a path is a file or a directory
+// containing the files. The files are not sent unless they are allowed or registered by RegisterLocalInfileReader.
+// pass to options when connect
+func (c *Conn) AllowLocalFiles(paths ...string) {
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		c.localFiles = append(c.localFiles, filepath.Clean(path))
+	}
+}
+
+// RegisterLocalInfileReader: send the content returned by f when the server requests the file name,
+// like "LOAD DATA LOCAL INFILE 'Reader::data'". The reader is closed after sent if it is an io.Closer.
+func (c *Conn) RegisterLocalInfileReader(name string, f func() (io.Reader, error)) {
+	if c.localInfileReaders == nil {
+		c.localInfileReaders = make(map[string]func() (io.Reader, error))
+	}
+	c.localInfileReaders[name] = f
+}
+
+func (c *Conn) UnregisterLocalInfileReader(name string) {
+	delete(c.localInfileReaders, name)
+}
+
+func (c *Conn) openLocalInfile(name string) (io.Reader, error) {
+	if f, ok := c.localInfileReaders[name]; ok {
+		return f()
+	}
+
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, allowed := range c.localFiles {
+		if path == allowed || strings.HasPrefix(path, allowed+string(filepath.Separator)) {
+			return os.Open(path)
+		}
+	}
+	return nil, errors.Errorf("local file '%s' is not allowed, see AllowLocalFiles", name)
+}
+
+// handleLocalInfile sends the file requested by the server and reads the result of the query.
+// The file is ended by an empty packet even if it can not be read, so the connection is still usable.
+func (c *Conn) handleLocalInfile(name string) (*Result, error) {
+	r, err := c.openLocalInfile(name)
+	if err == nil {
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
+		err = c.writeLocalInfile(r)
+	}
+
+	if werr := c.WritePacket(make([]byte, 4)); werr != nil {
+		return nil, errors.Trace(werr)
+	}
+
+	result, rerr := c.readOK()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return result, rerr
+}
+
+func (c *Conn) writeLocalInfile(r io.Reader) error {
+	data := make([]byte, 4+localInfilePacketSize)
+	for {
+		n, err := r.Read(data[4:])
+		if n > 0 {
+			if werr := c.WritePacket(data[:4+n]); werr != nil {
+				return errors.Trace(werr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Annotate(err, "read local infile")
+		}
+	}
+}
@@ -35,6 +35,52 @@ func (c *Conn) isEOFPacket(data []byte) bool {
 	return data[0] == EOF_HEADER && len(data) <= 5
 }
 
+// isResultsetEnd returns whether the packet ends the rows, it is an OK packet with the EOF header
+// with CLIENT_DEPRECATE_EOF, a row beginning with 0xfe is not shorter than a full packet
+func (c *Conn) isResultsetEnd(data []byte) bool {
+	if c.capability&CLIENT_DEPRECATE_EOF > 0 {
+		return data[0] == EOF_HEADER && len(data) < MaxPayloadLen
+	}
+	return c.isEOFPacket(data)
+}
+
+// handleResultsetEnd reads the status and the warnings of the packet ending the rows into the result
+func (c *Conn) handleResultsetEnd(data []byte, result *Result) error {
+	if c.capability&CLIENT_DEPRECATE_EOF > 0 {
+		r, err := c.handleOKPacket(data)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.Status = r.Status
+		result.Warnings = r.Warnings
+		result.SessionState = r.SessionState
+		return nil
+	}
+
+	if c.capability&CLIENT_PROTOCOL_41 > 0 {
+		//todo add strict_mode, warning will be treat as error
+		result.Warnings = binary.LittleEndian.Uint16(data[1:])
+		result.Status = binary.LittleEndian.Uint16(data[3:])
+		c.status = result.Status
+	}
+	return nil
+}
+
+// skipColumns reads the column definitions of a prepared statement, they are followed by
+// an EOF packet unless CLIENT_DEPRECATE_EOF
+func (c *Conn) skipColumns(count int) error {
+	if c.capability&CLIENT_DEPRECATE_EOF == 0 {
+		return c.readUntilEOF()
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err := c.ReadPacket(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Conn) handleOKPacket(data []byte) (*Result, error) {
 	var n int
 	var pos = 1
@@ -52,17 +98,37 @@ func (c *Conn) handleOKPacket(data []byte) (*Result, error) {
 		pos += 2
 
 		//todo:strict_mode, check warnings as error
-		//Warnings := binary.LittleEndian.Uint16(data[pos:])
-		//pos += 2
+		r.Warnings = binary.LittleEndian.Uint16(data[pos:])
+		pos += 2
 	} else if c.capability&CLIENT_TRANSACTIONS > 0 {
 		r.Status = binary.LittleEndian.Uint16(data[pos:])
 		c.status = r.Status
 		pos += 2
 	}
 
-	//new ok package will check CLIENT_SESSION_TRACK too, but I don't support it now.
+	// the info is followed by the session state changes with CLIENT_SESSION_TRACK
+	if c.capability&CLIENT_SESSION_TRACK > 0 && pos < len(data) {
+		//skip info
+		n, err := SkipLengthEncodedString(data[pos:])
+		if err != nil {
+			return nil, errors.Trace(ErrMalformPacket)
+		}
+		pos += n
+
+		if r.Status&SERVER_SESSION_STATE_CHANGED > 0 && pos < len(data) {
+			state, _, _, err := LengthEncodedString(data[pos:])
+			if err != nil {
+				return nil, errors.Trace(ErrMalformPacket)
+			}
+			if r.SessionState, err = ParseSessionState(state); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if r.SessionState.Schema != "" {
+				c.db = r.SessionState.Schema
+			}
+		}
+	}
 
-	//skip info
 	return r, nil
 }
 
@@ -137,6 +203,9 @@ func (c *Conn) handleAuthResult() error {
 					return err
 				}
 			}
+			// the server replies OK or ERR after the full authentication
+			_, err = c.readOK()
+			return err
 		} else {
 			errors.Errorf("invalid packet")
 		}
@@ -222,13 +291,52 @@ func (c *Conn) readResult(binary bool) (*Result, error) {
 	} else if data[0] == ERR_HEADER {
 		return nil, c.handleErrorPacket(data)
 	} else if data[0] == LocalInFile_HEADER {
-		return nil, ErrMalformPacket
+		return c.handleLocalInfile(string(data[1:]))
 	}
 
 	return c.readResultset(data, binary)
 }
 
+// readResults reads the result and the following ones while SERVER_MORE_RESULTS_EXISTS is set
+func (c *Conn) readResults(binary bool) ([]*Result, error) {
+	var results []*Result
+	for {
+		r, err := c.readResult(binary)
+		if err != nil {
+			return results, errors.Trace(err)
+		}
+		results = append(results, r)
+		if r.Status&SERVER_MORE_RESULTS_EXISTS == 0 {
+			return results, nil
+		}
+	}
+}
+
+// readFirstResult returns the first result, the following ones are read and discarded
+// so the connection can be used for the next command
+func (c *Conn) readFirstResult(binary bool) (*Result, error) {
+	results, err := c.readResults(binary)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
 func (c *Conn) readResultset(data []byte, binary bool) (*Result, error) {
+	result, err := c.readResultsetHeader(data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := c.readResultRows(result, binary); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return result, nil
+}
+
+// readResultsetHeader reads the column count and the columns of the result set
+func (c *Conn) readResultsetHeader(data []byte) (*Result, error) {
 	result := &Result{
 		Status:       0,
 		InsertId:     0,
@@ -251,10 +359,6 @@ func (c *Conn) readResultset(data []byte, binary bool) (*Result, error) {
 		return nil, errors.Trace(err)
 	}
 
-	if err := c.readResultRows(result, binary); err != nil {
-		return nil, errors.Trace(err)
-	}
-
 	return result, nil
 }
 
@@ -263,6 +367,11 @@ func (c *Conn) readResultColumns(result *Result) (err error) {
 	var data []byte
 
 	for {
+		// no EOF packet follows the columns with CLIENT_DEPRECATE_EOF
+		if c.capability&CLIENT_DEPRECATE_EOF > 0 && i == len(result.Fields) {
+			return
+		}
+
 		data, err = c.ReadPacket()
 		if err != nil {
 			return
@@ -284,6 +393,10 @@ func (c *Conn) readResultColumns(result *Result) (err error) {
 			return
 		}
 
+		if i == len(result.Fields) {
+			return ErrMalformPacket
+		}
+
 		result.Fields[i], err = FieldData(data).Parse()
 		if err != nil {
 			return
@@ -306,12 +419,9 @@ func (c *Conn) readResultRows(result *Result, isBinary bool) (err error) {
 		}
 
 		// EOF Packet
-		if c.isEOFPacket(data) {
-			if c.capability&CLIENT_PROTOCOL_41 > 0 {
-				//result.Warnings = binary.LittleEndian.Uint16(data[1:])
-				//todo add strict_mode, warning will be treat as error
-				result.Status = binary.LittleEndian.Uint16(data[3:])
-				c.status = result.Status
+		if c.isResultsetEnd(data) {
+			if err = c.handleResultsetEnd(data, result); err != nil {
+				return
 			}
 
 			break
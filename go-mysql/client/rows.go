@@ -0,0 +1,271 @@
+package client
+
+import (
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// Rows reads the rows of a result set on demand instead of holding all of them in memory.
+// The connection can not be used for other commands until all the rows are read or Rows is closed.
+//
+//	rows, err := conn.ExecuteRows("SELECT id, name FROM t")
+//	...
+//	defer rows.Close()
+//	for rows.Next() {
+//		rows.Scan(&id, &name)
+//	}
+//	err = rows.Err()
+//
+// The embedded Result has the columns of the result set, or the OK result of a statement without
+// result set, its Status is updated after the last row is read.
+type Rows struct {
+	*Result
+
+	c      *Conn
+	stmt   *Stmt // closed with the rows when the statement is prepared by ExecuteRows
+	binary bool
+
+	// the statement with an open cursor, the rows are fetched page by page
+	cursor    *Stmt
+	fetchSize uint32
+	fetching  bool
+	closing   bool
+
+	row     RowData
+	pending []byte // the packet read ahead, handled first by Next
+	err     error
+	done    bool
+}
+
+// ExecuteRows executes the command like Execute and returns the rows of the result set to be read on demand.
+// The results following the first one are read and discarded when the rows are done.
+func (c *Conn) ExecuteRows(command string, args ...interface{}) (*Rows, error) {
+	if len(args) == 0 {
+		if err := c.writeCommandStr(COM_QUERY, command); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return c.readRows(false)
+	}
+
+	s, err := c.Prepare(command)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rows, err := s.ExecuteRows(args...)
+	if err != nil {
+		s.Close()
+		return nil, errors.Trace(err)
+	}
+	rows.stmt = s
+	if rows.done {
+		rows.finish()
+	}
+	return rows, rows.err
+}
+
+// ExecuteRows executes the statement and returns the rows of the result set to be read on demand.
+func (s *Stmt) ExecuteRows(args ...interface{}) (*Rows, error) {
+	if err := s.write(args...); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return s.conn.readRows(true)
+}
+
+func (c *Conn) readRows(binary bool) (*Rows, error) {
+	data, err := c.ReadPacket()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rows := &Rows{c: c, binary: binary}
+	switch data[0] {
+	case OK_HEADER:
+		if rows.Result, err = c.handleOKPacket(data); err != nil {
+			return nil, errors.Trace(err)
+		}
+	case ERR_HEADER:
+		return nil, c.handleErrorPacket(data)
+	case LocalInFile_HEADER:
+		if rows.Result, err = c.handleLocalInfile(string(data[1:])); err != nil {
+			return nil, errors.Trace(err)
+		}
+	default:
+		if rows.Result, err = c.readResultsetHeader(data); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return rows, nil
+	}
+
+	// a statement without result set, the following results are read now
+	rows.Resultset = &Resultset{FieldNames: map[string]int{}}
+	rows.done = true
+	if rows.Status&SERVER_MORE_RESULTS_EXISTS != 0 {
+		if _, err = c.readResults(binary); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return rows, nil
+}
+
+// Next reads the next row, it returns false when there are no more rows or an error occurs, see Err.
+func (r *Rows) Next() bool {
+	for !r.done {
+		if r.cursor != nil && !r.fetching {
+			if err := r.cursor.fetch(r.fetchSize); err != nil {
+				r.err = errors.Trace(err)
+				r.finish()
+				return false
+			}
+			r.fetching = true
+		}
+
+		data := r.pending
+		r.pending = nil
+		if data == nil {
+			var err error
+			if data, err = r.c.ReadPacket(); err != nil {
+				r.err = errors.Trace(err)
+				r.finish()
+				return false
+			}
+		}
+
+		if r.c.isResultsetEnd(data) {
+			if err := r.c.handleResultsetEnd(data, r.Result); err != nil {
+				r.err = errors.Trace(err)
+				r.finish()
+				return false
+			}
+			// the end of a page, fetch the next one unless the last row is sent
+			if r.cursor != nil && r.Status&SERVER_STATUS_CURSOR_EXISTS != 0 && r.Status&SERVER_STATUS_LAST_ROW_SEND == 0 {
+				r.fetching = false
+				if r.closing {
+					r.err = r.cursor.reset()
+					r.finish()
+					return false
+				}
+				continue
+			}
+			// the results following this one are not returned
+			if r.Status&SERVER_MORE_RESULTS_EXISTS != 0 {
+				if _, err := r.c.readResults(r.binary); err != nil {
+					r.err = errors.Trace(err)
+				}
+			}
+			r.finish()
+			return false
+		}
+
+		// a text row never begins with 0xff and a binary row begins with 0x00
+		if data[0] == ERR_HEADER {
+			r.err = r.c.handleErrorPacket(data)
+			r.finish()
+			return false
+		}
+
+		r.row = data
+		return true
+	}
+	return false
+}
+
+func (r *Rows) finish() {
+	r.done = true
+	r.row = nil
+	if r.stmt != nil {
+		if err := r.stmt.Close(); err != nil && r.err == nil {
+			r.err = errors.Trace(err)
+		}
+		r.stmt = nil
+	}
+}
+
+// GetFields returns the columns of the result set, empty for a statement without result set.
+func (r *Rows) GetFields() []*Field {
+	return r.Fields
+}
+
+// RawRow returns the packet of the current row without the header, in the binary protocol when the rows
+// are returned by a statement. It is used to forward the rows without parsing them.
+func (r *Rows) RawRow() RowData {
+	return r.row
+}
+
+// Values parses the current row.
+func (r *Rows) Values() ([]interface{}, error) {
+	if r.row == nil {
+		return nil, errors.New("no current row, Next is not called or returns false")
+	}
+	return r.row.Parse(r.Fields, r.binary)
+}
+
+// Scan parses the current row into dest, one for each column, supported types are
+// *interface{}, *string, *[]byte, *int, *int64, *uint64, *float64 and *bool.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if len(dest) != len(r.Fields) {
+		return errors.Errorf("expect %d destinations for the columns, got %d", len(r.Fields), len(dest))
+	}
+
+	values, err := r.Values()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	rs := &Resultset{Fields: r.Fields, FieldNames: r.FieldNames, Values: [][]interface{}{values}}
+	for i := range dest {
+		switch d := dest[i].(type) {
+		case *interface{}:
+			*d = values[i]
+		case *string:
+			var s string
+			s, err = rs.GetString(0, i)
+			*d = string([]byte(s))
+		case *[]byte:
+			var s string
+			if s, err = rs.GetString(0, i); err == nil && values[i] != nil {
+				*d = []byte(s)
+			} else {
+				*d = nil
+			}
+		case *int:
+			var n int64
+			n, err = rs.GetInt(0, i)
+			*d = int(n)
+		case *int64:
+			*d, err = rs.GetInt(0, i)
+		case *uint64:
+			*d, err = rs.GetUint(0, i)
+		case *float64:
+			*d, err = rs.GetFloat(0, i)
+		case *bool:
+			var n int64
+			n, err = rs.GetInt(0, i)
+			*d = n != 0
+		default:
+			return errors.Errorf("unsupported destination type %T of column %d", dest[i], i)
+		}
+		if err != nil {
+			return errors.Annotatef(err, "scan column %d", i)
+		}
+	}
+	return nil
+}
+
+// Err returns the error occurred when reading the rows.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close reads and discards the remaining rows, so the connection can be used again.
+// An open cursor is closed without fetching the remaining rows.
+func (r *Rows) Close() error {
+	r.closing = true
+	if r.cursor != nil && !r.fetching && !r.done {
+		r.err = r.cursor.reset()
+		r.finish()
+	}
+	for r.Next() {
+	}
+	return r.err
+}
@@ -31,7 +31,87 @@ func (s *Stmt) Execute(args ...interface{}) (*Result, error) {
 		return nil, errors.Trace(err)
 	}
 
-	return s.conn.readResult(true)
+	return s.conn.readFirstResult(true)
+}
+
+// ExecuteMulti returns all the results of the statement, like the result sets of a CALL
+// followed by the status of the procedure.
+func (s *Stmt) ExecuteMulti(args ...interface{}) ([]*Result, error) {
+	if err := s.write(args...); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return s.conn.readResults(true)
+}
+
+// ExecuteCursor executes the statement with a read-only cursor, the rows are fetched from the server
+// fetchSize rows at a time by COM_STMT_FETCH when they are read.
+// The rows are returned at once if the server does not open the cursor.
+func (s *Stmt) ExecuteCursor(fetchSize int, args ...interface{}) (*Rows, error) {
+	if fetchSize <= 0 {
+		return nil, errors.Errorf("invalid fetch size %d", fetchSize)
+	}
+	if err := s.writeExecute(CURSOR_TYPE_READ_ONLY, args...); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rows, err := s.conn.readRows(true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// with CLIENT_DEPRECATE_EOF the status follows the columns only when the cursor is opened,
+	// otherwise the packet is the first row or the end of the rows
+	if !rows.done && s.conn.capability&CLIENT_DEPRECATE_EOF > 0 {
+		data, err := s.conn.ReadPacket()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !s.conn.isResultsetEnd(data) {
+			rows.pending = data
+		} else if err = s.conn.handleResultsetEnd(data, rows.Result); err != nil {
+			return nil, errors.Trace(err)
+		} else if rows.Status&SERVER_STATUS_CURSOR_EXISTS == 0 {
+			rows.pending = data
+		}
+	}
+	if !rows.done && rows.Status&SERVER_STATUS_CURSOR_EXISTS != 0 {
+		rows.cursor = s
+		rows.fetchSize = uint32(fetchSize)
+	}
+	return rows, nil
+}
+
+func (s *Stmt) fetch(count uint32) error {
+	s.conn.ResetSequence()
+
+	return s.conn.WritePacket([]byte{
+		0x09, //9 bytes long
+		0x00,
+		0x00,
+		0x00, //sequence
+
+		COM_STMT_FETCH,
+
+		byte(s.id),
+		byte(s.id >> 8),
+		byte(s.id >> 16),
+		byte(s.id >> 24),
+
+		byte(count),
+		byte(count >> 8),
+		byte(count >> 16),
+		byte(count >> 24),
+	})
+}
+
+// reset closes the cursor of the statement
+func (s *Stmt) reset() error {
+	if err := s.conn.writeCommandUint32(COM_STMT_RESET, s.id); err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err := s.conn.readOK()
+	return errors.Trace(err)
 }
 
 func (s *Stmt) Close() error {
@@ -43,6 +123,10 @@ func (s *Stmt) Close() error {
 }
 
 func (s *Stmt) write(args ...interface{}) error {
+	return s.writeExecute(CURSOR_TYPE_NO_CURSOR, args...)
+}
+
+func (s *Stmt) writeExecute(flag byte, args ...interface{}) error {
 	paramsNum := s.params
 
 	if len(args) != paramsNum {
@@ -136,8 +220,8 @@ func (s *Stmt) write(args ...interface{}) error {
 	data = append(data, COM_STMT_EXECUTE)
 	data = append(data, byte(s.id), byte(s.id>>8), byte(s.id>>16), byte(s.id>>24))
 
-	//flag: CURSOR_TYPE_NO_CURSOR
-	data = append(data, 0x00)
+	//flag: CURSOR_TYPE_NO_CURSOR or CURSOR_TYPE_READ_ONLY
+	data = append(data, flag)
 
 	//iteration-count, always 1
 	data = append(data, 1, 0, 0, 0)
@@ -201,13 +285,13 @@ func (c *Conn) Prepare(query string) (*Stmt, error) {
 	//warnings = binary.LittleEndian.Uint16(data[pos:])
 
 	if s.params > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+		if err := s.conn.skipColumns(s.params); err != nil {
 			return nil, errors.Trace(err)
 		}
 	}
 
 	if s.columns > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+		if err := s.conn.skipColumns(s.columns); err != nil {
 			return nil, errors.Trace(err)
 		}
 	}
@@ -110,11 +110,11 @@ func (c *conn) Exec(query string, args []sqldriver.Value) (sqldriver.Result, err
 
 func (c *conn) Query(query string, args []sqldriver.Value) (sqldriver.Rows, error) {
 	a := buildArgs(args)
-	r, err := c.Conn.Execute(query, a...)
+	r, err := c.Conn.ExecuteRows(query, a...)
 	if err != nil {
 		return nil, replyError(err)
 	}
-	return newRows(r.Resultset)
+	return newRows(r)
 }
 
 type stmt struct {
@@ -140,11 +140,11 @@ func (s *stmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
 
 func (s *stmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
 	a := buildArgs(args)
-	r, err := s.Stmt.Execute(a...)
+	r, err := s.Stmt.ExecuteRows(a...)
 	if err != nil {
 		return nil, replyError(err)
 	}
-	return newRows(r.Resultset)
+	return newRows(r)
 }
 
 type tx struct {
@@ -171,27 +171,27 @@ func (r *result) RowsAffected() (int64, error) {
 	return int64(r.Result.AffectedRows), nil
 }
 
+// rows reads the rows from the connection on demand
 type rows struct {
-	*mysql.Resultset
+	*client.Rows
 
 	columns []string
-	step    int
 }
 
-func newRows(r *mysql.Resultset) (*rows, error) {
-	if r == nil {
+func newRows(r *client.Rows) (*rows, error) {
+	if len(r.Fields) == 0 {
+		r.Close()
 		return nil, fmt.Errorf("invalid mysql query, no correct result")
 	}
 
 	rs := new(rows)
-	rs.Resultset = r
+	rs.Rows = r
 
 	rs.columns = make([]string, len(r.Fields))
 
 	for i, f := range r.Fields {
 		rs.columns[i] = hack.String(f.Name)
 	}
-	rs.step = 0
 
 	return rs, nil
 }
@@ -201,27 +201,25 @@ func (r *rows) Columns() []string {
 }
 
 func (r *rows) Close() error {
-	r.step = -1
-	return nil
+	return replyError(r.Rows.Close())
 }
 
 func (r *rows) Next(dest []sqldriver.Value) error {
-	if r.step >= r.Resultset.RowNumber() {
+	if !r.Rows.Next() {
+		if err := r.Rows.Err(); err != nil {
+			return replyError(err)
+		}
 		return io.EOF
-	} else if r.step == -1 {
-		return io.ErrUnexpectedEOF
 	}
 
-	for i := 0; i < r.Resultset.ColumnNumber(); i++ {
-		value, err := r.Resultset.GetValue(r.step, i)
-		if err != nil {
-			return err
-		}
-
-		dest[i] = sqldriver.Value(value)
+	values, err := r.Rows.Values()
+	if err != nil {
+		return err
 	}
 
-	r.step++
+	for i := range values {
+		dest[i] = sqldriver.Value(values[i])
+	}
 
 	return nil
 }
@@ -1,7 +1,6 @@
 package failover
 
 import (
-	"github.com/daiguadaidai/dal/go-mysql/mysql"
 	"github.com/pingcap/errors"
 )
 
@@ -16,16 +15,19 @@ import (
 //  3, Slaves must have same replication mode, all use GTID or not
 //
 func Failover(flavor string, slaves []*Server) ([]*Server, error) {
-	var h Handler
-	var err error
+	if _, err := FailoverMaster(flavor, slaves); err != nil {
+		return nil, errors.Trace(err)
+	}
 
-	switch flavor {
-	case mysql.MySQLFlavor:
-		h = new(MysqlGTIDHandler)
-	case mysql.MariaDBFlavor:
-		return nil, errors.Errorf("MariaDB failover is not supported now")
-	default:
-		return nil, errors.Errorf("invalid flavor %s", flavor)
+	return slaves, nil
+}
+
+// FailoverMaster does the same as Failover but returns the promoted master,
+// callers need it to route the writes to the new master.
+func FailoverMaster(flavor string, slaves []*Server) (*Server, error) {
+	h, err := NewHandler(flavor)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
 
 	// First check slaves use gtid or not
@@ -64,5 +66,5 @@ func Failover(flavor string, slaves []*Server) ([]*Server, error) {
 		}
 	}
 
-	return slaves, nil
+	return bestSlave, nil
 }
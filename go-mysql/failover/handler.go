@@ -1,5 +1,10 @@
 package failover
 
+import (
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
 type Handler interface {
 	// Promote slave s to master
 	Promote(s *Server) error
@@ -20,3 +25,15 @@ type Handler interface {
 	// Check all slaves have gtid enabled
 	CheckGTIDMode(slaves []*Server) error
 }
+
+// NewHandler returns the Handler for the flavor, only MySQL GTID mode is supported now.
+func NewHandler(flavor string) (Handler, error) {
+	switch flavor {
+	case mysql.MySQLFlavor:
+		return new(MysqlGTIDHandler), nil
+	case mysql.MariaDBFlavor:
+		return nil, errors.Errorf("MariaDB failover is not supported now")
+	default:
+		return nil, errors.Errorf("invalid flavor %s", flavor)
+	}
+}
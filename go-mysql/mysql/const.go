@@ -37,6 +37,18 @@ const (
 	SERVER_STATUS_METADATA_CHANGED     uint16 = 0x0400
 	SERVER_QUERY_WAS_SLOW              uint16 = 0x0800
 	SERVER_PS_OUT_PARAMS               uint16 = 0x1000
+	SERVER_STATUS_IN_TRANS_READONLY    uint16 = 0x2000
+	SERVER_SESSION_STATE_CHANGED       uint16 = 0x4000
+)
+
+// session state change types in the OK packet, see CLIENT_SESSION_TRACK
+const (
+	SESSION_TRACK_SYSTEM_VARIABLES byte = iota
+	SESSION_TRACK_SCHEMA
+	SESSION_TRACK_STATE_CHANGE
+	SESSION_TRACK_GTIDS
+	SESSION_TRACK_TRANSACTION_CHARACTERISTICS
+	SESSION_TRACK_TRANSACTION_STATE
 )
 
 const (
@@ -97,6 +109,19 @@ const (
 	CLIENT_PLUGIN_AUTH
 	CLIENT_CONNECT_ATTRS
 	CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+	CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS
+	CLIENT_SESSION_TRACK
+	CLIENT_DEPRECATE_EOF
+	CLIENT_OPTIONAL_RESULTSET_METADATA
+	CLIENT_ZSTD_COMPRESSION_ALGORITHM
+)
+
+// compression algorithms of the compressed protocol
+const (
+	COMPRESSION_ZLIB = "zlib"
+	COMPRESSION_ZSTD = "zstd" // MySQL 8.0.18+
+
+	DEFAULT_ZSTD_COMPRESSION_LEVEL = 3
 )
 
 const (
@@ -156,6 +181,14 @@ const (
 	UNIQUE_FLAG         = 65536
 )
 
+// flags of COM_STMT_EXECUTE
+const (
+	CURSOR_TYPE_NO_CURSOR  byte = 0x00
+	CURSOR_TYPE_READ_ONLY  byte = 0x01
+	CURSOR_TYPE_FOR_UPDATE byte = 0x02
+	CURSOR_TYPE_SCROLLABLE byte = 0x04
+)
+
 const (
 	DEFAULT_CHARSET               = "utf8"
 	DEFAULT_COLLATION_ID   uint8  = 33
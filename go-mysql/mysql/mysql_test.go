@@ -190,3 +190,32 @@ func (t *mysqlTestSuite) TestMysqlNullDecode(c *check.C) {
 	c.Assert(isNull, check.IsTrue)
 	c.Assert(n, check.Equals, 1)
 }
+
+func (t *mysqlTestSuite) TestMysqlParseSessionState(c *check.C) {
+	// autocommit=OFF and schema test, as sent by the MySQL server
+	data := []byte{
+		0x00, 0x0f, 0x0a, 'a', 'u', 't', 'o', 'c', 'o', 'm', 'm', 'i', 't', 0x03, 'O', 'F', 'F',
+		0x01, 0x05, 0x04, 't', 'e', 's', 't',
+		// unknown type is skipped
+		0xff, 0x01, 0x00,
+	}
+	s, err := ParseSessionState(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.SystemVariables, check.DeepEquals, map[string]string{"autocommit": "OFF"})
+	c.Assert(s.Schema, check.Equals, "test")
+
+	s = &SessionState{
+		SystemVariables:            map[string]string{"time_zone": "+08:00"},
+		Schema:                     "dal",
+		StateChanged:               true,
+		GTIDs:                      "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",
+		TransactionCharacteristics: "SET TRANSACTION READ ONLY;",
+		TransactionState:           "T_______",
+	}
+	parsed, err := ParseSessionState(s.Dump())
+	c.Assert(err, check.IsNil)
+	c.Assert(parsed, check.DeepEquals, s)
+
+	_, err = ParseSessionState(data[:10])
+	c.Assert(err, check.Equals, ErrMalformPacket)
+}
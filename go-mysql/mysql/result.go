@@ -6,7 +6,13 @@ type Result struct {
 	InsertId     uint64
 	AffectedRows uint64
 
+	// the warnings of the statement, reported by the OK packet or the end of the result set
+	Warnings uint16
+
 	*Resultset
+
+	// the session state changes of the OK packet with CLIENT_SESSION_TRACK, nil when nothing changed
+	SessionState *SessionState
 }
 
 type Executer interface {
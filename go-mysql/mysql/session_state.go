@@ -0,0 +1,148 @@
+package mysql
+
+// SessionState is the session state change information of the OK packet with CLIENT_SESSION_TRACK,
+// the MySQL server sends the changes tracked by the session_track_* system variables.
+// See: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_ok_packet.html
+type SessionState struct {
+	// the changed system variables, name to value
+	SystemVariables map[string]string
+	// the current schema, empty when not changed
+	Schema string
+	// some session state is changed, like user variables, temporary tables or prepared statements
+	StateChanged bool
+	// the GTIDs of the committed transaction, empty when not tracked
+	GTIDs string
+	// the statements to restart the transaction with the same characteristics, empty when not tracked
+	TransactionCharacteristics string
+	// the transaction state of 8 characters like "T_______", empty when not tracked
+	TransactionState string
+}
+
+// ParseSessionState parses the session state change information, the unknown types are skipped
+func ParseSessionState(data []byte) (*SessionState, error) {
+	s := new(SessionState)
+
+	pos := 0
+	for pos < len(data) {
+		typ := data[pos]
+		pos++
+		if pos >= len(data) {
+			return nil, ErrMalformPacket
+		}
+
+		v, _, n, err := LengthEncodedString(data[pos:])
+		if err != nil {
+			return nil, ErrMalformPacket
+		}
+		pos += n
+
+		switch typ {
+		case SESSION_TRACK_SYSTEM_VARIABLES:
+			name, value, err := parseTwoLengthEncodedStrings(v)
+			if err != nil {
+				return nil, err
+			}
+			if s.SystemVariables == nil {
+				s.SystemVariables = make(map[string]string)
+			}
+			s.SystemVariables[string(name)] = string(value)
+		case SESSION_TRACK_SCHEMA:
+			schema, err := parseLengthEncodedString(v)
+			if err != nil {
+				return nil, err
+			}
+			s.Schema = string(schema)
+		case SESSION_TRACK_STATE_CHANGE:
+			changed, err := parseLengthEncodedString(v)
+			if err != nil {
+				return nil, err
+			}
+			s.StateChanged = string(changed) == "1"
+		case SESSION_TRACK_GTIDS:
+			// the encoding specification, only 0 for the GTID set string is defined
+			if len(v) < 1 {
+				return nil, ErrMalformPacket
+			}
+			gtids, err := parseLengthEncodedString(v[1:])
+			if err != nil {
+				return nil, err
+			}
+			s.GTIDs = string(gtids)
+		case SESSION_TRACK_TRANSACTION_CHARACTERISTICS:
+			characteristics, err := parseLengthEncodedString(v)
+			if err != nil {
+				return nil, err
+			}
+			s.TransactionCharacteristics = string(characteristics)
+		case SESSION_TRACK_TRANSACTION_STATE:
+			state, err := parseLengthEncodedString(v)
+			if err != nil {
+				return nil, err
+			}
+			s.TransactionState = string(state)
+		}
+	}
+
+	return s, nil
+}
+
+// Dump returns the session state change information to be sent in the OK packet
+func (s *SessionState) Dump() []byte {
+	var data []byte
+
+	for name, value := range s.SystemVariables {
+		v := append(PutLengthEncodedString([]byte(name)), PutLengthEncodedString([]byte(value))...)
+		data = appendSessionState(data, SESSION_TRACK_SYSTEM_VARIABLES, v)
+	}
+	if s.Schema != "" {
+		data = appendSessionState(data, SESSION_TRACK_SCHEMA, PutLengthEncodedString([]byte(s.Schema)))
+	}
+	if s.StateChanged {
+		data = appendSessionState(data, SESSION_TRACK_STATE_CHANGE, PutLengthEncodedString([]byte("1")))
+	}
+	if s.GTIDs != "" {
+		v := append([]byte{0}, PutLengthEncodedString([]byte(s.GTIDs))...)
+		data = appendSessionState(data, SESSION_TRACK_GTIDS, v)
+	}
+	if s.TransactionCharacteristics != "" {
+		v := PutLengthEncodedString([]byte(s.TransactionCharacteristics))
+		data = appendSessionState(data, SESSION_TRACK_TRANSACTION_CHARACTERISTICS, v)
+	}
+	if s.TransactionState != "" {
+		v := PutLengthEncodedString([]byte(s.TransactionState))
+		data = appendSessionState(data, SESSION_TRACK_TRANSACTION_STATE, v)
+	}
+
+	return data
+}
+
+func appendSessionState(data []byte, typ byte, v []byte) []byte {
+	data = append(data, typ)
+	return append(data, PutLengthEncodedString(v)...)
+}
+
+func parseLengthEncodedString(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, ErrMalformPacket
+	}
+	v, _, _, err := LengthEncodedString(b)
+	if err != nil {
+		return nil, ErrMalformPacket
+	}
+	return v, nil
+}
+
+func parseTwoLengthEncodedStrings(b []byte) ([]byte, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, ErrMalformPacket
+	}
+	first, _, n, err := LengthEncodedString(b)
+	if err != nil {
+		return nil, nil, ErrMalformPacket
+	}
+	second, err := parseLengthEncodedString(b[n:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return first, second, nil
+}
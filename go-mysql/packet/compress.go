@@ -0,0 +1,263 @@
+package packet
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pingcap/errors"
+)
+
+// Compressed protocol
+// See: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_compression.html
+//
+// Every compressed packet has a 7 bytes header: 3 bytes length of the compressed payload, 1 byte compressed sequence
+// and 3 bytes length of the payload before compression (0 means the payload is not compressed).
+// The payload is one or more plain packets with their own 4 bytes headers.
+const (
+	CompressedHeaderLen = 7
+
+	// MinCompressLength: payloads shorter than this are sent uncompressed, same as MySQL
+	MinCompressLength = 50
+
+	// plain packets are buffered and compressed together, the buffer is flushed when it reaches this size,
+	// before reading, and when the sequence is reset
+	compressBufferSize = 64 * 1024
+)
+
+type compressor interface {
+	// compress appends the compressed src to dst
+	compress(dst []byte, src []byte) ([]byte, error)
+	// decompress appends the decompressed src to dst, length is the length after decompression
+	decompress(dst []byte, src []byte, length int) ([]byte, error)
+}
+
+func newCompressor(algorithm string, level int) (compressor, error) {
+	switch algorithm {
+	case COMPRESSION_ZLIB:
+		return new(zlibCompressor), nil
+	case COMPRESSION_ZSTD:
+		if level <= 0 {
+			level = DEFAULT_ZSTD_COMPRESSION_LEVEL
+		}
+		return &zstdCompressor{level: level}, nil
+	}
+	return nil, errors.Errorf("compression algorithm '%s' is not supported", algorithm)
+}
+
+type zlibCompressor struct {
+	w   *zlib.Writer
+	r   io.ReadCloser
+	buf bytes.Buffer
+}
+
+func (z *zlibCompressor) compress(dst []byte, src []byte) ([]byte, error) {
+	z.buf.Reset()
+	if z.w == nil {
+		z.w = zlib.NewWriter(&z.buf)
+	} else {
+		z.w.Reset(&z.buf)
+	}
+	if _, err := z.w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := z.w.Close(); err != nil {
+		return nil, err
+	}
+	return append(dst, z.buf.Bytes()...), nil
+}
+
+func (z *zlibCompressor) decompress(dst []byte, src []byte, length int) ([]byte, error) {
+	var err error
+	if z.r == nil {
+		z.r, err = zlib.NewReader(bytes.NewReader(src))
+	} else {
+		err = z.r.(zlib.Resetter).Reset(bytes.NewReader(src), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := len(dst)
+	dst = append(dst, make([]byte, length)...)
+	if _, err = io.ReadFull(z.r, dst[start:]); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+type zstdCompressor struct {
+	level int
+	enc   *zstd.Encoder
+	dec   *zstd.Decoder
+}
+
+func (z *zstdCompressor) compress(dst []byte, src []byte) ([]byte, error) {
+	if z.enc == nil {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(z.level)),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		z.enc = enc
+	}
+	return z.enc.EncodeAll(src, dst), nil
+}
+
+func (z *zstdCompressor) decompress(dst []byte, src []byte, length int) ([]byte, error) {
+	if z.dec == nil {
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		z.dec = dec
+	}
+	start := len(dst)
+	dst, err := z.dec.DecodeAll(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(dst)-start != length {
+		return nil, errors.Errorf("invalid decompressed length %d != %d", len(dst)-start, length)
+	}
+	return dst, nil
+}
+
+// SetCompression switches the connection to the compressed protocol, algorithm is COMPRESSION_ZLIB or COMPRESSION_ZSTD,
+// level is only used by zstd. Both sides switch after the OK packet of the authentication.
+func (c *Conn) SetCompression(algorithm string, level int) error {
+	comp, err := newCompressor(algorithm, level)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.compressor = comp
+	c.compression = algorithm
+	c.compressedSequence = 0
+	return nil
+}
+
+// Compression returns the compression algorithm in use, empty if the connection is not compressed
+func (c *Conn) Compression() string {
+	return c.compression
+}
+
+// compressedReader reads the plain packets from the compressed packets
+type compressedReader struct {
+	c *Conn
+}
+
+func (r compressedReader) Read(p []byte) (int, error) {
+	c := r.c
+	for len(c.readBuf) == 0 {
+		if err := c.readCompressedPacket(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *Conn) readCompressedPacket() error {
+	header := []byte{0, 0, 0, 0, 0, 0, 0}
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return ErrBadConn
+	}
+
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	sequence := header[3]
+	uncompressedLength := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+
+	if sequence != c.compressedSequence {
+		return errors.Errorf("invalid compressed sequence %d != %d", sequence, c.compressedSequence)
+	}
+	c.compressedSequence++
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return ErrBadConn
+	}
+
+	if uncompressedLength == 0 {
+		c.readBuf = payload
+		return nil
+	}
+
+	data, err := c.compressor.decompress(c.readBuf[:0], payload, uncompressedLength)
+	if err != nil {
+		return errors.Annotate(err, "decompress packet")
+	}
+	c.readBuf = data
+	return nil
+}
+
+// writeCompressed buffers the plain packets, they are compressed when flushed
+func (c *Conn) writeCompressed(data []byte) error {
+	if c.compressErr != nil {
+		return c.compressErr
+	}
+	c.writeBuf = append(c.writeBuf, data...)
+	if len(c.writeBuf) >= compressBufferSize {
+		return c.flushCompressed()
+	}
+	return nil
+}
+
+// flushCompressed writes the buffered plain packets as compressed packets,
+// the error is kept and returned by the later reads and writes
+func (c *Conn) flushCompressed() error {
+	if c.compressErr != nil {
+		return c.compressErr
+	}
+
+	for pos := 0; pos < len(c.writeBuf); pos += MaxPayloadLen {
+		end := pos + MaxPayloadLen
+		if end > len(c.writeBuf) {
+			end = len(c.writeBuf)
+		}
+		if err := c.writeCompressedPacket(c.writeBuf[pos:end]); err != nil {
+			c.compressErr = err
+			return err
+		}
+	}
+	c.writeBuf = c.writeBuf[:0]
+	return nil
+}
+
+func (c *Conn) writeCompressedPacket(payload []byte) error {
+	buf := make([]byte, CompressedHeaderLen, CompressedHeaderLen+len(payload))
+	uncompressedLength := 0
+	if len(payload) >= MinCompressLength {
+		compressed, err := c.compressor.compress(buf, payload)
+		if err != nil {
+			return errors.Annotate(err, "compress packet")
+		}
+		// send it uncompressed if the compression does not help
+		if len(compressed)-CompressedHeaderLen < len(payload) {
+			buf = compressed
+			uncompressedLength = len(payload)
+		}
+	}
+	if uncompressedLength == 0 {
+		buf = append(buf, payload...)
+	}
+
+	length := len(buf) - CompressedHeaderLen
+	buf[0] = byte(length)
+	buf[1] = byte(length >> 8)
+	buf[2] = byte(length >> 16)
+	buf[3] = c.compressedSequence
+	buf[4] = byte(uncompressedLength)
+	buf[5] = byte(uncompressedLength >> 8)
+	buf[6] = byte(uncompressedLength >> 16)
+
+	if n, err := c.Conn.Write(buf); err != nil {
+		return ErrBadConn
+	} else if n != len(buf) {
+		return ErrBadConn
+	}
+	c.compressedSequence++
+	return nil
+}
@@ -25,6 +25,14 @@ type Conn struct {
 	// able to read the "Client Hello" data since it has been buffered into the buffer reader)
 
 	Sequence uint8
+
+	// compressed protocol, see compress.go
+	compression        string
+	compressor         compressor
+	compressedSequence uint8
+	readBuf            []byte // decompressed data not read yet
+	writeBuf           []byte // plain packets not compressed yet
+	compressErr        error
 }
 
 func NewConn(conn net.Conn) *Conn {
@@ -45,10 +53,35 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 	}
 }
 
+func (c *Conn) reader() io.Reader {
+	if c.compressor == nil {
+		return c.Conn
+	}
+	return compressedReader{c}
+}
+
+func (c *Conn) write(data []byte) (int, error) {
+	if c.compressor == nil {
+		return c.Conn.Write(data)
+	}
+	if err := c.writeCompressed(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
 func (c *Conn) ReadPacketTo(w io.Writer) error {
 	header := []byte{0, 0, 0, 0}
 
-	if _, err := io.ReadFull(c.Conn, header); err != nil {
+	if c.compressor != nil {
+		// the peer is waiting for the buffered packets
+		if err := c.flushCompressed(); err != nil {
+			return ErrBadConn
+		}
+	}
+
+	r := c.reader()
+	if _, err := io.ReadFull(r, header); err != nil {
 		return ErrBadConn
 	}
 
@@ -60,13 +93,14 @@ func (c *Conn) ReadPacketTo(w io.Writer) error {
 
 	sequence := uint8(header[3])
 
-	if sequence != c.Sequence {
+	// MySQL does not check the sequence of the packets in the compressed packets, the compressed sequence is checked instead
+	if sequence != c.Sequence && c.compressor == nil {
 		return errors.Errorf("invalid sequence %d != %d", sequence, c.Sequence)
 	}
 
-	c.Sequence++
+	c.Sequence = sequence + 1
 
-	if n, err := io.CopyN(w, c.Conn, int64(length)); err != nil {
+	if n, err := io.CopyN(w, r, int64(length)); err != nil {
 		return ErrBadConn
 	} else if n != int64(length) {
 		return ErrBadConn
@@ -95,7 +129,7 @@ func (c *Conn) WritePacket(data []byte) error {
 
 		data[3] = c.Sequence
 
-		if n, err := c.Write(data[:4+MaxPayloadLen]); err != nil {
+		if n, err := c.write(data[:4+MaxPayloadLen]); err != nil {
 			return ErrBadConn
 		} else if n != (4 + MaxPayloadLen) {
 			return ErrBadConn
@@ -111,7 +145,7 @@ func (c *Conn) WritePacket(data []byte) error {
 	data[2] = byte(length >> 16)
 	data[3] = c.Sequence
 
-	if n, err := c.Write(data); err != nil {
+	if n, err := c.write(data); err != nil {
 		return ErrBadConn
 	} else if n != len(data) {
 		return ErrBadConn
@@ -193,10 +227,18 @@ func (c *Conn) WriteAuthSwitchPacket(authData []byte, addNUL bool) error {
 }
 
 func (c *Conn) ResetSequence() {
+	if c.compressor != nil {
+		// the buffered packets belong to the previous command, the error is returned by the later reads and writes
+		c.flushCompressed()
+		c.compressedSequence = 0
+	}
 	c.Sequence = 0
 }
 
 func (c *Conn) Close() error {
+	if c.compressor != nil {
+		c.flushCompressed()
+	}
 	c.Sequence = 0
 	if c.Conn != nil {
 		return c.Conn.Close()
@@ -54,6 +54,12 @@ type BinlogSyncerConfig struct {
 	// If not nil, use the provided tls.Config to connect to the database using TLS/SSL.
 	TLSConfig *tls.Config
 
+	// Use the compressed protocol if the master supports it, "zlib" or "zstd", empty to disable.
+	// It saves a lot of bandwidth for the cross datacenter replication.
+	Compression string
+	// zstd compression level (1-22), 0 to use the default
+	CompressionLevel int
+
 	// Use replication.Time structure for timestamp and datetime.
 	// We will use Local location for timestamp and UTC location for datatime.
 	ParseTime bool
@@ -204,6 +210,9 @@ func (b *BinlogSyncer) registerSlave() error {
 	var err error
 	b.c, err = client.Connect(addr, b.cfg.User, b.cfg.Password, "", func(c *client.Conn) {
 		c.SetTLSConfig(b.cfg.TLSConfig)
+		if b.cfg.Compression != "" {
+			c.UseCompression(b.cfg.Compression, b.cfg.CompressionLevel)
+		}
 	})
 	if err != nil {
 		return errors.Trace(err)
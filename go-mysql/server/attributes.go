@@ -0,0 +1,59 @@
+package server
+
+import (
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// AttributesHandler can be implemented by a Handler to see the connection attributes sent by the client
+// with CLIENT_CONNECT_ATTRS, like _client_name, _pid and program_name, e.g. to pass them to the backend
+// connections with client.Conn.SetAttributes or to audit the programs connected.
+type AttributesHandler interface {
+	// HandleAttributes is called after the authentication and after COM_CHANGE_USER, attrs may be empty
+	HandleAttributes(user string, attrs map[string]string) error
+}
+
+// Attributes returns the connection attributes sent by the client, see AttributesHandler
+func (c *Conn) Attributes() map[string]string {
+	return c.attributes
+}
+
+// readAttributes reads the length encoded key-value pairs following the auth plugin name
+// See: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_handshake_response.html
+func (c *Conn) readAttributes(data []byte, pos int) (int, error) {
+	c.attributes = nil
+	if c.capability&CLIENT_CONNECT_ATTRS == 0 || pos >= len(data) {
+		return pos, nil
+	}
+
+	attrs, _, n, err := LengthEncodedString(data[pos:])
+	if err != nil {
+		return 0, ErrMalformPacket
+	}
+	pos += n
+
+	c.attributes = make(map[string]string)
+	for i := 0; i < len(attrs); {
+		key, _, n, err := LengthEncodedString(attrs[i:])
+		if err != nil {
+			return 0, ErrMalformPacket
+		}
+		i += n
+		if i >= len(attrs) {
+			return 0, ErrMalformPacket
+		}
+		value, _, n, err := LengthEncodedString(attrs[i:])
+		if err != nil {
+			return 0, ErrMalformPacket
+		}
+		i += n
+		c.attributes[string(key)] = string(value)
+	}
+	return pos, nil
+}
+
+func (c *Conn) handleAttributes() error {
+	if h, ok := c.h.(AttributesHandler); ok {
+		return h.HandleAttributes(c.user, c.attributes)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// attributesHandler records the connection attributes
+type attributesHandler struct {
+	EmptyHandler
+	attrs chan map[string]string
+}
+
+func (h attributesHandler) HandleAttributes(user string, attrs map[string]string) error {
+	h.attrs <- attrs
+	return nil
+}
+
+// attributesProxyHandler connects to the backend with the attributes of the client
+type attributesProxyHandler struct {
+	EmptyHandler
+	backendAddr string
+	backends    chan *client.Conn
+}
+
+func (h attributesProxyHandler) HandleAttributes(user string, attrs map[string]string) error {
+	backend, err := client.Connect(h.backendAddr, "root", "123", "", func(c *client.Conn) {
+		c.SetAttributes(attrs)
+	})
+	if err != nil {
+		return err
+	}
+	h.backends <- backend
+	return nil
+}
+
+func TestAttributes(t *testing.T) {
+	serverConf := NewDefaultServer()
+	if err := serverConf.SetCompressionAlgorithms(mysql.COMPRESSION_ZSTD); err != nil {
+		t.Fatal(err)
+	}
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	h := attributesHandler{attrs: make(chan map[string]string, 10)}
+	addr := startTestServer(t, serverConf, p, h, nil)
+
+	// the attributes are followed by the zstd compression level
+	conn, err := client.Connect(addr, "root", "123", "", func(c *client.Conn) {
+		c.SetAttributes(map[string]string{"program_name": "app", "app_version": "1.0"})
+		c.UseCompression(mysql.COMPRESSION_ZSTD, 5)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	attrs := <-h.attrs
+	if attrs["_client_name"] != "go-mysql" || attrs["_pid"] != strconv.Itoa(os.Getpid()) ||
+		attrs["program_name"] != "app" || attrs["app_version"] != "1.0" {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+	if err = conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sent again by COM_CHANGE_USER
+	if err = conn.ChangeUser("root", "123", ""); err != nil {
+		t.Fatal(err)
+	}
+	if attrs = <-h.attrs; attrs["program_name"] != "app" {
+		t.Fatalf("unexpected attributes after change user %v", attrs)
+	}
+
+	// a proxy passes the attributes of its clients to the backend
+	proxy := attributesProxyHandler{backendAddr: addr, backends: make(chan *client.Conn, 1)}
+	proxyAddr := startTestServer(t, NewDefaultServer(), p, proxy, nil)
+	front, err := client.Connect(proxyAddr, "root", "123", "", func(c *client.Conn) {
+		c.SetAttributes(map[string]string{"program_name": "front"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer front.Close()
+	backend := <-proxy.backends
+	defer backend.Close()
+
+	if attrs = <-h.attrs; attrs["program_name"] != "front" {
+		t.Fatalf("unexpected attributes through the proxy %v", attrs)
+	}
+}
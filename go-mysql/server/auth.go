@@ -16,6 +16,10 @@ import (
 var ErrAccessDenied = errors.New("access denied")
 
 func (c *Conn) compareAuthData(authPluginName string, clientAuthData []byte) error {
+	if c.withoutPlainPassword() {
+		return c.compareAuthDataWithoutPassword(clientAuthData)
+	}
+
 	switch authPluginName {
 	case AUTH_NATIVE_PASSWORD:
 		if err := c.acquirePassword(); err != nil {
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// Password hash formats used by HashedCredentialProvider. An empty hash means an empty password.
+const (
+	// '*' + HEX(SHA1(SHA1(password))), the same as the authentication_string of mysql_native_password in mysql.user
+	NATIVE_PASSWORD_HASH_PREFIX = "*"
+	// '$SHA2$' + HEX(SHA256(SHA256(password))), what caching_sha2_password keeps in its cache
+	CACHING_SHA2_PASSWORD_HASH_PREFIX = "$SHA2$"
+)
+
+// HashedCredentialProvider is a credential provider keeping password hashes instead of plaintext passwords.
+//
+// A 'mysql_native_password' hash verifies 'mysql_native_password' logins, a 'caching_sha2_password' hash verifies
+// the fast authentication of 'caching_sha2_password'. Other combinations ask the client for the plaintext password
+// (TLS or RSA encrypted) and compare its hash. After a full authentication of 'caching_sha2_password' the
+// password is cached, call 'func (s *Server) InvalidateUserCache(string)' when the hash is updated at runtime.
+type HashedCredentialProvider interface {
+	CredentialProvider
+	// get the password hash made by NativePasswordHash or CachingSha2PasswordHash
+	GetCredentialHash(username string) (hash string, found bool, err error)
+}
+
+// PasswordChecker is a credential provider which can only verify plaintext passwords, e.g. by logging into
+// another MySQL server. The server must use 'caching_sha2_password' or 'sha256_password' as the default auth
+// method so the client sends the plaintext password (TLS or RSA encrypted).
+type PasswordChecker interface {
+	CredentialProvider
+	CheckPassword(username string, password string) (bool, error)
+}
+
+func NativePasswordHash(password string) string {
+	if password == "" {
+		return ""
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	return NATIVE_PASSWORD_HASH_PREFIX + strings.ToUpper(hex.EncodeToString(stage2[:]))
+}
+
+func CachingSha2PasswordHash(password string) string {
+	if password == "" {
+		return ""
+	}
+	stage1 := sha256.Sum256([]byte(password))
+	stage2 := sha256.Sum256(stage1[:])
+	return CACHING_SHA2_PASSWORD_HASH_PREFIX + hex.EncodeToString(stage2[:])
+}
+
+// ValidatePasswordHash checks the hash is made by NativePasswordHash or CachingSha2PasswordHash
+func ValidatePasswordHash(hash string) error {
+	_, _, err := parsePasswordHash(hash)
+	return err
+}
+
+// returns the prefix and the digest of the hash
+func parsePasswordHash(hash string) (string, []byte, error) {
+	if hash == "" {
+		return "", nil, nil
+	}
+
+	for _, c := range []struct {
+		prefix string
+		size   int
+	}{
+		{NATIVE_PASSWORD_HASH_PREFIX, sha1.Size},
+		{CACHING_SHA2_PASSWORD_HASH_PREFIX, sha256.Size},
+	} {
+		if !strings.HasPrefix(hash, c.prefix) {
+			continue
+		}
+		digest, err := hex.DecodeString(hash[len(c.prefix):])
+		if err != nil || len(digest) != c.size {
+			return "", nil, errors.Errorf("invalid password hash '%s'", hash)
+		}
+		return c.prefix, digest, nil
+	}
+
+	return "", nil, errors.Errorf("unknown password hash format '%s'", hash)
+}
+
+func matchPasswordHash(prefix string, digest []byte, password string) bool {
+	var hash string
+	switch prefix {
+	case NATIVE_PASSWORD_HASH_PREFIX:
+		hash = NativePasswordHash(password)
+	case CACHING_SHA2_PASSWORD_HASH_PREFIX:
+		hash = CachingSha2PasswordHash(password)
+	default:
+		return password == ""
+	}
+	_, d, _ := parsePasswordHash(hash)
+	return bytes.Equal(d, digest)
+}
+
+// the client sends SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+func nativeScrambleValidation(stage2, salt, scramble []byte) bool {
+	if len(scramble) != sha1.Size {
+		return false
+	}
+	crypt := sha1.New()
+	crypt.Write(salt)
+	crypt.Write(stage2)
+	stage1 := crypt.Sum(nil)
+	for i := range stage1 {
+		stage1[i] ^= scramble[i]
+	}
+	candidate := sha1.Sum(stage1)
+	return bytes.Equal(candidate[:], stage2)
+}
+
+func (c *Conn) withoutPlainPassword() bool {
+	switch c.credentialProvider.(type) {
+	case HashedCredentialProvider, PasswordChecker:
+		return true
+	}
+	return false
+}
+
+// authenticate the client with a HashedCredentialProvider or a PasswordChecker
+func (c *Conn) compareAuthDataWithoutPassword(authData []byte) error {
+	var prefix string
+	var digest []byte
+	var verify func(password string) (bool, error)
+
+	switch p := c.credentialProvider.(type) {
+	case HashedCredentialProvider:
+		hash, found, err := p.GetCredentialHash(c.user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return NewDefaultError(ER_NO_SUCH_USER, c.user, c.RemoteHost())
+		}
+		if prefix, digest, err = parsePasswordHash(hash); err != nil {
+			return err
+		}
+		verify = func(password string) (bool, error) {
+			return matchPasswordHash(prefix, digest, password), nil
+		}
+	case PasswordChecker:
+		found, err := p.CheckUsername(c.user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return NewDefaultError(ER_NO_SUCH_USER, c.user, c.RemoteHost())
+		}
+		verify = func(password string) (bool, error) {
+			return p.CheckPassword(c.user, password)
+		}
+	}
+
+	// Empty passwords are not hashed, but sent as empty string
+	if len(authData) == 0 {
+		return c.verifyPassword(verify, "")
+	}
+
+	switch c.authPluginName {
+	case AUTH_NATIVE_PASSWORD:
+		if prefix != NATIVE_PASSWORD_HASH_PREFIX {
+			if _, ok := c.credentialProvider.(PasswordChecker); ok {
+				return errors.Errorf("auth method '%s' can not be used with %T", AUTH_NATIVE_PASSWORD, c.credentialProvider)
+			}
+			return ErrAccessDenied
+		}
+		if nativeScrambleValidation(digest, c.salt, authData) {
+			return nil
+		}
+		return ErrAccessDenied
+
+	case AUTH_CACHING_SHA2_PASSWORD:
+		if prefix == CACHING_SHA2_PASSWORD_HASH_PREFIX {
+			if scrambleValidation(digest, c.salt, authData) {
+				return c.writeAuthMoreDataFastAuth()
+			}
+			return ErrAccessDenied
+		}
+		_, hashed := c.credentialProvider.(HashedCredentialProvider)
+		if hashed {
+			if cached, ok := c.serverConf.cacheShaPassword.Load(fmt.Sprintf("%s@%s", c.user, c.Conn.LocalAddr())); ok {
+				if scrambleValidation(cached.([]byte), c.salt, authData) {
+					return c.writeAuthMoreDataFastAuth()
+				}
+				return ErrAccessDenied
+			}
+		}
+		// full authentication
+		if err := c.writeAuthMoreDataFullAuth(); err != nil {
+			return err
+		}
+		data, err := c.readAuthSwitchRequestResponse()
+		if err != nil {
+			return err
+		}
+		password, err := c.readPlainPassword(data, true)
+		if err != nil {
+			return err
+		}
+		if err = c.verifyPassword(verify, password); err != nil {
+			return err
+		}
+		if hashed {
+			c.password = password
+			c.writeCachingSha2Cache()
+			c.password = ""
+		}
+		return nil
+
+	case AUTH_SHA256_PASSWORD:
+		cont, err := c.handlePublicKeyRetrieval(authData)
+		if err != nil || !cont {
+			return err
+		}
+		password, err := c.readPlainPassword(authData, false)
+		if err != nil {
+			return err
+		}
+		return c.verifyPassword(verify, password)
+
+	default:
+		return errors.Errorf("unknown authentication plugin name '%s'", c.authPluginName)
+	}
+}
+
+func (c *Conn) verifyPassword(verify func(password string) (bool, error), password string) error {
+	ok, err := verify(password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// read the plaintext password sent by 'sha256_password' or the full authentication of 'caching_sha2_password'
+func (c *Conn) readPlainPassword(authData []byte, allowPubKeyRequest bool) (string, error) {
+	if tlsConn, ok := c.Conn.Conn.(*tls.Conn); ok {
+		if !tlsConn.ConnectionState().HandshakeComplete {
+			return "", errors.New("incomplete TSL handshake")
+		}
+		// connection is SSL/TLS, client should send plain password
+		// deal with the trailing \NUL added for plain text password received
+		if l := len(authData); l != 0 && authData[l-1] == 0x00 {
+			authData = authData[:l-1]
+		}
+		return string(authData), nil
+	}
+
+	if c.serverConf.tlsConfig == nil || len(c.serverConf.tlsConfig.Certificates) == 0 {
+		return "", errors.New("server has no RSA key to decrypt the password, use TLS instead")
+	}
+
+	// client either request for the public key or send the encrypted password
+	if allowPubKeyRequest && len(authData) == 1 && authData[0] == 0x02 {
+		if err := c.writeAuthMoreDataPubkey(); err != nil {
+			return "", err
+		}
+		var err error
+		if authData, err = c.readAuthSwitchRequestResponse(); err != nil {
+			return "", err
+		}
+	}
+
+	key, ok := c.serverConf.tlsConfig.Certificates[0].PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("server private key is not a RSA key")
+	}
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, authData, nil)
+	if err != nil {
+		return "", err
+	}
+	// the client sends (password + \NUL) XOR salt
+	for i := range plain {
+		plain[i] ^= c.salt[i%len(c.salt)]
+	}
+	if l := len(plain); l != 0 && plain[l-1] == 0x00 {
+		plain = plain[:l-1]
+	}
+	return string(plain), nil
+}
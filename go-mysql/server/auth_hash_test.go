@@ -0,0 +1,184 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/test_util/test_keys"
+)
+
+type testHashedProvider struct {
+	*InMemoryProvider
+	hashes map[string]string
+}
+
+func (p *testHashedProvider) GetCredentialHash(username string) (string, bool, error) {
+	hash, ok := p.hashes[username]
+	return hash, ok, nil
+}
+
+type testPasswordChecker struct {
+	*InMemoryProvider
+}
+
+func (p *testPasswordChecker) CheckPassword(username string, password string) (bool, error) {
+	expected, found, err := p.GetCredential(username)
+	return found && expected == password, err
+}
+
+// start a server accepting connections, returns the address
+func startAuthServer(t *testing.T, serverConf *Server, p CredentialProvider) string {
+	return startTestServer(t, serverConf, p, EmptyHandler{}, nil)
+}
+
+// start a server accepting connections with the handler, returns the address.
+// wrap is applied to the accepted connections if not nil
+func startTestServer(t *testing.T, serverConf *Server, p CredentialProvider, h Handler, wrap func(net.Conn) net.Conn) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if wrap != nil {
+				conn = wrap(conn)
+			}
+			go func() {
+				c, err := NewCustomizedConn(conn, serverConf, p, h)
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestPasswordHash(t *testing.T) {
+	if hash := NativePasswordHash("123456"); hash != "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9" {
+		t.Fatalf("unexpected native password hash %s", hash)
+	}
+	for _, hash := range []string{"", NativePasswordHash("a"), CachingSha2PasswordHash("a")} {
+		if err := ValidatePasswordHash(hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, hash := range []string{"123456", "*123", "$SHA2$zz"} {
+		if err := ValidatePasswordHash(hash); err == nil {
+			t.Fatalf("hash '%s' should be invalid", hash)
+		}
+	}
+}
+
+func TestHashedCredentialProvider(t *testing.T) {
+	p := &testHashedProvider{NewInMemoryProvider(), map[string]string{
+		"native": NativePasswordHash("pass1"),
+		"sha2":   CachingSha2PasswordHash("pass2"),
+		"empty":  "",
+	}}
+
+	cases := []struct {
+		method   string
+		tls      bool
+		user     string
+		password string
+		ok       bool
+	}{
+		{mysql.AUTH_NATIVE_PASSWORD, false, "native", "pass1", true},
+		{mysql.AUTH_NATIVE_PASSWORD, false, "native", "wrong", false},
+		{mysql.AUTH_NATIVE_PASSWORD, false, "sha2", "pass2", false},
+		{mysql.AUTH_NATIVE_PASSWORD, false, "empty", "", true},
+		{mysql.AUTH_NATIVE_PASSWORD, false, "nobody", "", false},
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, false, "sha2", "pass2", true},
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, false, "sha2", "wrong", false},
+		// full authentication, the plaintext password is sent through TLS or RSA
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, true, "native", "pass1", true},
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, false, "native", "pass1", true},
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, true, "native", "wrong", false},
+		{mysql.AUTH_SHA256_PASSWORD, true, "sha2", "pass2", true},
+		{mysql.AUTH_SHA256_PASSWORD, true, "native", "wrong", false},
+	}
+	for _, c := range cases {
+		serverConf := NewServer("8.0.12", mysql.DEFAULT_COLLATION_ID, c.method, test_keys.PubPem, tlsConf)
+		addr := startAuthServer(t, serverConf, p)
+
+		conn, err := client.Connect(addr, c.user, c.password, "", func(conn *client.Conn) {
+			if c.tls {
+				conn.UseSSL(true)
+			}
+		})
+		if (err == nil) != c.ok {
+			t.Fatalf("%+v: unexpected result %v", c, err)
+		}
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestHashedCredentialProviderCache(t *testing.T) {
+	p := &testHashedProvider{NewInMemoryProvider(), map[string]string{"native": NativePasswordHash("pass1")}}
+	serverConf := NewServer("8.0.12", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_CACHING_SHA2_PASSWORD, test_keys.PubPem, tlsConf)
+	addr := startAuthServer(t, serverConf, p)
+
+	conn, err := client.Connect(addr, "native", "pass1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	cached := 0
+	serverConf.cacheShaPassword.Range(func(_, _ interface{}) bool {
+		cached++
+		return true
+	})
+	if cached != 1 {
+		t.Fatalf("the password should be cached after full authentication, got %d", cached)
+	}
+
+	serverConf.InvalidateUserCache("native")
+	serverConf.cacheShaPassword.Range(func(key, _ interface{}) bool {
+		t.Fatalf("cache %v should be invalidated", key)
+		return true
+	})
+}
+
+func TestPasswordChecker(t *testing.T) {
+	p := &testPasswordChecker{NewInMemoryProvider()}
+	p.AddUser("app", "pass")
+
+	for _, c := range []struct {
+		method   string
+		password string
+		ok       bool
+	}{
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, "pass", true},
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, "wrong", false},
+		{mysql.AUTH_SHA256_PASSWORD, "pass", true},
+		{mysql.AUTH_NATIVE_PASSWORD, "pass", false},
+	} {
+		serverConf := NewServer("8.0.12", mysql.DEFAULT_COLLATION_ID, c.method, test_keys.PubPem, tlsConf)
+		addr := startAuthServer(t, serverConf, p)
+
+		conn, err := client.Connect(addr, "app", c.password, "", func(conn *client.Conn) {
+			conn.UseSSL(true)
+		})
+		if (err == nil) != c.ok {
+			t.Fatalf("%+v: unexpected result %v", c, err)
+		}
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
@@ -18,6 +18,9 @@ func (c *Conn) handleAuthSwitchResponse() error {
 	if err != nil {
 		return err
 	}
+	if c.withoutPlainPassword() {
+		return c.compareAuthDataWithoutPassword(authData)
+	}
 
 	switch c.authPluginName {
 	case AUTH_NATIVE_PASSWORD:
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// SessionResetHandler can be implemented by a Handler to reset its session state, like the backend connections
+// of a proxy, after COM_CHANGE_USER or COM_RESET_CONNECTION. The prepared statements are closed before by HandleStmtClose.
+type SessionResetHandler interface {
+	// HandleResetSession is called with the user of the connection, it is the new user after COM_CHANGE_USER
+	HandleResetSession(user string) error
+}
+
+// resetSession closes the prepared statements and clears the transaction state
+func (c *Conn) resetSession() error {
+	for id, stmt := range c.stmts {
+		c.closeCursor(stmt)
+		if err := c.h.HandleStmtClose(stmt.Context); err != nil {
+			return err
+		}
+		delete(c.stmts, id)
+	}
+	c.ClearInTransaction()
+
+	if h, ok := c.h.(SessionResetHandler); ok {
+		return h.HandleResetSession(c.user)
+	}
+	return nil
+}
+
+func (c *Conn) handleResetConnection() error {
+	return c.resetSession()
+}
+
+// handleChangeUser re-authenticates the connection like the handshake, the auth data is scrambled with the salt
+// of the handshake and the server may switch the auth method.
+// See: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_change_user.html
+func (c *Conn) handleChangeUser(data []byte) error {
+	pos := bytes.IndexByte(data, 0x00)
+	if pos < 0 || pos+1 >= len(data) {
+		return ErrMalformPacket
+	}
+	user := string(data[:pos])
+	pos++
+
+	authLen := int(data[pos])
+	pos++
+	if pos+authLen > len(data) {
+		return ErrMalformPacket
+	}
+	authData := data[pos : pos+authLen]
+	pos += authLen
+
+	db := ""
+	if end := bytes.IndexByte(data[pos:], 0x00); end >= 0 {
+		db = string(data[pos : pos+end])
+		pos += end + 1
+	}
+
+	// skip the character set
+	pos += 2
+
+	authPluginName := AUTH_NATIVE_PASSWORD
+	if c.capability&CLIENT_PLUGIN_AUTH != 0 && pos < len(data) {
+		if end := bytes.IndexByte(data[pos:], 0x00); end >= 0 {
+			authPluginName = string(data[pos : pos+end])
+			pos += end + 1
+		}
+	}
+
+	if _, err := c.readAttributes(data, pos); err != nil {
+		return err
+	}
+
+	// the old user logs out, the session of the new user starts from scratch
+	c.logout()
+	c.loginChecked = false
+	c.loggedIn = false
+	c.logoutOnce = sync.Once{}
+
+	c.user = user
+	c.password = ""
+	c.db = ""
+	c.cachingSha2FullAuth = false
+	c.authPluginName = authPluginName
+
+	if err := c.changeUser(authData); err != nil {
+		if err == ErrAccessDenied {
+			err = NewDefaultError(ER_ACCESS_DENIED_ERROR, c.user, c.RemoteHost(), "Yes")
+		}
+		c.loginDone(err)
+		return err
+	}
+
+	if err := c.loginDone(nil); err != nil {
+		return err
+	}
+
+	if err := c.resetSession(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := c.handleAttributes(); err != nil {
+		return err
+	}
+
+	if db != "" {
+		if checker := c.serverConf.commandChecker; checker != nil {
+			if err := checker.CheckCommand(c, COM_INIT_DB, []byte(db)); err != nil {
+				return err
+			}
+		}
+		if err := c.h.UseDB(db); err != nil {
+			return err
+		}
+		c.db = db
+	}
+	return nil
+}
+
+func (c *Conn) changeUser(authData []byte) error {
+	if err := c.checkLogin(); err != nil {
+		return err
+	}
+
+	cont, err := c.handleAuthMatch(authData, 0)
+	if err != nil {
+		return err
+	}
+	if !cont {
+		return nil
+	}
+	return c.compareAuthData(c.authPluginName, authData)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/test_util/test_keys"
+)
+
+// sessionHandler records the session resets and the closed statements
+type sessionHandler struct {
+	EmptyHandler
+	resets chan string
+	closed chan struct{}
+}
+
+func (h sessionHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, 0, nil, nil
+}
+
+func (h sessionHandler) HandleStmtClose(context interface{}) error {
+	h.closed <- struct{}{}
+	return nil
+}
+
+func (h sessionHandler) HandleResetSession(user string) error {
+	h.resets <- user
+	return nil
+}
+
+func newSessionHandler() sessionHandler {
+	return sessionHandler{resets: make(chan string, 10), closed: make(chan struct{}, 10)}
+}
+
+func TestChangeUser(t *testing.T) {
+	p := &testHashedProvider{NewInMemoryProvider(), map[string]string{
+		"native": NativePasswordHash("pass1"),
+		"sha2":   CachingSha2PasswordHash("pass2"),
+		// mysql_native_password can not use the caching_sha2_password hash
+		"native2": NativePasswordHash("pass3"),
+	}}
+
+	for _, c := range []struct {
+		method string
+		tls    bool
+	}{
+		{mysql.AUTH_NATIVE_PASSWORD, false},
+		// the full authentication of caching_sha2_password through RSA and TLS
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, false},
+		{mysql.AUTH_CACHING_SHA2_PASSWORD, true},
+	} {
+		h := newSessionHandler()
+		serverConf := NewServer("8.0.12", mysql.DEFAULT_COLLATION_ID, c.method, test_keys.PubPem, tlsConf)
+		addr := startTestServer(t, serverConf, p, h, nil)
+
+		user, password := "native2", "pass3"
+		if c.method == mysql.AUTH_CACHING_SHA2_PASSWORD {
+			user, password = "sha2", "pass2"
+		}
+		conn, err := client.Connect(addr, user, password, "", func(conn *client.Conn) {
+			if c.tls {
+				conn.UseSSL(true)
+			}
+		})
+		if err != nil {
+			t.Fatalf("%+v: %v", c, err)
+		}
+
+		if _, err = conn.Prepare("select 1"); err != nil {
+			t.Fatal(err)
+		}
+		// switch between the users with different hashes, the server may switch the auth method
+		if err = conn.ChangeUser("native", "pass1", "db1"); err != nil {
+			t.Fatalf("%+v: %v", c, err)
+		}
+		if user := <-h.resets; user != "native" || len(h.closed) != 1 {
+			t.Fatalf("%+v: the session should be reset for the new user, got %s", c, user)
+		}
+		if conn.GetDB() != "db1" {
+			t.Fatalf("%+v: unexpected db %s", c, conn.GetDB())
+		}
+		if err = conn.ChangeUser(user, password, ""); err != nil {
+			t.Fatalf("%+v: %v", c, err)
+		}
+		if err = conn.Ping(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the connection is closed by the server when the authentication fails
+		if err = conn.ChangeUser("native", "wrong", ""); err == nil {
+			t.Fatalf("%+v: wrong password should fail", c)
+		}
+		if err = conn.Ping(); err == nil {
+			t.Fatalf("%+v: the connection should be closed", c)
+		}
+	}
+}
+
+func TestResetConnection(t *testing.T) {
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	h := newSessionHandler()
+	addr := startTestServer(t, NewDefaultServer(), p, h, nil)
+
+	conn, err := client.Connect(addr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err = conn.Prepare("select 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = conn.ResetConnection(); err != nil {
+		t.Fatal(err)
+	}
+	if user := <-h.resets; user != "root" || len(h.closed) != 2 {
+		t.Fatalf("the session should be reset, user %s, closed statements %d", user, len(h.closed))
+	}
+	if err = conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
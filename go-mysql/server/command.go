@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	. "github.com/daiguadaidai/dal/go-mysql/mysql"
 	"github.com/siddontang/go/hack"
@@ -30,6 +31,35 @@ type Handler interface {
 	HandleOtherCommand(cmd byte, data []byte) error
 }
 
+// StreamHandler can be implemented by a Handler to send big result sets without holding them in memory,
+// like forwarding the rows of a backend connection (client.Rows) packet by packet.
+type StreamHandler interface {
+	// HandleQueryStream is used instead of HandleQuery, it returns the rows to be sent on demand,
+	// or the result of a statement without result set when rows is nil. The rows are closed after sent.
+	HandleQueryStream(query string) (rows RowStream, r *Result, err error)
+}
+
+// RowStream is a result set read on demand, it is implemented by client.Rows. The rows are in the text protocol
+// for StreamHandler and in the binary protocol for CursorHandler.
+type RowStream interface {
+	GetFields() []*Field
+	// Next moves to the next row, it returns false when there are no more rows or an error occurs
+	Next() bool
+	// RawRow returns the current row packet without the header
+	RawRow() RowData
+	Err() error
+	Close() error
+}
+
+// MultiResultHandler can be implemented by a Handler to return several results for one COM_QUERY,
+// like a query with multiple statements (see Conn.MultiStatements) or a CALL of a stored procedure.
+// The results are sent with SERVER_MORE_RESULTS_EXISTS set in all but the last one.
+type MultiResultHandler interface {
+	// HandleMultiQuery is used instead of HandleQuery, when err is not nil it is sent after the results,
+	// e.g. the results of the statements before the failed one.
+	HandleMultiQuery(query string) (results []*Result, err error)
+}
+
 func (c *Conn) HandleCommand() error {
 	if c.Conn == nil {
 		return fmt.Errorf("connection closed")
@@ -42,10 +72,27 @@ func (c *Conn) HandleCommand() error {
 		return err
 	}
 
+	start := time.Now()
+
 	v := c.dispatch(data)
 
+	if c.resultsetFilter != nil {
+		v = c.filterResultset(data[0], v)
+	}
+
 	err = c.writeValue(v)
 
+	if c.recorder != nil {
+		cmdErr, _ := v.(error)
+		if m, ok := v.(*multiResult); ok {
+			cmdErr = m.err
+		}
+		if cmdErr == nil {
+			cmdErr = err
+		}
+		c.recorder.RecordCommand(c, data[0], data[1:], start, time.Since(start), cmdErr)
+	}
+
 	if c.Conn != nil {
 		c.ResetSequence()
 	}
@@ -61,12 +108,40 @@ func (c *Conn) dispatch(data []byte) interface{} {
 	cmd := data[0]
 	data = data[1:]
 
+	if checker := c.serverConf.commandChecker; checker != nil && cmd != COM_QUIT {
+		if err := checker.CheckCommand(c, cmd, data); err != nil {
+			return err
+		}
+	}
+
 	switch cmd {
 	case COM_QUIT:
 		c.Close()
 		c.Conn = nil
 		return noResponse{}
 	case COM_QUERY:
+		if h, ok := c.h.(LocalInfileHandler); ok {
+			if filename, ok := ParseLocalInfile(hack.String(data)); ok {
+				if r, err := c.handleLocalInfile(h, hack.String(data), filename); err != nil {
+					return err
+				} else {
+					return r
+				}
+			}
+		}
+		if h, ok := c.h.(MultiResultHandler); ok {
+			rs, err := h.HandleMultiQuery(hack.String(data))
+			return &multiResult{results: rs, err: err}
+		}
+		if h, ok := c.h.(StreamHandler); ok {
+			rows, r, err := h.HandleQueryStream(hack.String(data))
+			if err != nil {
+				return err
+			} else if rows != nil {
+				return rows
+			}
+			return r
+		}
 		if r, err := c.h.HandleQuery(hack.String(data)); err != nil {
 			return err
 		} else {
@@ -74,11 +149,23 @@ func (c *Conn) dispatch(data []byte) interface{} {
 		}
 	case COM_PING:
 		return nil
+	case COM_RESET_CONNECTION:
+		return c.handleResetConnection()
+	case COM_CHANGE_USER:
+		// the connection is closed when the authentication fails, like MySQL
+		if err := c.handleChangeUser(data); err != nil {
+			c.writeError(err)
+			c.Close()
+			c.Conn = nil
+			return noResponse{}
+		}
+		return nil
 	case COM_INIT_DB:
 		if err := c.h.UseDB(hack.String(data)); err != nil {
 			return err
 		} else {
-			return nil
+			c.db = string(data)
+			return &Result{SessionState: &SessionState{Schema: c.db}}
 		}
 	case COM_FIELD_LIST:
 		index := bytes.IndexByte(data, 0x00)
@@ -109,6 +196,12 @@ func (c *Conn) dispatch(data []byte) interface{} {
 		} else {
 			return r
 		}
+	case COM_STMT_FETCH:
+		if r, err := c.handleStmtFetch(data); err != nil {
+			return err
+		} else {
+			return r
+		}
 	case COM_STMT_CLOSE:
 		c.handleStmtClose(data)
 		return noResponse{}
@@ -128,6 +221,24 @@ func (c *Conn) dispatch(data []byte) interface{} {
 	return fmt.Errorf("command %d is not handled correctly", cmd)
 }
 
+// CommandChecker rejects commands before they reach the Handler, e.g. privilege checks.
+// The database given in the handshake is checked as a COM_INIT_DB after the authentication.
+// A COM_QUERY may contain several statements even when Conn.MultiStatements is false, all of them must be checked.
+type CommandChecker interface {
+	// CheckCommand is called with the command byte and the payload, the returned error is sent to the client.
+	// The payload must not be retained after the call returns.
+	CheckCommand(c *Conn, cmd byte, data []byte) error
+}
+
+// CommandRecorder is notified after every command handled by a connection,
+// it can be used to capture the client traffic for replaying later.
+type CommandRecorder interface {
+	// RecordCommand is called with the command byte and the payload after the response is sent,
+	// err is the error returned to the client, or the error when sending the response.
+	// The payload must not be retained after the call returns.
+	RecordCommand(c *Conn, cmd byte, data []byte, start time.Time, duration time.Duration, err error)
+}
+
 type EmptyHandler struct {
 }
 
@@ -158,3 +269,53 @@ func (h EmptyHandler) HandleOtherCommand(cmd byte, data []byte) error {
 		fmt.Sprintf("command %d is not supported now", cmd),
 	)
 }
+
+// ResultsetFilter can rewrite a resultset before it is sent to the client,
+// like masking some columns.
+type ResultsetFilter interface {
+	// FilterResultset returns the resultset to send, binary is true when the rows are in the
+	// binary protocol (the response of COM_STMT_EXECUTE). The resultset returned by the Handler
+	// may be shared, so it must not be modified in place.
+	FilterResultset(c *Conn, r *Resultset, binary bool) (*Resultset, error)
+}
+
+// ResultsetFilters chains several filters, they are applied in order.
+type ResultsetFilters []ResultsetFilter
+
+func (fs ResultsetFilters) FilterResultset(c *Conn, r *Resultset, binary bool) (*Resultset, error) {
+	var err error
+	for _, f := range fs {
+		if r, err = f.FilterResultset(c, r, binary); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (c *Conn) filterResultset(cmd byte, v interface{}) interface{} {
+	if m, ok := v.(*multiResult); ok {
+		filtered := &multiResult{results: make([]*Result, 0, len(m.results)), err: m.err}
+		for _, r := range m.results {
+			fv := c.filterResultset(cmd, r)
+			if err, ok := fv.(error); ok {
+				return err
+			}
+			filtered.results = append(filtered.results, fv.(*Result))
+		}
+		return filtered
+	}
+
+	r, ok := v.(*Result)
+	if !ok || r == nil || r.Resultset == nil {
+		return v
+	}
+
+	rs, err := c.resultsetFilter.FilterResultset(c, r.Resultset, cmd == COM_STMT_EXECUTE)
+	if err != nil {
+		return err
+	}
+
+	filtered := *r
+	filtered.Resultset = rs
+	return &filtered
+}
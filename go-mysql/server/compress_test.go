@@ -0,0 +1,175 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// countingConn counts the bytes written by the server
+type countingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+type wideHandler struct {
+	EmptyHandler
+}
+
+func (h wideHandler) HandleQuery(query string) (*mysql.Result, error) {
+	return h.result(query, false)
+}
+
+func (h wideHandler) result(query string, binary bool) (*mysql.Result, error) {
+	var values [][]interface{}
+	if query == "select wide" {
+		for i := 0; i < 1000; i++ {
+			values = append(values, []interface{}{int64(i), strings.Repeat("dal", 100)})
+		}
+	} else {
+		// echo the length of the query, used to test the packets larger than MaxPayloadLen
+		values = [][]interface{}{{int64(len(query)), query[len(query)-3:]}}
+	}
+	rs, err := mysql.BuildSimpleResultset([]string{"id", "name"}, values, binary)
+	if err != nil {
+		return nil, err
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+func (h wideHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, 2, nil, nil
+}
+
+func (h wideHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return h.result(query, true)
+}
+
+func (h wideHandler) HandleStmtClose(context interface{}) error {
+	return nil
+}
+
+func startCompressServer(t *testing.T, algorithms ...string) (string, *int64) {
+	serverConf := NewDefaultServer()
+	if err := serverConf.SetCompressionAlgorithms(algorithms...); err != nil {
+		t.Fatal(err)
+	}
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+
+	written := new(int64)
+	addr := startTestServer(t, serverConf, p, wideHandler{}, func(conn net.Conn) net.Conn {
+		return countingConn{Conn: conn, written: written}
+	})
+	return addr, written
+}
+
+// the bytes written by the server for the wide result set
+func wideResultBytes(t *testing.T, conn *client.Conn, written *int64) int64 {
+	before := atomic.LoadInt64(written)
+	r, err := conn.Execute("select wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Values) != 1000 {
+		t.Fatalf("expected 1000 rows, got %d", len(r.Values))
+	}
+	if name, _ := r.GetString(999, 1); name != strings.Repeat("dal", 100) {
+		t.Fatalf("unexpected value %s", name)
+	}
+	return atomic.LoadInt64(written) - before
+}
+
+func TestCompression(t *testing.T) {
+	addr, written := startCompressServer(t, mysql.COMPRESSION_ZLIB, mysql.COMPRESSION_ZSTD)
+
+	plain, err := client.Connect(addr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if plain.Compression() != "" {
+		t.Fatalf("compression is not requested, got %s", plain.Compression())
+	}
+	plainBytes := wideResultBytes(t, plain, written)
+
+	for _, algorithm := range []string{mysql.COMPRESSION_ZLIB, mysql.COMPRESSION_ZSTD} {
+		conn, err := client.Connect(addr, "root", "123", "", func(c *client.Conn) {
+			c.UseCompression(algorithm, 0)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if conn.Compression() != algorithm {
+			t.Fatalf("expected %s, got %s", algorithm, conn.Compression())
+		}
+
+		compressedBytes := wideResultBytes(t, conn, written)
+		if compressedBytes*5 > plainBytes {
+			t.Fatalf("%s: the compressed result set takes %d bytes, plain %d bytes", algorithm, compressedBytes, plainBytes)
+		}
+
+		// COM_STMT_CLOSE has no response, the next command must start a new compressed sequence
+		for i := 0; i < 3; i++ {
+			stmt, err := conn.Prepare("select wide")
+			if err != nil {
+				t.Fatalf("%s: %v", algorithm, err)
+			}
+			if _, err = stmt.Execute(); err != nil {
+				t.Fatalf("%s: %v", algorithm, err)
+			}
+			if err = stmt.Close(); err != nil {
+				t.Fatalf("%s: %v", algorithm, err)
+			}
+		}
+		if err = conn.Ping(); err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+
+		// the packet larger than MaxPayloadLen is split into several compressed packets
+		query := "select '" + strings.Repeat("x", mysql.MaxPayloadLen+100) + "end'"
+		r, err := conn.Execute(query)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if n, _ := r.GetInt(0, 0); n != int64(len(query)) {
+			t.Fatalf("%s: expected query length %d, got %d", algorithm, len(query), n)
+		}
+
+		if err = conn.Close(); err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+	}
+}
+
+func TestCompressionNotSupported(t *testing.T) {
+	addr, _ := startCompressServer(t, mysql.COMPRESSION_ZLIB)
+
+	conn, err := client.Connect(addr, "root", "123", "", func(c *client.Conn) {
+		c.UseCompression(mysql.COMPRESSION_ZSTD, 3)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Compression() != "" {
+		t.Fatalf("zstd is not allowed by the server, got %s", conn.Compression())
+	}
+	if _, err = conn.Execute("select wide"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = NewDefaultServer().SetCompressionAlgorithms("lz4"); err == nil {
+		t.Fatal("lz4 is not supported")
+	}
+}
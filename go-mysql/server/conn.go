@@ -2,6 +2,7 @@ package server
 
 import (
 	"net"
+	"sync"
 	"sync/atomic"
 
 	. "github.com/daiguadaidai/dal/go-mysql/mysql"
@@ -22,13 +23,27 @@ type Conn struct {
 	status         uint16
 	salt           []byte // should be 8 + 12 for auth-plugin-data-part-1 and auth-plugin-data-part-2
 
+	// compressed protocol negotiated in the handshake, used after the authentication
+	compression      string
+	compressionLevel int
+
 	credentialProvider  CredentialProvider
 	user                string
+	db                  string
 	password            string
 	cachingSha2FullAuth bool
+	attributes          map[string]string
+
+	loginChecked bool
+	loggedIn     bool
+	loginHost    string
+	logoutOnce   sync.Once
 
 	h Handler
 
+	recorder        CommandRecorder
+	resultsetFilter ResultsetFilter
+
 	stmts  map[uint32]*Stmt
 	stmtID uint32
 
@@ -90,24 +105,55 @@ func (c *Conn) handshake() error {
 
 	if err := c.readHandshakeResponse(); err != nil {
 		if err == ErrAccessDenied {
-			err = NewDefaultError(ER_ACCESS_DENIED_ERROR, c.user, c.LocalAddr().String(), "Yes")
+			err = NewDefaultError(ER_ACCESS_DENIED_ERROR, c.user, c.RemoteHost(), "Yes")
 		}
+		c.loginDone(err)
+		c.writeError(err)
+		return err
+	}
+
+	if err := c.loginDone(nil); err != nil {
+		c.writeError(err)
+		return err
+	}
+
+	if err := c.handleAttributes(); err != nil {
 		c.writeError(err)
 		return err
 	}
 
+	if checker := c.serverConf.commandChecker; checker != nil && c.db != "" {
+		if err := checker.CheckCommand(c, COM_INIT_DB, []byte(c.db)); err != nil {
+			c.writeError(err)
+			return err
+		}
+	}
+
 	if err := c.writeOK(nil); err != nil {
 		return err
 	}
 
 	c.ResetSequence()
 
+	// both sides switch to the compressed protocol after the OK packet
+	if c.compression != "" {
+		if err := c.SetCompression(c.compression, c.compressionLevel); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (c *Conn) Close() {
+	c.logout()
+	for _, s := range c.stmts {
+		c.closeCursor(s)
+	}
 	c.closed.Set(true)
-	c.Conn.Close()
+	if c.Conn != nil {
+		c.Conn.Close()
+	}
 }
 
 func (c *Conn) Closed() bool {
@@ -118,10 +164,59 @@ func (c *Conn) GetUser() string {
 	return c.user
 }
 
+// RemoteHost returns the host part of the client address. When the listener accepts PROXY protocol
+// headers the net.Conn reports the real client address instead of the load balancer's.
+func (c *Conn) RemoteHost() string {
+	addr := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// GetDB returns the current database, it is updated by the handshake and COM_INIT_DB,
+// handlers executing USE statements should call SetDB
+func (c *Conn) GetDB() string {
+	return c.db
+}
+
+func (c *Conn) SetDB(db string) {
+	c.db = db
+}
+
+// MultiStatements reports whether the client allows several statements in one COM_QUERY,
+// they should be returned by a MultiResultHandler
+func (c *Conn) MultiStatements() bool {
+	return c.capability&CLIENT_MULTI_STATEMENTS != 0
+}
+
+// serverCapability returns the capability flags advertised to the client. Multiple statements and results are
+// only advertised when the handler is a MultiResultHandler, LOAD DATA LOCAL INFILE when it is a LocalInfileHandler.
+func (c *Conn) serverCapability() uint32 {
+	capability := c.serverConf.capability
+	if _, ok := c.h.(MultiResultHandler); !ok {
+		capability &^= CLIENT_MULTI_STATEMENTS | CLIENT_MULTI_RESULTS | CLIENT_PS_MULTI_RESULTS
+	}
+	if _, ok := c.h.(LocalInfileHandler); !ok {
+		capability &^= CLIENT_LOCAL_FILES
+	}
+	return capability
+}
+
 func (c *Conn) ConnectionID() uint32 {
 	return c.connectionID
 }
 
+// SetCommandRecorder: record every command handled by this connection, nil to stop recording
+func (c *Conn) SetCommandRecorder(r CommandRecorder) {
+	c.recorder = r
+}
+
+// SetResultsetFilter: rewrite the resultsets before sending them to the client, nil to disable
+func (c *Conn) SetResultsetFilter(f ResultsetFilter) {
+	c.resultsetFilter = f
+}
+
 func (c *Conn) IsAutoCommit() bool {
 	return c.status&SERVER_STATUS_AUTOCOMMIT > 0
 }
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// CursorHandler can be implemented by a Handler to page big result sets with the read-only cursors,
+// the rows are read from the RowStream when the client fetches them by COM_STMT_FETCH.
+// Without it the cursors page the result set returned by HandleStmtExecute.
+type CursorHandler interface {
+	// HandleStmtExecuteCursor is used instead of HandleStmtExecute when the client opens a cursor, the rows
+	// are in the binary protocol, like client.Stmt.ExecuteRows of a backend connection. It returns the result
+	// of a statement without result set when rows is nil. The rows are closed with the cursor.
+	HandleStmtExecuteCursor(context interface{}, query string, args []interface{}) (rows RowStream, r *Result, err error)
+}
+
+// resultsetRows is the RowStream of a result set in memory
+type resultsetRows struct {
+	r *Resultset
+	i int
+}
+
+func (r *resultsetRows) GetFields() []*Field {
+	return r.r.Fields
+}
+
+func (r *resultsetRows) Next() bool {
+	if r.i >= len(r.r.RowDatas) {
+		return false
+	}
+	r.i++
+	return true
+}
+
+func (r *resultsetRows) RawRow() RowData {
+	return r.r.RowDatas[r.i-1]
+}
+
+func (r *resultsetRows) Err() error {
+	return nil
+}
+
+func (r *resultsetRows) Close() error {
+	return nil
+}
+
+// cursorHeader is the response of COM_STMT_EXECUTE opening a cursor, only the columns are sent
+type cursorHeader struct {
+	s    *Stmt
+	rows RowStream
+}
+
+// cursorFetch is the response of COM_STMT_FETCH
+type cursorFetch struct {
+	s     *Stmt
+	count uint32
+}
+
+func (c *Conn) openCursor(s *Stmt) (interface{}, error) {
+	if h, ok := c.h.(CursorHandler); ok {
+		rows, r, err := h.HandleStmtExecuteCursor(s.Context, s.Query, s.Args)
+		if err != nil {
+			return nil, err
+		} else if rows == nil {
+			return r, nil
+		}
+		return &cursorHeader{s: s, rows: rows}, nil
+	}
+
+	r, err := c.h.HandleStmtExecute(s.Context, s.Query, s.Args)
+	if err != nil {
+		return nil, err
+	} else if r == nil || r.Resultset == nil {
+		return r, nil
+	}
+	return &cursorHeader{s: s, rows: &resultsetRows{r: r.Resultset}}, nil
+}
+
+func (c *Conn) closeCursor(s *Stmt) {
+	if s.cursor != nil {
+		s.cursor.Close()
+		s.cursor = nil
+	}
+}
+
+func (c *Conn) handleStmtFetch(data []byte) (interface{}, error) {
+	if len(data) < 8 {
+		return nil, ErrMalformPacket
+	}
+
+	id := binary.LittleEndian.Uint32(data[0:4])
+	s, ok := c.stmts[id]
+	if !ok {
+		return nil, NewDefaultError(ER_UNKNOWN_STMT_HANDLER,
+			strconv.FormatUint(uint64(id), 10), "stmt_fetch")
+	}
+	if s.cursor == nil {
+		return nil, NewError(ER_STMT_HAS_NO_OPEN_CURSOR, fmt.Sprintf("The statement (%d) has no open cursor.", id))
+	}
+
+	return &cursorFetch{s: s, count: binary.LittleEndian.Uint32(data[4:8])}, nil
+}
+
+// writeCursorHeader writes the columns with SERVER_STATUS_CURSOR_EXISTS, the rows are sent by writeCursorFetch
+func (c *Conn) writeCursorHeader(h *cursorHeader) error {
+	fields, err := c.filterFields(h.rows.GetFields(), true)
+	if err != nil {
+		h.rows.Close()
+		return c.writeError(err)
+	}
+
+	h.s.cursor = h.rows
+	c.status |= SERVER_STATUS_CURSOR_EXISTS
+	defer func() { c.status &^= SERVER_STATUS_CURSOR_EXISTS }()
+	if err = c.writeResultsetFields(make([]byte, 4, 1024), fields); err != nil {
+		return err
+	}
+	// the columns are always followed by the status, the OK packet with CLIENT_DEPRECATE_EOF
+	if c.capability&CLIENT_DEPRECATE_EOF != 0 {
+		return c.writeResultsetEnd(nil)
+	}
+	return nil
+}
+
+// writeCursorFetch writes at most count rows, the cursor is closed with SERVER_STATUS_LAST_ROW_SEND after the last row
+func (c *Conn) writeCursorFetch(f *cursorFetch) error {
+	rows := f.s.cursor
+	fields := rows.GetFields()
+
+	data := make([]byte, 4, 1024)
+	var sent uint32
+	for ; sent < f.count && rows.Next(); sent++ {
+		row, err := c.filterRow(fields, rows.RawRow(), true)
+		if err != nil {
+			c.closeCursor(f.s)
+			return c.writeError(err)
+		}
+
+		data = data[0:4]
+		data = append(data, row...)
+		if err := c.WritePacket(data); err != nil {
+			return err
+		}
+	}
+
+	status := SERVER_STATUS_CURSOR_EXISTS
+	if sent < f.count {
+		if err := rows.Err(); err != nil {
+			c.closeCursor(f.s)
+			return c.writeError(err)
+		}
+		status = SERVER_STATUS_LAST_ROW_SEND
+		c.closeCursor(f.s)
+	}
+
+	c.status |= status
+	err := c.writeResultsetEnd(nil)
+	c.status &^= status
+	return err
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// fetchCounter counts COM_STMT_FETCH
+type fetchCounter struct {
+	fetches int64
+}
+
+func (f *fetchCounter) CheckCommand(c *Conn, cmd byte, data []byte) error {
+	if cmd == mysql.COM_STMT_FETCH {
+		atomic.AddInt64(&f.fetches, 1)
+	}
+	return nil
+}
+
+// cursorProxyHandler prepares the statements on the backend and pages the backend rows with the cursors
+type cursorProxyHandler struct {
+	EmptyHandler
+	backend *client.Conn
+}
+
+func (h cursorProxyHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	s, err := h.backend.Prepare(query)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return s.ParamNum(), s.ColumnNum(), s, nil
+}
+
+func (h cursorProxyHandler) HandleStmtExecuteCursor(context interface{}, query string, args []interface{}) (RowStream, *mysql.Result, error) {
+	rows, err := context.(*client.Stmt).ExecuteRows(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rows, nil, nil
+}
+
+func (h cursorProxyHandler) HandleStmtClose(context interface{}) error {
+	return context.(*client.Stmt).Close()
+}
+
+func readWideRows(t *testing.T, rows *client.Rows) int {
+	count := 0
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		if id != int64(count) || name != strings.Repeat("dal", 100) {
+			t.Fatalf("unexpected row %d: %d, %s", count, id, name)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCursor(t *testing.T) {
+	serverConf := NewDefaultServer()
+	counter := new(fetchCounter)
+	serverConf.SetCommandChecker(counter)
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	addr := startTestServer(t, serverConf, p, wideHandler{}, nil)
+
+	conn, err := client.Connect(addr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.Prepare("select wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the result set of HandleStmtExecute is paged, the last fetch returns no rows
+	rows, err := s.ExecuteCursor(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := readWideRows(t, rows); count != 1000 {
+		t.Fatalf("expect 1000 rows, got %d", count)
+	}
+	if fetches := atomic.LoadInt64(&counter.fetches); fetches != 11 {
+		t.Fatalf("expect 11 fetches, got %d", fetches)
+	}
+	if rows.Status&mysql.SERVER_STATUS_LAST_ROW_SEND == 0 {
+		t.Fatal("the last fetch should set SERVER_STATUS_LAST_ROW_SEND")
+	}
+
+	// closing the rows closes the cursor without fetching the remaining rows
+	if rows, err = s.ExecuteCursor(100); err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	if err = rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fetches := atomic.LoadInt64(&counter.fetches); fetches != 12 {
+		t.Fatalf("expect 12 fetches, got %d", fetches)
+	}
+
+	// executing again without cursor
+	r, err := s.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 1000 {
+		t.Fatalf("expect 1000 rows, got %d", r.RowNumber())
+	}
+	s.Close()
+}
+
+func TestCursorHandler(t *testing.T) {
+	backend, err := client.Connect(startWideServer(t), "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	addr := startTestServer(t, NewDefaultServer(), p, cursorProxyHandler{backend: backend}, nil)
+
+	conn, err := client.Connect(addr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := conn.Prepare("select wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, fetchSize := range []int{7, 1000, 5000} {
+		rows, err := s.ExecuteCursor(fetchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := readWideRows(t, rows); count != 1000 {
+			t.Fatalf("fetch size %d: expect 1000 rows, got %d", fetchSize, count)
+		}
+	}
+
+	// the backend rows are drained when the cursor is closed in the middle
+	rows, err := s.ExecuteCursor(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	rows.Close()
+	if err = conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err = backend.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// warningHandler reports warnings with the result sets, "select none" returns no rows
+type warningHandler struct {
+	wideHandler
+}
+
+func (h warningHandler) HandleQuery(query string) (*mysql.Result, error) {
+	return h.result(query, false)
+}
+
+func (h warningHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return h.result(query, true)
+}
+
+func (h warningHandler) result(query string, binary bool) (*mysql.Result, error) {
+	if query == "select none" {
+		rs, err := mysql.BuildSimpleResultset([]string{"id", "name"}, nil, binary)
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.Result{Resultset: rs}, nil
+	}
+
+	r, err := h.wideHandler.result(query, binary)
+	if err != nil {
+		return nil, err
+	}
+	r.Warnings = 3
+	return r, nil
+}
+
+func TestDeprecateEOF(t *testing.T) {
+	for _, deprecateEOF := range []bool{true, false} {
+		serverConf := NewDefaultServer()
+		if !deprecateEOF {
+			serverConf.capability &^= mysql.CLIENT_DEPRECATE_EOF
+		}
+		p := NewInMemoryProvider()
+		p.AddUser("root", "123")
+		addr := startTestServer(t, serverConf, p, warningHandler{}, nil)
+
+		conn, err := client.Connect(addr, "root", "123", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the warnings are reported by the packet following the rows
+		r, err := conn.Execute("select wide")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.RowNumber() != 1000 || r.Warnings != 3 {
+			t.Fatalf("deprecate eof %v: unexpected result, %d rows, %d warnings", deprecateEOF, r.RowNumber(), r.Warnings)
+		}
+
+		if r, err = conn.Execute("select none"); err != nil {
+			t.Fatal(err)
+		}
+		if r.ColumnNumber() != 2 || r.RowNumber() != 0 {
+			t.Fatalf("deprecate eof %v: unexpected empty result set", deprecateEOF)
+		}
+
+		rows, err := conn.ExecuteRows("select wide")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := readWideRows(t, rows); count != 1000 || rows.Warnings != 3 {
+			t.Fatalf("deprecate eof %v: unexpected rows, %d rows, %d warnings", deprecateEOF, count, rows.Warnings)
+		}
+
+		// the columns of the prepared statements and the cursors
+		for _, query := range []string{"select wide", "select none"} {
+			s, err := conn.Prepare(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r, err = s.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			expect := 1000
+			if query == "select none" {
+				expect = 0
+			}
+			if r.RowNumber() != expect {
+				t.Fatalf("deprecate eof %v: %s: expect %d rows, got %d", deprecateEOF, query, expect, r.RowNumber())
+			}
+
+			rows, err := s.ExecuteCursor(300)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := readWideRows(t, rows); count != expect {
+				t.Fatalf("deprecate eof %v: %s: expect %d rows by cursor, got %d", deprecateEOF, query, expect, count)
+			}
+			s.Close()
+		}
+
+		if err = conn.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+}
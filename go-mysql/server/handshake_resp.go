@@ -14,9 +14,13 @@ func (c *Conn) readHandshakeResponse() error {
 	if err != nil {
 		return err
 	}
+	c.readCompression(data)
 	if pos, err = c.readUserName(data, pos); err != nil {
 		return err
 	}
+	if err = c.checkLogin(); err != nil {
+		return err
+	}
 	authData, authLen, pos, err := c.readAuthData(data, pos)
 	if err != nil {
 		return err
@@ -30,6 +34,10 @@ func (c *Conn) readHandshakeResponse() error {
 
 	pos = c.readPluginName(data, pos)
 
+	if pos, err = c.readAttributes(data, pos); err != nil {
+		return err
+	}
+
 	cont, err := c.handleAuthMatch(authData, pos)
 	if err != nil {
 		return err
@@ -38,8 +46,6 @@ func (c *Conn) readHandshakeResponse() error {
 		return nil
 	}
 
-	// ignore connect attrs for now, the proxy does not support passing attrs to actual MySQL server
-
 	// try to authenticate the client
 	return c.compareAuthData(c.authPluginName, authData)
 }
@@ -59,6 +65,8 @@ func (c *Conn) readFirstPart() ([]byte, int, error) {
 
 	//capability
 	c.capability = binary.LittleEndian.Uint32(data[:4])
+	// ignore the flags not advertised because the handler does not support them
+	c.capability &^= c.serverConf.capability &^ c.serverCapability()
 	if c.capability&CLIENT_SECURE_CONNECTION == 0 {
 		return nil, 0, errors.New("CLIENT_SECURE_CONNECTION compatible client is required")
 	}
@@ -92,6 +100,20 @@ func (c *Conn) readFirstPart() ([]byte, int, error) {
 	return data, pos, nil
 }
 
+// readCompression picks the compression algorithm requested by the client and allowed by the server,
+// the connection switches to it after the authentication.
+func (c *Conn) readCompression(data []byte) {
+	c.compression = ""
+	switch {
+	case c.capability&CLIENT_ZSTD_COMPRESSION_ALGORITHM != 0 && c.serverConf.capability&CLIENT_ZSTD_COMPRESSION_ALGORITHM != 0:
+		c.compression = COMPRESSION_ZSTD
+		// the zstd compression level is the last field of the handshake response
+		c.compressionLevel = int(data[len(data)-1])
+	case c.capability&CLIENT_COMPRESS != 0 && c.serverConf.capability&CLIENT_COMPRESS != 0:
+		c.compression = COMPRESSION_ZLIB
+	}
+}
+
 func (c *Conn) readUserName(data []byte, pos int) (int, error) {
 	//user name
 	user := string(data[pos : pos+bytes.IndexByte(data[pos:], 0x00)])
@@ -112,6 +134,7 @@ func (c *Conn) readDb(data []byte, pos int) (int, error) {
 		if err := c.h.UseDB(db); err != nil {
 			return 0, err
 		}
+		c.db = db
 	}
 	return pos, nil
 }
@@ -119,7 +142,7 @@ func (c *Conn) readDb(data []byte, pos int) (int, error) {
 func (c *Conn) readPluginName(data []byte, pos int) int {
 	if c.capability&CLIENT_PLUGIN_AUTH != 0 {
 		c.authPluginName = string(data[pos : pos+bytes.IndexByte(data[pos:], 0x00)])
-		pos += len(c.authPluginName)
+		pos += len(c.authPluginName) + 1
 	} else {
 		// The method used is Native Authentication if both CLIENT_PROTOCOL_41 and CLIENT_SECURE_CONNECTION are set,
 		// but CLIENT_PLUGIN_AUTH is not set, so we fallback to 'mysql_native_password'
@@ -139,7 +162,7 @@ func (c *Conn) readAuthData(data []byte, pos int) ([]byte, int, int, error) {
 		}
 		if isNULL {
 			// no auth length and no auth data, just \NUL, considered invalid auth data, and reject connection as MySQL does
-			return nil, 0, 0, NewDefaultError(ER_ACCESS_DENIED_ERROR, c.LocalAddr().String(), c.user, "Yes")
+			return nil, 0, 0, NewDefaultError(ER_ACCESS_DENIED_ERROR, c.user, c.RemoteHost(), "Yes")
 		}
 		auth = authData
 		authLen = readBytes
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	. "github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// LocalInfileHandler can be implemented by a Handler to support LOAD DATA LOCAL INFILE,
+// the file is requested from the client and streamed to the handler.
+type LocalInfileHandler interface {
+	// HandleLocalInfile is used instead of HandleQuery for LOAD DATA LOCAL INFILE, filename is the file in the query
+	// and r reads its content from the client, the content not read is discarded after the call.
+	// A proxy can forward it by client.Conn.RegisterLocalInfileReader with the same file name.
+	HandleLocalInfile(query string, filename string, r io.Reader) (*Result, error)
+}
+
+var localInfileRe = regexp.MustCompile(`(?is)^\s*LOAD\s+(?:DATA|XML)\s+(?:LOW_PRIORITY\s+|CONCURRENT\s+)?LOCAL\s+INFILE\s+('(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*")`)
+
+// ParseLocalInfile returns the file name of a LOAD DATA LOCAL INFILE query
+func ParseLocalInfile(query string) (string, bool) {
+	items := localInfileRe.FindStringSubmatch(query)
+	if items == nil {
+		return "", false
+	}
+
+	quoted := items[1]
+	quote := quoted[0]
+	quoted = quoted[1 : len(quoted)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		ch := quoted[i]
+		switch {
+		case ch == '\\' && i+1 < len(quoted):
+			i++
+			switch quoted[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			default:
+				b.WriteByte(quoted[i])
+			}
+		case ch == quote && i+1 < len(quoted) && quoted[i+1] == quote:
+			i++
+			b.WriteByte(ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String(), true
+}
+
+// localInfileReader reads the file packets sent by the client until the empty packet
+type localInfileReader struct {
+	c    *Conn
+	buf  []byte
+	done bool
+	err  error
+}
+
+func (r *localInfileReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+
+		data, err := r.c.ReadPacket()
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		if len(data) == 0 {
+			r.done = true
+		}
+		r.buf = data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (c *Conn) handleLocalInfile(h LocalInfileHandler, query string, filename string) (*Result, error) {
+	if c.capability&CLIENT_LOCAL_FILES == 0 {
+		return nil, NewDefaultError(ER_NOT_ALLOWED_COMMAND)
+	}
+
+	data := make([]byte, 4, 5+len(filename))
+	data = append(data, LocalInFile_HEADER)
+	data = append(data, filename...)
+	if err := c.WritePacket(data); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	r := &localInfileReader{c: c}
+	result, err := h.HandleLocalInfile(query, filename, r)
+
+	// the rest of the file must be read before sending the result
+	if _, cerr := io.Copy(io.Discard, r); cerr != nil {
+		return nil, errors.Trace(cerr)
+	}
+	return result, err
+}
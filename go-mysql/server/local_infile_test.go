@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// loadHandler counts the lines of the file
+type loadHandler struct {
+	EmptyHandler
+	content *bytes.Buffer
+}
+
+func (h loadHandler) HandleLocalInfile(query string, filename string, r io.Reader) (*mysql.Result, error) {
+	h.content.Reset()
+	if _, err := io.Copy(h.content, r); err != nil {
+		return nil, err
+	}
+	return &mysql.Result{AffectedRows: uint64(bytes.Count(h.content.Bytes(), []byte("\n")))}, nil
+}
+
+// loadProxyHandler forwards LOAD DATA LOCAL INFILE to the backend
+type loadProxyHandler struct {
+	EmptyHandler
+	backend *client.Conn
+}
+
+func (h loadProxyHandler) HandleLocalInfile(query string, filename string, r io.Reader) (*mysql.Result, error) {
+	h.backend.RegisterLocalInfileReader(filename, func() (io.Reader, error) { return r, nil })
+	defer h.backend.UnregisterLocalInfileReader(filename)
+	return h.backend.Execute(query)
+}
+
+func TestParseLocalInfile(t *testing.T) {
+	cases := map[string]string{
+		"LOAD DATA LOCAL INFILE '/tmp/a.csv' INTO TABLE t":                   "/tmp/a.csv",
+		"load data low_priority local infile \"/tmp/it's.csv\" into table t": "/tmp/it's.csv",
+		"  LOAD XML CONCURRENT LOCAL INFILE 'a''b\\\\c.xml' INTO TABLE t":    "a'b\\c.xml",
+		"LOAD DATA INFILE '/tmp/a.csv' INTO TABLE t":                         "",
+		"SELECT 'LOAD DATA LOCAL INFILE \\'/tmp/a.csv\\''":                   "",
+	}
+	for query, expect := range cases {
+		filename, ok := ParseLocalInfile(query)
+		if ok != (expect != "") || filename != expect {
+			t.Fatalf("%s: expect '%s', got '%s'", query, expect, filename)
+		}
+	}
+}
+
+func TestLocalInfile(t *testing.T) {
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	h := loadHandler{content: new(bytes.Buffer)}
+	backendAddr := startTestServer(t, NewDefaultServer(), p, h, nil)
+
+	dir, err := ioutil.TempDir("", "local_infile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("1,dal\n", 20000)
+	if err = ioutil.WriteFile(filepath.Join(dir, "data.csv"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := client.Connect(backendAddr, "root", "123", "", func(c *client.Conn) { c.AllowLocalFiles(dir) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the allowed file, larger than one packet
+	r, err := conn.Execute("LOAD DATA LOCAL INFILE '" + filepath.Join(dir, "data.csv") + "' INTO TABLE t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 20000 || h.content.String() != content {
+		t.Fatalf("unexpected file received, %d lines", r.AffectedRows)
+	}
+
+	// the files not allowed are not sent, the connection is still usable
+	if _, err = conn.Execute("LOAD DATA LOCAL INFILE '/etc/passwd' INTO TABLE t"); err == nil {
+		t.Fatal("the file not allowed should not be sent")
+	}
+	if h.content.Len() != 0 {
+		t.Fatal("the server should receive an empty file")
+	}
+	if err = conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// pass through a proxy with a registered reader
+	backend, err := client.Connect(backendAddr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	proxyAddr := startTestServer(t, NewDefaultServer(), p, loadProxyHandler{backend: backend}, nil)
+
+	front, err := client.Connect(proxyAddr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer front.Close()
+	front.RegisterLocalInfileReader("Reader::data", func() (io.Reader, error) {
+		return strings.NewReader("a\nb\n"), nil
+	})
+	if r, err = front.Execute("LOAD DATA LOCAL INFILE 'Reader::data' INTO TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+	if r.AffectedRows != 2 || h.content.String() != "a\nb\n" {
+		t.Fatalf("unexpected file received through the proxy, %d lines", r.AffectedRows)
+	}
+}
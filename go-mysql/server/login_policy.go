@@ -0,0 +1,51 @@
+package server
+
+// LoginPolicy restricts which clients may log in, it is consulted during the handshake.
+type LoginPolicy interface {
+	// CheckLogin is called once the user name is read and before the password is verified,
+	// e.g. to reject clients from disallowed hosts or locked accounts.
+	CheckLogin(user string, host string) error
+	// LoginDone is called with the authentication result, nil on success. Returning an error after a successful
+	// authentication still rejects the connection, e.g. when the user already has too many connections.
+	LoginDone(user string, host string, authErr error) error
+	// Logout is called when a connection accepted by LoginDone is closed.
+	Logout(user string, host string)
+}
+
+// SetLoginPolicy: set the login policy of the connections created after, nil to disable
+func (s *Server) SetLoginPolicy(p LoginPolicy) {
+	s.loginPolicy = p
+}
+
+func (c *Conn) checkLogin() error {
+	if c.serverConf.loginPolicy == nil {
+		return nil
+	}
+	if err := c.serverConf.loginPolicy.CheckLogin(c.user, c.RemoteHost()); err != nil {
+		return err
+	}
+	c.loginChecked = true
+	return nil
+}
+
+func (c *Conn) loginDone(authErr error) error {
+	if c.serverConf.loginPolicy == nil || !c.loginChecked {
+		return nil
+	}
+	host := c.RemoteHost()
+	if err := c.serverConf.loginPolicy.LoginDone(c.user, host, authErr); err != nil || authErr != nil {
+		return err
+	}
+	c.loginHost = host
+	c.loggedIn = true
+	return nil
+}
+
+func (c *Conn) logout() {
+	if c.serverConf.loginPolicy == nil || !c.loggedIn {
+		return
+	}
+	c.logoutOnce.Do(func() {
+		c.serverConf.loginPolicy.Logout(c.user, c.loginHost)
+	})
+}
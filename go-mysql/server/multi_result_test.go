@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// multiHandler splits the query by ';', "select N" returns N as a result set, "call p" returns
+// two result sets and the status of the procedure, "fail" returns an error
+type multiHandler struct {
+	EmptyHandler
+}
+
+func (h multiHandler) HandleMultiQuery(query string) ([]*mysql.Result, error) {
+	var results []*mysql.Result
+	for _, stmt := range strings.Split(query, ";") {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case stmt == "fail":
+			return results, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "statement failed")
+		case stmt == "call p":
+			for i := 1; i <= 2; i++ {
+				r, err := selectResult(fmt.Sprint(i))
+				if err != nil {
+					return results, err
+				}
+				results = append(results, r)
+			}
+			results = append(results, &mysql.Result{})
+		case strings.HasPrefix(stmt, "select "):
+			r, err := selectResult(strings.TrimPrefix(stmt, "select "))
+			if err != nil {
+				return results, err
+			}
+			results = append(results, r)
+		default:
+			results = append(results, &mysql.Result{AffectedRows: 1})
+		}
+	}
+	return results, nil
+}
+
+func selectResult(value string) (*mysql.Result, error) {
+	rs, err := mysql.BuildSimpleResultset([]string{"v"}, [][]interface{}{{value}}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+func TestMultiResult(t *testing.T) {
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	addr := startTestServer(t, NewDefaultServer(), p, multiHandler{}, nil)
+
+	conn, err := client.Connect(addr, "root", "123", "", func(c *client.Conn) { c.UseMultiStatements() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	results, err := conn.ExecuteMulti("select a; update t; call p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("expect 5 results, got %d", len(results))
+	}
+	for i, expect := range []string{"a", "", "1", "2", ""} {
+		r := results[i]
+		more := r.Status&mysql.SERVER_MORE_RESULTS_EXISTS != 0
+		if more != (i < len(results)-1) {
+			t.Fatalf("unexpected SERVER_MORE_RESULTS_EXISTS of result %d", i)
+		}
+		if expect == "" {
+			if r.Resultset != nil && len(r.Fields) > 0 {
+				t.Fatalf("result %d should be an OK packet", i)
+			}
+			continue
+		}
+		if v, _ := r.GetString(0, 0); v != expect {
+			t.Fatalf("result %d: expect %s, got %s", i, expect, v)
+		}
+	}
+
+	// the results before the failed statement are returned with the error
+	results, err = conn.ExecuteMulti("select a; fail; select b")
+	if err == nil || !strings.Contains(err.Error(), "statement failed") {
+		t.Fatalf("expect statement failed, got %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expect 1 result before the error, got %d", len(results))
+	}
+
+	// Execute returns the first result and discards the others, the connection is still usable
+	r, err := conn.Execute("call p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := r.GetString(0, 0); v != "1" {
+		t.Fatalf("expect the first result set, got %s", v)
+	}
+	if err = conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerCapability(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		h               Handler
+		multiStatements bool
+		localFiles      bool
+	}{
+		{EmptyHandler{}, false, false},
+		{multiHandler{}, true, false},
+		{loadHandler{content: new(bytes.Buffer)}, false, true},
+	}
+	for _, test := range tests {
+		conns := make(chan *Conn, 1)
+		go func(h Handler) {
+			conn, err := l.Accept()
+			if err != nil {
+				conns <- nil
+				return
+			}
+			c, err := NewConn(conn, "root", "123", h)
+			if err != nil {
+				conn.Close()
+			}
+			conns <- c
+		}(test.h)
+
+		conn, err := client.Connect(l.Addr().String(), "root", "123", "", func(c *client.Conn) { c.UseMultiStatements() })
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := <-conns
+		conn.Close()
+		if c == nil {
+			t.Fatalf("%T: handshake failed", test.h)
+		}
+
+		// the flags are only advertised when the handler supports them, even if the client sets them
+		if c.MultiStatements() != test.multiStatements || c.serverCapability()&mysql.CLIENT_MULTI_STATEMENTS != 0 != test.multiStatements {
+			t.Fatalf("%T: expect multi statements %v", test.h, test.multiStatements)
+		}
+		if c.capability&mysql.CLIENT_LOCAL_FILES != 0 != test.localFiles {
+			t.Fatalf("%T: expect local files %v", test.h, test.localFiles)
+		}
+		c.Close()
+	}
+}
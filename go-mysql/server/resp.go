@@ -7,22 +7,43 @@ import (
 )
 
 func (c *Conn) writeOK(r *Result) error {
+	return c.writeOKPacket(OK_HEADER, r)
+}
+
+// writeOKPacket writes the OK packet with the header, it is EOF_HEADER when the OK packet ends a result set
+func (c *Conn) writeOKPacket(header byte, r *Result) error {
 	if r == nil {
 		r = &Result{}
 	}
 
 	r.Status |= c.status
 
-	data := make([]byte, 4, 32)
+	// the session state changes are sent only to the clients with CLIENT_SESSION_TRACK
+	var state []byte
+	if r.SessionState != nil && c.capability&CLIENT_SESSION_TRACK > 0 {
+		state = r.SessionState.Dump()
+	}
+	status := r.Status &^ SERVER_SESSION_STATE_CHANGED
+	if len(state) > 0 {
+		status |= SERVER_SESSION_STATE_CHANGED
+	}
+
+	data := make([]byte, 4, 32+len(state))
 
-	data = append(data, OK_HEADER)
+	data = append(data, header)
 
 	data = append(data, PutLengthEncodedInt(r.AffectedRows)...)
 	data = append(data, PutLengthEncodedInt(r.InsertId)...)
 
 	if c.capability&CLIENT_PROTOCOL_41 > 0 {
-		data = append(data, byte(r.Status), byte(r.Status>>8))
-		data = append(data, 0, 0)
+		data = append(data, byte(status), byte(status>>8))
+		data = append(data, byte(r.Warnings), byte(r.Warnings>>8))
+	}
+
+	if len(state) > 0 {
+		// empty info
+		data = append(data, 0)
+		data = append(data, PutLengthEncodedString(state)...)
 	}
 
 	return c.WritePacket(data)
@@ -50,18 +71,38 @@ func (c *Conn) writeError(e error) error {
 	return c.WritePacket(data)
 }
 
-func (c *Conn) writeEOF() error {
+func (c *Conn) writeEOF(warnings uint16) error {
 	data := make([]byte, 4, 9)
 
 	data = append(data, EOF_HEADER)
 	if c.capability&CLIENT_PROTOCOL_41 > 0 {
-		data = append(data, 0, 0)
+		data = append(data, byte(warnings), byte(warnings>>8))
 		data = append(data, byte(c.status), byte(c.status>>8))
 	}
 
 	return c.WritePacket(data)
 }
 
+// writeColumnsEOF writes the EOF packet following the columns, it is omitted with CLIENT_DEPRECATE_EOF
+func (c *Conn) writeColumnsEOF() error {
+	if c.capability&CLIENT_DEPRECATE_EOF != 0 {
+		return nil
+	}
+	return c.writeEOF(0)
+}
+
+// writeResultsetEnd writes the packet following the rows with the warnings of the result, it is an OK packet
+// with the EOF header instead of the EOF packet with CLIENT_DEPRECATE_EOF
+func (c *Conn) writeResultsetEnd(r *Result) error {
+	if c.capability&CLIENT_DEPRECATE_EOF != 0 {
+		return c.writeOKPacket(EOF_HEADER, r)
+	}
+	if r == nil {
+		return c.writeEOF(0)
+	}
+	return c.writeEOF(r.Warnings)
+}
+
 // see: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_auth_switch_request.html
 func (c *Conn) writeAuthSwitchRequest(newAuthPluginName string) error {
 	data := make([]byte, 4)
@@ -115,17 +156,38 @@ func (c *Conn) writeAuthMoreDataFastAuth() error {
 	return c.WritePacket(data)
 }
 
-func (c *Conn) writeResultset(r *Resultset) error {
-	columnLen := PutLengthEncodedInt(uint64(len(r.Fields)))
-
+func (c *Conn) writeResultset(r *Result) error {
 	data := make([]byte, 4, 1024)
 
-	data = append(data, columnLen...)
+	if err := c.writeResultsetFields(data, r.Fields); err != nil {
+		return err
+	}
+
+	for _, v := range r.RowDatas {
+		data = data[0:4]
+		data = append(data, v...)
+		if err := c.WritePacket(data); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeResultsetEnd(r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeResultsetFields writes the column count, the columns and the EOF packet unless CLIENT_DEPRECATE_EOF,
+// data is the buffer to use
+func (c *Conn) writeResultsetFields(data []byte, fields []*Field) error {
+	data = data[0:4]
+	data = append(data, PutLengthEncodedInt(uint64(len(fields)))...)
 	if err := c.WritePacket(data); err != nil {
 		return err
 	}
 
-	for _, v := range r.Fields {
+	for _, v := range fields {
 		data = data[0:4]
 		data = append(data, v.Dump()...)
 		if err := c.WritePacket(data); err != nil {
@@ -133,23 +195,66 @@ func (c *Conn) writeResultset(r *Resultset) error {
 		}
 	}
 
-	if err := c.writeEOF(); err != nil {
+	return c.writeColumnsEOF()
+}
+
+// writeRowStream writes the rows one by one as they are read, the ResultsetFilter is applied to every row.
+// The columns are sent before the rows are read, so an error when reading ends the result set with an error packet.
+func (c *Conn) writeRowStream(rows RowStream) error {
+	defer rows.Close()
+
+	fields := rows.GetFields()
+	header, err := c.filterFields(fields, false)
+	if err != nil {
+		return c.writeError(err)
+	}
+
+	data := make([]byte, 4, 1024)
+	if err := c.writeResultsetFields(data, header); err != nil {
 		return err
 	}
 
-	for _, v := range r.RowDatas {
+	for rows.Next() {
+		row, err := c.filterRow(fields, rows.RawRow(), false)
+		if err != nil {
+			return c.writeError(err)
+		}
+
 		data = data[0:4]
-		data = append(data, v...)
+		data = append(data, row...)
 		if err := c.WritePacket(data); err != nil {
 			return err
 		}
 	}
 
-	if err := c.writeEOF(); err != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return c.writeError(err)
 	}
+	return c.writeResultsetEnd(nil)
+}
 
-	return nil
+// filterFields returns the columns to send for the rows filtered by filterRow
+func (c *Conn) filterFields(fields []*Field, binary bool) ([]*Field, error) {
+	if c.resultsetFilter == nil {
+		return fields, nil
+	}
+	filtered, err := c.resultsetFilter.FilterResultset(c, &Resultset{Fields: fields}, binary)
+	if err != nil {
+		return nil, err
+	}
+	return filtered.Fields, nil
+}
+
+// filterRow applies the ResultsetFilter to one row of the streamed rows
+func (c *Conn) filterRow(fields []*Field, row RowData, binary bool) (RowData, error) {
+	if c.resultsetFilter == nil {
+		return row, nil
+	}
+	filtered, err := c.resultsetFilter.FilterResultset(c, &Resultset{Fields: fields, RowDatas: []RowData{row}}, binary)
+	if err != nil {
+		return nil, err
+	}
+	return filtered.RowDatas[0], nil
 }
 
 func (c *Conn) writeFieldList(fs []*Field) error {
@@ -163,7 +268,7 @@ func (c *Conn) writeFieldList(fs []*Field) error {
 		}
 	}
 
-	if err := c.writeEOF(); err != nil {
+	if err := c.writeResultsetEnd(nil); err != nil {
 		return err
 	}
 	return nil
@@ -171,6 +276,48 @@ func (c *Conn) writeFieldList(fs []*Field) error {
 
 type noResponse struct{}
 
+// multiResult is the response of a MultiResultHandler
+type multiResult struct {
+	results []*Result
+	err     error
+}
+
+// writeMultiResult sends the results with SERVER_MORE_RESULTS_EXISTS set in all but the last one,
+// the error is sent after the results
+func (c *Conn) writeMultiResult(m *multiResult) error {
+	if c.capability&CLIENT_MULTI_RESULTS == 0 {
+		if m.err != nil {
+			return c.writeError(m.err)
+		}
+		if len(m.results) > 1 {
+			return c.writeError(NewError(ER_SP_BADSELECT, "the client does not support multiple results"))
+		}
+	}
+
+	if len(m.results) == 0 {
+		if m.err != nil {
+			return c.writeError(m.err)
+		}
+		return c.writeOK(nil)
+	}
+
+	for i, r := range m.results {
+		if i < len(m.results)-1 || m.err != nil {
+			c.status |= SERVER_MORE_RESULTS_EXISTS
+		}
+		err := c.writeValue(r)
+		c.status &^= SERVER_MORE_RESULTS_EXISTS
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.err != nil {
+		return c.writeError(m.err)
+	}
+	return nil
+}
+
 func (c *Conn) writeValue(value interface{}) error {
 	switch v := value.(type) {
 	case noResponse:
@@ -181,10 +328,18 @@ func (c *Conn) writeValue(value interface{}) error {
 		return c.writeOK(nil)
 	case *Result:
 		if v != nil && v.Resultset != nil {
-			return c.writeResultset(v.Resultset)
+			return c.writeResultset(v)
 		} else {
 			return c.writeOK(v)
 		}
+	case RowStream:
+		return c.writeRowStream(v)
+	case *cursorHeader:
+		return c.writeCursorHeader(v)
+	case *cursorFetch:
+		return c.writeCursorFetch(v)
+	case *multiResult:
+		return c.writeMultiResult(v)
 	case []*Field:
 		return c.writeFieldList(v)
 	case *Stmt:
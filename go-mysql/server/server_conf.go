@@ -3,6 +3,7 @@ package server
 import (
 	"crypto/tls"
 	"fmt"
+	"strings"
 	"sync"
 
 	. "github.com/daiguadaidai/dal/go-mysql/mysql"
@@ -34,6 +35,8 @@ type Server struct {
 	pubKey            []byte
 	tlsConfig         *tls.Config
 	cacheShaPassword  *sync.Map // 'user@host' -> SHA256(SHA256(PASSWORD))
+	loginPolicy       LoginPolicy
+	commandChecker    CommandChecker
 }
 
 // NewDefaultServer: New mysql server with default settings.
@@ -43,6 +46,8 @@ type Server struct {
 // non-TLS connection). By default, it will verify the client certificate if present. You can enable TLS support on
 // the client side without providing a client-side certificate. So only when you need the server to verify client
 // identity for maximum security, you need to set a signed certificate for the client.
+// Multiple statements and results are only advertised when the handler implements MultiResultHandler,
+// LOAD DATA LOCAL INFILE only when it implements LocalInfileHandler.
 func NewDefaultServer() *Server {
 	caPem, caKey := generateCA()
 	certPem, keyPem := generateAndSignRSACerts(caPem, caKey)
@@ -51,7 +56,9 @@ func NewDefaultServer() *Server {
 		serverVersion:   "5.7.0",
 		protocolVersion: 10,
 		capability: CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG | CLIENT_CONNECT_WITH_DB | CLIENT_PROTOCOL_41 |
-			CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH | CLIENT_SSL | CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA,
+			CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH | CLIENT_SSL | CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA |
+			CLIENT_MULTI_STATEMENTS | CLIENT_MULTI_RESULTS | CLIENT_PS_MULTI_RESULTS | CLIENT_LOCAL_FILES | CLIENT_SESSION_TRACK |
+			CLIENT_DEPRECATE_EOF | CLIENT_CONNECT_ATTRS,
 		collationId:       DEFAULT_COLLATION_ID,
 		defaultAuthMethod: AUTH_NATIVE_PASSWORD,
 		pubKey:            getPublicKeyFromCert(certPem),
@@ -78,7 +85,9 @@ func NewServer(serverVersion string, collationId uint8, defaultAuthMethod string
 	//	panic(fmt.Sprintf("default auth method is not one of the allowed auth methods"))
 	//}
 	var capFlag = CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG | CLIENT_CONNECT_WITH_DB | CLIENT_PROTOCOL_41 |
-		CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH | CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+		CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH | CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA |
+		CLIENT_MULTI_STATEMENTS | CLIENT_MULTI_RESULTS | CLIENT_PS_MULTI_RESULTS | CLIENT_LOCAL_FILES | CLIENT_SESSION_TRACK |
+		CLIENT_DEPRECATE_EOF | CLIENT_CONNECT_ATTRS
 	if tlsConfig != nil {
 		capFlag |= CLIENT_SSL
 	}
@@ -101,3 +110,37 @@ func isAuthMethodSupported(authMethod string) bool {
 func (s *Server) InvalidateCache(username string, host string) {
 	s.cacheShaPassword.Delete(fmt.Sprintf("%s@%s", username, host))
 }
+
+// SetCompressionAlgorithms: allow the clients to use the compressed protocol, algorithms are "zlib" and "zstd",
+// no algorithms to disable the compression
+func (s *Server) SetCompressionAlgorithms(algorithms ...string) error {
+	capability := s.capability &^ (CLIENT_COMPRESS | CLIENT_ZSTD_COMPRESSION_ALGORITHM)
+	for _, algorithm := range algorithms {
+		switch algorithm {
+		case COMPRESSION_ZLIB:
+			capability |= CLIENT_COMPRESS
+		case COMPRESSION_ZSTD:
+			capability |= CLIENT_ZSTD_COMPRESSION_ALGORITHM
+		default:
+			return fmt.Errorf("compression algorithm '%s' is not supported", algorithm)
+		}
+	}
+	s.capability = capability
+	return nil
+}
+
+// SetCommandChecker: check the commands of the connections created after, nil to disable
+func (s *Server) SetCommandChecker(checker CommandChecker) {
+	s.commandChecker = checker
+}
+
+// InvalidateUserCache: remove the cached password of the user for all hosts
+func (s *Server) InvalidateUserCache(username string) {
+	prefix := username + "@"
+	s.cacheShaPassword.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			s.cacheShaPassword.Delete(key)
+		}
+		return true
+	})
+}
@@ -239,16 +239,16 @@ func (h *testHandler) handleQuery(query string, binary bool) (*mysql.Result, err
 		if err != nil {
 			return nil, errors.Trace(err)
 		} else {
-			return &mysql.Result{0, 0, 0, r}, nil
+			return &mysql.Result{Resultset: r}, nil
 		}
 	case "insert":
-		return &mysql.Result{0, 1, 0, nil}, nil
+		return &mysql.Result{InsertId: 1}, nil
 	case "delete":
-		return &mysql.Result{0, 0, 1, nil}, nil
+		return &mysql.Result{AffectedRows: 1}, nil
 	case "update":
-		return &mysql.Result{0, 0, 1, nil}, nil
+		return &mysql.Result{AffectedRows: 1}, nil
 	case "replace":
-		return &mysql.Result{0, 0, 1, nil}, nil
+		return &mysql.Result{AffectedRows: 1}, nil
 	default:
 		return nil, fmt.Errorf("invalid query %s", query)
 	}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// sessionStateHandler reports the session state changes of the statements
+type sessionStateHandler struct {
+	EmptyHandler
+}
+
+func (h sessionStateHandler) HandleQuery(query string) (*mysql.Result, error) {
+	switch query {
+	case "use db2":
+		return &mysql.Result{SessionState: &mysql.SessionState{Schema: "db2"}}, nil
+	case "begin":
+		return &mysql.Result{SessionState: &mysql.SessionState{
+			SystemVariables:  map[string]string{"autocommit": "OFF"},
+			StateChanged:     true,
+			TransactionState: "T_______",
+		}}, nil
+	}
+	return nil, nil
+}
+
+func TestSessionState(t *testing.T) {
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	addr := startTestServer(t, NewDefaultServer(), p, sessionStateHandler{}, nil)
+
+	conn, err := client.Connect(addr, "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := conn.Execute("begin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &mysql.SessionState{
+		SystemVariables:  map[string]string{"autocommit": "OFF"},
+		StateChanged:     true,
+		TransactionState: "T_______",
+	}
+	if !reflect.DeepEqual(r.SessionState, expect) {
+		t.Fatalf("unexpected session state %+v", r.SessionState)
+	}
+	if r.Status&mysql.SERVER_SESSION_STATE_CHANGED == 0 {
+		t.Fatal("SERVER_SESSION_STATE_CHANGED should be set")
+	}
+
+	// the schema changes are tracked by the client
+	if r, err = conn.Execute("use db2"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.GetDB() != "db2" {
+		t.Fatalf("expect db2, got %s", conn.GetDB())
+	}
+	if err = conn.UseDB("db3"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.GetDB() != "db3" {
+		t.Fatalf("expect db3, got %s", conn.GetDB())
+	}
+
+	if r, err = conn.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if r.SessionState != nil || r.Status&mysql.SERVER_SESSION_STATE_CHANGED != 0 {
+		t.Fatal("no session state should be changed")
+	}
+}
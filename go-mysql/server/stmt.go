@@ -31,6 +31,9 @@ type Stmt struct {
 	Args []interface{}
 
 	Context interface{}
+
+	// the read-only cursor opened by the last execution
+	cursor RowStream
 }
 
 func (s *Stmt) Rest(params int, columns int, context interface{}) {
@@ -74,7 +77,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 			}
 		}
 
-		if err := c.writeEOF(); err != nil {
+		if err := c.writeColumnsEOF(); err != nil {
 			return err
 		}
 	}
@@ -89,7 +92,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 			}
 		}
 
-		if err := c.writeEOF(); err != nil {
+		if err := c.writeColumnsEOF(); err != nil {
 			return err
 		}
 
@@ -97,7 +100,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 	return nil
 }
 
-func (c *Conn) handleStmtExecute(data []byte) (*Result, error) {
+func (c *Conn) handleStmtExecute(data []byte) (interface{}, error) {
 	if len(data) < 9 {
 		return nil, ErrMalformPacket
 	}
@@ -114,8 +117,8 @@ func (c *Conn) handleStmtExecute(data []byte) (*Result, error) {
 
 	flag := data[pos]
 	pos++
-	//now we only support CURSOR_TYPE_NO_CURSOR flag
-	if flag != 0 {
+	//now we only support CURSOR_TYPE_NO_CURSOR and CURSOR_TYPE_READ_ONLY flag
+	if flag&^CURSOR_TYPE_READ_ONLY != 0 {
 		return nil, NewError(ER_UNKNOWN_ERROR, fmt.Sprintf("unsupported flag %d", flag))
 	}
 
@@ -154,6 +157,18 @@ func (c *Conn) handleStmtExecute(data []byte) (*Result, error) {
 		}
 	}
 
+	// a new execution closes the cursor of the last one
+	c.closeCursor(s)
+
+	if flag&CURSOR_TYPE_READ_ONLY != 0 {
+		v, err := c.openCursor(s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s.ResetParams()
+		return v, nil
+	}
+
 	var r *Result
 	var err error
 	if r, err = c.h.HandleStmtExecute(s.Context, s.Query, s.Args); err != nil {
@@ -336,6 +351,7 @@ func (c *Conn) handleStmtReset(data []byte) (*Result, error) {
 	}
 
 	s.ResetParams()
+	c.closeCursor(s)
 
 	return &Result{}, nil
 }
@@ -353,6 +369,7 @@ func (c *Conn) handleStmtClose(data []byte) error {
 		return nil
 	}
 
+	c.closeCursor(stmt)
 	if err := c.h.HandleStmtClose(stmt.Context); err != nil {
 		return err
 	}
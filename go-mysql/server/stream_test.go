@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// proxyHandler forwards the queries to the backend and streams the rows back
+type proxyHandler struct {
+	EmptyHandler
+	backend *client.Conn
+}
+
+func (h proxyHandler) HandleQueryStream(query string) (RowStream, *mysql.Result, error) {
+	rows, err := h.backend.ExecuteRows(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows.GetFields()) == 0 {
+		return nil, rows.Result, nil
+	}
+	return rows, nil, nil
+}
+
+// upperFilter upper cases the name column
+type upperFilter struct{}
+
+func (f upperFilter) FilterResultset(c *Conn, r *mysql.Resultset, binary bool) (*mysql.Resultset, error) {
+	filtered := &mysql.Resultset{Fields: r.Fields, FieldNames: r.FieldNames}
+	for _, row := range r.RowDatas {
+		values, err := row.Parse(r.Fields, binary)
+		if err != nil {
+			return nil, err
+		}
+		newRow := mysql.RowData(mysql.PutLengthEncodedString([]byte(fmt.Sprint(values[0]))))
+		newRow = append(newRow, mysql.PutLengthEncodedString([]byte(strings.ToUpper(fmt.Sprintf("%s", values[1]))))...)
+		filtered.RowDatas = append(filtered.RowDatas, newRow)
+	}
+	return filtered, nil
+}
+
+func startWideServer(t *testing.T) string {
+	p := NewInMemoryProvider()
+	p.AddUser("root", "123")
+	return startTestServer(t, NewDefaultServer(), p, wideHandler{}, nil)
+}
+
+func TestExecuteRows(t *testing.T) {
+	conn, err := client.Connect(startWideServer(t), "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rows, err := conn.ExecuteRows("select wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for rows.Next() {
+		var id int64
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		if id != int64(count) || name != strings.Repeat("dal", 100) {
+			t.Fatalf("unexpected row %d: %d, %s", count, id, name)
+		}
+		if len(rows.RawRow()) == 0 {
+			t.Fatal("raw row should not be empty")
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1000 || rows.RowNumber() != 0 {
+		t.Fatalf("expect 1000 rows not buffered, got %d, buffered %d", count, rows.RowNumber())
+	}
+
+	// closing the rows in the middle discards the remaining rows
+	rows, err = conn.ExecuteRows("select wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	if err = rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatal("closed rows should not have next row")
+	}
+
+	// the statement prepared for the args is closed with the rows
+	rows, err = conn.ExecuteRows("select abc", 1)
+	if err == nil {
+		t.Fatal("the argument count mismatch should fail")
+	}
+	s, err := conn.Prepare("select abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows, err = s.ExecuteRows(); err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	for rows.Next() {
+		if values, err = rows.Values(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(values) != 2 || values[0].(int64) != 10 {
+		t.Fatalf("unexpected binary row %v", values)
+	}
+	s.Close()
+
+	if err = conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	backend, err := client.Connect(startWideServer(t), "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c, err := NewConn(conn, "root", "123", proxyHandler{backend: backend})
+		if err != nil {
+			return
+		}
+		c.SetResultsetFilter(upperFilter{})
+		for c.HandleCommand() == nil {
+		}
+	}()
+
+	conn, err := client.Connect(l.Addr().String(), "root", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := conn.Execute("select wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RowNumber() != 1000 {
+		t.Fatalf("expect 1000 rows, got %d", r.RowNumber())
+	}
+	if name, _ := r.GetString(999, 1); name != strings.Repeat("DAL", 100) {
+		t.Fatalf("unexpected name %s", name)
+	}
+}
@@ -0,0 +1,381 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/mysqldb/pool"
+)
+
+const (
+	CLUSTER_DEFAULT_DRAIN_TIMEOUT_MS = 3000
+	CLUSTER_DEFAULT_GTID_TIMEOUT_MS  = 10000
+
+	clusterGTIDCheckInterval = 100 * time.Millisecond
+)
+
+// 逻辑集群配置
+// [[clusters]]
+// name = "order"
+// master = "10.0.0.1:3306"
+// slaves = ["10.0.0.2:3306", "10.0.0.3:3306"]
+// username = "dal"
+// password = "123456"
+// db_name = "order"
+// charset = "utf8mb4"
+// min_open = 10
+// max_open = 100
+type Config struct {
+	Name     string   `toml:"name"`
+	Master   string   `toml:"master"`
+	Slaves   []string `toml:"slaves"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	DBName   string   `toml:"db_name"`
+	Charset  string   `toml:"charset"`
+	MinOpen  int32    `toml:"min_open"`
+	MaxOpen  int32    `toml:"max_open"`
+}
+
+// 集群的后端拓扑, 切换时整体替换, 不会修改
+type Topology struct {
+	Master *pool.MySQLPool
+	Slaves []*pool.MySQLPool
+
+	refs    int64 // 使用这个拓扑的事务数, 由 Cluster 的锁保护
+	retired bool  // 已经被替换, 事务都结束后关闭
+}
+
+func (this *Topology) String() string {
+	slaves := make([]string, 0, len(this.Slaves))
+	for _, s := range this.Slaves {
+		slaves = append(slaves, s.Addr())
+	}
+	return fmt.Sprintf("master: %s, slaves: [%s]", this.Master.Addr(), strings.Join(slaves, ", "))
+}
+
+func (this *Topology) Close() {
+	this.Master.Close()
+	for _, s := range this.Slaves {
+		s.Close()
+	}
+}
+
+func openPool(cfg *Config, addr string) (*pool.MySQLPool, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("集群 %s 的后端地址 %s 格式错误. %s", cfg.Name, addr, err.Error())
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("集群 %s 的后端地址 %s 端口错误. %s", cfg.Name, addr, err.Error())
+	}
+	return pool.Open(host, uint16(port), cfg.Username, cfg.Password, cfg.DBName, cfg.Charset, true,
+		cfg.MinOpen, cfg.MaxOpen)
+}
+
+// 使用集群的链接配置打开新的拓扑
+func OpenTopology(cfg *Config, master string, slaves []string) (*Topology, error) {
+	t := new(Topology)
+	var err error
+	if t.Master, err = openPool(cfg, master); err != nil {
+		return nil, err
+	}
+	for _, addr := range slaves {
+		s, err := openPool(cfg, addr)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.Slaves = append(t.Slaves, s)
+	}
+	return t, nil
+}
+
+// 切换参数
+type SwitchoverConfig struct {
+	DrainTimeoutMS int64 `json:"drain_timeout_ms"` // 等待执行中的事务结束的时间, 超时放弃切换
+	WaitGTID       bool  `json:"wait_gtid"`        // 是否等待新 master 的 gtid_executed 包含旧 master 的
+	GTIDTimeoutMS  int64 `json:"gtid_timeout_ms"`  // 等待 GTID 的时间, 超时放弃切换
+	Force          bool  `json:"force"`            // 等待事务结束超时后强制切换, 用于旧 master 已经不可用的故障切换
+
+	// 事务都结束后, 替换拓扑前执行, 例如设置旧 master 只读, 提升新 master. 返回错误放弃切换
+	Prepare func() error `json:"-"`
+}
+
+func (this *SwitchoverConfig) setDefault() {
+	if this.DrainTimeoutMS <= 0 {
+		this.DrainTimeoutMS = CLUSTER_DEFAULT_DRAIN_TIMEOUT_MS
+	}
+	if this.GTIDTimeoutMS <= 0 {
+		this.GTIDTimeoutMS = CLUSTER_DEFAULT_GTID_TIMEOUT_MS
+	}
+}
+
+// 逻辑集群, 路由到集群的每个事务(自动提交的语句也是一个事务)需要通过 Begin/End 包裹,
+// 切换时暂停新的事务, 等待执行中的事务结束后替换拓扑, 应用不需要重启.
+type Cluster struct {
+	sync.Mutex
+	cond     *sync.Cond
+	cfg      Config
+	topology *Topology
+	paused   bool
+	inflight int64
+
+	switchMu     sync.Mutex // 同一时间只能有一个切换
+	gtidExecuted func(p *pool.MySQLPool) (string, error)
+}
+
+func NewCluster(cfg Config) (*Cluster, error) {
+	t, err := OpenTopology(&cfg, cfg.Master, cfg.Slaves)
+	if err != nil {
+		return nil, err
+	}
+	return newCluster(cfg, t), nil
+}
+
+func newCluster(cfg Config, t *Topology) *Cluster {
+	c := &Cluster{cfg: cfg, topology: t, gtidExecuted: gtidExecuted}
+	c.cond = sync.NewCond(&c.Mutex)
+	return c
+}
+
+func (this *Cluster) Name() string {
+	return this.cfg.Name
+}
+
+// 当前拓扑
+func (this *Cluster) Topology() *Topology {
+	this.Lock()
+	defer this.Unlock()
+	return this.topology
+}
+
+// 开始一个事务, 返回事务需要使用的拓扑, 事务结束后必须使用这个拓扑调用 End.
+// 切换中会等待切换完成
+func (this *Cluster) Begin() *Topology {
+	this.Lock()
+	defer this.Unlock()
+	for this.paused {
+		this.cond.Wait()
+	}
+	this.inflight++
+	this.topology.refs++
+	return this.topology
+}
+
+// 事务结束, t 是 Begin 返回的拓扑. 已经被替换的拓扑在最后一个事务结束后关闭
+func (this *Cluster) End(t *Topology) {
+	this.Lock()
+	if this.inflight--; this.inflight == 0 {
+		this.cond.Broadcast()
+	}
+	t.refs--
+	closeNow := t.retired && t.refs == 0
+	this.Unlock()
+
+	if closeNow {
+		t.Close()
+	}
+}
+
+// 关闭被替换的拓扑, 还有事务在使用时等最后一个事务 End 之后再关闭
+func (this *Cluster) Retire(t *Topology) {
+	this.Lock()
+	closeNow := !t.retired && t.refs == 0
+	t.retired = true
+	this.Unlock()
+
+	if closeNow {
+		t.Close()
+	}
+}
+
+// 执行中的事务数
+func (this *Cluster) Inflight() int64 {
+	this.Lock()
+	defer this.Unlock()
+	return this.inflight
+}
+
+// 切换到新的拓扑, 成功返回旧的拓扑(由调用者使用 Retire 关闭).
+// 失败时恢复使用原来的拓扑, 新的拓扑不会被关闭.
+//  1. 暂停新的事务
+//  2. 等待执行中的事务结束(Force 时超时也继续切换)
+//  3. 执行 Prepare(可选)
+//  4. 等待新 master 的 gtid_executed 包含旧 master 的(可选)
+//  5. 替换拓扑, 恢复事务
+func (this *Cluster) Switchover(target *Topology, cfg SwitchoverConfig) (*Topology, error) {
+	cfg.setDefault()
+
+	this.switchMu.Lock()
+	defer this.switchMu.Unlock()
+
+	start := time.Now()
+	this.Lock()
+	this.paused = true
+	this.Unlock()
+	defer this.resume()
+
+	if err := this.drain(time.Duration(cfg.DrainTimeoutMS) * time.Millisecond); err != nil {
+		if !cfg.Force {
+			return nil, err
+		}
+		seelog.Warnf("%s. 强制切换", err.Error())
+	}
+
+	old := this.Topology()
+	if cfg.Prepare != nil {
+		if err := cfg.Prepare(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.WaitGTID {
+		if err := this.waitGTID(old.Master, target.Master, time.Duration(cfg.GTIDTimeoutMS)*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+
+	this.Lock()
+	this.topology = target
+	this.Unlock()
+
+	seelog.Infof("集群 %s 切换完成, 暂停了 %s. 旧拓扑: %s. 新拓扑: %s",
+		this.cfg.Name, time.Since(start), old.String(), target.String())
+
+	return old, nil
+}
+
+func (this *Cluster) resume() {
+	this.Lock()
+	this.paused = false
+	this.cond.Broadcast()
+	this.Unlock()
+}
+
+// 等待执行中的事务结束
+func (this *Cluster) drain(timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		this.Lock()
+		this.cond.Broadcast()
+		this.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+
+	this.Lock()
+	defer this.Unlock()
+	for this.inflight > 0 {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("集群 %s 等待执行中的事务结束超时(%s), 还有 %d 个事务在执行",
+				this.cfg.Name, timeout, this.inflight)
+		}
+		this.cond.Wait()
+	}
+	return nil
+}
+
+// 等待新 master 追上旧 master
+func (this *Cluster) waitGTID(oldMaster *pool.MySQLPool, newMaster *pool.MySQLPool, timeout time.Duration) error {
+	s, err := this.gtidExecuted(oldMaster)
+	if err != nil {
+		return fmt.Errorf("集群 %s 获取旧 master %s 的 gtid_executed 出错. %s", this.cfg.Name, oldMaster.Addr(), err.Error())
+	}
+	oldSet, err := mysql.ParseMysqlGTIDSet(s)
+	if err != nil {
+		return fmt.Errorf("集群 %s 解析旧 master %s 的 gtid_executed 出错. %s", this.cfg.Name, oldMaster.Addr(), err.Error())
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		s, err = this.gtidExecuted(newMaster)
+		if err != nil {
+			return fmt.Errorf("集群 %s 获取新 master %s 的 gtid_executed 出错. %s", this.cfg.Name, newMaster.Addr(), err.Error())
+		}
+		newSet, err := mysql.ParseMysqlGTIDSet(s)
+		if err != nil {
+			return fmt.Errorf("集群 %s 解析新 master %s 的 gtid_executed 出错. %s", this.cfg.Name, newMaster.Addr(), err.Error())
+		}
+		if newSet.Contain(oldSet) {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("集群 %s 等待新 master %s 追上旧 master(%s)超时(%s), 新 master: %s, 放弃切换",
+				this.cfg.Name, newMaster.Addr(), oldSet.String(), timeout, newSet.String())
+		}
+		time.Sleep(clusterGTIDCheckInterval)
+	}
+}
+
+// 使用集群原来的链接配置打开新的后端并切换, 切换成功后关闭旧的后端.
+// 强制切换时旧的后端可能还有事务在使用, 在这些事务都结束后才关闭
+func (this *Cluster) SwitchoverTo(master string, slaves []string, cfg SwitchoverConfig) (*Topology, error) {
+	target, err := OpenTopology(&this.cfg, master, slaves)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := this.Switchover(target, cfg)
+	if err != nil {
+		target.Close()
+		return nil, err
+	}
+	this.Retire(old)
+
+	return target, nil
+}
+
+func gtidExecuted(p *pool.MySQLPool) (string, error) {
+	conn, err := p.Get()
+	if err != nil {
+		return "", err
+	}
+
+	r, err := conn.Execute("SELECT @@GLOBAL.gtid_executed")
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	p.Release(conn)
+
+	return r.GetString(0, 0)
+}
+
+// 管理所有的逻辑集群
+type Manager struct {
+	sync.RWMutex
+	clusters map[string]*Cluster
+}
+
+func NewManager() *Manager {
+	return &Manager{clusters: make(map[string]*Cluster)}
+}
+
+func (this *Manager) Add(c *Cluster) {
+	this.Lock()
+	defer this.Unlock()
+	this.clusters[c.Name()] = c
+}
+
+func (this *Manager) Get(name string) (*Cluster, bool) {
+	this.RLock()
+	defer this.RUnlock()
+	c, ok := this.clusters[name]
+	return c, ok
+}
+
+// 切换指定的集群, 参考 Cluster.SwitchoverTo
+func (this *Manager) Switchover(name string, master string, slaves []string, cfg SwitchoverConfig) (*Topology, error) {
+	c, ok := this.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("集群 %s 不存在", name)
+	}
+	return c.SwitchoverTo(master, slaves, cfg)
+}
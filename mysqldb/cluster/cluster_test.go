@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/mysqldb/pool"
+)
+
+func newTestTopology(t *testing.T, master string) *Topology {
+	cfg := &Config{Name: "order", Username: "dal", Password: "123", MinOpen: 1, MaxOpen: 1}
+	topo, err := OpenTopology(cfg, master, []string{"127.0.0.1:13307"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topo
+}
+
+func Test_Cluster_Switchover(t *testing.T) {
+	c := newCluster(Config{Name: "order"}, newTestTopology(t, "127.0.0.1:13306"))
+	blue := c.Topology()
+	green := newTestTopology(t, "127.0.0.1:23306")
+
+	// 切换前开始的事务
+	if c.Begin() != blue {
+		t.Fatal("切换前应该使用旧拓扑")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		old, err := c.Switchover(green, SwitchoverConfig{DrainTimeoutMS: 5000})
+		if err == nil && old != blue {
+			t.Error("应该返回旧拓扑")
+		}
+		done <- err
+	}()
+
+	// 切换中开始的事务需要等待切换完成
+	var wg sync.WaitGroup
+	var got *Topology
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		got = c.Begin()
+		c.End(got)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("执行中的事务没有结束, 不应该完成切换. %v", err)
+	default:
+	}
+	if c.Topology() != blue {
+		t.Fatal("执行中的事务没有结束, 不应该替换拓扑")
+	}
+
+	c.End(blue)
+	if err := <-done; err != nil {
+		t.Fatalf("切换失败. %v", err)
+	}
+	wg.Wait()
+	if got != green {
+		t.Fatal("切换中开始的事务应该使用新拓扑")
+	}
+	if c.Inflight() != 0 {
+		t.Fatalf("执行中的事务数应该是0, 实际: %d", c.Inflight())
+	}
+}
+
+func Test_Cluster_DrainTimeout(t *testing.T) {
+	c := newCluster(Config{Name: "order"}, newTestTopology(t, "127.0.0.1:13306"))
+	blue := c.Topology()
+	tx := c.Begin()
+	defer c.End(tx)
+
+	_, err := c.Switchover(newTestTopology(t, "127.0.0.1:23306"), SwitchoverConfig{DrainTimeoutMS: 100})
+	if err == nil || !strings.Contains(err.Error(), "超时") {
+		t.Fatalf("等待事务结束应该超时. %v", err)
+	}
+	if c.Topology() != blue {
+		t.Fatal("切换失败应该继续使用旧拓扑")
+	}
+
+	// 切换失败后恢复事务
+	finished := make(chan struct{})
+	go func() {
+		c.End(c.Begin())
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("切换失败后应该恢复事务")
+	}
+}
+
+func Test_Cluster_WaitGTID(t *testing.T) {
+	c := newCluster(Config{Name: "order"}, newTestTopology(t, "127.0.0.1:13306"))
+	green := newTestTopology(t, "127.0.0.1:23306")
+
+	const uuid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	var mu sync.Mutex
+	gtids := map[string]string{
+		"127.0.0.1:13306": uuid + ":1-100",
+		"127.0.0.1:23306": uuid + ":1-90",
+	}
+	c.gtidExecuted = func(p *pool.MySQLPool) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return gtids[p.Addr()], nil
+	}
+
+	// 新 master 一直没有追上
+	if _, err := c.Switchover(green, SwitchoverConfig{WaitGTID: true, GTIDTimeoutMS: 300}); err == nil {
+		t.Fatal("新 master 没有追上, 应该切换失败")
+	}
+
+	// 新 master 追上之后切换
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		gtids["127.0.0.1:23306"] = uuid + ":1-100,\n4e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"
+		mu.Unlock()
+	}()
+	start := time.Now()
+	if _, err := c.Switchover(green, SwitchoverConfig{WaitGTID: true, GTIDTimeoutMS: 5000}); err != nil {
+		t.Fatalf("新 master 追上之后应该切换成功. %v", err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Fatal("应该等待新 master 追上")
+	}
+	if c.Topology() != green {
+		t.Fatal("应该使用新拓扑")
+	}
+}
+
+func Test_Manager_Switchover(t *testing.T) {
+	m := NewManager()
+	c, err := NewCluster(Config{Name: "order", Master: "127.0.0.1:13306", Slaves: []string{"127.0.0.1:13307"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Add(c)
+
+	if _, err = m.Switchover("user", "127.0.0.1:23306", nil, SwitchoverConfig{}); err == nil {
+		t.Fatal("集群不存在应该报错")
+	}
+	if _, err = m.Switchover("order", "127.0.0.1", nil, SwitchoverConfig{}); err == nil {
+		t.Fatal("地址错误应该报错")
+	}
+
+	topo, err := m.Switchover("order", "127.0.0.1:23306", []string{"127.0.0.1:23307"}, SwitchoverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topo.String() != "master: 127.0.0.1:23306, slaves: [127.0.0.1:23307]" {
+		t.Fatalf("新拓扑不对: %s", topo.String())
+	}
+	if c.Topology() != topo {
+		t.Fatal("集群应该使用新拓扑")
+	}
+}
@@ -0,0 +1,447 @@
+package ha
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/failover"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/mysqldb/cluster"
+)
+
+const (
+	HA_DEFAULT_CHECK_INTERVAL_MS = 1000
+	HA_DEFAULT_CHECK_TIMEOUT_MS  = 1000
+	HA_DEFAULT_FAILURE_THRESHOLD = 3
+	HA_DEFAULT_DRAIN_TIMEOUT_MS  = 1000
+)
+
+// 集群高可用配置
+// [ha]
+// enable = true
+// flavor = "mysql"
+// user = "dal_admin"
+// password = "123456"
+// repl_user = "repl"
+// repl_password = "123456"
+// check_interval_ms = 1000
+// check_timeout_ms = 1000
+// failure_threshold = 3
+// quorum = 0
+// auto_failover = true
+type Config struct {
+	Enable           bool   `toml:"enable"`
+	Flavor           string `toml:"flavor"`        // 目前只支持 mysql(GTID 模式)
+	User             string `toml:"user"`          // 探测和执行 CHANGE MASTER 等语句的管理账号
+	Password         string `toml:"password"`      //
+	ReplUser         string `toml:"repl_user"`     // 复制账号
+	ReplPassword     string `toml:"repl_password"` //
+	CheckIntervalMS  int64  `toml:"check_interval_ms"`
+	CheckTimeoutMS   int64  `toml:"check_timeout_ms"`
+	FailureThreshold int    `toml:"failure_threshold"` // 连续探测失败多少次后确认 master 故障
+	// 确认 master 故障需要的票数: dal 探测失败算一票, 每个 IO 线程没有运行的 slave 算一票.
+	// <= 0 时使用过半数, 避免 dal 自己的网络问题导致误切换
+	Quorum         int   `toml:"quorum"`
+	AutoFailover   bool  `toml:"auto_failover"`    // 确认故障后是否自动切换, 否则只发出事件
+	DrainTimeoutMS int64 `toml:"drain_timeout_ms"` // 切换时等待执行中的事务结束的时间
+}
+
+func (this *Config) setDefault() {
+	if len(this.Flavor) == 0 {
+		this.Flavor = mysql.MySQLFlavor
+	}
+	if this.CheckIntervalMS <= 0 {
+		this.CheckIntervalMS = HA_DEFAULT_CHECK_INTERVAL_MS
+	}
+	if this.CheckTimeoutMS <= 0 {
+		this.CheckTimeoutMS = HA_DEFAULT_CHECK_TIMEOUT_MS
+	}
+	if this.FailureThreshold <= 0 {
+		this.FailureThreshold = HA_DEFAULT_FAILURE_THRESHOLD
+	}
+	if this.DrainTimeoutMS <= 0 {
+		this.DrainTimeoutMS = HA_DEFAULT_DRAIN_TIMEOUT_MS
+	}
+}
+
+type EventType string
+
+const (
+	EVENT_MASTER_DOWN       EventType = "master_down"       // 确认 master 故障
+	EVENT_FAILOVER_DONE     EventType = "failover_done"     // 故障切换完成
+	EVENT_FAILOVER_FAILED   EventType = "failover_failed"   // 故障切换失败, 需要人工处理
+	EVENT_SWITCHOVER_DONE   EventType = "switchover_done"   // 计划内切换完成
+	EVENT_SWITCHOVER_FAILED EventType = "switchover_failed" // 计划内切换失败
+)
+
+type Event struct {
+	Type      EventType
+	Cluster   string
+	OldMaster string
+	NewMaster string
+	Slaves    []string
+	Votes     int // 确认 master 故障的票数
+	Err       error
+	Time      time.Time
+}
+
+func (this *Event) String() string {
+	s := fmt.Sprintf("集群: %s, 事件: %s, 旧 master: %s, 新 master: %s, slaves: [%s]",
+		this.Cluster, this.Type, this.OldMaster, this.NewMaster, strings.Join(this.Slaves, ", "))
+	if this.Votes > 0 {
+		s += fmt.Sprintf(", 票数: %d", this.Votes)
+	}
+	if this.Err != nil {
+		s += fmt.Sprintf(", 错误: %s", this.Err.Error())
+	}
+	return s
+}
+
+// 事件回调, 例如通知运维或者更新服务发现
+type Hook func(e *Event)
+
+// 监控集群的 master, 确认故障后使用 go-mysql/failover 提升最新的 slave,
+// 其他 slave 指向新 master, 然后切换集群的路由.
+type Monitor struct {
+	sync.Mutex
+	cfg     Config
+	cluster *cluster.Cluster
+	hooks   []Hook
+
+	opMu     sync.Mutex // 探测, 故障切换和计划内切换不能同时进行
+	failures int
+	stopped  bool // 故障切换失败后不再自动切换, 需要人工处理后调用 ResumeAutoFailover
+
+	ping      func(addr string) error
+	newServer func(addr string) *failover.Server
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func NewMonitor(cfg Config, c *cluster.Cluster) (*Monitor, error) {
+	cfg.setDefault()
+	if _, err := failover.NewHandler(cfg.Flavor); err != nil {
+		return nil, fmt.Errorf("集群 %s 高可用配置错误. %s", c.Name(), err.Error())
+	}
+
+	m := &Monitor{cfg: cfg, cluster: c, closeCh: make(chan struct{})}
+	m.ping = m.pingMaster
+	m.newServer = func(addr string) *failover.Server {
+		return failover.NewServer(addr, failover.User{Name: cfg.User, Password: cfg.Password},
+			failover.User{Name: cfg.ReplUser, Password: cfg.ReplPassword})
+	}
+	return m, nil
+}
+
+func (this *Monitor) AddHook(h Hook) {
+	this.Lock()
+	defer this.Unlock()
+	this.hooks = append(this.hooks, h)
+}
+
+func (this *Monitor) emit(e *Event) {
+	e.Cluster = this.cluster.Name()
+	e.Time = time.Now()
+	if e.Err != nil {
+		seelog.Errorf("高可用事件. %s", e.String())
+	} else {
+		seelog.Warnf("高可用事件. %s", e.String())
+	}
+
+	this.Lock()
+	hooks := this.hooks
+	this.Unlock()
+	for _, h := range hooks {
+		h(e)
+	}
+}
+
+// 开始定时探测
+func (this *Monitor) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(this.cfg.CheckIntervalMS) * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-this.closeCh:
+				return
+			case <-ticker.C:
+				this.Check()
+			}
+		}
+	}()
+}
+
+func (this *Monitor) Close() {
+	this.closeOnce.Do(func() {
+		close(this.closeCh)
+	})
+}
+
+// 故障切换失败后恢复自动切换
+func (this *Monitor) ResumeAutoFailover() {
+	this.opMu.Lock()
+	defer this.opMu.Unlock()
+	this.stopped = false
+	this.failures = 0
+}
+
+// 建立链接和 PING 都需要在 check_timeout_ms 内完成, 丢包的 master 不能等到默认的链接超时
+func (this *Monitor) pingMaster(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(this.cfg.CheckTimeoutMS)*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	conn, err := client.ConnectContext(ctx, addr, this.cfg.User, this.cfg.Password, "", func(c *client.Conn) {
+		c.SetDeadline(deadline)
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Ping()
+}
+
+func topologyAddrs(t *cluster.Topology) (string, []string) {
+	slaves := make([]string, 0, len(t.Slaves))
+	for _, s := range t.Slaves {
+		slaves = append(slaves, s.Addr())
+	}
+	return t.Master.Addr(), slaves
+}
+
+// 探测一次 master, 连续失败达到阈值后向 slave 确认, 票数足够则确认 master 故障.
+// 返回是否确认故障
+func (this *Monitor) Check() bool {
+	this.opMu.Lock()
+	defer this.opMu.Unlock()
+
+	master, slaves := topologyAddrs(this.cluster.Topology())
+	err := this.ping(master)
+	if err == nil {
+		this.failures = 0
+		return false
+	}
+
+	this.failures++
+	seelog.Warnf("集群 %s 探测 master %s 失败(%d/%d). %s", this.cluster.Name(), master,
+		this.failures, this.cfg.FailureThreshold, err.Error())
+	if this.failures < this.cfg.FailureThreshold {
+		return false
+	}
+
+	votes := 1 + this.slaveVotes(slaves)
+	quorum := this.cfg.Quorum
+	if quorum <= 0 {
+		quorum = (1+len(slaves))/2 + 1
+	}
+	if votes < quorum {
+		seelog.Warnf("集群 %s 的 master %s 故障票数 %d 小于 %d, 不切换", this.cluster.Name(), master, votes, quorum)
+		return false
+	}
+
+	this.failures = 0
+	this.emit(&Event{Type: EVENT_MASTER_DOWN, OldMaster: master, Slaves: slaves, Votes: votes})
+	if !this.cfg.AutoFailover || this.stopped {
+		return true
+	}
+
+	if err := this.failover(master, slaves); err != nil {
+		this.stopped = true
+	}
+	return true
+}
+
+// IO 线程没有运行的 slave 数, 连不上的 slave 不投票
+func (this *Monitor) slaveVotes(slaves []string) int {
+	votes := 0
+	for _, addr := range slaves {
+		s := this.newServer(addr)
+		r, err := s.SlaveStatus()
+		s.Close()
+		if err != nil {
+			seelog.Warnf("集群 %s 获取 slave %s 的状态出错. %s", this.cluster.Name(), addr, err.Error())
+			continue
+		}
+		if running, _ := r.GetStringByName(0, "Slave_IO_Running"); running != "Yes" {
+			votes++
+		}
+	}
+	return votes
+}
+
+func (this *Monitor) newServers(addrs []string) []*failover.Server {
+	servers := make([]*failover.Server, 0, len(addrs))
+	for _, addr := range addrs {
+		servers = append(servers, this.newServer(addr))
+	}
+	return servers
+}
+
+func closeServers(servers []*failover.Server) {
+	for _, s := range servers {
+		s.Close()
+	}
+}
+
+// 提升新 master: 不再作为 slave, 并且可写
+func promote(h failover.Handler, s *failover.Server) error {
+	if err := h.Promote(s); err != nil {
+		return err
+	}
+	if err := s.ResetSlaveALL(); err != nil {
+		return err
+	}
+	return s.SetReadonly(false)
+}
+
+// master 故障切换, 旧 master 已经不可用, 直接从拓扑中去掉
+func (this *Monitor) failover(oldMaster string, slaves []string) error {
+	if len(slaves) == 0 {
+		err := fmt.Errorf("集群 %s 没有 slave, 不能切换", this.cluster.Name())
+		this.emit(&Event{Type: EVENT_FAILOVER_FAILED, OldMaster: oldMaster, Err: err})
+		return err
+	}
+
+	servers := this.newServers(slaves)
+	defer closeServers(servers)
+
+	// FailoverMaster 已经执行了 Promote, 只需要让新 master 不再作为 slave 并且可写
+	newMaster, err := failover.FailoverMaster(this.cfg.Flavor, servers)
+	if err == nil {
+		err = newMaster.ResetSlaveALL()
+	}
+	if err == nil {
+		err = newMaster.SetReadonly(false)
+	}
+	if err != nil {
+		this.emit(&Event{Type: EVENT_FAILOVER_FAILED, OldMaster: oldMaster, Slaves: slaves, Err: err})
+		return err
+	}
+
+	remains := make([]string, 0, len(slaves)-1)
+	for _, addr := range slaves {
+		if addr != newMaster.Addr {
+			remains = append(remains, addr)
+		}
+	}
+
+	_, err = this.cluster.SwitchoverTo(newMaster.Addr, remains, cluster.SwitchoverConfig{
+		DrainTimeoutMS: this.cfg.DrainTimeoutMS,
+		Force:          true,
+	})
+	e := &Event{Type: EVENT_FAILOVER_DONE, OldMaster: oldMaster, NewMaster: newMaster.Addr, Slaves: remains, Err: err}
+	if err != nil {
+		e.Type = EVENT_FAILOVER_FAILED
+	}
+	this.emit(e)
+
+	return err
+}
+
+// 计划内切换, 旧 master 需要可用. newMaster 为空时选择数据最新的 slave.
+//  1. 暂停新的事务, 等待执行中的事务结束
+//  2. 旧 master 设置只读, 等待所有 slave 追上旧 master
+//  3. 提升新 master, 其他 slave 和旧 master 指向新 master
+//  4. 切换路由, 恢复事务
+func (this *Monitor) Switchover(newMaster string) (*cluster.Topology, error) {
+	this.opMu.Lock()
+	defer this.opMu.Unlock()
+
+	oldMaster, slaves := topologyAddrs(this.cluster.Topology())
+	t, err := this.switchover(oldMaster, slaves, newMaster)
+	e := &Event{Type: EVENT_SWITCHOVER_DONE, OldMaster: oldMaster, NewMaster: newMaster, Err: err}
+	if err != nil {
+		e.Type = EVENT_SWITCHOVER_FAILED
+		e.Slaves = slaves
+	} else {
+		e.NewMaster, e.Slaves = topologyAddrs(t)
+	}
+	this.emit(e)
+
+	return t, err
+}
+
+func (this *Monitor) switchover(oldMaster string, slaves []string, newMaster string) (*cluster.Topology, error) {
+	h, err := failover.NewHandler(this.cfg.Flavor)
+	if err != nil {
+		return nil, err
+	}
+
+	old := this.newServer(oldMaster)
+	defer old.Close()
+	servers := this.newServers(slaves)
+	defer closeServers(servers)
+
+	var target *failover.Server
+	if len(newMaster) == 0 {
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("集群 %s 没有 slave, 不能切换", this.cluster.Name())
+		}
+		bests, err := h.FindBestSlaves(servers)
+		if err != nil {
+			return nil, err
+		}
+		target = bests[0]
+	} else {
+		for _, s := range servers {
+			if s.Addr == newMaster {
+				target = s
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("%s 不是集群 %s 的 slave", newMaster, this.cluster.Name())
+		}
+	}
+
+	// 旧 master 变成新 master 的 slave
+	remains := []string{oldMaster}
+	for _, s := range servers {
+		if s != target {
+			remains = append(remains, s.Addr)
+		}
+	}
+
+	prepare := func() error {
+		if err := old.SetReadonly(true); err != nil {
+			return fmt.Errorf("旧 master %s 设置只读出错. %s", oldMaster, err.Error())
+		}
+		for _, s := range servers {
+			if err := h.WaitCatchMaster(s, old); err != nil {
+				// 还没有提升新 master, 恢复旧 master 可写
+				if rollbackErr := old.SetReadonly(false); rollbackErr != nil {
+					seelog.Errorf("旧 master %s 恢复可写出错. %s", oldMaster, rollbackErr.Error())
+				}
+				return fmt.Errorf("等待 slave %s 追上旧 master 出错. %s", s.Addr, err.Error())
+			}
+		}
+
+		if err := promote(h, target); err != nil {
+			return fmt.Errorf("提升新 master %s 出错, 需要人工处理. %s", target.Addr, err.Error())
+		}
+		if err := h.ChangeMasterTo(old, target); err != nil {
+			return fmt.Errorf("旧 master %s 指向新 master 出错, 需要人工处理. %s", oldMaster, err.Error())
+		}
+		for _, s := range servers {
+			if s == target {
+				continue
+			}
+			if err := h.ChangeMasterTo(s, target); err != nil {
+				return fmt.Errorf("slave %s 指向新 master 出错, 需要人工处理. %s", s.Addr, err.Error())
+			}
+		}
+		return nil
+	}
+
+	return this.cluster.SwitchoverTo(target.Addr, remains, cluster.SwitchoverConfig{
+		DrainTimeoutMS: this.cfg.DrainTimeoutMS,
+		Prepare:        prepare,
+	})
+}
@@ -0,0 +1,320 @@
+package ha
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/daiguadaidai/dal/mysqldb/cluster"
+	"github.com/daiguadaidai/dal/mysqldb/pool"
+)
+
+const testUUID = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+// 模拟复制状态的 MySQL
+type fakeMySQL struct {
+	sync.Mutex
+	l         net.Listener
+	master    string // 为空代表不是 slave
+	ioRunning bool
+	readOnly  bool
+	execPos   int64
+}
+
+func newFakeMySQL(t *testing.T, master string, ioRunning bool, execPos int64) *fakeMySQL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeMySQL{l: l, master: master, ioRunning: ioRunning, readOnly: len(master) > 0, execPos: execPos}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewConn(conn, "root", "123", &fakeHandler{m: m})
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+	return m
+}
+
+func (this *fakeMySQL) addr() string {
+	return this.l.Addr().String()
+}
+
+func (this *fakeMySQL) state() (string, bool, bool) {
+	this.Lock()
+	defer this.Unlock()
+	return this.master, this.ioRunning, this.readOnly
+}
+
+type fakeHandler struct {
+	server.EmptyHandler
+	m *fakeMySQL
+}
+
+var changeMasterRe = regexp.MustCompile(`MASTER_HOST = "([^"]*)", MASTER_PORT = (\d+)`)
+
+func resultset(names []string, values [][]interface{}) (*mysql.Result, error) {
+	rs, err := mysql.BuildSimpleResultset(names, values, false)
+	if err != nil {
+		return nil, err
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+func (this *fakeHandler) HandleQuery(query string) (*mysql.Result, error) {
+	m := this.m
+	m.Lock()
+	defer m.Unlock()
+
+	q := strings.ToUpper(strings.TrimSpace(query))
+	switch {
+	case q == "SELECT @@GTID_MODE":
+		return resultset([]string{"@@gtid_mode"}, [][]interface{}{{"ON"}})
+	case q == "SHOW SLAVE STATUS":
+		running := "No"
+		if m.ioRunning {
+			running = "Yes"
+		}
+		r, err := resultset([]string{"Slave_IO_Running", "Retrieved_Gtid_Set", "Relay_Master_Log_File", "Exec_Master_Log_Pos"},
+			[][]interface{}{{running, testUUID + ":1-10", "mysql-bin.000001", m.execPos}})
+		if err == nil && len(m.master) == 0 {
+			r.RowDatas = nil
+		}
+		return r, err
+	case q == "SHOW MASTER STATUS":
+		return resultset([]string{"Executed_Gtid_Set"}, [][]interface{}{{testUUID + ":1-10"}})
+	case strings.HasPrefix(q, "SELECT WAIT_UNTIL_SQL_THREAD_AFTER_GTIDS"):
+		return resultset([]string{"r"}, [][]interface{}{{int64(0)}})
+	case q == "STOP SLAVE IO_THREAD" || q == "STOP SLAVE":
+		m.ioRunning = false
+	case q == "START SLAVE":
+		m.ioRunning = true
+	case q == "RESET SLAVE ALL":
+		m.master = ""
+	case q == "SET GLOBAL READ_ONLY = ON":
+		m.readOnly = true
+	case q == "SET GLOBAL READ_ONLY = OFF":
+		m.readOnly = false
+	case strings.HasPrefix(q, "CHANGE MASTER TO"):
+		items := changeMasterRe.FindStringSubmatch(query)
+		m.master = net.JoinHostPort(items[1], items[2])
+	}
+	return &mysql.Result{}, nil
+}
+
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+type testEvents struct {
+	sync.Mutex
+	events []*Event
+}
+
+func (this *testEvents) hook(e *Event) {
+	this.Lock()
+	defer this.Unlock()
+	this.events = append(this.events, e)
+}
+
+func (this *testEvents) types() []EventType {
+	this.Lock()
+	defer this.Unlock()
+	types := make([]EventType, 0, len(this.events))
+	for _, e := range this.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func newTestMonitor(t *testing.T, cfg Config, master string, slaves ...*fakeMySQL) (*Monitor, *cluster.Cluster, *testEvents) {
+	addrs := make([]string, 0, len(slaves))
+	for _, s := range slaves {
+		addrs = append(addrs, s.addr())
+	}
+	c, err := cluster.NewCluster(cluster.Config{Name: "order", Master: master, Slaves: addrs,
+		Username: "root", Password: "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.User, cfg.Password, cfg.ReplUser, cfg.ReplPassword = "root", "123", "repl", "123"
+	m, err := NewMonitor(cfg, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := new(testEvents)
+	m.AddHook(events.hook)
+	return m, c, events
+}
+
+func Test_Monitor_Failover(t *testing.T) {
+	master := deadAddr(t)
+	s1 := newFakeMySQL(t, master, false, 100)
+	s2 := newFakeMySQL(t, master, false, 200)
+	m, c, events := newTestMonitor(t, Config{FailureThreshold: 2, AutoFailover: true}, master, s1, s2)
+
+	if m.Check() {
+		t.Fatal("第一次探测失败不应该确认故障")
+	}
+	if !m.Check() {
+		t.Fatal("连续两次探测失败应该确认故障")
+	}
+
+	types := events.types()
+	if len(types) != 2 || types[0] != EVENT_MASTER_DOWN || types[1] != EVENT_FAILOVER_DONE {
+		t.Fatalf("事件不对: %v", types)
+	}
+
+	// 数据最新的 s2 成为新 master
+	if c.Topology().String() != "master: "+s2.addr()+", slaves: ["+s1.addr()+"]" {
+		t.Fatalf("切换后的拓扑不对: %s", c.Topology().String())
+	}
+	if masterAddr, _, readOnly := s2.state(); masterAddr != "" || readOnly {
+		t.Fatalf("新 master 不应该是 slave 并且需要可写. master: %s, read_only: %t", masterAddr, readOnly)
+	}
+	if masterAddr, running, _ := s1.state(); masterAddr != s2.addr() || !running {
+		t.Fatalf("slave 应该指向新 master. master: %s, io running: %t", masterAddr, running)
+	}
+}
+
+func Test_Monitor_PingTimeout(t *testing.T) {
+	// 接受链接但是不返回握手包的 master
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	m := &Monitor{cfg: Config{CheckTimeoutMS: 100, User: "root", Password: "123"}}
+	start := time.Now()
+	if err = m.pingMaster(l.Addr().String()); err == nil {
+		t.Fatal("没有响应的 master 应该探测失败")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("探测应该在 check_timeout_ms 内结束, 实际: %s", elapsed)
+	}
+}
+
+func Test_Monitor_ForceFailover(t *testing.T) {
+	master := deadAddr(t)
+	s1 := newFakeMySQL(t, master, false, 100)
+	s2 := newFakeMySQL(t, master, false, 200)
+	m, c, events := newTestMonitor(t, Config{FailureThreshold: 1, AutoFailover: true, DrainTimeoutMS: 100}, master, s1, s2)
+
+	// 切换时还没有结束的事务
+	tx := c.Begin()
+	old := tx.Slaves[0]
+	conn, err := old.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Check() {
+		t.Fatal("探测失败应该确认故障")
+	}
+	if types := events.types(); len(types) != 2 || types[1] != EVENT_FAILOVER_DONE {
+		t.Fatalf("等待事务结束超时应该强制切换: %v", types)
+	}
+
+	// 旧拓扑在事务结束后才关闭, 之后归还的链接直接关闭
+	if _, err = conn.Execute("SELECT 1"); err != nil {
+		t.Fatalf("强制切换后执行中的事务应该还可以使用链接. %v", err)
+	}
+	c.End(tx)
+	if err = old.Release(conn); err != nil {
+		t.Fatal(err)
+	}
+	if old.NumOpen() != 0 {
+		t.Fatalf("关闭后归还的链接应该被关闭, 打开的链接数: %d", old.NumOpen())
+	}
+	if _, err = old.Get(); err != pool.ErrPoolClosed {
+		t.Fatalf("关闭后获取链接应该返回 ErrPoolClosed, 实际: %v", err)
+	}
+}
+
+func Test_Monitor_Quorum(t *testing.T) {
+	master := deadAddr(t)
+	// slave 的 IO 线程还在运行, 说明 master 只是 dal 访问不了
+	s1 := newFakeMySQL(t, master, true, 100)
+	s2 := newFakeMySQL(t, master, true, 200)
+	m, c, events := newTestMonitor(t, Config{FailureThreshold: 1, AutoFailover: true}, master, s1, s2)
+
+	if m.Check() {
+		t.Fatal("票数不够不应该确认故障")
+	}
+	if len(events.types()) != 0 || c.Topology().Master.Addr() != master {
+		t.Fatal("票数不够不应该切换")
+	}
+
+	// 一个 slave 也确认 master 故障, 2 票达到 3 个节点的过半数, 但是没有开启自动切换
+	s1.Lock()
+	s1.ioRunning = false
+	s1.Unlock()
+	m.cfg.AutoFailover = false
+	if !m.Check() {
+		t.Fatal("票数足够应该确认故障")
+	}
+	if types := events.types(); len(types) != 1 || types[0] != EVENT_MASTER_DOWN {
+		t.Fatalf("事件不对: %v", types)
+	}
+	if c.Topology().Master.Addr() != master {
+		t.Fatal("没有开启自动切换不应该切换")
+	}
+}
+
+func Test_Monitor_Switchover(t *testing.T) {
+	master := newFakeMySQL(t, "", false, 0)
+	s1 := newFakeMySQL(t, master.addr(), true, 100)
+	s2 := newFakeMySQL(t, master.addr(), true, 100)
+	m, c, events := newTestMonitor(t, Config{}, master.addr(), s1, s2)
+
+	if _, err := m.Switchover("127.0.0.1:1"); err == nil {
+		t.Fatal("新 master 不是 slave 应该报错")
+	}
+
+	topo, err := m.Switchover(s2.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topo != c.Topology() || topo.String() != "master: "+s2.addr()+", slaves: ["+master.addr()+", "+s1.addr()+"]" {
+		t.Fatalf("切换后的拓扑不对: %s", c.Topology().String())
+	}
+
+	types := events.types()
+	if len(types) != 2 || types[0] != EVENT_SWITCHOVER_FAILED || types[1] != EVENT_SWITCHOVER_DONE {
+		t.Fatalf("事件不对: %v", types)
+	}
+
+	// 旧 master 只读并且指向新 master
+	if masterAddr, running, readOnly := master.state(); masterAddr != s2.addr() || !running || !readOnly {
+		t.Fatalf("旧 master 应该成为只读的 slave. master: %s, io running: %t, read_only: %t", masterAddr, running, readOnly)
+	}
+	if masterAddr, _, readOnly := s2.state(); masterAddr != "" || readOnly {
+		t.Fatalf("新 master 不应该是 slave 并且需要可写. master: %s, read_only: %t", masterAddr, readOnly)
+	}
+	if masterAddr, _, _ := s1.state(); masterAddr != s2.addr() {
+		t.Fatalf("slave 应该指向新 master. master: %s", masterAddr)
+	}
+}
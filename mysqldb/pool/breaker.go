@@ -0,0 +1,342 @@
+package pool
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// 熔断器状态
+type BreakerState int32
+
+const (
+	BREAKER_STATE_CLOSED    BreakerState = iota // 正常, 所有请求都可以通过
+	BREAKER_STATE_OPEN                          // 熔断, 后端从路由中摘除, 等待探测
+	BREAKER_STATE_HALF_OPEN                     // 半开, 探测成功后允许少量请求通过
+)
+
+func (this BreakerState) String() string {
+	switch this {
+	case BREAKER_STATE_CLOSED:
+		return "closed"
+	case BREAKER_STATE_OPEN:
+		return "open"
+	case BREAKER_STATE_HALF_OPEN:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(this))
+}
+
+const (
+	BREAKER_DEFAULT_FAILURE_THRESHOLD   = 5
+	BREAKER_DEFAULT_ERROR_RATE          = 0.5
+	BREAKER_DEFAULT_WINDOW_SIZE         = 100
+	BREAKER_DEFAULT_MIN_REQUESTS        = 20
+	BREAKER_DEFAULT_PROBE_INTERVAL_MS   = 2000
+	BREAKER_DEFAULT_HALF_OPEN_SUCCESSES = 3
+)
+
+var ErrBreakerOpen = errors.New("backend circuit breaker is open")
+
+// 熔断器配置
+type BreakerConfig struct {
+	FailureThreshold   int     `toml:"failure_threshold"`     // 连续失败多少次熔断
+	ErrorRate          float64 `toml:"error_rate"`            // 窗口内错误率超过多少熔断
+	WindowSize         int     `toml:"window_size"`           // 计算错误率的窗口(最近多少次请求)
+	MinRequests        int     `toml:"min_requests"`          // 窗口内请求数达到多少才计算错误率
+	ProbeIntervalMS    int64   `toml:"probe_interval_ms"`     // 熔断后探测的间隔
+	HalfOpenSuccesses  int     `toml:"half_open_successes"`   // 半开状态下连续成功多少次恢复
+	HalfOpenMaxRunning int     `toml:"half_open_max_running"` // 半开状态下最多允许同时通过的请求
+}
+
+func (this *BreakerConfig) setDefault() {
+	if this.FailureThreshold < 1 {
+		this.FailureThreshold = BREAKER_DEFAULT_FAILURE_THRESHOLD
+	}
+	if this.ErrorRate <= 0 || this.ErrorRate > 1 {
+		this.ErrorRate = BREAKER_DEFAULT_ERROR_RATE
+	}
+	if this.WindowSize < 1 {
+		this.WindowSize = BREAKER_DEFAULT_WINDOW_SIZE
+	}
+	if this.MinRequests < 1 {
+		this.MinRequests = BREAKER_DEFAULT_MIN_REQUESTS
+	}
+	if this.ProbeIntervalMS < 1 {
+		this.ProbeIntervalMS = BREAKER_DEFAULT_PROBE_INTERVAL_MS
+	}
+	if this.HalfOpenSuccesses < 1 {
+		this.HalfOpenSuccesses = BREAKER_DEFAULT_HALF_OPEN_SUCCESSES
+	}
+	if this.HalfOpenMaxRunning < 1 {
+		this.HalfOpenMaxRunning = this.HalfOpenSuccesses
+	}
+}
+
+// 熔断器. 熔断后会定时探测后端, 探测成功进入半开状态, 半开状态下连续成功后恢复
+type Breaker struct {
+	sync.Mutex
+	name  string
+	cfg   BreakerConfig
+	state BreakerState
+
+	consecutiveFailures int
+	window              []bool // 最近请求是否失败, 环形数组
+	windowPos           int
+	windowCount         int
+	windowFailures      int
+
+	halfOpenSuccesses int
+	halfOpenRunning   int
+
+	probe         func() error // 探测后端是否可用
+	probing       bool
+	stopC         chan struct{}
+	onStateChange func(name string, from BreakerState, to BreakerState)
+	onOpen        func() // 熔断时调用, 链接池用来关闭空闲链接
+}
+
+func NewBreaker(name string, cfg BreakerConfig, probe func() error) *Breaker {
+	cfg.setDefault()
+
+	return &Breaker{
+		name:   name,
+		cfg:    cfg,
+		state:  BREAKER_STATE_CLOSED,
+		window: make([]bool, cfg.WindowSize),
+		probe:  probe,
+		stopC:  make(chan struct{}),
+	}
+}
+
+// 设置状态变化的回调, 路由可以通过该回调摘除/恢复后端
+func (this *Breaker) SetOnStateChange(f func(name string, from BreakerState, to BreakerState)) {
+	this.Lock()
+	this.onStateChange = f
+	this.Unlock()
+}
+
+func (this *Breaker) State() BreakerState {
+	this.Lock()
+	defer this.Unlock()
+	return this.state
+}
+
+// 后端是否可以参与路由
+func (this *Breaker) Available() bool {
+	return this.State() != BREAKER_STATE_OPEN
+}
+
+// 请求之前调用, 返回 ErrBreakerOpen 代表请求不能通过.
+// 通过后必须调用 Done 汇报请求结果
+func (this *Breaker) Allow() error {
+	this.Lock()
+	defer this.Unlock()
+
+	switch this.state {
+	case BREAKER_STATE_OPEN:
+		return ErrBreakerOpen
+	case BREAKER_STATE_HALF_OPEN:
+		if this.halfOpenRunning >= this.cfg.HalfOpenMaxRunning {
+			return ErrBreakerOpen
+		}
+		this.halfOpenRunning++
+	}
+
+	return nil
+}
+
+// 汇报通过 Allow 的请求结果
+func (this *Breaker) Done(err error) {
+	this.Lock()
+	if this.state == BREAKER_STATE_HALF_OPEN && this.halfOpenRunning > 0 {
+		this.halfOpenRunning--
+	}
+	this.Unlock()
+
+	this.Report(err)
+}
+
+// 通过 Allow 的请求没有访问后端(例如使用了空闲链接), 只释放半开状态的名额, 不计入统计
+func (this *Breaker) Skip() {
+	this.Lock()
+	if this.state == BREAKER_STATE_HALF_OPEN && this.halfOpenRunning > 0 {
+		this.halfOpenRunning--
+	}
+	this.Unlock()
+}
+
+// 汇报请求结果(不需要先调用 Allow). 只有后端故障相关的错误(IsBackendError)才会计入失败
+func (this *Breaker) Report(err error) {
+	failed := IsBackendError(err)
+
+	this.Lock()
+	defer this.Unlock()
+
+	switch this.state {
+	case BREAKER_STATE_HALF_OPEN:
+		if failed {
+			seelog.Warnf("%s. 熔断器半开状态下请求失败, 重新熔断. %v", this.name, err)
+			this.setState(BREAKER_STATE_OPEN)
+			return
+		}
+		this.halfOpenSuccesses++
+		if this.halfOpenSuccesses >= this.cfg.HalfOpenSuccesses {
+			seelog.Infof("%s. 熔断器半开状态下连续成功%d次, 恢复正常", this.name, this.halfOpenSuccesses)
+			this.setState(BREAKER_STATE_CLOSED)
+		}
+	case BREAKER_STATE_CLOSED:
+		this.record(failed)
+		if !failed {
+			return
+		}
+		if this.consecutiveFailures >= this.cfg.FailureThreshold {
+			seelog.Errorf("%s. 连续失败%d次, 熔断. %v", this.name, this.consecutiveFailures, err)
+			this.setState(BREAKER_STATE_OPEN)
+			return
+		}
+		if this.windowCount >= this.cfg.MinRequests &&
+			float64(this.windowFailures)/float64(this.windowCount) >= this.cfg.ErrorRate {
+			seelog.Errorf("%s. 最近%d次请求失败%d次, 熔断. %v",
+				this.name, this.windowCount, this.windowFailures, err)
+			this.setState(BREAKER_STATE_OPEN)
+		}
+	}
+}
+
+// 记录请求结果到窗口中, 需要在获取 mutex lock 后使用
+func (this *Breaker) record(failed bool) {
+	if failed {
+		this.consecutiveFailures++
+	} else {
+		this.consecutiveFailures = 0
+	}
+
+	if this.windowCount == len(this.window) {
+		if this.window[this.windowPos] {
+			this.windowFailures--
+		}
+	} else {
+		this.windowCount++
+	}
+	this.window[this.windowPos] = failed
+	if failed {
+		this.windowFailures++
+	}
+	this.windowPos = (this.windowPos + 1) % len(this.window)
+}
+
+// 重置统计信息, 需要在获取 mutex lock 后使用
+func (this *Breaker) reset() {
+	this.consecutiveFailures = 0
+	this.windowPos = 0
+	this.windowCount = 0
+	this.windowFailures = 0
+	this.halfOpenSuccesses = 0
+	this.halfOpenRunning = 0
+}
+
+// 修改状态, 需要在获取 mutex lock 后使用
+func (this *Breaker) setState(state BreakerState) {
+	from := this.state
+	if from == state {
+		return
+	}
+
+	this.state = state
+	this.reset()
+
+	if state == BREAKER_STATE_OPEN && !this.probing {
+		this.probing = true
+		go this.probeLoop()
+	}
+	if state == BREAKER_STATE_OPEN && this.onOpen != nil {
+		go this.onOpen()
+	}
+
+	if this.onStateChange != nil {
+		go this.onStateChange(this.name, from, state)
+	}
+}
+
+// 熔断后定时探测后端, 探测成功后进入半开状态
+func (this *Breaker) probeLoop() {
+	ticker := time.NewTicker(time.Duration(this.cfg.ProbeIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.stopC:
+			this.Lock()
+			this.probing = false
+			this.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		err := this.probe()
+
+		this.Lock()
+		if this.state != BREAKER_STATE_OPEN {
+			this.probing = false
+			this.Unlock()
+			return
+		}
+		if err != nil {
+			seelog.Warnf("%s. 熔断探测失败. %v", this.name, err)
+			this.Unlock()
+			continue
+		}
+
+		seelog.Infof("%s. 熔断探测成功, 进入半开状态", this.name)
+		this.setState(BREAKER_STATE_HALF_OPEN)
+		this.probing = false
+		this.Unlock()
+		return
+	}
+}
+
+// 停止探测
+func (this *Breaker) Close() {
+	select {
+	case <-this.stopC:
+	default:
+		close(this.stopC)
+	}
+}
+
+// 判断是否是后端故障相关的错误:
+// 链接被拒绝, 坏链接, 超时, Too many connections
+func IsBackendError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	err = errors.Cause(err)
+
+	if mysql.ErrorEqual(err, mysql.ErrBadConn) {
+		return true
+	}
+
+	switch e := err.(type) {
+	case *mysql.MyError:
+		return e.Code == mysql.ER_CON_COUNT_ERROR
+	case net.Error:
+		if e.Timeout() {
+			return true
+		}
+	}
+
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+
+	return stderrors.Is(err, syscall.ECONNREFUSED) || stderrors.Is(err, syscall.ECONNRESET)
+}
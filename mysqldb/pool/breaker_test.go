@@ -0,0 +1,259 @@
+package pool
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+// 测试熔断器状态变化: 正常 -> 熔断 -> 半开 -> 正常
+func Test_Breaker_State(t *testing.T) {
+	var probeOK int32
+	b := NewBreaker("test", BreakerConfig{
+		FailureThreshold:  3,
+		ProbeIntervalMS:   10,
+		HalfOpenSuccesses: 2,
+	}, func() error {
+		if atomic.LoadInt32(&probeOK) == 1 {
+			return nil
+		}
+		return mysql.ErrBadConn
+	})
+	defer b.Close()
+
+	// 非后端故障的错误不计入失败
+	for i := 0; i < 10; i++ {
+		b.Report(mysql.NewDefaultError(mysql.ER_DUP_ENTRY, "1", "PRIMARY"))
+	}
+	if b.State() != BREAKER_STATE_CLOSED {
+		t.Fatalf("业务错误不应该熔断, 当前状态:%s", b.State())
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatal(err)
+		}
+		b.Done(mysql.NewDefaultError(mysql.ER_CON_COUNT_ERROR))
+	}
+	if b.State() != BREAKER_STATE_OPEN {
+		t.Fatalf("连续失败后应该熔断, 当前状态:%s", b.State())
+	}
+	if err := b.Allow(); err != ErrBreakerOpen {
+		t.Fatalf("熔断后请求应该不能通过: %v", err)
+	}
+
+	// 探测失败一直保持熔断
+	time.Sleep(50 * time.Millisecond)
+	if b.State() != BREAKER_STATE_OPEN {
+		t.Fatalf("探测失败应该保持熔断, 当前状态:%s", b.State())
+	}
+
+	atomic.StoreInt32(&probeOK, 1)
+	waitBreakerState(t, b, BREAKER_STATE_HALF_OPEN)
+
+	// 半开状态下只允许少量请求通过
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Allow(); err != ErrBreakerOpen {
+		t.Fatal("半开状态下超过允许的请求数应该不能通过")
+	}
+	b.Done(nil)
+	b.Done(nil)
+	if b.State() != BREAKER_STATE_CLOSED {
+		t.Fatalf("半开状态下连续成功应该恢复, 当前状态:%s", b.State())
+	}
+}
+
+// 测试半开状态失败重新熔断
+func Test_Breaker_HalfOpenFailed(t *testing.T) {
+	b := NewBreaker("test", BreakerConfig{FailureThreshold: 1, ProbeIntervalMS: 10}, func() error { return nil })
+	defer b.Close()
+
+	changes := make(chan BreakerState, 10)
+	b.SetOnStateChange(func(name string, from BreakerState, to BreakerState) {
+		changes <- to
+	})
+
+	b.Report(mysql.ErrBadConn)
+	waitBreakerState(t, b, BREAKER_STATE_HALF_OPEN)
+
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(mysql.ErrBadConn)
+	if b.State() != BREAKER_STATE_OPEN && b.State() != BREAKER_STATE_HALF_OPEN {
+		t.Fatalf("半开状态下失败应该重新熔断, 当前状态:%s", b.State())
+	}
+
+	if to := <-changes; to != BREAKER_STATE_OPEN {
+		t.Fatalf("第一次状态变化应该是熔断, 实际:%s", to)
+	}
+}
+
+// 测试按照错误率熔断
+func Test_Breaker_ErrorRate(t *testing.T) {
+	b := NewBreaker("test", BreakerConfig{
+		FailureThreshold: 100,
+		ErrorRate:        0.5,
+		WindowSize:       10,
+		MinRequests:      10,
+	}, func() error { return errors.New("down") })
+	defer b.Close()
+
+	for i := 0; i < 10; i++ {
+		if i%2 == 1 {
+			b.Report(mysql.ErrBadConn)
+		} else {
+			b.Report(nil)
+		}
+	}
+	if b.State() != BREAKER_STATE_OPEN {
+		t.Fatalf("错误率达到阈值应该熔断, 当前状态:%s", b.State())
+	}
+}
+
+// 测试链接池熔断: 后端不可用时不会反复创建链接
+func Test_MySQLPool_Breaker(t *testing.T) {
+	p, err := Open("127.0.0.1", 1, username, password, db, charset, isAutoCommit, poolMinOpen, poolMaxOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.EnableBreaker(BreakerConfig{FailureThreshold: 2, ProbeIntervalMS: 60000})
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err = p.Get(); err == nil {
+			t.Fatal("后端不可用, 获取链接应该失败")
+		}
+	}
+	if p.Available() {
+		t.Fatal("连续链接失败后应该熔断")
+	}
+
+	start := time.Now()
+	if _, err = p.Get(); err == nil {
+		t.Fatal("熔断后获取链接应该失败")
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("熔断后获取链接应该直接返回")
+	}
+}
+
+// 使用中修改熔断配置, 需要使用 -race 运行
+func Test_MySQLPool_EnableBreakerConcurrent(t *testing.T) {
+	p, err := Open("127.0.0.1", 1, username, password, db, charset, isAutoCommit, poolMinOpen, poolMaxOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				p.Get()
+				p.Available()
+				p.Report(nil)
+				p.Breaker()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		p.EnableBreaker(BreakerConfig{FailureThreshold: 2, ProbeIntervalMS: 60000})
+	}
+	wg.Wait()
+}
+
+type okHandler struct {
+	server.EmptyHandler
+}
+
+func (h okHandler) HandleQuery(query string) (*mysql.Result, error) {
+	return &mysql.Result{}, nil
+}
+
+// 测试链接池熔断只统计新建链接: 使用空闲链接不会让半开状态恢复, 熔断时关闭空闲链接
+func Test_MySQLPool_BreakerIdleConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewConn(conn, "root", "123", okHandler{})
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	p, err := Open(addr.IP.String(), uint16(addr.Port), "root", "123", "", charset, true, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	b := p.EnableBreaker(BreakerConfig{FailureThreshold: 1, ProbeIntervalMS: 10, HalfOpenSuccesses: 2})
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(conn)
+
+	// 熔断后空闲链接被关闭
+	p.Report(mysql.ErrBadConn)
+	for i := 0; i < 100 && p.NumOpen() > 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p.NumOpen() != 0 {
+		t.Fatalf("熔断后应该关闭空闲链接, 当前链接数: %d", p.NumOpen())
+	}
+	waitBreakerState(t, b, BREAKER_STATE_HALF_OPEN)
+
+	// 新建链接成功一次, 使用空闲链接不计入成功次数
+	for i := 0; i < 3; i++ {
+		if conn, err = p.Get(); err != nil {
+			t.Fatal(err)
+		}
+		p.Release(conn)
+	}
+	if b.State() != BREAKER_STATE_HALF_OPEN {
+		t.Fatalf("只有一次访问后端, 应该还是半开状态, 当前状态:%s", b.State())
+	}
+	p.Report(nil)
+	if b.State() != BREAKER_STATE_CLOSED {
+		t.Fatalf("语句执行成功后应该恢复, 当前状态:%s", b.State())
+	}
+}
+
+func waitBreakerState(t *testing.T, b *Breaker, state BreakerState) {
+	for i := 0; i < 100; i++ {
+		if b.State() == state {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("等待熔断器状态:%s 超时, 当前状态:%s", state, b.State())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/cihub/seelog"
 	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/pingcap/errors"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -105,6 +106,8 @@ const (
 	MYSQL_POOL_MAX_OPEN_LIMIT = 1000
 )
 
+var ErrPoolClosed = errors.New("连接池已经关闭")
+
 type MySQLPool struct {
 	sync.Mutex
 	connChan  chan *client.Conn
@@ -113,6 +116,8 @@ type MySQLPool struct {
 	minOpen   int32
 	maxOpen   int32
 	numOpen   int32
+	breaker   atomic.Pointer[Breaker] // 熔断器, 为 nil 代表没有开启熔断. Get 时不获取 mutex lock, 所以使用原子操作
+	closed    bool                    // 关闭后 Get 返回 ErrPoolClosed, Release 直接关闭链接
 }
 
 func Open(
@@ -165,9 +170,69 @@ func Open(
 	return p, nil
 }
 
-// 关闭连接池
+// 开启熔断. 后端故障时 Get 直接返回 ErrBreakerOpen, 不再反复创建链接.
+// 熔断器只统计新建链接和执行语句(Report)的结果, 使用空闲链接不算访问了后端.
+// 熔断时关闭所有空闲链接, 熔断后会定时使用 Ping 探测后端, 探测成功后进入半开状态
+func (this *MySQLPool) EnableBreaker(cfg BreakerConfig) *Breaker {
+	this.Lock()
+	defer this.Unlock()
+
+	breaker := NewBreaker(this.cfg.addr(), cfg, this.probe)
+	breaker.onOpen = this.closeIdle
+	if old := this.breaker.Swap(breaker); old != nil {
+		old.Close()
+	}
+
+	return breaker
+}
+
+// 获取熔断器, 没有开启熔断返回 nil
+func (this *MySQLPool) Breaker() *Breaker {
+	return this.breaker.Load()
+}
+
+// 后端是否可用(熔断状态下不可用), 路由时需要跳过不可用的后端
+func (this *MySQLPool) Available() bool {
+	breaker := this.breaker.Load()
+	if breaker == nil {
+		return true
+	}
+	return breaker.Available()
+}
+
+// 汇报链接执行语句的结果, 用于熔断器统计后端错误率
+func (this *MySQLPool) Report(err error) {
+	if breaker := this.breaker.Load(); breaker != nil {
+		breaker.Report(err)
+	}
+}
+
+// 探测后端是否可用
+func (this *MySQLPool) probe() error {
+	conn, err := client.Connect(this.cfg.addr(), this.cfg.Username, this.cfg.Password, this.cfg.DBName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Ping()
+}
+
+// 关闭连接池, 还在使用的链接在 Release 时关闭
 func (this *MySQLPool) Close() {
+	this.Lock()
+	if this.closed {
+		this.Unlock()
+		return
+	}
+	this.closed = true
 	close(this.connChan)
+	this.Unlock()
+
+	if breaker := this.breaker.Load(); breaker != nil {
+		breaker.Close()
+	}
+
 	for conn := range this.connChan {
 		this.Lock()
 		if err := this.closeConn(conn); err != nil {
@@ -212,31 +277,62 @@ func (this *MySQLPool) deleteThreadMapItem(threadID uint32) {
 
 // 获取链接
 func (this *MySQLPool) Get() (*client.Conn, error) {
+	breaker := this.breaker.Load()
+	if breaker == nil {
+		conn, _, err := this.get()
+		return conn, err
+	}
+
+	// 熔断状态下直接返回错误
+	if err := breaker.Allow(); err != nil {
+		return nil, fmt.Errorf("%s. %s", this.cfg.addr(), err.Error())
+	}
+
+	conn, dialed, err := this.get()
+	if dialed {
+		breaker.Done(err)
+	} else {
+		breaker.Skip()
+	}
+
+	return conn, err
+}
+
+// 获取链接, dialed 代表是否新建了链接(访问了后端)
+func (this *MySQLPool) get() (conn *client.Conn, dialed bool, err error) {
 	// 先从chan中获取资源
 	select {
 	case conn, ok := <-this.connChan:
 		if ok {
-			return conn, nil
+			return conn, false, nil
 		}
 	default:
 	}
 
 	this.Lock()
-	// 等待获取资源
+	if this.closed {
+		this.Unlock()
+		return nil, false, ErrPoolClosed
+	}
+
+	// 等待获取资源, 等待中连接池关闭返回错误
 	if this.NumOpen() >= this.maxOpen {
 		this.Unlock()
-		conn := <-this.connChan
-		return conn, nil
+		conn, ok := <-this.connChan
+		if !ok {
+			return nil, false, ErrPoolClosed
+		}
+		return conn, false, nil
 	}
 
 	// 新键资源
 	this.incrNumOpen() // 添加已经使用资源
 	// 新键链接
-	conn, err := client.Connect(this.cfg.addr(), this.cfg.Username, this.cfg.Password, this.cfg.DBName)
+	conn, err = client.Connect(this.cfg.addr(), this.cfg.Username, this.cfg.Password, this.cfg.DBName)
 	if err != nil {
 		this.Unlock()
 		this.decrNumOpen() // 链接没有成功删除已经使用资源
-		return nil, fmt.Errorf("链接数据库出错: %s", err.Error())
+		return nil, true, errors.Annotate(err, "链接数据库出错")
 	}
 
 	// 设置链接开始使用时间戳
@@ -246,7 +342,7 @@ func (this *MySQLPool) Get() (*client.Conn, error) {
 	if err = conn.SetAutoCommit(this.cfg.IsAutoCommit); err != nil {
 		this.closeConn(conn)
 		this.Unlock()
-		return nil, fmt.Errorf("(新建链接)执行 set autocommit: %t 出错. %s",
+		return nil, true, fmt.Errorf("(新建链接)执行 set autocommit: %t 出错. %s",
 			this.cfg.IsAutoCommit, err.Error())
 	}
 
@@ -254,30 +350,53 @@ func (this *MySQLPool) Get() (*client.Conn, error) {
 	if err = conn.SetCharset(this.cfg.Charset); err != nil {
 		this.closeConn(conn)
 		this.Unlock()
-		return nil, fmt.Errorf("(新建链接)执行 set names %s 出错. %s",
+		return nil, true, fmt.Errorf("(新建链接)执行 set names %s 出错. %s",
 			this.cfg.Charset, err.Error())
 	}
 	this.Unlock()
 
-	return conn, nil
+	return conn, true, nil
+}
+
+// 关闭所有空闲链接, 熔断时空闲链接很可能已经不可用
+func (this *MySQLPool) closeIdle() {
+	for {
+		select {
+		case conn, ok := <-this.connChan:
+			if !ok {
+				return
+			}
+			this.Lock()
+			if err := this.closeConn(conn); err != nil {
+				seelog.Warnf("空闲链接(thread id): %d. 关闭失败. %s", conn.GetConnectionID(), err.Error())
+			}
+			this.Unlock()
+		default:
+			return
+		}
+	}
 }
 
-// 归还链接
+// 归还链接, 连接池已经关闭时直接关闭链接
 func (this *MySQLPool) Release(conn *client.Conn) error {
 	this.Lock()
+	defer this.Unlock()
 
-	if this.NumOpen() > this.maxOpen { // 关闭资源
+	if this.closed || this.NumOpen() > this.maxOpen { // 关闭资源
 		this.closeConn(conn)
-		this.Unlock()
 		return nil
 	}
 
-	this.Unlock()
-
+	// connChan 的容量是最大链接数的上限, 不会阻塞. 在锁中发送, 避免和 Close 同时进行
 	this.connChan <- conn
 	return nil
 }
 
+// 后端地址 host:port, 作为限流等功能中后端的标识
+func (this *MySQLPool) Addr() string {
+	return this.cfg.addr()
+}
+
 // 获取允许最大打开数
 func (this *MySQLPool) MaxOpen() int32 {
 	return this.maxOpen
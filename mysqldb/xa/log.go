@@ -0,0 +1,160 @@
+package xa
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_STATE_COMMIT = "commit" // 所有分支都 prepare 成功, 决定提交
+	LOG_STATE_DONE   = "done"   // 所有分支都提交完成
+)
+
+// 协调者日志中的一条记录
+type LogRecord struct {
+	Gtrid    string   `json:"gtrid"`
+	State    string   `json:"state"`
+	Branches []string `json:"branches,omitempty"`
+	Time     int64    `json:"time"`
+}
+
+// 协调者日志. 每行一条 json 记录, 每次写入都会 fsync.
+// 只记录提交决定和完成, 没有提交决定的事务在恢复时都会回滚(presumed abort).
+type FileLog struct {
+	sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileLog(path string) (*FileLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开XA协调者日志 %s 出错. %s", path, err.Error())
+	}
+
+	return &FileLog{path: path, file: f}, nil
+}
+
+// 追加一条记录, 返回前已经写入磁盘
+func (this *FileLog) Append(rec *LogRecord) error {
+	if rec.Time == 0 {
+		rec.Time = time.Now().Unix()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	this.Lock()
+	defer this.Unlock()
+
+	if _, err = this.file.Write(data); err != nil {
+		return fmt.Errorf("写XA协调者日志出错. %s", err.Error())
+	}
+	if err = this.file.Sync(); err != nil {
+		return fmt.Errorf("同步XA协调者日志到磁盘出错. %s", err.Error())
+	}
+	return nil
+}
+
+// 获取已经决定提交但是还没有完成的事务. gtrid -> 记录
+func (this *FileLog) Pending() (map[string]*LogRecord, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.pending()
+}
+
+// 需要在获取 mutex lock 后使用
+func (this *FileLog) pending() (map[string]*LogRecord, error) {
+	f, err := os.Open(this.path)
+	if err != nil {
+		return nil, fmt.Errorf("读取XA协调者日志 %s 出错. %s", this.path, err.Error())
+	}
+	defer f.Close()
+
+	pending := make(map[string]*LogRecord)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		rec := new(LogRecord)
+		if err = json.Unmarshal(line, rec); err != nil {
+			// 最后一行可能是崩溃时没有写完整的记录, 这时事务没有决定提交
+			continue
+		}
+		switch rec.State {
+		case LOG_STATE_COMMIT:
+			pending[rec.Gtrid] = rec
+		case LOG_STATE_DONE:
+			delete(pending, rec.Gtrid)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取XA协调者日志 %s 出错. %s", this.path, err.Error())
+	}
+
+	return pending, nil
+}
+
+// 压缩日志, 只保留还没有完成的提交记录
+func (this *FileLog) Compact() error {
+	this.Lock()
+	defer this.Unlock()
+
+	pending, err := this.pending()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := this.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("创建XA协调者日志临时文件 %s 出错. %s", tmpPath, err.Error())
+	}
+	w := bufio.NewWriter(f)
+	for _, rec := range pending {
+		data, _ := json.Marshal(rec)
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("写XA协调者日志临时文件 %s 出错. %s", tmpPath, err.Error())
+	}
+
+	if err = os.Rename(tmpPath, this.path); err != nil {
+		return fmt.Errorf("替换XA协调者日志出错. %s", err.Error())
+	}
+
+	// 重新打开新的日志文件
+	newFile, err := os.OpenFile(this.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("打开XA协调者日志 %s 出错. %s", this.path, err.Error())
+	}
+	this.file.Close()
+	this.file = newFile
+
+	return nil
+}
+
+func (this *FileLog) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.file.Close()
+}
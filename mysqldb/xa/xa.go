@@ -0,0 +1,406 @@
+package xa
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+const (
+	XA_FORMAT_ID    = 1
+	XA_GTRID_PREFIX = "dal"
+
+	XA_DEFAULT_RETRY_INTERVAL_MS = 1000
+)
+
+// 后端分片的链接, client.Conn 实现了该接口
+type Executor interface {
+	Execute(command string, args ...interface{}) (*mysql.Result, error)
+}
+
+// XA 事务协调者.
+// 事务只涉及一个分片时使用 XA COMMIT ... ONE PHASE, 多个分片时使用两阶段提交:
+//  1. 所有分支 XA END, XA PREPARE
+//  2. 写协调者日志(决定提交)
+//  3. 所有分支 XA COMMIT
+//  4. 写协调者日志(完成)
+//
+// 第二阶段提交失败的分支通过 StartRetry 在后台重试.
+// 启动时(Begin 之前)通过 Recover 处理上次运行悬挂的事务: 日志中决定提交的提交, 其他的回滚.
+type Coordinator struct {
+	sync.Mutex
+	nodeID     string // 区分不同的 dal 实例, 恢复时只处理自己的事务
+	log        *FileLog
+	seq        uint64
+	unfinished map[string][]string // 第二阶段没有完成的事务: gtrid -> 还没有提交成功的分片
+
+	recoverMu sync.Mutex // Recover 执行期间 Begin 需要等待
+	serving   bool       // 已经开始过事务, 不能再 Recover
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func NewCoordinator(nodeID string, log *FileLog) *Coordinator {
+	return &Coordinator{nodeID: nodeID, log: log, unfinished: make(map[string][]string), closeCh: make(chan struct{})}
+}
+
+// 停止后台重试
+func (this *Coordinator) Close() {
+	this.closeOnce.Do(func() {
+		close(this.closeCh)
+	})
+}
+
+func (this *Coordinator) gtridPrefix() string {
+	return fmt.Sprintf("%s-%s-", XA_GTRID_PREFIX, this.nodeID)
+}
+
+// 开始一个分布式事务, Recover 执行期间会等待恢复完成
+func (this *Coordinator) Begin() *Transaction {
+	this.recoverMu.Lock()
+	this.serving = true
+	this.recoverMu.Unlock()
+
+	gtrid := fmt.Sprintf("%s%d-%d", this.gtridPrefix(), time.Now().UnixNano(), atomic.AddUint64(&this.seq, 1))
+	return &Transaction{coordinator: this, gtrid: gtrid}
+}
+
+// 生成 xid, 使用16进制避免转义问题
+func xid(gtrid string, bqual string) string {
+	return fmt.Sprintf("X'%x',X'%x',%d", gtrid, bqual, XA_FORMAT_ID)
+}
+
+type branch struct {
+	shard string
+	conn  Executor
+}
+
+// 一个分布式事务, 不能并发使用
+type Transaction struct {
+	coordinator *Coordinator
+	gtrid       string
+	branches    []*branch
+	finished    bool
+}
+
+func (this *Transaction) Gtrid() string {
+	return this.gtrid
+}
+
+// 在分片上执行语句, 第一次使用该分片时会先执行 XA START
+func (this *Transaction) Execute(shard string, conn Executor, query string, args ...interface{}) (*mysql.Result, error) {
+	if this.finished {
+		return nil, fmt.Errorf("XA事务 %s 已经结束", this.gtrid)
+	}
+
+	b := this.branch(shard)
+	if b == nil {
+		if _, err := conn.Execute("XA START " + xid(this.gtrid, shard)); err != nil {
+			return nil, err
+		}
+		b = &branch{shard: shard, conn: conn}
+		this.branches = append(this.branches, b)
+	}
+
+	return b.conn.Execute(query, args...)
+}
+
+func (this *Transaction) branch(shard string) *branch {
+	for _, b := range this.branches {
+		if b.shard == shard {
+			return b
+		}
+	}
+	return nil
+}
+
+// 提交事务. 写入提交决定之前的错误会回滚所有分支.
+// 写入提交决定之后分支提交失败不会返回错误, 该分支由后台重试提交(参考 StartRetry), 重启后由 Recover 提交.
+func (this *Transaction) Commit() error {
+	if this.finished {
+		return fmt.Errorf("XA事务 %s 已经结束", this.gtrid)
+	}
+	this.finished = true
+
+	switch len(this.branches) {
+	case 0:
+		return nil
+	case 1:
+		b := this.branches[0]
+		x := xid(this.gtrid, b.shard)
+		if _, err := b.conn.Execute("XA END " + x); err != nil {
+			this.rollback()
+			return err
+		}
+		if _, err := b.conn.Execute("XA COMMIT " + x + " ONE PHASE"); err != nil {
+			this.rollback()
+			return err
+		}
+		return nil
+	}
+
+	// 第一阶段
+	for _, b := range this.branches {
+		x := xid(this.gtrid, b.shard)
+		if _, err := b.conn.Execute("XA END " + x); err != nil {
+			this.rollback()
+			return err
+		}
+		if _, err := b.conn.Execute("XA PREPARE " + x); err != nil {
+			this.rollback()
+			return err
+		}
+	}
+
+	shards := make([]string, 0, len(this.branches))
+	for _, b := range this.branches {
+		shards = append(shards, b.shard)
+	}
+	if err := this.coordinator.log.Append(&LogRecord{Gtrid: this.gtrid, State: LOG_STATE_COMMIT, Branches: shards}); err != nil {
+		this.rollback()
+		return err
+	}
+
+	// 第二阶段
+	var failed []string
+	for _, b := range this.branches {
+		if _, err := b.conn.Execute("XA COMMIT " + xid(this.gtrid, b.shard)); err != nil {
+			seelog.Errorf("XA事务 %s 分片 %s 提交失败, 等待后台重试提交. %s", this.gtrid, b.shard, err.Error())
+			failed = append(failed, b.shard)
+		}
+	}
+	if len(failed) > 0 {
+		this.coordinator.addUnfinished(this.gtrid, failed)
+		return nil
+	}
+	if err := this.coordinator.log.Append(&LogRecord{Gtrid: this.gtrid, State: LOG_STATE_DONE}); err != nil {
+		seelog.Warnf("XA事务 %s 写完成日志失败, 等待后台重试. %s", this.gtrid, err.Error())
+		this.coordinator.addUnfinished(this.gtrid, nil)
+	}
+
+	return nil
+}
+
+// 回滚事务
+func (this *Transaction) Rollback() error {
+	if this.finished {
+		return fmt.Errorf("XA事务 %s 已经结束", this.gtrid)
+	}
+	this.finished = true
+
+	return this.rollback()
+}
+
+// 回滚所有分支, 分支可能处于 ACTIVE, IDLE 或 PREPARED 状态
+func (this *Transaction) rollback() error {
+	var firstErr error
+	for _, b := range this.branches {
+		x := xid(this.gtrid, b.shard)
+		b.conn.Execute("XA END " + x) // 已经 END 的分支会报错, 忽略
+		if _, err := b.conn.Execute("XA ROLLBACK " + x); err != nil {
+			seelog.Errorf("XA事务 %s 分片 %s 回滚失败. %s", this.gtrid, b.shard, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (this *Coordinator) addUnfinished(gtrid string, shards []string) {
+	this.Lock()
+	defer this.Unlock()
+	this.unfinished[gtrid] = shards
+}
+
+// 在后台定时重试第二阶段没有完成的事务, 直到 Close. shards 为所有分片的链接, 不能和事务共用.
+// interval <= 0 时使用 XA_DEFAULT_RETRY_INTERVAL_MS
+func (this *Coordinator) StartRetry(shards map[string]Executor, interval time.Duration) {
+	if interval <= 0 {
+		interval = XA_DEFAULT_RETRY_INTERVAL_MS * time.Millisecond
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-this.closeCh:
+				return
+			case <-ticker.C:
+				this.RetryCommits(shards)
+			}
+		}
+	}()
+}
+
+// 重试一次第二阶段没有完成的事务: 提交失败的分支, 全部提交后写完成日志.
+// 返回还没有完成的事务数
+func (this *Coordinator) RetryCommits(shards map[string]Executor) int {
+	this.Lock()
+	unfinished := make(map[string][]string, len(this.unfinished))
+	for gtrid, branches := range this.unfinished {
+		unfinished[gtrid] = branches
+	}
+	this.Unlock()
+
+	for gtrid, branches := range unfinished {
+		var remains []string
+		for _, shard := range branches {
+			conn, ok := shards[shard]
+			if !ok {
+				seelog.Errorf("XA事务 %s 的分片 %s 没有提供链接, 无法重试提交", gtrid, shard)
+				remains = append(remains, shard)
+				continue
+			}
+			if _, err := conn.Execute("XA COMMIT " + xid(gtrid, shard)); err != nil && !isUnknownXid(err) {
+				seelog.Errorf("XA事务 %s 分片 %s 重试提交失败. %s", gtrid, shard, err.Error())
+				remains = append(remains, shard)
+			}
+		}
+		if len(remains) > 0 {
+			this.addUnfinished(gtrid, remains)
+			continue
+		}
+
+		if err := this.log.Append(&LogRecord{Gtrid: gtrid, State: LOG_STATE_DONE}); err != nil {
+			seelog.Warnf("XA事务 %s 写完成日志失败, 等待下次重试. %s", gtrid, err.Error())
+			this.addUnfinished(gtrid, nil)
+			continue
+		}
+		seelog.Infof("XA事务 %s 重试提交完成", gtrid)
+
+		this.Lock()
+		delete(this.unfinished, gtrid)
+		this.Unlock()
+	}
+
+	return this.Unfinished()
+}
+
+// 第二阶段没有完成的事务数
+func (this *Coordinator) Unfinished() int {
+	this.Lock()
+	defer this.Unlock()
+	return len(this.unfinished)
+}
+
+// 恢复上次运行悬挂的事务. shards 为所有分片的链接.
+// 每个分片执行 XA RECOVER, 只处理当前 dal 实例的事务: 日志中决定提交的提交, 其他的回滚.
+// 所有分片都处理成功后会压缩日志.
+// 执行中的事务也是悬挂的状态, 会被回滚, 所以只能在启动时, 开始处理事务之前执行:
+// 执行期间 Begin 会等待, 已经 Begin 过之后执行返回错误.
+func (this *Coordinator) Recover(shards map[string]Executor) error {
+	this.recoverMu.Lock()
+	defer this.recoverMu.Unlock()
+	if this.serving {
+		return fmt.Errorf("XA事务恢复只能在启动时, 开始事务之前执行")
+	}
+
+	pending, err := this.log.Pending()
+	if err != nil {
+		return err
+	}
+
+	prefix := this.gtridPrefix()
+	var firstErr error
+	for shard, conn := range shards {
+		xids, err := recoverXids(conn)
+		if err != nil {
+			seelog.Errorf("分片 %s 执行 XA RECOVER 失败. %s", shard, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, x := range xids {
+			if !strings.HasPrefix(x.gtrid, prefix) {
+				continue
+			}
+
+			if _, ok := pending[x.gtrid]; ok {
+				_, err = conn.Execute("XA COMMIT " + xid(x.gtrid, x.bqual))
+				seelog.Infof("恢复XA事务: 分片 %s, gtrid: %s, 提交", shard, x.gtrid)
+			} else {
+				_, err = conn.Execute("XA ROLLBACK " + xid(x.gtrid, x.bqual))
+				seelog.Infof("恢复XA事务: 分片 %s, gtrid: %s, 回滚", shard, x.gtrid)
+			}
+			if err != nil {
+				seelog.Errorf("分片 %s 恢复XA事务 %s 失败. %s", shard, x.gtrid, err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// 所有分片都已经没有悬挂的事务了, 标记为完成
+	for gtrid, rec := range pending {
+		for _, shard := range rec.Branches {
+			if _, ok := shards[shard]; !ok {
+				return fmt.Errorf("XA事务 %s 的分片 %s 没有提供链接, 无法恢复", gtrid, shard)
+			}
+		}
+		if err = this.log.Append(&LogRecord{Gtrid: gtrid, State: LOG_STATE_DONE}); err != nil {
+			return err
+		}
+	}
+
+	return this.log.Compact()
+}
+
+// 分支已经不存在(XAER_NOTA), 说明之前的提交其实已经成功了, 例如只是没有收到响应
+func isUnknownXid(err error) bool {
+	myErr, ok := errors.Cause(err).(*mysql.MyError)
+	return ok && myErr.Code == mysql.ER_XAER_NOTA
+}
+
+type recoveredXid struct {
+	gtrid string
+	bqual string
+}
+
+// 执行 XA RECOVER, 返回处于 PREPARED 状态的 xid
+func recoverXids(conn Executor) ([]recoveredXid, error) {
+	r, err := conn.Execute("XA RECOVER")
+	if err != nil {
+		return nil, err
+	}
+	if r.Resultset == nil {
+		return nil, nil
+	}
+
+	xids := make([]recoveredXid, 0, r.RowNumber())
+	for i := 0; i < r.RowNumber(); i++ {
+		formatID, err := r.GetIntByName(i, "formatID")
+		if err != nil {
+			return nil, err
+		}
+		gtridLen, err := r.GetIntByName(i, "gtrid_length")
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.GetStringByName(i, "data")
+		if err != nil {
+			return nil, err
+		}
+		if formatID != XA_FORMAT_ID || int(gtridLen) > len(data) {
+			continue
+		}
+		xids = append(xids, recoveredXid{gtrid: data[:gtridLen], bqual: data[gtridLen:]})
+	}
+
+	return xids, nil
+}
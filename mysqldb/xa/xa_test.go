@@ -0,0 +1,249 @@
+package xa
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// 模拟分片, 记录执行的语句, 维护 PREPARED 状态的 xid
+type fakeShard struct {
+	queries  []string
+	prepared map[string]string // xid -> gtrid+bqual
+	failOn   string            // 执行包含该字符串的语句时返回错误
+	failErr  error             // 返回的错误, 为 nil 时使用默认的错误
+}
+
+func newFakeShard() *fakeShard {
+	return &fakeShard{prepared: make(map[string]string)}
+}
+
+func (this *fakeShard) Execute(command string, args ...interface{}) (*mysql.Result, error) {
+	this.queries = append(this.queries, command)
+	if len(this.failOn) > 0 && strings.Contains(command, this.failOn) {
+		if this.failErr != nil {
+			return nil, this.failErr
+		}
+		return nil, fmt.Errorf("执行 %s 失败", command)
+	}
+
+	switch {
+	case strings.HasPrefix(command, "XA PREPARE "):
+		x := strings.TrimPrefix(command, "XA PREPARE ")
+		this.prepared[x] = decodeXid(x)
+	case strings.HasPrefix(command, "XA COMMIT "), strings.HasPrefix(command, "XA ROLLBACK "):
+		x := command[strings.Index(command, "X'"):]
+		delete(this.prepared, x)
+	case command == "XA RECOVER":
+		var values [][]interface{}
+		for x, data := range this.prepared {
+			gtrid := decodeXid(x[:strings.IndexByte(x, ',')])
+			values = append(values, []interface{}{XA_FORMAT_ID, len(gtrid), len(data) - len(gtrid), data})
+		}
+		rs, err := mysql.BuildSimpleResultset([]string{"formatID", "gtrid_length", "bqual_length", "data"}, values, false)
+		if err != nil {
+			return nil, err
+		}
+		// 和 client.Conn 返回的一样, 需要有解码后的值
+		rs.Values = values
+		rs.FieldNames = map[string]int{"formatID": 0, "gtrid_length": 1, "bqual_length": 2, "data": 3}
+		return &mysql.Result{Resultset: rs}, nil
+	}
+	return &mysql.Result{}, nil
+}
+
+// X'aa',X'bb',1 -> gtrid+bqual
+func decodeXid(x string) string {
+	var data []byte
+	for _, part := range strings.Split(x, ",") {
+		if !strings.HasPrefix(part, "X'") {
+			continue
+		}
+		var b []byte
+		fmt.Sscanf(strings.Trim(part[1:], "'"), "%x", &b)
+		data = append(data, b...)
+	}
+	return string(data)
+}
+
+func newTestCoordinator(t *testing.T, path string) *Coordinator {
+	log, err := NewFileLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return NewCoordinator("node1", log)
+}
+
+func Test_Transaction_TwoPhaseCommit(t *testing.T) {
+	c := newTestCoordinator(t, filepath.Join(t.TempDir(), "xa.log"))
+	s1, s2 := newFakeShard(), newFakeShard()
+
+	tx := c.Begin()
+	if _, err := tx.Execute("s1", s1, "insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Execute("s2", s2, "insert into t values(2)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Execute("s1", s1, "insert into t values(3)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	x := xid(tx.Gtrid(), "s1")
+	expect := []string{"XA START " + x, "insert into t values(1)", "insert into t values(3)",
+		"XA END " + x, "XA PREPARE " + x, "XA COMMIT " + x}
+	if strings.Join(s1.queries, ";") != strings.Join(expect, ";") {
+		t.Fatalf("分片 s1 执行的语句不对: %v", s1.queries)
+	}
+
+	pending, err := c.log.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("提交完成后不应该有未完成的事务: %v", pending)
+	}
+}
+
+func Test_Transaction_OnePhase(t *testing.T) {
+	c := newTestCoordinator(t, filepath.Join(t.TempDir(), "xa.log"))
+	s1 := newFakeShard()
+
+	tx := c.Begin()
+	tx.Execute("s1", s1, "update t set a = 1")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if last := s1.queries[len(s1.queries)-1]; !strings.HasSuffix(last, " ONE PHASE") {
+		t.Fatalf("只有一个分片应该使用一阶段提交: %v", s1.queries)
+	}
+}
+
+func Test_Transaction_PrepareFail(t *testing.T) {
+	c := newTestCoordinator(t, filepath.Join(t.TempDir(), "xa.log"))
+	s1, s2 := newFakeShard(), newFakeShard()
+	s2.failOn = "XA PREPARE"
+
+	tx := c.Begin()
+	tx.Execute("s1", s1, "insert into t values(1)")
+	tx.Execute("s2", s2, "insert into t values(2)")
+	if err := tx.Commit(); err == nil {
+		t.Fatal("prepare 失败提交应该返回错误")
+	}
+	if len(s1.prepared) != 0 {
+		t.Fatal("prepare 失败后所有分支都应该回滚")
+	}
+	if last := s1.queries[len(s1.queries)-1]; !strings.HasPrefix(last, "XA ROLLBACK ") {
+		t.Fatalf("分片 s1 应该回滚: %v", s1.queries)
+	}
+}
+
+func Test_Coordinator_RetryCommit(t *testing.T) {
+	c := newTestCoordinator(t, filepath.Join(t.TempDir(), "xa.log"))
+	defer c.Close()
+	s1, s2, s3 := newFakeShard(), newFakeShard(), newFakeShard()
+	shards := map[string]Executor{"s1": s1, "s2": s2, "s3": s3}
+
+	// 决定提交后 s2 提交失败
+	s2.failOn = "XA COMMIT"
+	tx := c.Begin()
+	tx.Execute("s1", s1, "insert into t values(1)")
+	tx.Execute("s2", s2, "insert into t values(2)")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.RetryCommits(shards); n != 1 {
+		t.Fatalf("分片还不能提交, 应该还有1个没有完成的事务, 实际: %d", n)
+	}
+
+	// s3 提交成功了但是没有收到响应, 重试时分支已经不存在
+	s3.failOn, s3.failErr = "XA COMMIT", mysql.NewError(mysql.ER_XAER_NOTA, "XAER_NOTA: Unknown XID")
+	tx = c.Begin()
+	tx.Execute("s1", s1, "insert into t values(3)")
+	tx.Execute("s3", s3, "insert into t values(4)")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2.failOn = ""
+	c.StartRetry(shards, 10*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for c.Unfinished() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(s2.prepared) != 0 {
+		t.Fatalf("后台应该重试提交 s2 的分支: %v", s2.prepared)
+	}
+	pending, err := c.log.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("重试提交完成后不应该有未完成的事务: %v", pending)
+	}
+}
+
+func Test_Coordinator_Recover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xa.log")
+	c := newTestCoordinator(t, path)
+	s1, s2 := newFakeShard(), newFakeShard()
+
+	// 决定提交后 s2 提交失败(模拟崩溃)
+	s2.failOn = "XA COMMIT"
+	committed := c.Begin()
+	committed.Execute("s1", s1, "insert into t values(1)")
+	committed.Execute("s2", s2, "insert into t values(2)")
+	if err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// prepare 之后没有决定提交(模拟崩溃)
+	inDoubt := c.Begin()
+	inDoubt.Execute("s1", s1, "insert into t values(3)")
+	s1.Execute("XA END " + xid(inDoubt.Gtrid(), "s1"))
+	s1.Execute("XA PREPARE " + xid(inDoubt.Gtrid(), "s1"))
+
+	// 其他 dal 实例的事务
+	s1.prepared["X'6f74686572',X'7331',1"] = "others1"
+
+	// 开始过事务之后不能恢复, 会回滚执行中的事务
+	if err := c.Recover(map[string]Executor{"s1": s1, "s2": s2}); err == nil {
+		t.Fatal("开始事务之后执行恢复应该返回错误")
+	}
+
+	// 重启后恢复
+	s2.failOn = ""
+	restarted := newTestCoordinator(t, path)
+	if err := restarted.Recover(map[string]Executor{"s1": s1, "s2": s2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s2.prepared) != 0 {
+		t.Fatalf("s2 的事务应该被提交: %v", s2.prepared)
+	}
+	if last := s2.queries[len(s2.queries)-1]; last != "XA COMMIT "+xid(committed.Gtrid(), "s2") {
+		t.Fatalf("s2 应该提交决定提交的事务: %v", s2.queries)
+	}
+	if last := s1.queries[len(s1.queries)-1]; last != "XA ROLLBACK "+xid(inDoubt.Gtrid(), "s1") {
+		t.Fatalf("s1 应该回滚没有决定提交的事务: %v", s1.queries)
+	}
+	if len(s1.prepared) != 1 {
+		t.Fatalf("不应该处理其他 dal 实例的事务: %v", s1.prepared)
+	}
+
+	pending, err := restarted.log.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("恢复后不应该有未完成的事务: %v", pending)
+	}
+}
@@ -0,0 +1,271 @@
+package account
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/pingcap/errors"
+)
+
+const (
+	ACCOUNT_DEFAULT_LOCK_TIME_MS = 10 * 60 * 1000
+
+	// 超过这个数量时清理过期的登录失败记录, 避免随机用户名暴力破解时占用太多内存
+	accountMaxFailureRecords = 10000
+)
+
+// 前端用户登录限制配置
+// [account]
+// max_failed_logins = 5
+// lock_time_ms = 600000
+// [[account.users]]
+// user = "app"
+// hosts = ["10.0.%", "192.168.1.0/24"]
+// max_connections = 200
+type Config struct {
+	MaxFailedLogins int64        `toml:"max_failed_logins"` // 连续登录失败多少次后锁定账号, <= 0 不锁定
+	LockTimeMS      int64        `toml:"lock_time_ms"`      // 锁定时间
+	Users           []UserConfig `toml:"users"`
+}
+
+type UserConfig struct {
+	User string `toml:"user"`
+	// 允许登录的客户端地址, 和 MySQL 的 'user'@'host' 相同:
+	// % 匹配任意字符, _ 匹配单个字符, 也支持 CIDR(10.0.0.0/8) 和子网掩码(10.0.0.0/255.0.0.0).
+	// 为空代表不限制
+	Hosts          []string `toml:"hosts"`
+	MaxConnections int64    `toml:"max_connections"` // 最大链接数, <= 0 不限制
+}
+
+type hostMatcher struct {
+	pattern string
+	ipNet   *net.IPNet
+}
+
+func newHostMatcher(pattern string) (*hostMatcher, error) {
+	m := &hostMatcher{pattern: strings.ToLower(pattern)}
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+			m.ipNet = ipNet
+			return m, nil
+		}
+		ip, mask := net.ParseIP(pattern[:i]).To4(), net.ParseIP(pattern[i+1:]).To4()
+		if ip == nil || mask == nil {
+			return nil, fmt.Errorf("host %s 格式错误", pattern)
+		}
+		m.ipNet = &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+	}
+	return m, nil
+}
+
+func (this *hostMatcher) match(host string) bool {
+	if this.ipNet != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && this.ipNet.Contains(ip)
+	}
+	return likeMatch(this.pattern, strings.ToLower(host))
+}
+
+// 和 SQL 的 LIKE 相同, % 匹配任意个字符, _ 匹配单个字符
+func likeMatch(pattern string, s string) bool {
+	if len(pattern) == 0 {
+		return len(s) == 0
+	}
+	switch pattern[0] {
+	case '%':
+		for i := 0; i <= len(s); i++ {
+			if likeMatch(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '_':
+		return len(s) > 0 && likeMatch(pattern[1:], s[1:])
+	}
+	return len(s) > 0 && s[0] == pattern[0] && likeMatch(pattern[1:], s[1:])
+}
+
+type userRule struct {
+	hosts          []*hostMatcher
+	maxConnections int64
+}
+
+// 登录失败的记录
+type failure struct {
+	count       int64
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// 前端用户的登录限制, 实现了 server.LoginPolicy, 通过 server.Server.SetLoginPolicy 设置.
+//   - 客户端地址限制
+//   - 最大链接数
+//   - 连续登录失败后锁定账号
+type Policy struct {
+	sync.Mutex
+	maxFailedLogins int64
+	lockTime        time.Duration
+	rules           map[string]*userRule
+	connections     map[string]int64
+	failures        map[string]*failure
+
+	now func() time.Time
+}
+
+var _ server.LoginPolicy = (*Policy)(nil)
+
+func NewPolicy(cfg Config) (*Policy, error) {
+	p := &Policy{
+		connections: make(map[string]int64),
+		failures:    make(map[string]*failure),
+		now:         time.Now,
+	}
+	if err := p.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// 修改配置, 配置错误时保留原来的配置. 已经建立的链接不受影响
+func (this *Policy) SetConfig(cfg Config) error {
+	rules := make(map[string]*userRule, len(cfg.Users))
+	for _, u := range cfg.Users {
+		rule := &userRule{maxConnections: u.MaxConnections}
+		for _, host := range u.Hosts {
+			m, err := newHostMatcher(host)
+			if err != nil {
+				return fmt.Errorf("用户 %s 的 %s", u.User, err.Error())
+			}
+			rule.hosts = append(rule.hosts, m)
+		}
+		rules[u.User] = rule
+	}
+
+	lockTime := time.Duration(cfg.LockTimeMS) * time.Millisecond
+	if lockTime <= 0 {
+		lockTime = ACCOUNT_DEFAULT_LOCK_TIME_MS * time.Millisecond
+	}
+
+	this.Lock()
+	this.rules = rules
+	this.maxFailedLogins = cfg.MaxFailedLogins
+	this.lockTime = lockTime
+	this.Unlock()
+
+	return nil
+}
+
+// 检查客户端地址和账号是否锁定
+func (this *Policy) CheckLogin(user string, host string) error {
+	this.Lock()
+	defer this.Unlock()
+
+	if rule, ok := this.rules[user]; ok && len(rule.hosts) > 0 {
+		allowed := false
+		for _, m := range rule.hosts {
+			if m.match(host) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			seelog.Warnf("用户 %s 不允许从 %s 登录", user, host)
+			return mysql.NewDefaultError(mysql.ER_HOST_NOT_PRIVILEGED, host)
+		}
+	}
+
+	if f, ok := this.failures[user]; ok && this.now().Before(f.lockedUntil) {
+		return mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR, fmt.Sprintf(
+			"Access denied for user '%s'@'%s'. Account is blocked for %d second(s) (%d second(s) remaining) due to %d consecutive failed logins.",
+			user, host, int64(this.lockTime/time.Second), int64(f.lockedUntil.Sub(this.now())/time.Second)+1, f.count))
+	}
+
+	return nil
+}
+
+// 记录登录失败次数, 检查最大链接数
+func (this *Policy) LoginDone(user string, host string, authErr error) error {
+	this.Lock()
+	defer this.Unlock()
+
+	if authErr != nil {
+		if isAccessDenied(authErr) {
+			this.addFailure(user, host)
+		}
+		return nil
+	}
+
+	delete(this.failures, user)
+
+	if rule, ok := this.rules[user]; ok && rule.maxConnections > 0 && this.connections[user] >= rule.maxConnections {
+		return mysql.NewDefaultError(mysql.ER_TOO_MANY_USER_CONNECTIONS, user)
+	}
+	this.connections[user]++
+
+	return nil
+}
+
+func (this *Policy) Logout(user string, host string) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.connections[user]--; this.connections[user] <= 0 {
+		delete(this.connections, user)
+	}
+}
+
+// 当前的链接数
+func (this *Policy) Connections(user string) int64 {
+	this.Lock()
+	defer this.Unlock()
+	return this.connections[user]
+}
+
+// 解锁账号
+func (this *Policy) UnlockAccount(user string) {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.failures, user)
+}
+
+func (this *Policy) addFailure(user string, host string) {
+	if this.maxFailedLogins <= 0 {
+		return
+	}
+
+	now := this.now()
+	f, ok := this.failures[user]
+	if !ok || (!f.lockedUntil.IsZero() && !now.Before(f.lockedUntil)) {
+		f = new(failure)
+		this.failures[user] = f
+	}
+	f.count++
+	f.lastFailure = now
+	if f.count >= this.maxFailedLogins {
+		f.lockedUntil = now.Add(this.lockTime)
+		seelog.Warnf("用户 %s 连续登录失败 %d 次(最后一次来自 %s), 锁定到 %s", user, f.count, host,
+			f.lockedUntil.Format("2006-01-02 15:04:05"))
+	}
+
+	if len(this.failures) > accountMaxFailureRecords {
+		for u, record := range this.failures {
+			if now.Sub(record.lastFailure) > this.lockTime && !now.Before(record.lockedUntil) {
+				delete(this.failures, u)
+			}
+		}
+	}
+}
+
+func isAccessDenied(err error) bool {
+	if err == server.ErrAccessDenied {
+		return true
+	}
+	myErr, ok := errors.Cause(err).(*mysql.MyError)
+	return ok && (myErr.Code == mysql.ER_ACCESS_DENIED_ERROR || myErr.Code == mysql.ER_NO_SUCH_USER)
+}
@@ -0,0 +1,186 @@
+package account
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+func Test_HostMatcher(t *testing.T) {
+	cases := []struct {
+		pattern string
+		host    string
+		match   bool
+	}{
+		{"10.0.%", "10.0.1.2", true},
+		{"10.0.%", "10.1.1.2", false},
+		{"%", "192.168.1.1", true},
+		{"192.168.1._", "192.168.1.5", true},
+		{"192.168.1._", "192.168.1.15", false},
+		{"10.0.0.0/8", "10.2.3.4", true},
+		{"10.0.0.0/8", "11.2.3.4", false},
+		{"192.168.1.0/255.255.255.0", "192.168.1.200", true},
+		{"192.168.1.0/255.255.255.0", "192.168.2.1", false},
+		{"App.Example.com", "app.example.com", true},
+	}
+	for _, c := range cases {
+		m, err := newHostMatcher(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.match(c.host) != c.match {
+			t.Fatalf("%s 匹配 %s 结果应该是 %v", c.pattern, c.host, c.match)
+		}
+	}
+
+	if _, err := newHostMatcher("10.0.0.0/abc"); err == nil {
+		t.Fatal("错误的子网掩码应该报错")
+	}
+}
+
+func Test_Policy_Lock(t *testing.T) {
+	p, err := NewPolicy(Config{MaxFailedLogins: 3, LockTimeMS: 60000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	denied := mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, "app", "127.0.0.1", "YES")
+	for i := 0; i < 2; i++ {
+		p.LoginDone("app", "127.0.0.1", denied)
+	}
+	// 登录成功后重新计数
+	p.LoginDone("app", "127.0.0.1", nil)
+	p.Logout("app", "127.0.0.1")
+	for i := 0; i < 2; i++ {
+		p.LoginDone("app", "127.0.0.1", denied)
+	}
+	// 其他错误不计数
+	p.LoginDone("app", "127.0.0.1", mysql.ErrBadConn)
+	if err = p.CheckLogin("app", "127.0.0.1"); err != nil {
+		t.Fatalf("没有达到失败次数不应该锁定. %v", err)
+	}
+
+	p.LoginDone("app", "127.0.0.1", denied)
+	if err = p.CheckLogin("app", "127.0.0.1"); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Fatalf("连续失败 3 次应该锁定. %v", err)
+	}
+	if err = p.CheckLogin("report", "127.0.0.1"); err != nil {
+		t.Fatalf("其他用户不应该被锁定. %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err = p.CheckLogin("app", "127.0.0.1"); err != nil {
+		t.Fatalf("锁定时间过后应该可以登录. %v", err)
+	}
+	// 解锁后重新计数
+	p.LoginDone("app", "127.0.0.1", denied)
+	if err = p.CheckLogin("app", "127.0.0.1"); err != nil {
+		t.Fatalf("解锁后应该重新计数. %v", err)
+	}
+}
+
+func startServer(t *testing.T, p server.LoginPolicy) string {
+	serverConf := server.NewDefaultServer()
+	serverConf.SetLoginPolicy(p)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	provider := server.NewInMemoryProvider()
+	provider.AddUser("app", "pass")
+	provider.AddUser("report", "pass")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewCustomizedConn(conn, serverConf, provider, server.EmptyHandler{})
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func errorCode(err error) uint16 {
+	if myErr, ok := err.(*mysql.MyError); ok {
+		return myErr.Code
+	}
+	if i := strings.Index(err.Error(), "ERROR "); i >= 0 {
+		var code uint16
+		for _, ch := range err.Error()[i+6:] {
+			if ch < '0' || ch > '9' {
+				break
+			}
+			code = code*10 + uint16(ch-'0')
+		}
+		return code
+	}
+	return 0
+}
+
+func Test_Policy_Handshake(t *testing.T) {
+	p, err := NewPolicy(Config{
+		MaxFailedLogins: 2,
+		Users: []UserConfig{
+			{User: "app", Hosts: []string{"127.0.0.%"}, MaxConnections: 1},
+			{User: "report", Hosts: []string{"10.0.0.0/8"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, p)
+
+	if _, err = client.Connect(addr, "report", "pass", ""); err == nil || errorCode(err) != mysql.ER_HOST_NOT_PRIVILEGED {
+		t.Fatalf("不允许的客户端地址应该登录失败. %v", err)
+	}
+
+	conn, err := client.Connect(addr, "app", "pass", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Connect(addr, "app", "pass", ""); err == nil || errorCode(err) != mysql.ER_TOO_MANY_USER_CONNECTIONS {
+		t.Fatalf("超过最大链接数应该登录失败. %v", err)
+	}
+	conn.Close()
+	for i := 0; i < 100 && p.Connections("app") > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.Connections("app") != 0 {
+		t.Fatalf("链接关闭后链接数应该减少, 当前: %d", p.Connections("app"))
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = client.Connect(addr, "app", "wrong", ""); err == nil || errorCode(err) != mysql.ER_ACCESS_DENIED_ERROR {
+			t.Fatalf("密码错误应该登录失败. %v", err)
+		}
+	}
+	if _, err = client.Connect(addr, "app", "pass", ""); err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Fatalf("账号锁定后应该登录失败. %v", err)
+	}
+
+	p.UnlockAccount("app")
+	conn, err = client.Connect(addr, "app", "pass", "")
+	if err != nil {
+		t.Fatalf("解锁后应该可以登录. %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,356 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/server/sqlutil"
+)
+
+const (
+	CACHE_DEFAULT_MAX_ENTRIES = 10000
+	CACHE_DEFAULT_MAX_BYTES   = 64 * 1024 * 1024
+	CACHE_DEFAULT_TTL_MS      = 60000
+
+	// 语句中带有该 hint 的 SELECT 也会被缓存. 例如: select /*+ dal_cache */ * from t
+	CACHE_HINT = "dal_cache"
+)
+
+// 结果中有这些函数的语句每次执行的结果都可能不一样, 不缓存
+var nonDeterministicFuncs = []string{
+	"now(", "sysdate(", "curdate(", "curtime(", "current_timestamp", "current_date", "current_time",
+	"unix_timestamp(", "utc_timestamp(", "localtime", "rand(", "uuid(", "uuid_short(",
+	"connection_id(", "last_insert_id(", "found_rows(", "row_count(", "get_lock(", "sleep(",
+	"user(", "current_user", "database(", "schema(", "@",
+}
+
+// 查询结果缓存配置
+// [cache]
+// enable = true
+// max_entries = 10000
+// ttl_ms = 60000
+// fingerprints = ["select * from t where id = ?"]
+type Config struct {
+	Enable       bool     `toml:"enable"`
+	MaxEntries   int      `toml:"max_entries"`  // 最多缓存的结果集个数
+	MaxBytes     int64    `toml:"max_bytes"`    // 缓存的结果集最多占用的内存
+	TTLMS        int64    `toml:"ttl_ms"`       // 缓存的过期时间, binlog 中断时作为兜底
+	Fingerprints []string `toml:"fingerprints"` // 需要缓存的 SQL 指纹, 可以是 sqlutil.Fingerprint 或 sqlutil.FingerprintID
+}
+
+// 缓存统计
+type Stats struct {
+	Entries       int
+	Bytes         int64
+	Hits          int64
+	Misses        int64
+	Invalidations int64 // 由于表数据变更删除的缓存个数
+	Evictions     int64 // 由于容量或过期删除的缓存个数
+}
+
+func (this Stats) String() string {
+	return fmt.Sprintf("entries: %d, bytes: %d, hits: %d, misses: %d, invalidations: %d, evictions: %d",
+		this.Entries, this.Bytes, this.Hits, this.Misses, this.Invalidations, this.Evictions)
+}
+
+type entry struct {
+	key      string
+	rs       *mysql.Resultset
+	tables   []string
+	size     int64
+	expireAt time.Time
+	elem     *list.Element
+}
+
+// 获取缓存前需要先获取 Ticket, 执行完语句后通过 Ticket 放入缓存.
+// Ticket 记录了获取时相关表的版本, 如果执行期间表数据发生了变更, 结果不会放入缓存.
+type Ticket struct {
+	key      string
+	tables   []string
+	versions []uint64
+	epoch    uint64
+}
+
+// 查询结果缓存.
+// key 为 user + db + 规范化后的 SQL, 只缓存文本协议的 SELECT 结果集, 事务中不读取也不放入缓存.
+// 通过 binlog 中的表数据变更(Invalidator)或者 dal 自己执行的写语句(InvalidateByQuery)使缓存失效.
+type Cache struct {
+	sync.Mutex
+	cfg          Config
+	fingerprints map[string]bool
+
+	entries  map[string]*entry
+	lru      *list.List                   // 最近使用的在前面
+	tables   map[string]map[string]*entry // db.table -> 使用了该表的缓存
+	versions map[string]uint64            // db.table -> 版本, 表数据每次变更 +1
+	epoch    uint64                       // 清空缓存时 +1
+	bytes    int64
+
+	hits          int64
+	misses        int64
+	invalidations int64
+	evictions     int64
+}
+
+func NewCache(cfg Config) *Cache {
+	c := &Cache{
+		entries:  make(map[string]*entry),
+		lru:      list.New(),
+		tables:   make(map[string]map[string]*entry),
+		versions: make(map[string]uint64),
+	}
+	c.SetConfig(cfg)
+
+	return c
+}
+
+// 修改配置, 已经缓存的结果不会失效
+func (this *Cache) SetConfig(cfg Config) {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = CACHE_DEFAULT_MAX_ENTRIES
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = CACHE_DEFAULT_MAX_BYTES
+	}
+	if cfg.TTLMS <= 0 {
+		cfg.TTLMS = CACHE_DEFAULT_TTL_MS
+	}
+
+	fingerprints := make(map[string]bool, len(cfg.Fingerprints))
+	for _, fp := range cfg.Fingerprints {
+		fingerprints[strings.ToLower(strings.TrimSpace(fp))] = true
+	}
+
+	this.Lock()
+	this.cfg = cfg
+	this.fingerprints = fingerprints
+	this.evict(time.Now())
+	this.Unlock()
+}
+
+// 是否可以缓存该语句: 匹配配置的指纹或者带有 CACHE_HINT 的 SELECT, 并且不包含不确定的函数
+func (this *Cache) Cacheable(query string) bool {
+	this.Lock()
+	enable := this.cfg.Enable
+	fingerprints := this.fingerprints
+	this.Unlock()
+
+	if !enable || sqlutil.GetStmtType(query) != sqlutil.STMT_SELECT {
+		return false
+	}
+
+	fp := sqlutil.Fingerprint(query)
+	if !sqlutil.HasHint(query, CACHE_HINT) && !fingerprints[fp] && !fingerprints[sqlutil.FingerprintID(query)] {
+		return false
+	}
+	for _, fn := range nonDeterministicFuncs {
+		if strings.Contains(fp, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func cacheKey(user string, db string, query string) string {
+	return user + "\x00" + db + "\x00" + sqlutil.Normalize(query)
+}
+
+// 获取缓存的结果集. 没有命中时返回可以用来放入缓存的 Ticket, 语句不能缓存时 Ticket 为 nil.
+// 返回的结果集是共享的, 不能修改.
+// inTrans 代表链接是否在事务中(状态中有 SERVER_STATUS_IN_TRANS 或者关闭了自动提交), 事务中不使用缓存:
+// 事务中的读需要看到自己还没有提交的修改, 也不能读到比事务的快照新的数据.
+func (this *Cache) Get(user string, db string, query string, inTrans bool) (*mysql.Resultset, *Ticket) {
+	if inTrans || !this.Cacheable(query) {
+		return nil, nil
+	}
+
+	key := cacheKey(user, db, query)
+	var tables []string
+
+	this.Lock()
+	defer this.Unlock()
+
+	if e, ok := this.entries[key]; ok {
+		if time.Now().Before(e.expireAt) {
+			this.lru.MoveToFront(e.elem)
+			this.hits++
+			return e.rs, nil
+		}
+		tables = e.tables
+		this.remove(e)
+		this.evictions++
+	}
+	this.misses++
+
+	if tables == nil {
+		// 有解析不了的表引用时不缓存, 漏掉的表修改后缓存不会失效
+		var ok bool
+		if tables, ok = sqlutil.ExtractTablesStrict(query, db); !ok {
+			return nil, nil
+		}
+	}
+	if len(tables) == 0 { // 没有使用表, 无法失效
+		return nil, nil
+	}
+
+	ticket := &Ticket{key: key, tables: tables, versions: make([]uint64, len(tables)), epoch: this.epoch}
+	for i, table := range tables {
+		ticket.versions[i] = this.versions[table]
+	}
+
+	return nil, ticket
+}
+
+// 放入缓存. 获取 Ticket 之后相关的表数据发生了变更则不会放入.
+// inTrans 代表语句执行后链接是否在事务中(例如关闭了自动提交时 SELECT 开始了事务), 事务中的结果不放入缓存
+func (this *Cache) Put(ticket *Ticket, rs *mysql.Resultset, inTrans bool) {
+	if ticket == nil || rs == nil || inTrans {
+		return
+	}
+
+	size := resultsetSize(rs)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if !this.cfg.Enable || size > this.cfg.MaxBytes || ticket.epoch != this.epoch {
+		return
+	}
+	for i, table := range ticket.tables {
+		if this.versions[table] != ticket.versions[i] {
+			return
+		}
+	}
+
+	if old, ok := this.entries[ticket.key]; ok {
+		this.remove(old)
+	}
+
+	now := time.Now()
+	e := &entry{
+		key:      ticket.key,
+		rs:       rs,
+		tables:   ticket.tables,
+		size:     size,
+		expireAt: now.Add(time.Duration(this.cfg.TTLMS) * time.Millisecond),
+	}
+	e.elem = this.lru.PushFront(e)
+	this.entries[e.key] = e
+	this.bytes += size
+	for _, table := range e.tables {
+		keys, ok := this.tables[table]
+		if !ok {
+			keys = make(map[string]*entry)
+			this.tables[table] = keys
+		}
+		keys[e.key] = e
+	}
+
+	this.evict(now)
+}
+
+// 删除使用了该表的缓存
+func (this *Cache) InvalidateTable(db string, table string) {
+	name := strings.ToLower(db) + "." + strings.ToLower(table)
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.versions[name]++
+	for _, e := range this.tables[name] {
+		this.remove(e)
+		this.invalidations++
+	}
+}
+
+// dal 执行了写语句后, 马上删除语句中涉及的表的缓存, 不需要等待 binlog
+func (this *Cache) InvalidateByQuery(db string, query string) {
+	if !sqlutil.GetStmtType(query).IsWrite() {
+		return
+	}
+	tables, ok := sqlutil.ExtractTablesStrict(query, db)
+	if !ok { // 找不全修改的表, 清空所有缓存
+		this.Purge()
+		return
+	}
+	for _, name := range tables {
+		if idx := strings.IndexByte(name, '.'); idx >= 0 {
+			this.InvalidateTable(name[:idx], name[idx+1:])
+		}
+	}
+}
+
+// 清空所有缓存. binlog 同步中断(可能漏掉了数据变更)时需要调用
+func (this *Cache) Purge() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.epoch++
+	this.invalidations += int64(len(this.entries))
+	this.entries = make(map[string]*entry)
+	this.lru.Init()
+	this.tables = make(map[string]map[string]*entry)
+	this.bytes = 0
+}
+
+func (this *Cache) Stats() Stats {
+	this.Lock()
+	defer this.Unlock()
+
+	return Stats{
+		Entries:       len(this.entries),
+		Bytes:         this.bytes,
+		Hits:          this.hits,
+		Misses:        this.misses,
+		Invalidations: this.invalidations,
+		Evictions:     this.evictions,
+	}
+}
+
+// 需要在获取 mutex lock 后使用
+func (this *Cache) remove(e *entry) {
+	if _, ok := this.entries[e.key]; !ok {
+		return
+	}
+
+	delete(this.entries, e.key)
+	this.lru.Remove(e.elem)
+	this.bytes -= e.size
+	for _, table := range e.tables {
+		if keys, ok := this.tables[table]; ok {
+			delete(keys, e.key)
+			if len(keys) == 0 {
+				delete(this.tables, table)
+			}
+		}
+	}
+}
+
+// 淘汰过期和超出容量的缓存, 需要在获取 mutex lock 后使用
+func (this *Cache) evict(now time.Time) {
+	for this.lru.Len() > 0 {
+		e := this.lru.Back().Value.(*entry)
+		if len(this.entries) <= this.cfg.MaxEntries && this.bytes <= this.cfg.MaxBytes && now.Before(e.expireAt) {
+			break
+		}
+		this.remove(e)
+		this.evictions++
+	}
+}
+
+// 估算结果集占用的内存, 编码后的数据和解码后的值各算一份
+func resultsetSize(rs *mysql.Resultset) int64 {
+	var size int64
+	for _, f := range rs.Fields {
+		size += int64(len(f.Schema) + len(f.Table) + len(f.OrgTable) + len(f.Name) + len(f.OrgName) + 32)
+	}
+	for _, row := range rs.RowDatas {
+		size += int64(len(row)) * 2
+	}
+	return size
+}
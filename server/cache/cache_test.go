@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/canal"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/schema"
+)
+
+func buildResultset(t *testing.T, values [][]interface{}) *mysql.Resultset {
+	rs, err := mysql.BuildSimpleResultset([]string{"id", "name"}, values, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func Test_Cache(t *testing.T) {
+	c := NewCache(Config{Enable: true, Fingerprints: []string{"select id, name from t where id = ?"}})
+	rs := buildResultset(t, [][]interface{}{{1, "a"}})
+
+	if r, ticket := c.Get("u1", "db1", "select id, name from t where id = 2 for update", false); r != nil || ticket != nil {
+		t.Fatal("for update 语句不能缓存")
+	}
+	if r, ticket := c.Get("u1", "db1", "select * from t2", false); r != nil || ticket != nil {
+		t.Fatal("没有配置的语句不能缓存")
+	}
+	if r, ticket := c.Get("u1", "db1", "select /*+ dal_cache */ now() from t2", false); r != nil || ticket != nil {
+		t.Fatal("包含不确定函数的语句不能缓存")
+	}
+
+	r, ticket := c.Get("u1", "db1", "select id, name from t where id = 1", false)
+	if r != nil || ticket == nil {
+		t.Fatal("第一次获取应该没有命中")
+	}
+	c.Put(ticket, rs, false)
+
+	if r, _ = c.Get("u1", "db1", "select  id, name\n from t where id = 1; -- comment", false); r != rs {
+		t.Fatal("规范化后相同的语句应该命中")
+	}
+	if r, _ = c.Get("u1", "db1", "select id, name from t where id = 2", false); r != nil {
+		t.Fatal("参数不同不应该命中")
+	}
+	if r, _ = c.Get("u2", "db1", "select id, name from t where id = 1", false); r != nil {
+		t.Fatal("用户不同不应该命中")
+	}
+	if r, _ = c.Get("u1", "db2", "select id, name from t where id = 1", false); r != nil {
+		t.Fatal("数据库不同不应该命中")
+	}
+
+	c.InvalidateByQuery("db1", "update t set name = 'b' where id = 1")
+	if r, _ = c.Get("u1", "db1", "select id, name from t where id = 1", false); r != nil {
+		t.Fatal("写语句之后缓存应该失效")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Invalidations != 1 || stats.Entries != 0 {
+		t.Fatalf("统计信息不对: %s", stats)
+	}
+}
+
+func Test_Cache_InvalidateDuringQuery(t *testing.T) {
+	c := NewCache(Config{Enable: true})
+	query := "select /*+ dal_cache */ id, name from db1.t"
+
+	_, ticket := c.Get("u1", "", query, false)
+	if ticket == nil {
+		t.Fatal("带 hint 的语句应该可以缓存")
+	}
+	// 执行期间表数据发生变更
+	c.InvalidateTable("DB1", "T")
+	c.Put(ticket, buildResultset(t, [][]interface{}{{1, "a"}}), false)
+
+	if r, _ := c.Get("u1", "", query, false); r != nil {
+		t.Fatal("执行期间表数据发生变更, 结果不应该放入缓存")
+	}
+}
+
+func Test_Cache_InTransaction(t *testing.T) {
+	c := NewCache(Config{Enable: true})
+	query := "select /*+ dal_cache */ id, name from db1.t"
+
+	// 事务中执行的语句结果不放入缓存
+	_, ticket := c.Get("u1", "", query, false)
+	c.Put(ticket, buildResultset(t, [][]interface{}{{1, "a"}}), true)
+	if r, _ := c.Get("u1", "", query, false); r != nil {
+		t.Fatal("事务中的结果不应该放入缓存")
+	}
+
+	_, ticket = c.Get("u1", "", query, false)
+	c.Put(ticket, buildResultset(t, [][]interface{}{{1, "a"}}), false)
+	// 事务中修改了表但是还没有提交, 缓存还没有失效, 事务中不能读缓存
+	if r, ticket := c.Get("u1", "", query, true); r != nil || ticket != nil {
+		t.Fatal("事务中不应该使用缓存")
+	}
+	if r, _ := c.Get("u1", "", query, false); r == nil {
+		t.Fatal("事务外应该命中缓存")
+	}
+}
+
+func Test_Cache_TableRefs(t *testing.T) {
+	c := NewCache(Config{Enable: true})
+
+	// STRAIGHT_JOIN 后面的表修改后缓存需要失效
+	query := "select /*+ dal_cache */ * from t1 straight_join t2 force index(i) on t1.id = t2.id"
+	_, ticket := c.Get("u1", "db1", query, false)
+	if ticket == nil {
+		t.Fatal("语句应该可以缓存")
+	}
+	c.Put(ticket, buildResultset(t, [][]interface{}{{1, "a"}}), false)
+	c.InvalidateTable("db1", "t2")
+	if r, _ := c.Get("u1", "db1", query, false); r != nil {
+		t.Fatal("第二个表修改后缓存应该失效")
+	}
+
+	// 解析不了的表引用不缓存
+	if r, ticket := c.Get("u1", "db1", "select /*+ dal_cache */ * from t1, (t2", false); r != nil || ticket != nil {
+		t.Fatal("解析不了的语句不应该缓存")
+	}
+}
+
+func Test_Cache_Evict(t *testing.T) {
+	c := NewCache(Config{Enable: true, MaxEntries: 2})
+	queries := []string{
+		"select /*+ dal_cache */ * from t1",
+		"select /*+ dal_cache */ * from t2",
+		"select /*+ dal_cache */ * from t3",
+	}
+	for _, query := range queries {
+		_, ticket := c.Get("u1", "db1", query, false)
+		c.Put(ticket, buildResultset(t, [][]interface{}{{1, "a"}}), false)
+	}
+
+	if r, _ := c.Get("u1", "db1", queries[0], false); r != nil {
+		t.Fatal("最久没有使用的缓存应该被淘汰")
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatalf("统计信息不对: %s", stats)
+	}
+}
+
+func Test_Invalidator(t *testing.T) {
+	c := NewCache(Config{Enable: true})
+	h := NewInvalidator(c)
+	queries := []string{
+		"select /*+ dal_cache */ * from t1 join t2 on t1.id = t2.id",
+		"select /*+ dal_cache */ * from t3",
+	}
+	for _, query := range queries {
+		_, ticket := c.Get("u1", "db1", query, false)
+		c.Put(ticket, buildResultset(t, [][]interface{}{{1, "a"}}), false)
+	}
+
+	e := &canal.RowsEvent{Table: &schema.Table{Schema: "db1", Name: "t2"}, Action: canal.UpdateAction}
+	if err := h.OnRow(e); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Get("u1", "db1", queries[0], false); r != nil {
+		t.Fatal("binlog 中 t2 数据变更, 缓存应该失效")
+	}
+	if r, _ := c.Get("u1", "db1", queries[1], false); r == nil {
+		t.Fatal("t3 的缓存不应该失效")
+	}
+
+	if err := h.OnTableChanged("db1", "t3"); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Get("u1", "db1", queries[1], false); r != nil {
+		t.Fatal("t3 表结构变更, 缓存应该失效")
+	}
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"github.com/daiguadaidai/dal/go-mysql/canal"
+)
+
+// 通过 binlog 使缓存失效, 需要通过 canal.SetEventHandler 设置
+// 表中的数据变更(OnRow)和表结构变更(OnTableChanged, 包括 truncate, drop, rename)都会删除该表相关的缓存.
+// canal.Run 返回错误后需要调用 Cache.Purge, 避免中断期间的数据变更没有让缓存失效.
+type Invalidator struct {
+	canal.DummyEventHandler
+	cache *Cache
+}
+
+var _ canal.EventHandler = (*Invalidator)(nil)
+
+func NewInvalidator(c *Cache) *Invalidator {
+	return &Invalidator{cache: c}
+}
+
+func (this *Invalidator) OnRow(e *canal.RowsEvent) error {
+	this.cache.InvalidateTable(e.Table.Schema, e.Table.Name)
+	return nil
+}
+
+func (this *Invalidator) OnTableChanged(schema string, table string) error {
+	this.cache.InvalidateTable(schema, table)
+	return nil
+}
+
+func (this *Invalidator) String() string {
+	return "CacheInvalidator"
+}
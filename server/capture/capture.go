@@ -0,0 +1,214 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+// 抓包文件格式:
+// 文件头: CAPTURE_MAGIC
+// 每个命令一条记录:
+//
+//	conn id           uvarint
+//	开始时间偏移(纳秒) uvarint, 相对于开始抓包的时间
+//	执行耗时(纳秒)     uvarint
+//	command           1 byte
+//	flag              1 byte, RECORD_FLAG_FAILED: 返回给客户端的是错误
+//	payload 长度       uvarint
+//	payload
+const CAPTURE_MAGIC = "DALCAP\x01\n"
+
+const (
+	RECORD_FLAG_FAILED byte = 1 << iota
+)
+
+// 一个命令的记录
+type Record struct {
+	ConnID  uint32
+	Offset  time.Duration // 命令开始时间, 相对于开始抓包的时间
+	Elapsed time.Duration // 原始的执行耗时
+	Cmd     byte
+	Failed  bool // 原始执行返回给客户端的是错误
+	Payload []byte
+}
+
+// 抓包. 实现了 server.CommandRecorder, 通过 server.Conn.SetCommandRecorder 设置给每个前端链接
+type Recorder struct {
+	sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	buf     [binary.MaxVarintLen64*4 + 2]byte
+	closed  bool
+	records int64
+}
+
+var _ server.CommandRecorder = (*Recorder)(nil)
+
+// 创建抓包文件
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("创建抓包文件 %s 出错. %s", path, err.Error())
+	}
+
+	r := &Recorder{
+		file:  f,
+		w:     bufio.NewWriterSize(f, 64*1024),
+		start: time.Now(),
+	}
+	if _, err = r.w.WriteString(CAPTURE_MAGIC); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("写抓包文件头出错. %s", err.Error())
+	}
+
+	return r, nil
+}
+
+func (this *Recorder) RecordCommand(c *server.Conn, cmd byte, data []byte, start time.Time, duration time.Duration, err error) {
+	offset := start.Sub(this.start)
+	if offset < 0 {
+		offset = 0
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.closed {
+		return
+	}
+
+	var flag byte
+	if err != nil {
+		flag |= RECORD_FLAG_FAILED
+	}
+
+	buf := this.buf[:]
+	n := binary.PutUvarint(buf, uint64(c.ConnectionID()))
+	n += binary.PutUvarint(buf[n:], uint64(offset))
+	n += binary.PutUvarint(buf[n:], uint64(duration))
+	buf[n] = cmd
+	buf[n+1] = flag
+	n += 2
+	n += binary.PutUvarint(buf[n:], uint64(len(data)))
+
+	if _, werr := this.w.Write(buf[:n]); werr != nil {
+		seelog.Errorf("写抓包文件出错, 停止抓包. %s", werr.Error())
+		this.closeFile()
+		return
+	}
+	if _, werr := this.w.Write(data); werr != nil {
+		seelog.Errorf("写抓包文件出错, 停止抓包. %s", werr.Error())
+		this.closeFile()
+		return
+	}
+	this.records++
+}
+
+// 已经记录的命令数
+func (this *Recorder) Records() int64 {
+	this.Lock()
+	defer this.Unlock()
+	return this.records
+}
+
+// 停止抓包, 把缓存写入文件
+func (this *Recorder) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.closed {
+		return nil
+	}
+	return this.closeFile()
+}
+
+// 需要在获取 mutex lock 后使用
+func (this *Recorder) closeFile() error {
+	this.closed = true
+
+	err := this.w.Flush()
+	if cerr := this.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// 读取抓包文件
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	magic := make([]byte, len(CAPTURE_MAGIC))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("读取抓包文件头出错. %s", err.Error())
+	}
+	if string(magic) != CAPTURE_MAGIC {
+		return nil, fmt.Errorf("不是合法的抓包文件")
+	}
+
+	return &Reader{r: br}, nil
+}
+
+// 读取下一条记录, 读取完成返回 io.EOF
+func (this *Reader) Next() (*Record, error) {
+	connID, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("读取 conn id 出错. %s", err.Error())
+	}
+
+	rec := &Record{ConnID: uint32(connID)}
+
+	offset, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+	rec.Offset = time.Duration(offset)
+
+	elapsed, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+	rec.Elapsed = time.Duration(elapsed)
+
+	if rec.Cmd, err = this.r.ReadByte(); err != nil {
+		return nil, truncatedError(err)
+	}
+	flag, err := this.r.ReadByte()
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+	rec.Failed = flag&RECORD_FLAG_FAILED != 0
+
+	length, err := binary.ReadUvarint(this.r)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+	rec.Payload = make([]byte, length)
+	if _, err = io.ReadFull(this.r, rec.Payload); err != nil {
+		return nil, truncatedError(err)
+	}
+
+	return rec, nil
+}
+
+func truncatedError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("抓包文件记录不完整. %s", err.Error())
+}
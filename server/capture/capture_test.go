@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+const (
+	testUser     = "root"
+	testPassword = "123456"
+)
+
+// 简单的后端, 记录收到的语句
+type testHandler struct {
+	server.EmptyHandler
+	sync.Mutex
+	queries []string
+}
+
+func (this *testHandler) HandleQuery(query string) (*mysql.Result, error) {
+	this.Lock()
+	this.queries = append(this.queries, query)
+	this.Unlock()
+
+	if strings.HasPrefix(query, "select") {
+		rs, err := mysql.BuildSimpleResultset([]string{"a"}, [][]interface{}{{1}}, false)
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.Result{Resultset: rs}, nil
+	}
+	if strings.HasPrefix(query, "bad") {
+		return nil, fmt.Errorf("bad query")
+	}
+	return &mysql.Result{AffectedRows: 1}, nil
+}
+
+func (this *testHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return strings.Count(query, "?"), 0, nil, nil
+}
+
+func (this *testHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	this.Lock()
+	this.queries = append(this.queries, fmt.Sprintf("%s %v", query, args))
+	this.Unlock()
+	return &mysql.Result{AffectedRows: 1}, nil
+}
+
+// 启动一个测试 server, 返回监听地址
+func startServer(t *testing.T, h server.Handler, recorder server.CommandRecorder) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, err := server.NewConn(c, testUser, testPassword, h)
+				if err != nil {
+					return
+				}
+				if recorder != nil {
+					conn.SetCommandRecorder(recorder)
+				}
+				for !conn.Closed() {
+					if err := conn.HandleCommand(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return l
+}
+
+func Test_CaptureAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dal.cap")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 抓包
+	origin := new(testHandler)
+	l := startServer(t, origin, recorder)
+	conn, err := client.Connect(l.Addr().String(), testUser, testPassword, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Execute("select a from t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Execute("insert into t values(?, ?)", int64(1), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Execute("bad sql"); err == nil {
+		t.Fatal("bad sql 应该返回错误")
+	}
+	conn.Close()
+	l.Close()
+
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 回放
+	target := new(testHandler)
+	l = startServer(t, target, nil)
+	defer l.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Replay(r, ReplayConfig{Addr: l.Addr().String(), User: testUser, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Sessions != 1 || report.NewErrors != 0 || report.FixedErrors != 0 {
+		t.Fatalf("回放报告不对: %s", report)
+	}
+	if strings.Join(target.queries, ";") != strings.Join(origin.queries, ";") {
+		t.Fatalf("回放的语句和原始语句不一致. 原始: %v, 回放: %v", origin.queries, target.queries)
+	}
+	if len(target.queries) != 3 {
+		t.Fatalf("应该回放3条语句, 实际: %v", target.queries)
+	}
+}
+
+func Test_ReplayStmtExecute(t *testing.T) {
+	target := new(testHandler)
+	l := startServer(t, target, nil)
+	defer l.Close()
+
+	conn, err := client.Connect(l.Addr().String(), testUser, testPassword, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := new(Report)
+	s := &replaySession{conn: conn, report: report, stmts: make(map[uint32]*replayStmt)}
+
+	// 二进制协议的 DATETIME, TIME, DATE 参数
+	execute := []byte{1, 0, 0, 0, 0, 1, 0, 0, 0, 0x00, 1,
+		mysql.MYSQL_TYPE_DATETIME, 0, mysql.MYSQL_TYPE_TIME, 0, mysql.MYSQL_TYPE_DATE, 0,
+		11, 0xe8, 0x07, 3, 9, 14, 5, 6, 0x40, 0xe2, 0x01, 0x00,
+		8, 1, 1, 0, 0, 0, 2, 3, 4,
+		4, 0xe8, 0x07, 12, 31,
+	}
+	s.run([]*Record{
+		{Cmd: mysql.COM_STMT_PREPARE, Payload: []byte("insert into t values(?, ?, ?)")},
+		{Cmd: mysql.COM_STMT_EXECUTE, Payload: execute},
+	})
+
+	if report.Replayed != 2 || report.NewErrors != 0 {
+		t.Fatalf("回放报告不对: %s", report)
+	}
+	expected := fmt.Sprintf("%s %v", "insert into t values(?, ?, ?)",
+		[]interface{}{[]byte("2024-03-09 14:05:06.123456"), []byte("-26:03:04"), []byte("2024-12-31")})
+	if len(target.queries) != 1 || target.queries[0] != expected {
+		t.Fatalf("回放的参数不对: %v", target.queries)
+	}
+}
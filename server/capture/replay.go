@@ -0,0 +1,445 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/siddontang/go/hack"
+)
+
+// 回放配置
+type ReplayConfig struct {
+	Addr     string  // 回放的目标 host:port
+	User     string  // 回放使用的用户
+	Password string  // 回放使用的密码
+	DB       string  // 链接时使用的数据库
+	Speed    float64 // 回放速度, 2 代表两倍速. <= 0 代表不等待, 尽快回放
+}
+
+// 回放报告
+type Report struct {
+	sync.Mutex
+	Sessions        int64
+	Commands        int64
+	Replayed        int64
+	Skipped         int64         // 不支持回放的命令
+	NewErrors       int64         // 原始执行成功, 回放失败
+	FixedErrors     int64         // 原始执行失败, 回放成功
+	OriginalLatency time.Duration // 原始累计耗时
+	ReplayLatency   time.Duration // 回放累计耗时
+
+	latencyDiffs []time.Duration
+}
+
+func (this *Report) add(rec *Record, latency time.Duration, err error) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.Replayed++
+	this.OriginalLatency += rec.Elapsed
+	this.ReplayLatency += latency
+	this.latencyDiffs = append(this.latencyDiffs, latency-rec.Elapsed)
+
+	if err != nil && !rec.Failed {
+		this.NewErrors++
+	} else if err == nil && rec.Failed {
+		this.FixedErrors++
+	}
+}
+
+func (this *Report) skip() {
+	this.Lock()
+	this.Skipped++
+	this.Unlock()
+}
+
+// 回放耗时和原始耗时差值的百分位数, p 范围 0~100
+func (this *Report) LatencyDiffPercentile(p float64) time.Duration {
+	this.Lock()
+	defer this.Unlock()
+
+	if len(this.latencyDiffs) == 0 {
+		return 0
+	}
+
+	diffs := make([]time.Duration, len(this.latencyDiffs))
+	copy(diffs, this.latencyDiffs)
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i] < diffs[j] })
+
+	idx := int(math.Ceil(p/100*float64(len(diffs)))) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(diffs) {
+		idx = len(diffs) - 1
+	}
+	return diffs[idx]
+}
+
+func (this *Report) String() string {
+	var avgOriginal, avgReplay time.Duration
+	this.Lock()
+	if this.Replayed > 0 {
+		avgOriginal = this.OriginalLatency / time.Duration(this.Replayed)
+		avgReplay = this.ReplayLatency / time.Duration(this.Replayed)
+	}
+	s := fmt.Sprintf("sessions: %d, commands: %d, replayed: %d, skipped: %d, new errors: %d, fixed errors: %d, "+
+		"avg original latency: %s, avg replay latency: %s",
+		this.Sessions, this.Commands, this.Replayed, this.Skipped, this.NewErrors, this.FixedErrors,
+		avgOriginal, avgReplay)
+	this.Unlock()
+
+	return fmt.Sprintf("%s, latency diff p50: %s, p99: %s",
+		s, this.LatencyDiffPercentile(50), this.LatencyDiffPercentile(99))
+}
+
+// 回放抓包文件. 每个原始链接使用一个新链接回放, 按照原始的时间间隔发送命令
+func Replay(r *Reader, cfg ReplayConfig) (*Report, error) {
+	sessions := make(map[uint32][]*Record)
+	var order []uint32
+
+	report := new(Report)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if _, ok := sessions[rec.ConnID]; !ok {
+			order = append(order, rec.ConnID)
+		}
+		sessions[rec.ConnID] = append(sessions[rec.ConnID], rec)
+		report.Commands++
+	}
+	report.Sessions = int64(len(order))
+
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for _, connID := range order {
+		wg.Add(1)
+		go func(records []*Record) {
+			defer wg.Done()
+			s := &replaySession{cfg: cfg, start: start, report: report, stmts: make(map[uint32]*replayStmt)}
+			s.run(records)
+		}(sessions[connID])
+	}
+	wg.Wait()
+
+	return report, nil
+}
+
+type replayStmt struct {
+	stmt       *client.Stmt
+	paramTypes []byte // 上一次执行的参数类型, new-params-bound-flag 为 0 时使用
+}
+
+// 回放一个原始链接的命令
+type replaySession struct {
+	cfg    ReplayConfig
+	start  time.Time
+	report *Report
+	conn   *client.Conn
+	stmts  map[uint32]*replayStmt // 原始 statement id -> 回放的 statement
+	stmtID uint32
+}
+
+func (this *replaySession) run(records []*Record) {
+	defer func() {
+		if this.conn != nil {
+			this.conn.Close()
+		}
+	}()
+
+	for _, rec := range records {
+		this.wait(rec.Offset)
+
+		if rec.Cmd == mysql.COM_QUIT {
+			return
+		}
+
+		if this.conn == nil {
+			conn, err := client.Connect(this.cfg.Addr, this.cfg.User, this.cfg.Password, this.cfg.DB)
+			if err != nil {
+				seelog.Errorf("回放链接 %s 失败, 跳过原始链接(conn id: %d)的命令. %s",
+					this.cfg.Addr, rec.ConnID, err.Error())
+				return
+			}
+			this.conn = conn
+		}
+
+		start := time.Now()
+		handled, err := this.execute(rec)
+		latency := time.Since(start)
+		if !handled {
+			this.report.skip()
+			continue
+		}
+
+		if err != nil && !rec.Failed {
+			seelog.Warnf("回放出错. 原始 conn id: %d, command: %d. %s", rec.ConnID, rec.Cmd, err.Error())
+		}
+		this.report.add(rec, latency, err)
+
+		if mysql.ErrorEqual(err, mysql.ErrBadConn) {
+			this.conn.Close()
+			this.conn = nil
+			this.stmts = make(map[uint32]*replayStmt)
+		}
+	}
+}
+
+// 等待到命令的原始发送时间
+func (this *replaySession) wait(offset time.Duration) {
+	if this.cfg.Speed <= 0 {
+		return
+	}
+
+	at := this.start.Add(time.Duration(float64(offset) / this.cfg.Speed))
+	if d := time.Until(at); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// 执行一个命令, 不支持回放的命令返回 false
+func (this *replaySession) execute(rec *Record) (bool, error) {
+	data := rec.Payload
+
+	switch rec.Cmd {
+	case mysql.COM_QUERY:
+		_, err := this.conn.Execute(hack.String(data))
+		return true, err
+	case mysql.COM_INIT_DB:
+		return true, this.conn.UseDB(string(data))
+	case mysql.COM_PING:
+		return true, this.conn.Ping()
+	case mysql.COM_STMT_PREPARE:
+		// 和 server.Conn 一样, statement id 从 1 开始递增
+		this.stmtID++
+		stmt, err := this.conn.Prepare(string(data))
+		if err == nil {
+			this.stmts[this.stmtID] = &replayStmt{stmt: stmt}
+		}
+		return true, err
+	case mysql.COM_STMT_EXECUTE:
+		s, args, err := this.parseStmtExecute(data)
+		if err != nil {
+			return true, err
+		}
+		_, err = s.stmt.Execute(args...)
+		return true, err
+	case mysql.COM_STMT_CLOSE:
+		if len(data) < 4 {
+			return true, mysql.ErrMalformPacket
+		}
+		id := binary.LittleEndian.Uint32(data)
+		if s, ok := this.stmts[id]; ok {
+			delete(this.stmts, id)
+			return true, s.stmt.Close()
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// 解析 COM_STMT_EXECUTE 的参数
+func (this *replaySession) parseStmtExecute(data []byte) (*replayStmt, []interface{}, error) {
+	if len(data) < 9 {
+		return nil, nil, mysql.ErrMalformPacket
+	}
+
+	id := binary.LittleEndian.Uint32(data[0:4])
+	s, ok := this.stmts[id]
+	if !ok {
+		return nil, nil, mysql.NewError(mysql.ER_UNKNOWN_STMT_HANDLER,
+			fmt.Sprintf("Unknown prepared statement handler (%d) given to stmt_execute", id))
+	}
+
+	// 跳过 stmt id, flag, iteration-count
+	pos := 9
+	paramNum := s.stmt.ParamNum()
+	args := make([]interface{}, paramNum)
+	if paramNum == 0 {
+		return s, args, nil
+	}
+
+	nullBitmapLen := (paramNum + 7) >> 3
+	if len(data) < pos+nullBitmapLen+1 {
+		return nil, nil, mysql.ErrMalformPacket
+	}
+	nullBitmap := data[pos : pos+nullBitmapLen]
+	pos += nullBitmapLen
+
+	if data[pos] == 1 {
+		pos++
+		if len(data) < pos+(paramNum<<1) {
+			return nil, nil, mysql.ErrMalformPacket
+		}
+		s.paramTypes = data[pos : pos+(paramNum<<1)]
+		pos += paramNum << 1
+	} else {
+		pos++
+	}
+
+	values := data[pos:]
+	pos = 0
+	for i := 0; i < paramNum; i++ {
+		if nullBitmap[i>>3]&(1<<(uint(i)%8)) > 0 {
+			continue
+		}
+		if len(s.paramTypes) < (i<<1)+2 {
+			return nil, nil, mysql.ErrMalformPacket
+		}
+
+		v, n, err := parseBinaryParam(s.paramTypes[i<<1], s.paramTypes[(i<<1)+1]&0x80 > 0, values[pos:])
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = v
+		pos += n
+	}
+
+	return s, args, nil
+}
+
+// 解析一个二进制协议的参数, 返回参数值和占用的字节数
+func parseBinaryParam(tp byte, isUnsigned bool, data []byte) (interface{}, int, error) {
+	need := 0
+	switch tp {
+	case mysql.MYSQL_TYPE_NULL:
+		return nil, 0, nil
+	case mysql.MYSQL_TYPE_TINY:
+		need = 1
+	case mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_YEAR:
+		need = 2
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT:
+		need = 4
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE:
+		need = 8
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE, mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_TIMESTAMP,
+		mysql.MYSQL_TYPE_TIME:
+		// 1 个字节的长度 + 压缩的字段
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return nil, 0, mysql.ErrMalformPacket
+		}
+		n := int(data[0])
+		var v string
+		var err error
+		if tp == mysql.MYSQL_TYPE_TIME {
+			v, err = formatBinaryTime(data[1 : 1+n])
+		} else {
+			v, err = formatBinaryDateTime(tp, data[1:1+n])
+		}
+		return v, 1 + n, err
+	default:
+		// DECIMAL/NEWDECIMAL 和字符串类型一样是 length encoded string
+		v, isNull, n, err := mysql.LengthEncodedString(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		if isNull {
+			return nil, n, nil
+		}
+		return v, n, nil
+	}
+
+	if len(data) < need {
+		return nil, 0, mysql.ErrMalformPacket
+	}
+
+	switch tp {
+	case mysql.MYSQL_TYPE_TINY:
+		if isUnsigned {
+			return uint8(data[0]), need, nil
+		}
+		return int8(data[0]), need, nil
+	case mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_YEAR:
+		if isUnsigned {
+			return binary.LittleEndian.Uint16(data), need, nil
+		}
+		return int16(binary.LittleEndian.Uint16(data)), need, nil
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG:
+		if isUnsigned {
+			return binary.LittleEndian.Uint32(data), need, nil
+		}
+		return int32(binary.LittleEndian.Uint32(data)), need, nil
+	case mysql.MYSQL_TYPE_FLOAT:
+		return math.Float32frombits(binary.LittleEndian.Uint32(data)), need, nil
+	case mysql.MYSQL_TYPE_LONGLONG:
+		if isUnsigned {
+			return binary.LittleEndian.Uint64(data), need, nil
+		}
+		return int64(binary.LittleEndian.Uint64(data)), need, nil
+	default: // MYSQL_TYPE_DOUBLE
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), need, nil
+	}
+}
+
+// 二进制协议的 DATE/DATETIME/TIMESTAMP 参数: year(2) month day [hour minute second [microsecond(4)]],
+// 格式化成 2006-01-02 15:04:05.999999, DATE 只有日期部分
+func formatBinaryDateTime(tp byte, data []byte) (string, error) {
+	var year, month, day, hour, minute, second, micro int
+	switch len(data) {
+	case 11:
+		micro = int(binary.LittleEndian.Uint32(data[7:11]))
+		fallthrough
+	case 7:
+		hour, minute, second = int(data[4]), int(data[5]), int(data[6])
+		fallthrough
+	case 4:
+		year, month, day = int(binary.LittleEndian.Uint16(data[0:2])), int(data[2]), int(data[3])
+	case 0:
+	default:
+		return "", fmt.Errorf("日期参数的长度错误: %d", len(data))
+	}
+
+	date := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	if tp == mysql.MYSQL_TYPE_DATE || tp == mysql.MYSQL_TYPE_NEWDATE {
+		return date, nil
+	}
+	return date + " " + formatClock(hour, minute, second, micro), nil
+}
+
+// 二进制协议的 TIME 参数: is_negative days(4) hour minute second [microsecond(4)],
+// 格式化成 [-]hh:mm:ss[.ffffff], 小时包括天数
+func formatBinaryTime(data []byte) (string, error) {
+	var negative bool
+	var days, hour, minute, second, micro int
+	switch len(data) {
+	case 12:
+		micro = int(binary.LittleEndian.Uint32(data[8:12]))
+		fallthrough
+	case 8:
+		negative = data[0] == 1
+		days = int(binary.LittleEndian.Uint32(data[1:5]))
+		hour, minute, second = int(data[5]), int(data[6]), int(data[7])
+	case 0:
+	default:
+		return "", fmt.Errorf("时间参数的长度错误: %d", len(data))
+	}
+
+	v := formatClock(days*24+hour, minute, second, micro)
+	if negative {
+		v = "-" + v
+	}
+	return v, nil
+}
+
+// hh:mm:ss[.ffffff], 去掉微秒结尾的 0
+func formatClock(hour int, minute int, second int, micro int) string {
+	v := fmt.Sprintf("%02d:%02d:%02d", hour, minute, second)
+	if micro > 0 {
+		v += strings.TrimRight(fmt.Sprintf(".%06d", micro), "0")
+	}
+	return v
+}
@@ -0,0 +1,155 @@
+package credential
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+const (
+	CREDENTIAL_SOURCE_FILE        = "file"        // 密码 hash 文件
+	CREDENTIAL_SOURCE_MYSQL       = "mysql"       // MySQL 中的用户表
+	CREDENTIAL_SOURCE_PASSTHROUGH = "passthrough" // 使用客户端的用户名密码登录后端 MySQL 验证
+)
+
+// 前端用户认证配置, 配置中只保存密码的 hash(使用 dal passwd 生成), 不保存明文密码.
+// [credential]
+// source = "file"
+// file = "./dal_users.toml"
+type Config struct {
+	Source      string            `toml:"source"`
+	File        string            `toml:"file"`
+	MySQL       MySQLConfig       `toml:"mysql"`
+	Passthrough PassthroughConfig `toml:"passthrough"`
+}
+
+// 用户名 -> 密码 hash, 实现了 server.HashedCredentialProvider.
+// 更新时会清除密码变化用户的 caching_sha2_password 缓存
+type hashStore struct {
+	sync.RWMutex
+	hashes     map[string]string
+	serverConf *server.Server
+}
+
+var _ server.HashedCredentialProvider = (*hashStore)(nil)
+
+func (this *hashStore) CheckUsername(username string) (bool, error) {
+	this.RLock()
+	defer this.RUnlock()
+	_, ok := this.hashes[username]
+	return ok, nil
+}
+
+// 没有明文密码, 认证时使用 GetCredentialHash
+func (this *hashStore) GetCredential(username string) (string, bool, error) {
+	return "", false, fmt.Errorf("用户 %s 只保存了密码的 hash", username)
+}
+
+func (this *hashStore) GetCredentialHash(username string) (string, bool, error) {
+	this.RLock()
+	defer this.RUnlock()
+	hash, ok := this.hashes[username]
+	return hash, ok, nil
+}
+
+func (this *hashStore) Users() []string {
+	this.RLock()
+	defer this.RUnlock()
+	users := make([]string, 0, len(this.hashes))
+	for user := range this.hashes {
+		users = append(users, user)
+	}
+	return users
+}
+
+// 更新用户, 返回密码 hash 格式错误的用户. 格式错误的行只记录日志并跳过(原来有该用户时保留原来的密码),
+// 不影响其他用户的修改和删除
+func (this *hashStore) set(hashes map[string]string) []string {
+	this.Lock()
+	old := this.hashes
+	valid := make(map[string]string, len(hashes))
+	var skipped []string
+	for user, hash := range hashes {
+		if err := server.ValidatePasswordHash(hash); err != nil {
+			seelog.Errorf("用户 %s 的密码 hash 格式错误, 跳过. %s", user, err.Error())
+			skipped = append(skipped, user)
+			if oldHash, ok := old[user]; ok {
+				valid[user] = oldHash
+			}
+			continue
+		}
+		valid[user] = hash
+	}
+	this.hashes = valid
+	this.Unlock()
+	sort.Strings(skipped)
+
+	if this.serverConf == nil {
+		return skipped
+	}
+	for user, hash := range old {
+		if newHash, ok := valid[user]; !ok || newHash != hash {
+			seelog.Infof("用户 %s 的密码已经修改或者删除, 清除缓存", user)
+			this.serverConf.InvalidateUserCache(user)
+		}
+	}
+	return skipped
+}
+
+// 密码 hash 文件:
+//
+//	[users]
+//	app = "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9"
+//	report = "$SHA2$..."
+type hashFile struct {
+	Users map[string]string `toml:"users"`
+}
+
+// 从密码 hash 文件中加载用户, 修改文件后调用 Reload 生效
+type FileProvider struct {
+	hashStore
+	path string
+}
+
+func NewFileProvider(path string, serverConf *server.Server) (*FileProvider, error) {
+	p := &FileProvider{path: path}
+	p.serverConf = serverConf
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// 重新加载文件, 失败时继续使用原来的用户. 密码 hash 格式错误的用户返回错误, 其他用户照常生效
+func (this *FileProvider) Reload() error {
+	f := new(hashFile)
+	if _, err := toml.DecodeFile(this.path, f); err != nil {
+		return fmt.Errorf("读取密码文件 %s 出错. %s", this.path, err.Error())
+	}
+	if f.Users == nil {
+		f.Users = make(map[string]string)
+	}
+	if skipped := this.set(f.Users); len(skipped) > 0 {
+		return fmt.Errorf("密码文件 %s 中用户 %s 的密码 hash 格式错误, 没有生效", this.path, strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// 根据配置创建前端用户认证
+func NewProvider(cfg Config, serverConf *server.Server) (server.CredentialProvider, error) {
+	switch cfg.Source {
+	case CREDENTIAL_SOURCE_FILE:
+		return NewFileProvider(cfg.File, serverConf)
+	case CREDENTIAL_SOURCE_MYSQL:
+		return NewMySQLProvider(cfg.MySQL, serverConf)
+	case CREDENTIAL_SOURCE_PASSTHROUGH:
+		return NewPassthroughProvider(cfg.Passthrough), nil
+	}
+	return nil, fmt.Errorf("用户认证方式 %s 不支持, 只支持 %s, %s, %s", cfg.Source,
+		CREDENTIAL_SOURCE_FILE, CREDENTIAL_SOURCE_MYSQL, CREDENTIAL_SOURCE_PASSTHROUGH)
+}
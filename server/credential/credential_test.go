@@ -0,0 +1,208 @@
+package credential
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/daiguadaidai/dal/go-mysql/test_util/test_keys"
+)
+
+func newTestServerConf() *server.Server {
+	tlsConf := server.NewServerTLSConfig(test_keys.CaPem, test_keys.CertPem, test_keys.KeyPem, tls.VerifyClientCertIfGiven)
+	return server.NewServer("8.0.12", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_CACHING_SHA2_PASSWORD, test_keys.PubPem, tlsConf)
+}
+
+func startServer(t *testing.T, serverConf *server.Server, p server.CredentialProvider) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewCustomizedConn(conn, serverConf, p, server.EmptyHandler{})
+				if err == nil {
+					c.Close()
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func login(addr string, user string, password string) error {
+	conn, err := client.Connect(addr, user, password, "", func(c *client.Conn) { c.UseSSL(true) })
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func writeHashFile(t *testing.T, path string, users map[string]string) {
+	content := "[users]\n"
+	for user, hash := range users {
+		content += fmt.Sprintf("%s = %q\n", user, hash)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_FileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dal_users.toml")
+	writeHashFile(t, path, map[string]string{"app": server.NativePasswordHash("pass1")})
+
+	serverConf := newTestServerConf()
+	p, err := NewFileProvider(path, serverConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, serverConf, p)
+
+	// 第一次完整认证, 第二次使用缓存
+	for i := 0; i < 2; i++ {
+		if err = login(addr, "app", "pass1"); err != nil {
+			t.Fatalf("第 %d 次登录失败. %v", i+1, err)
+		}
+	}
+
+	// 修改密码后缓存需要失效
+	writeHashFile(t, path, map[string]string{"app": server.CachingSha2PasswordHash("pass2")})
+	if err = p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err = login(addr, "app", "pass1"); err == nil {
+		t.Fatal("修改密码后使用旧密码应该登录失败")
+	}
+	if err = login(addr, "app", "pass2"); err != nil {
+		t.Fatalf("使用新密码登录失败. %v", err)
+	}
+
+	// 错误的文件不影响原来的用户
+	writeHashFile(t, path, map[string]string{"app": "pass3"})
+	if err = p.Reload(); err == nil {
+		t.Fatal("明文密码应该报错")
+	}
+	if err = login(addr, "app", "pass2"); err != nil {
+		t.Fatalf("加载失败后应该使用原来的用户. %v", err)
+	}
+}
+
+type fakeExecutor struct {
+	users [][]interface{}
+	err   error
+}
+
+func (this *fakeExecutor) Execute(query string, args ...interface{}) (*mysql.Result, error) {
+	if this.err != nil {
+		return nil, this.err
+	}
+	rs, err := mysql.BuildSimpleResultset([]string{"user", "password_hash"}, this.users, false)
+	if err != nil {
+		return nil, err
+	}
+	rs.Values = this.users
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+func Test_MySQLProvider(t *testing.T) {
+	executor := &fakeExecutor{users: [][]interface{}{{"app", server.NativePasswordHash("pass1")}}}
+	p, err := newMySQLProvider(MySQLConfig{}, nil, executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if hash, ok, _ := p.GetCredentialHash("app"); !ok || hash != server.NativePasswordHash("pass1") {
+		t.Fatalf("加载的用户不对: %s", hash)
+	}
+
+	executor.users = [][]interface{}{{"report", ""}}
+	if err = p.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := p.CheckUsername("app"); ok {
+		t.Fatal("删除的用户应该不存在")
+	}
+	if ok, _ := p.CheckUsername("report"); !ok {
+		t.Fatal("新加的用户应该存在")
+	}
+
+	executor.err = fmt.Errorf("链接失败")
+	if err = p.Refresh(); err == nil {
+		t.Fatal("刷新失败应该返回错误")
+	}
+	if ok, _ := p.CheckUsername("report"); !ok {
+		t.Fatal("刷新失败后应该使用原来的用户")
+	}
+}
+
+func Test_MySQLProvider_BadRow(t *testing.T) {
+	executor := &fakeExecutor{users: [][]interface{}{
+		{"app", server.NativePasswordHash("pass1")},
+		{"report", server.NativePasswordHash("pass2")},
+	}}
+	p, err := newMySQLProvider(MySQLConfig{}, nil, executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// 一行格式错误, 同时删除了 report, 格式错误的行不能影响删除生效
+	executor.users = [][]interface{}{
+		{"app", server.CachingSha2PasswordHash("pass3")},
+		{"bad", "pass4"},
+	}
+	if err = p.Refresh(); err != nil {
+		t.Fatalf("格式错误的行不应该导致刷新失败. %v", err)
+	}
+	if ok, _ := p.CheckUsername("report"); ok {
+		t.Fatal("删除的用户应该不存在")
+	}
+	if ok, _ := p.CheckUsername("bad"); ok {
+		t.Fatal("密码 hash 格式错误的用户不应该加载")
+	}
+	if hash, ok, _ := p.GetCredentialHash("app"); !ok || hash != server.CachingSha2PasswordHash("pass3") {
+		t.Fatalf("修改的用户应该生效: %s", hash)
+	}
+}
+
+func Test_PassthroughProvider(t *testing.T) {
+	p := NewPassthroughProvider(PassthroughConfig{Addr: "backend"})
+	p.connect = func(addr string, user string, password string) error {
+		if password == "down" {
+			return fmt.Errorf("后端不可用")
+		}
+		if password != "pass" {
+			return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, user, "127.0.0.1", "YES")
+		}
+		return nil
+	}
+
+	serverConf := newTestServerConf()
+	addr := startServer(t, serverConf, p)
+	if err := login(addr, "app", "pass"); err != nil {
+		t.Fatalf("登录失败. %v", err)
+	}
+	if err := login(addr, "app", "wrong"); err == nil {
+		t.Fatal("密码错误应该登录失败")
+	}
+	if ok, err := p.CheckPassword("app", "down"); ok || err == nil {
+		t.Fatal("后端不可用应该返回错误")
+	}
+}
@@ -0,0 +1,147 @@
+package credential
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+const (
+	CREDENTIAL_DEFAULT_TABLE               = "dal.dal_user"
+	CREDENTIAL_DEFAULT_USER_COLUMN         = "user"
+	CREDENTIAL_DEFAULT_HASH_COLUMN         = "password_hash"
+	CREDENTIAL_DEFAULT_REFRESH_INTERVAL_MS = 10000
+)
+
+// 从 MySQL 的用户表加载前端用户, 定期刷新.
+// 用户表:
+//
+//	CREATE TABLE dal.dal_user (
+//	    user          VARCHAR(64) NOT NULL PRIMARY KEY,
+//	    password_hash VARCHAR(128) NOT NULL
+//	)
+//
+// [credential.mysql]
+// addr = "127.0.0.1:3306"
+// user = "dal_admin"
+// password = "123456"
+// table = "dal.dal_user"
+// refresh_interval_ms = 10000
+type MySQLConfig struct {
+	Addr              string `toml:"addr"`
+	User              string `toml:"user"`
+	Password          string `toml:"password"`
+	Table             string `toml:"table"`
+	UserColumn        string `toml:"user_column"`
+	HashColumn        string `toml:"hash_column"`
+	RefreshIntervalMS int64  `toml:"refresh_interval_ms"`
+}
+
+func (this *MySQLConfig) setDefault() {
+	if len(this.Table) == 0 {
+		this.Table = CREDENTIAL_DEFAULT_TABLE
+	}
+	if len(this.UserColumn) == 0 {
+		this.UserColumn = CREDENTIAL_DEFAULT_USER_COLUMN
+	}
+	if len(this.HashColumn) == 0 {
+		this.HashColumn = CREDENTIAL_DEFAULT_HASH_COLUMN
+	}
+	if this.RefreshIntervalMS <= 0 {
+		this.RefreshIntervalMS = CREDENTIAL_DEFAULT_REFRESH_INTERVAL_MS
+	}
+}
+
+// 在保存用户表的 MySQL 上执行语句
+type Executor interface {
+	Execute(query string, args ...interface{}) (*mysql.Result, error)
+}
+
+// 每次执行都新建链接, 刷新用户的频率很低, 不需要链接池
+type clientExecutor struct {
+	cfg MySQLConfig
+}
+
+func (this *clientExecutor) Execute(query string, args ...interface{}) (*mysql.Result, error) {
+	conn, err := client.Connect(this.cfg.Addr, this.cfg.User, this.cfg.Password, "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.Execute(query, args...)
+}
+
+type MySQLProvider struct {
+	hashStore
+	cfg      MySQLConfig
+	executor Executor
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func NewMySQLProvider(cfg MySQLConfig, serverConf *server.Server) (*MySQLProvider, error) {
+	return newMySQLProvider(cfg, serverConf, &clientExecutor{cfg: cfg})
+}
+
+func newMySQLProvider(cfg MySQLConfig, serverConf *server.Server, executor Executor) (*MySQLProvider, error) {
+	cfg.setDefault()
+	p := &MySQLProvider{cfg: cfg, executor: executor, closeCh: make(chan struct{})}
+	p.serverConf = serverConf
+	if err := p.Refresh(); err != nil {
+		return nil, err
+	}
+
+	go p.refreshLoop()
+
+	return p, nil
+}
+
+// 从用户表重新加载用户, 失败时继续使用原来的用户. 密码 hash 格式错误的行只记录日志, 不影响其他用户
+func (this *MySQLProvider) Refresh() error {
+	query := fmt.Sprintf("SELECT `%s`, `%s` FROM %s", this.cfg.UserColumn, this.cfg.HashColumn, this.cfg.Table)
+	r, err := this.executor.Execute(query)
+	if err != nil {
+		return fmt.Errorf("从 %s 加载用户出错. %s", this.cfg.Table, err.Error())
+	}
+
+	hashes := make(map[string]string)
+	if r.Resultset != nil {
+		for i := range r.Values {
+			user, _ := r.GetString(i, 0)
+			hash, _ := r.GetString(i, 1)
+			hashes[user] = hash
+		}
+	}
+
+	this.set(hashes)
+	return nil
+}
+
+func (this *MySQLProvider) refreshLoop() {
+	ticker := time.NewTicker(time.Duration(this.cfg.RefreshIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.closeCh:
+			return
+		case <-ticker.C:
+			if err := this.Refresh(); err != nil {
+				seelog.Errorf("刷新前端用户出错. %s", err.Error())
+			}
+		}
+	}
+}
+
+func (this *MySQLProvider) Close() {
+	this.closeOnce.Do(func() {
+		close(this.closeCh)
+	})
+}
@@ -0,0 +1,60 @@
+package credential
+
+import (
+	"fmt"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/pingcap/errors"
+)
+
+// 使用客户端的用户名密码登录后端 MySQL 验证, dal 不保存任何密码.
+// 需要拿到客户端的明文密码, 所以 dal 的默认认证方式需要是 caching_sha2_password 或 sha256_password,
+// 并且客户端使用 TLS 链接(或者使用 RSA 公钥加密密码).
+// [credential.passthrough]
+// addr = "127.0.0.1:3306"
+type PassthroughConfig struct {
+	Addr string `toml:"addr"`
+}
+
+type PassthroughProvider struct {
+	addr    string
+	connect func(addr string, user string, password string) error
+}
+
+var _ server.PasswordChecker = (*PassthroughProvider)(nil)
+
+func NewPassthroughProvider(cfg PassthroughConfig) *PassthroughProvider {
+	return &PassthroughProvider{
+		addr: cfg.Addr,
+		connect: func(addr string, user string, password string) error {
+			conn, err := client.Connect(addr, user, password, "")
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
+
+// 用户是否存在由后端 MySQL 决定
+func (this *PassthroughProvider) CheckUsername(username string) (bool, error) {
+	return true, nil
+}
+
+func (this *PassthroughProvider) GetCredential(username string) (string, bool, error) {
+	return "", false, fmt.Errorf("用户 %s 需要登录后端 MySQL 验证", username)
+}
+
+// 密码错误返回 false, 后端 MySQL 不可用等其他错误返回 error
+func (this *PassthroughProvider) CheckPassword(username string, password string) (bool, error) {
+	err := this.connect(this.addr, username, password)
+	if err == nil {
+		return true, nil
+	}
+	if myErr, ok := errors.Cause(err).(*mysql.MyError); ok && myErr.Code == mysql.ER_ACCESS_DENIED_ERROR {
+		return false, nil
+	}
+	return false, err
+}
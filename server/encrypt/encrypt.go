@@ -0,0 +1,283 @@
+package encrypt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/daiguadaidai/dal/server/rowutil"
+	"github.com/daiguadaidai/dal/server/sqlutil"
+)
+
+// 透明加密配置, 加密列需要是 VARBINARY/BLOB 类型
+// [encrypt]
+// key_file = "./dal.keys"
+// [[encrypt.columns]]
+// column = "db1.t_user.phone"
+// deterministic = true
+type Config struct {
+	KeyFile string         `toml:"key_file"`
+	Columns []ColumnConfig `toml:"columns"`
+}
+
+type ColumnConfig struct {
+	Column string `toml:"column"` // db.table.column
+	// 确定性加密: 相同的明文得到相同的密文, 可以作为等值查询条件(=, IN).
+	// 随机加密更安全, 但是不能作为查询条件.
+	Deterministic bool `toml:"deterministic"`
+}
+
+// 透明加密.
+// 改写 INSERT/UPDATE/WHERE 中加密列的字面值(RewriteQuery)和 prepare 语句的参数(EncryptArgs),
+// 结果集中的加密列会被解密(实现了 server.ResultsetFilter).
+// 密钥轮换: 在密钥文件中添加新的密钥并修改 active, 然后调用 Reload.
+// 旧的数据依然可以解密, 但是确定性加密的等值查询只会使用 active 密钥, 需要通过 ReEncrypt 把旧数据重新加密.
+type Encryptor struct {
+	sync.RWMutex
+	keyFile string
+	keyring *Keyring
+	columns map[string]*ColumnConfig // db.table.column(小写) -> 配置
+}
+
+var _ server.ResultsetFilter = (*Encryptor)(nil)
+
+func NewEncryptor(cfg Config) (*Encryptor, error) {
+	keyring, err := LoadKeyring(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptor(cfg, keyring)
+}
+
+func newEncryptor(cfg Config, keyring *Keyring) (*Encryptor, error) {
+	e := &Encryptor{keyFile: cfg.KeyFile, keyring: keyring, columns: make(map[string]*ColumnConfig)}
+	for i := range cfg.Columns {
+		c := cfg.Columns[i]
+		if strings.Count(c.Column, ".") != 2 {
+			return nil, fmt.Errorf("加密列 %s 需要是 db.table.column 格式", c.Column)
+		}
+		e.columns[strings.ToLower(c.Column)] = &c
+	}
+	return e, nil
+}
+
+// 重新加载密钥文件, 用于密钥轮换. 加载失败时继续使用原来的密钥
+func (this *Encryptor) Reload() error {
+	keyring, err := LoadKeyring(this.keyFile)
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	this.keyring = keyring
+	this.Unlock()
+	return nil
+}
+
+func (this *Encryptor) getKeyring() *Keyring {
+	this.RLock()
+	defer this.RUnlock()
+	return this.keyring
+}
+
+// 找到列对应的加密配置. 列名前面的表名可能是别名, 这时在语句使用的所有表中查找
+func (this *Encryptor) columnConfig(tables []string, qualifier string, column string) *ColumnConfig {
+	column = strings.ToLower(column)
+	qualifier = strings.ToLower(qualifier)
+
+	if len(qualifier) > 0 {
+		for _, table := range tables {
+			if table[strings.IndexByte(table, '.')+1:] == qualifier {
+				return this.columns[table+"."+column]
+			}
+		}
+	}
+	for _, table := range tables {
+		if c, ok := this.columns[table+"."+column]; ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// 需要加密的值
+type encryptValue struct {
+	sqlutil.ColumnValue
+	cfg *ColumnConfig
+}
+
+func (this *Encryptor) findValues(db string, query string) ([]encryptValue, error) {
+	if len(this.columns) == 0 {
+		return nil, nil
+	}
+
+	tables := sqlutil.ExtractTables(query, db)
+	if len(tables) == 0 {
+		return nil, nil
+	}
+
+	var values []encryptValue
+	for _, v := range sqlutil.FindColumnValues(query) {
+		cfg := this.columnConfig(tables, v.Qualifier, v.Column)
+		if cfg == nil || v.IsNull {
+			continue
+		}
+		if v.Compare && !cfg.Deterministic {
+			return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR,
+				fmt.Sprintf("随机加密的列 %s 不能作为查询条件", cfg.Column))
+		}
+		values = append(values, encryptValue{ColumnValue: v, cfg: cfg})
+	}
+	return values, nil
+}
+
+// 把语句中加密列的字面值替换为密文(16进制字面值)
+func (this *Encryptor) RewriteQuery(db string, query string) (string, error) {
+	values, err := this.findValues(db, query)
+	if err != nil || len(values) == 0 {
+		return query, err
+	}
+
+	keyring := this.getKeyring()
+	sort.Slice(values, func(i, j int) bool { return values[i].Start < values[j].Start })
+
+	var sb strings.Builder
+	last := 0
+	for _, v := range values {
+		if v.ParamIndex >= 0 || v.Start < last {
+			continue
+		}
+		data, err := keyring.Encrypt([]byte(v.Value), v.cfg.Deterministic)
+		if err != nil {
+			return query, err
+		}
+		sb.WriteString(query[last:v.Start])
+		sb.WriteString("X'")
+		sb.WriteString(hex.EncodeToString(data))
+		sb.WriteString("'")
+		last = v.End
+	}
+	sb.WriteString(query[last:])
+
+	return sb.String(), nil
+}
+
+// 加密 prepare 语句中加密列对应的参数, 返回新的参数列表
+func (this *Encryptor) EncryptArgs(db string, query string, args []interface{}) ([]interface{}, error) {
+	values, err := this.findValues(db, query)
+	if err != nil || len(values) == 0 {
+		return args, err
+	}
+
+	keyring := this.getKeyring()
+	newArgs := make([]interface{}, len(args))
+	copy(newArgs, args)
+	for _, v := range values {
+		if v.ParamIndex < 0 || v.ParamIndex >= len(args) || args[v.ParamIndex] == nil {
+			continue
+		}
+
+		var plain []byte
+		switch arg := args[v.ParamIndex].(type) {
+		case []byte:
+			plain = arg
+		case string:
+			plain = []byte(arg)
+		default:
+			plain = []byte(fmt.Sprint(arg))
+		}
+
+		data, err := keyring.Encrypt(plain, v.cfg.Deterministic)
+		if err != nil {
+			return args, err
+		}
+		newArgs[v.ParamIndex] = data
+	}
+
+	return newArgs, nil
+}
+
+// 使用 active 密钥重新加密, 用于密钥轮换后迁移旧数据
+func (this *Encryptor) ReEncrypt(data []byte, deterministic bool) ([]byte, error) {
+	keyring := this.getKeyring()
+	plain, err := keyring.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Encrypt(plain, deterministic)
+}
+
+func (this *Encryptor) FilterResultset(c *server.Conn, r *mysql.Resultset, binary bool) (*mysql.Resultset, error) {
+	return this.Decrypt(r, binary)
+}
+
+// 解密结果集中的加密列, 没有加密列时返回原来的结果集. 不会修改原来的结果集.
+// 不是密文的值(例如加密之前写入的数据)原样返回.
+func (this *Encryptor) Decrypt(r *mysql.Resultset, binary bool) (*mysql.Resultset, error) {
+	if r == nil || len(this.columns) == 0 {
+		return r, nil
+	}
+
+	columns := make([]bool, len(r.Fields))
+	found := false
+	for i, f := range r.Fields {
+		name := strings.ToLower(string(f.Schema) + "." + string(f.OrgTable) + "." + string(f.OrgName))
+		if _, ok := this.columns[name]; ok && len(f.OrgTable) > 0 {
+			columns[i] = true
+			found = true
+		}
+	}
+	if !found {
+		return r, nil
+	}
+
+	decrypted := &mysql.Resultset{
+		Fields:     make([]*mysql.Field, len(r.Fields)),
+		FieldNames: r.FieldNames,
+		RowDatas:   make([]mysql.RowData, 0, len(r.RowDatas)),
+	}
+	for i, f := range r.Fields {
+		if !columns[i] {
+			decrypted.Fields[i] = f
+			continue
+		}
+		field := *f
+		field.Data = nil
+		field.Type = mysql.MYSQL_TYPE_VAR_STRING
+		field.Charset = 33 // utf8_general_ci
+		field.Flag &^= mysql.BINARY_FLAG
+		decrypted.Fields[i] = &field
+	}
+
+	keyring := this.getKeyring()
+	decrypt := func(column int, value []byte) ([]byte, error) {
+		if !IsEncrypted(value) {
+			return value, nil
+		}
+		return keyring.Decrypt(value)
+	}
+	for _, row := range r.RowDatas {
+		newRow, err := rowutil.RewriteRow(r.Fields, row, binary, columns, decrypt)
+		if err != nil {
+			return nil, err
+		}
+		decrypted.RowDatas = append(decrypted.RowDatas, newRow)
+	}
+
+	if len(r.Values) > 0 {
+		decrypted.Values = make([][]interface{}, len(decrypted.RowDatas))
+		for i, row := range decrypted.RowDatas {
+			values, err := row.Parse(decrypted.Fields, binary)
+			if err != nil {
+				return nil, err
+			}
+			decrypted.Values[i] = values
+		}
+	}
+
+	return decrypted, nil
+}
@@ -0,0 +1,196 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+func randomKey(t *testing.T) string {
+	raw := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func writeKeyFile(t *testing.T, path string, active string, keys map[string]string) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "active = %q\n[keys]\n", active)
+	for id, k := range keys {
+		fmt.Fprintf(&sb, "%s = %q\n", id, k)
+	}
+	if err := ioutil.WriteFile(path, []byte(sb.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestEncryptor(t *testing.T) (*Encryptor, string, map[string]string) {
+	path := filepath.Join(t.TempDir(), "dal.keys")
+	keys := map[string]string{"k1": randomKey(t)}
+	writeKeyFile(t, path, "k1", keys)
+
+	e, err := NewEncryptor(Config{
+		KeyFile: path,
+		Columns: []ColumnConfig{
+			{Column: "db1.t_user.phone", Deterministic: true},
+			{Column: "db1.t_user.id_card"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, path, keys
+}
+
+// 取出改写后语句中的所有16进制字面值
+func hexLiterals(t *testing.T, query string) [][]byte {
+	var literals [][]byte
+	for _, part := range strings.Split(query, "X'")[1:] {
+		data, err := hex.DecodeString(part[:strings.IndexByte(part, '\'')])
+		if err != nil {
+			t.Fatal(err)
+		}
+		literals = append(literals, data)
+	}
+	return literals
+}
+
+func Test_Keyring(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, KEY_SIZE)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, _ := keyring.Encrypt([]byte("138"), true)
+	d2, _ := keyring.Encrypt([]byte("138"), true)
+	r1, _ := keyring.Encrypt([]byte("138"), false)
+	r2, _ := keyring.Encrypt([]byte("138"), false)
+	if !bytes.Equal(d1, d2) || bytes.Equal(r1, r2) {
+		t.Fatal("确定性加密密文应该相同, 随机加密密文应该不同")
+	}
+
+	plain, err := keyring.Decrypt(r1)
+	if err != nil || string(plain) != "138" {
+		t.Fatalf("解密结果不对: %s, %v", plain, err)
+	}
+
+	r1[len(r1)-1] ^= 0xff
+	if _, err = keyring.Decrypt(r1); err == nil {
+		t.Fatal("密文被篡改后解密应该失败")
+	}
+}
+
+func Test_Encryptor_RewriteQuery(t *testing.T) {
+	e, _, _ := newTestEncryptor(t)
+
+	query, err := e.RewriteQuery("db1", "insert into t_user(name, phone, id_card) values('a', '138', '110')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(query, "insert into t_user(name, phone, id_card) values('a', X'") {
+		t.Fatalf("改写后的语句不对: %s", query)
+	}
+	literals := hexLiterals(t, query)
+	if len(literals) != 2 {
+		t.Fatalf("应该有两个加密的值: %s", query)
+	}
+	if plain, _ := e.getKeyring().Decrypt(literals[1]); string(plain) != "110" {
+		t.Fatalf("加密的值不对: %s", plain)
+	}
+
+	// 确定性加密的等值查询
+	where, err := e.RewriteQuery("db1", "select * from t_user u where u.phone = '138'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hexLiterals(t, where)[0], literals[0]) {
+		t.Fatal("确定性加密的查询条件应该和写入的密文相同")
+	}
+
+	// 随机加密的列不能作为查询条件
+	if _, err = e.RewriteQuery("db1", "select * from t_user where id_card = '110'"); err == nil {
+		t.Fatal("随机加密的列作为查询条件应该报错")
+	}
+
+	// 其他表的同名列不加密
+	if query, _ = e.RewriteQuery("db1", "update t2 set phone = '138'"); query != "update t2 set phone = '138'" {
+		t.Fatalf("不应该改写: %s", query)
+	}
+}
+
+func Test_Encryptor_Args(t *testing.T) {
+	e, _, _ := newTestEncryptor(t)
+
+	args, err := e.EncryptArgs("db1", "update t_user set id_card = ?, name = ? where phone = ?", []interface{}{"110", "a", int64(138)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[1] != "a" || !IsEncrypted(args[0].([]byte)) || !IsEncrypted(args[2].([]byte)) {
+		t.Fatalf("参数加密不对: %v", args)
+	}
+	if plain, _ := e.getKeyring().Decrypt(args[2].([]byte)); string(plain) != "138" {
+		t.Fatalf("数字参数加密不对: %s", plain)
+	}
+}
+
+func Test_Encryptor_Decrypt(t *testing.T) {
+	e, path, keys := newTestEncryptor(t)
+	old, _ := e.getKeyring().Encrypt([]byte("138"), true)
+
+	// 轮换密钥
+	keys["k2"] = randomKey(t)
+	writeKeyFile(t, path, "k2", keys)
+	if err := e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if e.getKeyring().ActiveKeyID() != "k2" {
+		t.Fatal("轮换后应该使用新的密钥")
+	}
+	newer, _ := e.getKeyring().Encrypt([]byte("139"), true)
+
+	for _, binary := range []bool{false, true} {
+		rs, err := mysql.BuildSimpleResultset([]string{"name", "phone"},
+			[][]interface{}{{"a", old}, {"b", newer}, {"c", []byte("plain")}}, binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range rs.Fields {
+			f.Schema, f.OrgTable = []byte("db1"), []byte("t_user")
+			f.OrgName = f.Name
+		}
+
+		decrypted, err := e.Decrypt(rs, binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var phones []string
+		for _, row := range decrypted.RowDatas {
+			values, err := row.Parse(decrypted.Fields, binary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			phones = append(phones, string(values[1].([]byte)))
+		}
+		if strings.Join(phones, ",") != "138,139,plain" {
+			t.Fatalf("解密结果不对(binary: %v): %v", binary, phones)
+		}
+	}
+
+	// 旧数据重新加密
+	data, err := e.ReEncrypt(old, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[3:3+data[2]]) != "k2" {
+		t.Fatal("重新加密应该使用新的密钥")
+	}
+}
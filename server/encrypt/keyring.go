@@ -0,0 +1,162 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/BurntSushi/toml"
+)
+
+// 密文格式:
+//
+//	CIPHER_MAGIC      1 byte
+//	CIPHER_VERSION    1 byte
+//	key id 长度        1 byte
+//	key id
+//	nonce             12 bytes
+//	AES-256-GCM 密文(包含 16 bytes tag)
+//
+// 密文中带有 key id, 轮换密钥后旧的数据依然可以解密.
+const (
+	CIPHER_MAGIC   byte = 0xEC
+	CIPHER_VERSION byte = 1
+
+	KEY_SIZE   = 32
+	NONCE_SIZE = 12
+	TAG_SIZE   = 16
+)
+
+type key struct {
+	id     string
+	aead   cipher.AEAD
+	macKey []byte // 确定性加密时用来生成 nonce
+}
+
+// 加密使用的密钥, 从密钥文件加载. 密钥文件格式(toml):
+//
+//	active = "k2"
+//	[keys]
+//	k1 = "base64 编码的 32 字节密钥"
+//	k2 = "base64 编码的 32 字节密钥"
+//
+// 使用 active 密钥加密, 所有密钥都可以用来解密.
+type Keyring struct {
+	active *key
+	keys   map[string]*key
+}
+
+type keyFile struct {
+	Active string            `toml:"active"`
+	Keys   map[string]string `toml:"keys"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	kf := new(keyFile)
+	if _, err := toml.DecodeFile(path, kf); err != nil {
+		return nil, fmt.Errorf("读取密钥文件 %s 出错. %s", path, err.Error())
+	}
+
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("密钥 %s 不是合法的 base64 编码. %s", id, err.Error())
+		}
+		keys[id] = raw
+	}
+
+	return NewKeyring(kf.Active, keys)
+}
+
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*key, len(keys))}
+	for id, raw := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("密钥 id 长度需要在 1~255 之间: %s", id)
+		}
+		if len(raw) != KEY_SIZE {
+			return nil, fmt.Errorf("密钥 %s 长度需要是 %d 字节, 当前: %d", id, KEY_SIZE, len(raw))
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha256.New, raw)
+		mac.Write([]byte("dal deterministic nonce"))
+
+		k.keys[id] = &key{id: id, aead: aead, macKey: mac.Sum(nil)}
+	}
+
+	var ok bool
+	if k.active, ok = k.keys[active]; !ok {
+		return nil, fmt.Errorf("加密使用的密钥 %s 不存在", active)
+	}
+
+	return k, nil
+}
+
+func (this *Keyring) ActiveKeyID() string {
+	return this.active.id
+}
+
+// 加密. deterministic 为 true 时相同的明文得到相同的密文(nonce 由明文的 HMAC 生成), 可以用来做等值查询
+func (this *Keyring) Encrypt(plain []byte, deterministic bool) ([]byte, error) {
+	k := this.active
+	header := []byte{CIPHER_MAGIC, CIPHER_VERSION, byte(len(k.id))}
+	header = append(header, k.id...)
+
+	nonce := make([]byte, NONCE_SIZE)
+	if deterministic {
+		mac := hmac.New(sha256.New, k.macKey)
+		mac.Write(plain)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("生成随机 nonce 出错. %s", err.Error())
+	}
+
+	out := make([]byte, 0, len(header)+NONCE_SIZE+len(plain)+TAG_SIZE)
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, plain, header), nil
+}
+
+// 解密
+func (this *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("不是合法的密文")
+	}
+
+	idLen := int(data[2])
+	id := string(data[3 : 3+idLen])
+	k, ok := this.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("解密使用的密钥 %s 不存在", id)
+	}
+
+	header := data[:3+idLen]
+	nonce := data[3+idLen : 3+idLen+NONCE_SIZE]
+	plain, err := k.aead.Open(nil, nonce, data[3+idLen+NONCE_SIZE:], header)
+	if err != nil {
+		return nil, fmt.Errorf("使用密钥 %s 解密失败. %s", id, err.Error())
+	}
+	return plain, nil
+}
+
+// 是否是加密后的数据
+func IsEncrypted(data []byte) bool {
+	if len(data) < 3 || data[0] != CIPHER_MAGIC || data[1] != CIPHER_VERSION {
+		return false
+	}
+	return len(data) >= 3+int(data[2])+NONCE_SIZE+TAG_SIZE
+}
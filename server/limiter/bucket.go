@@ -0,0 +1,132 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// 令牌桶. 按照 rate 个/秒 的速度生成令牌, 桶中最多存放 burst 个令牌
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 补充令牌, 需要在获取 mutex lock 后使用
+func (this *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(this.last).Seconds()
+	if elapsed > 0 {
+		this.tokens += elapsed * this.rate
+		if this.tokens > this.burst {
+			this.tokens = this.burst
+		}
+	}
+	this.last = now
+}
+
+// 预定一个令牌, 返回需要等待的时间.
+// 如果等待的时间超过了 maxWait 则不会预定令牌, 并返回 false
+func (this *tokenBucket) reserve(maxWait time.Duration) (time.Duration, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+	this.refill(now)
+
+	if this.tokens >= 1 {
+		this.tokens--
+		return 0, true
+	}
+
+	wait := time.Duration((1 - this.tokens) / this.rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+
+	// 令牌可以为负数, 代表已经被预定
+	this.tokens--
+	return wait, true
+}
+
+// 令牌桶是否已经装满
+func (this *tokenBucket) isFull() bool {
+	this.Lock()
+	defer this.Unlock()
+
+	this.refill(time.Now())
+	return this.tokens >= this.burst
+}
+
+// 归还预定了但没有使用的令牌
+func (this *tokenBucket) cancel() {
+	this.Lock()
+	this.tokens++
+	if this.tokens > this.burst {
+		this.tokens = this.burst
+	}
+	this.Unlock()
+}
+
+// 并发限制
+type semaphore struct {
+	slots chan struct{}
+}
+
+func newSemaphore(max int) *semaphore {
+	return &semaphore{slots: make(chan struct{}, max)}
+}
+
+// 获取一个执行位置, 最多等待到 deadline. deadline 为 0 代表不等待
+func (this *semaphore) acquire(deadline time.Time) bool {
+	select {
+	case this.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if deadline.IsZero() {
+		return false
+	}
+
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case this.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (this *semaphore) release() {
+	<-this.slots
+}
+
+// 当前正在执行的数量
+func (this *semaphore) inUse() int {
+	return len(this.slots)
+}
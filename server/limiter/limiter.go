@@ -0,0 +1,297 @@
+package limiter
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+const (
+	LIMIT_MODE_QUEUE     = "queue"     // 超过限制后排队等待, 最多等待 QueueTimeoutMS
+	LIMIT_MODE_FAIL_FAST = "fail_fast" // 超过限制后直接返回错误
+
+	LIMIT_DEFAULT_QUEUE_TIMEOUT_MS = 1000
+	LIMIT_RULE_DEFAULT_KEY         = "*"    // 没有单独配置的 key 都使用该规则(每个 key 单独计数)
+	LIMIT_STATE_IDLE_TIMEOUT_MS    = 600000 // 使用默认规则的 key 超过该时间没有使用, 清理它的限流状态
+)
+
+// 限流的维度
+const (
+	DIMENSION_USER        = "user"
+	DIMENSION_FINGERPRINT = "fingerprint"
+	DIMENSION_BACKEND     = "backend"
+)
+
+// 单个限流规则. 值 <= 0 代表不限制
+type Rule struct {
+	QPS           float64 `toml:"qps"`
+	Burst         int     `toml:"burst"`
+	MaxConcurrent int     `toml:"max_concurrent"`
+}
+
+func (this Rule) isEmpty() bool {
+	return this.QPS <= 0 && this.MaxConcurrent <= 0
+}
+
+// 限流配置
+// [limit]
+// mode = "queue"
+// queue_timeout_ms = 500
+// [limit.users.app]
+// qps = 1000
+// max_concurrent = 50
+type Config struct {
+	Mode           string          `toml:"mode"`
+	QueueTimeoutMS int64           `toml:"queue_timeout_ms"`
+	Users          map[string]Rule `toml:"users"`        // key: 前端用户名
+	Fingerprints   map[string]Rule `toml:"fingerprints"` // key: SQL 指纹的 md5 (sqlutil.FingerprintID)
+	Backends       map[string]Rule `toml:"backends"`     // key: 后端地址 host:port
+}
+
+// 某一个 key 的限流状态
+type state struct {
+	rule     Rule
+	bucket   *tokenBucket
+	sem      *semaphore
+	dynamic  bool         // 使用默认规则创建的状态, 空闲后会被清理
+	lastUsed atomic.Int64 // 最后一次使用的时间(UnixNano)
+}
+
+func newState(rule Rule, dynamic bool) *state {
+	s := &state{rule: rule, dynamic: dynamic}
+	s.lastUsed.Store(time.Now().UnixNano())
+	if rule.QPS > 0 {
+		s.bucket = newTokenBucket(rule.QPS, rule.Burst)
+	}
+	if rule.MaxConcurrent > 0 {
+		s.sem = newSemaphore(rule.MaxConcurrent)
+	}
+	return s
+}
+
+// 是否可以清理: 超过 timeout 没有使用, 没有正在执行的语句, 并且令牌桶已经装满(和新建的状态没有区别)
+func (this *state) isIdle(now time.Time, timeout time.Duration) bool {
+	if now.Sub(time.Unix(0, this.lastUsed.Load())) < timeout {
+		return false
+	}
+	if this.sem != nil && this.sem.inUse() > 0 {
+		return false
+	}
+	if this.bucket != nil && !this.bucket.isFull() {
+		return false
+	}
+	return true
+}
+
+type Limiter struct {
+	sync.RWMutex
+	mode         string
+	queueTimeout time.Duration
+	rules        map[string]map[string]Rule // dimension -> key -> rule
+	states       *sync.Map                  // dimension:key -> *state
+	idleTimeout  time.Duration              // 使用默认规则的状态空闲多久后清理
+	lastEvict    atomic.Int64               // 最后一次清理空闲状态的时间(UnixNano)
+}
+
+func NewLimiter(cfg *Config) *Limiter {
+	l := &Limiter{idleTimeout: LIMIT_STATE_IDLE_TIMEOUT_MS * time.Millisecond}
+	l.lastEvict.Store(time.Now().UnixNano())
+	l.SetConfig(cfg)
+	return l
+}
+
+// 重新设置限流配置. 已经获取的 Ticket 在 Release 的时候依然归还给老的状态
+func (this *Limiter) SetConfig(cfg *Config) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+
+	mode := cfg.Mode
+	switch mode {
+	case LIMIT_MODE_QUEUE, LIMIT_MODE_FAIL_FAST:
+	case "":
+		mode = LIMIT_MODE_FAIL_FAST
+	default:
+		seelog.Warnf("未知的限流模式:%s, 使用默认模式:%s", mode, LIMIT_MODE_FAIL_FAST)
+		mode = LIMIT_MODE_FAIL_FAST
+	}
+
+	queueTimeout := cfg.QueueTimeoutMS
+	if queueTimeout <= 0 {
+		queueTimeout = LIMIT_DEFAULT_QUEUE_TIMEOUT_MS
+	}
+
+	this.Lock()
+	this.mode = mode
+	this.queueTimeout = time.Duration(queueTimeout) * time.Millisecond
+	this.rules = map[string]map[string]Rule{
+		DIMENSION_USER:        cfg.Users,
+		DIMENSION_FINGERPRINT: cfg.Fingerprints,
+		DIMENSION_BACKEND:     cfg.Backends,
+	}
+	this.states = new(sync.Map)
+	this.Unlock()
+}
+
+// 获取某个维度某个 key 的状态, 没有配置规则返回 nil
+func (this *Limiter) getState(dimension string, key string) *state {
+	if len(key) == 0 {
+		return nil
+	}
+
+	stateKey := dimension + ":" + key
+	if s, ok := this.states.Load(stateKey); ok {
+		s.(*state).lastUsed.Store(time.Now().UnixNano())
+		return s.(*state)
+	}
+
+	rules := this.rules[dimension]
+	rule, ok := rules[key]
+	dynamic := false
+	if !ok {
+		if rule, ok = rules[LIMIT_RULE_DEFAULT_KEY]; !ok {
+			return nil
+		}
+		dynamic = true
+	}
+	if rule.isEmpty() {
+		return nil
+	}
+
+	s, loaded := this.states.LoadOrStore(stateKey, newState(rule, dynamic))
+	if loaded {
+		s.(*state).lastUsed.Store(time.Now().UnixNano())
+	}
+	return s.(*state)
+}
+
+// 清理使用默认规则创建的空闲状态, 否则 key 很多的维度(比如指纹)状态会一直增长.
+// 每 idleTimeout 最多清理一次
+func (this *Limiter) evictIdle(states *sync.Map, idleTimeout time.Duration) {
+	now := time.Now()
+	last := this.lastEvict.Load()
+	if now.Sub(time.Unix(0, last)) < idleTimeout || !this.lastEvict.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	states.Range(func(key, value interface{}) bool {
+		if s := value.(*state); s.dynamic && s.isIdle(now, idleTimeout) {
+			states.Delete(key)
+		}
+		return true
+	})
+}
+
+// 执行一个语句前需要获取 Ticket, 执行完成后需要调用 Ticket.Release.
+// 超过限制返回 MySQL 错误, 可以直接返回给客户端
+func (this *Limiter) Acquire(user string, fingerprint string, backend string) (*Ticket, error) {
+	this.RLock()
+	mode := this.mode
+	queueTimeout := this.queueTimeout
+	items := []struct {
+		dimension string
+		key       string
+		state     *state
+	}{
+		{DIMENSION_USER, user, this.getState(DIMENSION_USER, user)},
+		{DIMENSION_FINGERPRINT, fingerprint, this.getState(DIMENSION_FINGERPRINT, fingerprint)},
+		{DIMENSION_BACKEND, backend, this.getState(DIMENSION_BACKEND, backend)},
+	}
+	states := this.states
+	idleTimeout := this.idleTimeout
+	this.RUnlock()
+
+	this.evictIdle(states, idleTimeout)
+
+	var deadline time.Time
+	if mode == LIMIT_MODE_QUEUE {
+		deadline = time.Now().Add(queueTimeout)
+	}
+
+	ticket := new(Ticket)
+
+	// 先获取并发限制
+	for _, item := range items {
+		if item.state == nil || item.state.sem == nil {
+			continue
+		}
+		if !item.state.sem.acquire(deadline) {
+			ticket.Release()
+			return nil, newLimitError(item.dimension, item.key, "max_concurrent", int64(item.state.rule.MaxConcurrent))
+		}
+		ticket.sems = append(ticket.sems, item.state.sem)
+	}
+
+	// 获取QPS令牌
+	var wait time.Duration
+	var buckets []*tokenBucket
+	for _, item := range items {
+		if item.state == nil || item.state.bucket == nil {
+			continue
+		}
+
+		var maxWait time.Duration
+		if !deadline.IsZero() {
+			maxWait = time.Until(deadline)
+		}
+
+		w, ok := item.state.bucket.reserve(maxWait)
+		if !ok {
+			for _, b := range buckets {
+				b.cancel()
+			}
+			ticket.Release()
+			return nil, newLimitError(item.dimension, item.key, "qps", int64(item.state.rule.QPS))
+		}
+		buckets = append(buckets, item.state.bucket)
+		if w > wait {
+			wait = w
+		}
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return ticket, nil
+}
+
+// 当前某个维度某个 key 正在执行的数量
+func (this *Limiter) InUse(dimension string, key string) int {
+	this.RLock()
+	s := this.getState(dimension, key)
+	this.RUnlock()
+
+	if s == nil || s.sem == nil {
+		return 0
+	}
+	return s.sem.inUse()
+}
+
+// 获取执行权限的凭证
+type Ticket struct {
+	once sync.Once
+	sems []*semaphore
+}
+
+// 归还执行位置, 可以重复调用
+func (this *Ticket) Release() {
+	if this == nil {
+		return
+	}
+
+	this.once.Do(func() {
+		for _, sem := range this.sems {
+			sem.release()
+		}
+	})
+}
+
+func newLimitError(dimension string, key string, resource string, value int64) error {
+	return mysql.NewError(mysql.ER_USER_LIMIT_REACHED,
+		fmt.Sprintf("%s '%s' has exceeded the '%s' resource (current value: %d)", dimension, key, resource, value))
+}
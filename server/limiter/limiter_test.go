@@ -0,0 +1,178 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// 测试并发限制, fail fast 模式
+func Test_Limiter_MaxConcurrent_FailFast(t *testing.T) {
+	l := NewLimiter(&Config{
+		Mode:  LIMIT_MODE_FAIL_FAST,
+		Users: map[string]Rule{"batch": {MaxConcurrent: 2}},
+	})
+
+	t1, err := l.Acquire("batch", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, err := l.Acquire("batch", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = l.Acquire("batch", "", "")
+	if err == nil {
+		t.Fatal("超过最大并发应该返回错误")
+	}
+	if e, ok := err.(*mysql.MyError); !ok || e.Code != mysql.ER_USER_LIMIT_REACHED {
+		t.Fatalf("错误类型不对: %v", err)
+	}
+
+	// 其他用户不受影响
+	if _, err = l.Acquire("app", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	t1.Release()
+	t1.Release() // 重复释放不影响
+	if n := l.InUse(DIMENSION_USER, "batch"); n != 1 {
+		t.Fatalf("正在执行的数量应该是1, 实际:%d", n)
+	}
+
+	t3, err := l.Acquire("batch", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2.Release()
+	t3.Release()
+}
+
+// 测试排队模式, 等待到其他语句执行完成
+func Test_Limiter_MaxConcurrent_Queue(t *testing.T) {
+	l := NewLimiter(&Config{
+		Mode:           LIMIT_MODE_QUEUE,
+		QueueTimeoutMS: 500,
+		Backends:       map[string]Rule{LIMIT_RULE_DEFAULT_KEY: {MaxConcurrent: 1}},
+	})
+
+	t1, err := l.Acquire("app", "", "127.0.0.1:3306")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		t1.Release()
+	}()
+
+	t2, err := l.Acquire("app", "", "127.0.0.1:3306")
+	if err != nil {
+		t.Fatal("排队模式应该等到执行位置:", err)
+	}
+
+	// 等待超时
+	start := time.Now()
+	if _, err = l.Acquire("app", "", "127.0.0.1:3306"); err == nil {
+		t.Fatal("排队超时应该返回错误")
+	}
+	if time.Since(start) < 400*time.Millisecond {
+		t.Fatal("应该排队等待到超时")
+	}
+	t2.Release()
+}
+
+// 测试 QPS 限制
+func Test_Limiter_QPS(t *testing.T) {
+	l := NewLimiter(&Config{
+		Mode:         LIMIT_MODE_FAIL_FAST,
+		Fingerprints: map[string]Rule{"fp1": {QPS: 10, Burst: 3}},
+	})
+
+	for i := 0; i < 3; i++ {
+		ticket, err := l.Acquire("", "fp1", "")
+		if err != nil {
+			t.Fatalf("第%d次获取失败: %v", i, err)
+		}
+		ticket.Release()
+	}
+	if _, err := l.Acquire("", "fp1", ""); err == nil {
+		t.Fatal("超过 burst 应该返回错误")
+	}
+
+	// 排队模式可以等待令牌生成
+	l.SetConfig(&Config{
+		Mode:           LIMIT_MODE_QUEUE,
+		QueueTimeoutMS: 1000,
+		Fingerprints:   map[string]Rule{"fp1": {QPS: 20, Burst: 1}},
+	})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		ticket, err := l.Acquire("", "fp1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ticket.Release()
+	}
+	if time.Since(start) < 80*time.Millisecond {
+		t.Fatal("排队模式应该按照 QPS 等待")
+	}
+}
+
+func countStates(l *Limiter) int {
+	n := 0
+	l.states.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// 测试清理使用默认规则的空闲状态
+func Test_Limiter_EvictIdle(t *testing.T) {
+	l := NewLimiter(&Config{
+		Mode: LIMIT_MODE_FAIL_FAST,
+		Fingerprints: map[string]Rule{
+			LIMIT_RULE_DEFAULT_KEY: {QPS: 1000, MaxConcurrent: 1},
+			"fp0":                  {QPS: 1000},
+		},
+	})
+	l.idleTimeout = 50 * time.Millisecond
+
+	for _, fingerprint := range []string{"fp0", "fp1", "fp2"} {
+		ticket, err := l.Acquire("", fingerprint, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ticket.Release()
+	}
+	busy, err := l.Acquire("", "fp3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countStates(l); n != 4 {
+		t.Fatalf("应该有4个限流状态, 实际:%d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	ticket, err := l.Acquire("", "fp4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket.Release()
+
+	// 单独配置的 fp0 和正在执行的 fp3 不清理
+	for _, fingerprint := range []string{"fp1", "fp2"} {
+		if _, ok := l.states.Load(DIMENSION_FINGERPRINT + ":" + fingerprint); ok {
+			t.Fatalf("%s 空闲的状态应该被清理", fingerprint)
+		}
+	}
+	if n := countStates(l); n != 3 {
+		t.Fatalf("应该剩下 fp0, fp3, fp4 3个限流状态, 实际:%d", n)
+	}
+	if _, err = l.Acquire("", "fp3", ""); err == nil {
+		t.Fatal("正在执行的状态不应该被清理, 超过最大并发应该返回错误")
+	}
+	busy.Release()
+}
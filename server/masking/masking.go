@@ -0,0 +1,219 @@
+package masking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/daiguadaidai/dal/server/rowutil"
+)
+
+const (
+	MASK_POLICY_FULL    = "full"    // 全部替换为掩码字符
+	MASK_POLICY_PARTIAL = "partial" // 保留前后几位, 例如: 138****1234
+	MASK_POLICY_HASH    = "hash"    // sha256, 相同的值脱敏后还是相同, 可以用来关联
+	MASK_POLICY_NULL    = "null"    // 返回 NULL
+
+	MASK_DEFAULT_CHAR = "*"
+	MASK_ALL_USERS    = "*"
+)
+
+// 数据脱敏配置
+// [[masking.rules]]
+// column = "db1.t_user.phone"
+// policy = "partial"
+// keep_prefix = 3
+// keep_suffix = 4
+// users = ["analyst", "support"]
+type Config struct {
+	Rules []Rule `toml:"rules"`
+}
+
+type Rule struct {
+	Column     string   `toml:"column"`      // db.table.column, 对应 MySQL 返回的 Field 的 Schema, OrgTable, OrgName
+	Policy     string   `toml:"policy"`      // full, partial, hash, null
+	KeepPrefix int      `toml:"keep_prefix"` // partial: 保留前几个字符
+	KeepSuffix int      `toml:"keep_suffix"` // partial: 保留后几个字符
+	MaskChar   string   `toml:"mask_char"`   // full, partial: 掩码字符, 默认 *
+	Salt       string   `toml:"salt"`        // hash: 盐
+	Users      []string `toml:"users"`       // 需要脱敏的前端用户, * 代表所有用户
+}
+
+func (this *Rule) appliesTo(user string) bool {
+	for _, u := range this.Users {
+		if u == MASK_ALL_USERS || u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// 对一个值脱敏, 返回 nil 代表 NULL
+func (this *Rule) mask(value []byte) []byte {
+	switch this.Policy {
+	case MASK_POLICY_NULL:
+		return nil
+	case MASK_POLICY_HASH:
+		sum := sha256.Sum256(append([]byte(this.Salt), value...))
+		return []byte(hex.EncodeToString(sum[:]))
+	}
+
+	maskChar := this.MaskChar
+	if len(maskChar) == 0 {
+		maskChar = MASK_DEFAULT_CHAR
+	}
+
+	n := utf8.RuneCount(value)
+	prefix, suffix := 0, 0
+	if this.Policy == MASK_POLICY_PARTIAL && this.KeepPrefix+this.KeepSuffix < n {
+		prefix, suffix = this.KeepPrefix, this.KeepSuffix
+	}
+
+	runes := []rune(string(value))
+	var sb strings.Builder
+	sb.WriteString(string(runes[:prefix]))
+	sb.WriteString(strings.Repeat(maskChar, n-prefix-suffix))
+	sb.WriteString(string(runes[n-suffix:]))
+	return []byte(sb.String())
+}
+
+// 结果集脱敏, 实现了 server.ResultsetFilter, 通过 server.Conn.SetResultsetFilter 设置给前端链接.
+// 通过 MySQL 返回的列信息(Schema, OrgTable, OrgName)确定列的来源,
+// 表达式(例如 concat(phone))没有来源信息, 不会被脱敏, 需要配合权限控制使用.
+type Masker struct {
+	sync.RWMutex
+	rules map[string]*Rule // db.table.column(小写) -> 规则
+}
+
+var _ server.ResultsetFilter = (*Masker)(nil)
+
+func NewMasker(cfg Config) (*Masker, error) {
+	m := new(Masker)
+	if err := m.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 修改配置, 配置错误时保留原来的配置
+func (this *Masker) SetConfig(cfg Config) error {
+	rules := make(map[string]*Rule, len(cfg.Rules))
+	for i := range cfg.Rules {
+		rule := cfg.Rules[i]
+		rule.Policy = strings.ToLower(rule.Policy)
+		switch rule.Policy {
+		case MASK_POLICY_FULL, MASK_POLICY_PARTIAL, MASK_POLICY_HASH, MASK_POLICY_NULL:
+		default:
+			return fmt.Errorf("列 %s 脱敏策略 %s 不支持, 只支持 %s, %s, %s, %s", rule.Column, rule.Policy,
+				MASK_POLICY_FULL, MASK_POLICY_PARTIAL, MASK_POLICY_HASH, MASK_POLICY_NULL)
+		}
+		if strings.Count(rule.Column, ".") != 2 {
+			return fmt.Errorf("脱敏列 %s 需要是 db.table.column 格式", rule.Column)
+		}
+		if rule.KeepPrefix < 0 || rule.KeepSuffix < 0 {
+			return fmt.Errorf("脱敏列 %s keep_prefix 和 keep_suffix 不能小于0", rule.Column)
+		}
+		rules[strings.ToLower(rule.Column)] = &rule
+	}
+
+	this.Lock()
+	this.rules = rules
+	this.Unlock()
+
+	return nil
+}
+
+func (this *Masker) FilterResultset(c *server.Conn, r *mysql.Resultset, binary bool) (*mysql.Resultset, error) {
+	return this.Mask(c.GetUser(), r, binary)
+}
+
+// 对结果集脱敏, 没有需要脱敏的列时返回原来的结果集. 不会修改原来的结果集
+func (this *Masker) Mask(user string, r *mysql.Resultset, binary bool) (*mysql.Resultset, error) {
+	this.RLock()
+	rules := this.rules
+	this.RUnlock()
+
+	if len(rules) == 0 || r == nil {
+		return r, nil
+	}
+
+	columnRules := make([]*Rule, len(r.Fields))
+	found := false
+	for i, f := range r.Fields {
+		if len(f.OrgTable) == 0 || len(f.OrgName) == 0 {
+			continue
+		}
+		name := strings.ToLower(string(f.Schema) + "." + string(f.OrgTable) + "." + string(f.OrgName))
+		if rule, ok := rules[name]; ok && rule.appliesTo(user) {
+			columnRules[i] = rule
+			found = true
+		}
+	}
+	if !found {
+		return r, nil
+	}
+
+	masked := &mysql.Resultset{
+		Fields:     make([]*mysql.Field, len(r.Fields)),
+		FieldNames: r.FieldNames,
+		RowDatas:   make([]mysql.RowData, 0, len(r.RowDatas)),
+	}
+	for i, f := range r.Fields {
+		if columnRules[i] == nil {
+			masked.Fields[i] = f
+			continue
+		}
+		// 脱敏后的值都是字符串
+		field := *f
+		field.Data = nil
+		if columnRules[i].Policy != MASK_POLICY_NULL {
+			field.Type = mysql.MYSQL_TYPE_VAR_STRING
+			field.Charset = 33 // utf8_general_ci
+			field.Flag &^= mysql.BINARY_FLAG | mysql.UNSIGNED_FLAG | mysql.ZEROFILL_FLAG
+			field.Decimal = 0
+			field.ColumnLength = 1024
+		}
+		masked.Fields[i] = &field
+	}
+
+	columns := make([]bool, len(columnRules))
+	for i, rule := range columnRules {
+		columns[i] = rule != nil
+	}
+	mask := func(column int, value []byte) ([]byte, error) {
+		return columnRules[column].mask(value), nil
+	}
+	for _, row := range r.RowDatas {
+		newRow, err := rowutil.RewriteRow(r.Fields, row, binary, columns, mask)
+		if err != nil {
+			return nil, err
+		}
+		masked.RowDatas = append(masked.RowDatas, newRow)
+	}
+
+	if len(r.Values) > 0 {
+		if err := fillValues(masked, binary); err != nil {
+			return nil, err
+		}
+	}
+
+	return masked, nil
+}
+
+// 解码后的值也需要脱敏, 避免直接使用 Values 的地方拿到原始数据
+func fillValues(r *mysql.Resultset, binary bool) error {
+	r.Values = make([][]interface{}, len(r.RowDatas))
+	for i, row := range r.RowDatas {
+		values, err := row.Parse(r.Fields, binary)
+		if err != nil {
+			return err
+		}
+		r.Values[i] = values
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package masking
+
+import (
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+func buildResultset(t *testing.T, values [][]interface{}, binary bool) *mysql.Resultset {
+	rs, err := mysql.BuildSimpleResultset([]string{"id", "phone", "email"}, values, binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"id", "phone", "email"} {
+		rs.Fields[i].Schema = []byte("db1")
+		rs.Fields[i].Table = []byte("u")
+		rs.Fields[i].OrgTable = []byte("t_user")
+		rs.Fields[i].OrgName = []byte(name)
+	}
+	return rs
+}
+
+func newTestMasker(t *testing.T) *Masker {
+	m, err := NewMasker(Config{Rules: []Rule{
+		{Column: "db1.t_user.phone", Policy: MASK_POLICY_PARTIAL, KeepPrefix: 3, KeepSuffix: 4, Users: []string{"analyst"}},
+		{Column: "DB1.T_USER.EMAIL", Policy: MASK_POLICY_NULL, Users: []string{MASK_ALL_USERS}},
+		{Column: "db1.t_user.id", Policy: MASK_POLICY_FULL, Users: []string{"support"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func Test_Masker_Text(t *testing.T) {
+	m := newTestMasker(t)
+	rs := buildResultset(t, [][]interface{}{{1, "13812341234", "a@b.com"}, {2, "110", nil}}, false)
+
+	masked, err := m.Mask("analyst", rs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := masked.RowDatas[0].Parse(masked.Fields, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(values[1].([]byte)) != "138****1234" || values[2] != nil || values[0].(int64) != 1 {
+		t.Fatalf("脱敏结果不对: %v", values)
+	}
+	values, _ = masked.RowDatas[1].Parse(masked.Fields, false)
+	if string(values[1].([]byte)) != "***" {
+		t.Fatalf("长度不够时应该全部掩码: %v", values)
+	}
+
+	// 原来的结果集不能被修改
+	values, _ = rs.RowDatas[0].Parse(rs.Fields, false)
+	if string(values[1].([]byte)) != "13812341234" {
+		t.Fatalf("原来的结果集被修改了: %v", values)
+	}
+
+	masked, _ = m.Mask("support", rs, false)
+	values, _ = masked.RowDatas[0].Parse(masked.Fields, false)
+	if string(values[0].([]byte)) != "*" || string(values[1].([]byte)) != "13812341234" {
+		t.Fatalf("support 用户脱敏结果不对: %v", values)
+	}
+}
+
+func Test_Masker_Binary(t *testing.T) {
+	m := newTestMasker(t)
+	rs := buildResultset(t, [][]interface{}{{int64(10), "13812341234", "a@b.com"}}, true)
+
+	masked, err := m.Mask("support", rs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := masked.RowDatas[0].Parse(masked.Fields, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(values[0].([]byte)) != "**" || string(values[1].([]byte)) != "13812341234" || values[2] != nil {
+		t.Fatalf("脱敏结果不对: %v", values)
+	}
+}
+
+func Test_Masker_NoRule(t *testing.T) {
+	m := newTestMasker(t)
+	rs := buildResultset(t, [][]interface{}{{1, "13812341234", "a@b.com"}}, false)
+	rs.Fields[2].OrgTable = nil // 表达式
+
+	if masked, _ := m.Mask("other", rs, false); masked != rs {
+		t.Fatal("没有需要脱敏的列应该返回原来的结果集")
+	}
+	if _, err := NewMasker(Config{Rules: []Rule{{Column: "t.c", Policy: MASK_POLICY_FULL}}}); err == nil {
+		t.Fatal("列名格式错误应该报错")
+	}
+}
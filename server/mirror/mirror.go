@@ -0,0 +1,324 @@
+package mirror
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/mysqldb/pool"
+	"github.com/daiguadaidai/dal/server/sqlutil"
+)
+
+const (
+	MIRROR_DEFAULT_QUEUE_SIZE = 10000
+	MIRROR_DEFAULT_WORKERS    = 8
+)
+
+// 流量镜像配置
+// [mirror]
+// enable = true
+// sample_rate = 0.1
+// mirror_writes = false
+type Config struct {
+	Enable       bool    `toml:"enable"`
+	SampleRate   float64 `toml:"sample_rate"`   // 采样比例 0~1
+	MirrorWrites bool    `toml:"mirror_writes"` // 是否镜像写语句, 影子集群需要是独立的数据副本
+	QueueSize    int     `toml:"queue_size"`    // 等待回放的语句队列长度, 队列满了直接丢弃
+	Workers      int     `toml:"workers"`       // 回放的并发数
+}
+
+// 在影子集群上执行语句
+type Executor interface {
+	Execute(db string, query string) (*mysql.Result, error)
+}
+
+// 使用 MySQLPool 作为影子集群
+type PoolExecutor struct {
+	pool *pool.MySQLPool
+}
+
+func NewPoolExecutor(p *pool.MySQLPool) *PoolExecutor {
+	return &PoolExecutor{pool: p}
+}
+
+func (this *PoolExecutor) Execute(db string, query string) (*mysql.Result, error) {
+	conn, err := this.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	var r *mysql.Result
+	defaultDB := conn.GetDB()
+	if len(db) > 0 {
+		err = conn.UseDB(db)
+	}
+	if err == nil {
+		r, err = conn.Execute(query)
+	}
+	this.pool.Report(err)
+
+	if mysql.ErrorEqual(err, mysql.ErrBadConn) || !restoreDB(conn, defaultDB) {
+		closeConn(conn)
+	} else {
+		this.pool.Release(conn)
+	}
+
+	return r, err
+}
+
+// 链接放回链接池之前恢复链接池默认的库, 否则之后 db 为空的语句会在上一次使用的库中执行.
+// 没有默认库的链接不能恢复(不能取消 USE), 返回 false
+func restoreDB(conn *client.Conn, defaultDB string) bool {
+	if conn.GetDB() == defaultDB {
+		return true
+	}
+	if len(defaultDB) == 0 {
+		return false
+	}
+	if err := conn.UseDB(defaultDB); err != nil {
+		seelog.Warnf("影子集群链接(thread id): %d. 恢复默认库 %s 失败. %s", conn.GetConnectionID(), defaultDB, err.Error())
+		return false
+	}
+	return true
+}
+
+func closeConn(conn *client.Conn) {
+	if err := conn.Close(); err != nil {
+		seelog.Warnf("影子集群链接(thread id): %d. 关闭失败. %s", conn.GetConnectionID(), err.Error())
+	}
+}
+
+// 需要回放的语句
+type item struct {
+	db              string
+	query           string
+	primaryChecksum uint32
+	primaryErr      bool
+	primaryLatency  time.Duration
+}
+
+// 镜像统计信息
+type Stats struct {
+	Submitted      int64 // 提交回放的语句数
+	Dropped        int64 // 队列满了丢弃的语句数
+	Replayed       int64 // 已经回放的语句数
+	Errors         int64 // 影子集群执行出错(主集群没有出错)的语句数
+	Mismatches     int64 // 结果集校验和不一致的语句数
+	PrimaryLatency int64 // 主集群累计耗时(微秒)
+	ShadowLatency  int64 // 影子集群累计耗时(微秒)
+	MaxLatencyDiff int64 // 影子集群比主集群慢的最大耗时(微秒)
+}
+
+// 流量镜像: 异步把采样的语句回放到影子集群, 丢弃结果, 只记录耗时和结果集差异
+type Mirror struct {
+	cfg      Config
+	executor Executor
+	queue    chan *item
+	wg       sync.WaitGroup
+	closeMu  sync.RWMutex
+	closed   int32
+	stats    Stats
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+func NewMirror(cfg Config, executor Executor) *Mirror {
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = MIRROR_DEFAULT_QUEUE_SIZE
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = MIRROR_DEFAULT_WORKERS
+	}
+	if cfg.SampleRate < 0 {
+		cfg.SampleRate = 0
+	} else if cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+
+	m := &Mirror{
+		cfg:      cfg,
+		executor: executor,
+		queue:    make(chan *item, cfg.QueueSize),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		m.wg.Add(1)
+		go m.loop()
+	}
+
+	return m
+}
+
+// 判断语句是否需要镜像
+func (this *Mirror) shouldMirror(query string) bool {
+	if !this.cfg.Enable || atomic.LoadInt32(&this.closed) == 1 {
+		return false
+	}
+
+	// WITH 语句按照 CTE 后面的主语句判断, 多语句中的每个语句都需要判断
+	stmts := sqlutil.SplitStatements(query)
+	if len(stmts) == 0 {
+		return false
+	}
+	for _, stmt := range stmts {
+		stmtType := sqlutil.GetStmtType(stmt)
+		if !stmtType.IsRead() && !(this.cfg.MirrorWrites && stmtType.IsWrite()) {
+			return false
+		}
+		// SELECT ... INTO OUTFILE/DUMPFILE 会在影子集群的机器上写文件
+		if fp := sqlutil.Fingerprint(stmt); strings.Contains(fp, " into outfile ") || strings.Contains(fp, " into dumpfile ") {
+			return false
+		}
+	}
+
+	if this.cfg.SampleRate >= 1 {
+		return true
+	}
+
+	this.randMu.Lock()
+	hit := this.rand.Float64() < this.cfg.SampleRate
+	this.randMu.Unlock()
+
+	return hit
+}
+
+// 主集群执行完成后提交镜像, 不会阻塞调用方.
+// primaryResult, primaryErr 为主集群执行的结果, 用于和影子集群对比
+func (this *Mirror) Submit(db string, query string, primaryResult *mysql.Result, primaryErr error, primaryLatency time.Duration) {
+	if !this.shouldMirror(query) {
+		return
+	}
+
+	it := &item{
+		db:              db,
+		query:           query,
+		primaryChecksum: ResultChecksum(primaryResult),
+		primaryErr:      primaryErr != nil,
+		primaryLatency:  primaryLatency,
+	}
+
+	this.closeMu.RLock()
+	defer this.closeMu.RUnlock()
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return
+	}
+
+	atomic.AddInt64(&this.stats.Submitted, 1)
+	select {
+	case this.queue <- it:
+	default:
+		atomic.AddInt64(&this.stats.Dropped, 1)
+	}
+}
+
+func (this *Mirror) loop() {
+	defer this.wg.Done()
+
+	for it := range this.queue {
+		this.replay(it)
+	}
+}
+
+// 在影子集群回放语句
+func (this *Mirror) replay(it *item) {
+	start := time.Now()
+	r, err := this.executor.Execute(it.db, it.query)
+	latency := time.Since(start)
+
+	atomic.AddInt64(&this.stats.Replayed, 1)
+	atomic.AddInt64(&this.stats.PrimaryLatency, int64(it.primaryLatency/time.Microsecond))
+	atomic.AddInt64(&this.stats.ShadowLatency, int64(latency/time.Microsecond))
+
+	diff := int64((latency - it.primaryLatency) / time.Microsecond)
+	for {
+		max := atomic.LoadInt64(&this.stats.MaxLatencyDiff)
+		if diff <= max || atomic.CompareAndSwapInt64(&this.stats.MaxLatencyDiff, max, diff) {
+			break
+		}
+	}
+
+	if err != nil {
+		if !it.primaryErr {
+			atomic.AddInt64(&this.stats.Errors, 1)
+			seelog.Warnf("影子集群执行出错. 指纹: %s. %s", sqlutil.Fingerprint(it.query), err.Error())
+		}
+		return
+	}
+
+	if it.primaryErr {
+		atomic.AddInt64(&this.stats.Errors, 1)
+		seelog.Warnf("主集群执行出错, 影子集群执行成功. 指纹: %s", sqlutil.Fingerprint(it.query))
+		return
+	}
+
+	if checksum := ResultChecksum(r); checksum != it.primaryChecksum {
+		atomic.AddInt64(&this.stats.Mismatches, 1)
+		seelog.Warnf("影子集群结果不一致. 指纹: %s. 主集群校验和: %d, 影子集群校验和: %d, 主集群耗时: %s, 影子集群耗时: %s",
+			sqlutil.Fingerprint(it.query), it.primaryChecksum, checksum, it.primaryLatency, latency)
+	}
+}
+
+// 获取统计信息
+func (this *Mirror) Stats() Stats {
+	return Stats{
+		Submitted:      atomic.LoadInt64(&this.stats.Submitted),
+		Dropped:        atomic.LoadInt64(&this.stats.Dropped),
+		Replayed:       atomic.LoadInt64(&this.stats.Replayed),
+		Errors:         atomic.LoadInt64(&this.stats.Errors),
+		Mismatches:     atomic.LoadInt64(&this.stats.Mismatches),
+		PrimaryLatency: atomic.LoadInt64(&this.stats.PrimaryLatency),
+		ShadowLatency:  atomic.LoadInt64(&this.stats.ShadowLatency),
+		MaxLatencyDiff: atomic.LoadInt64(&this.stats.MaxLatencyDiff),
+	}
+}
+
+func (this Stats) String() string {
+	return fmt.Sprintf("submitted: %d, dropped: %d, replayed: %d, errors: %d, mismatches: %d, "+
+		"primary latency: %dus, shadow latency: %dus, max latency diff: %dus",
+		this.Submitted, this.Dropped, this.Replayed, this.Errors, this.Mismatches,
+		this.PrimaryLatency, this.ShadowLatency, this.MaxLatencyDiff)
+}
+
+// 停止镜像, 等待已经提交的语句回放完成
+func (this *Mirror) Close() {
+	this.closeMu.Lock()
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		this.closeMu.Unlock()
+		return
+	}
+	close(this.queue)
+	this.closeMu.Unlock()
+
+	this.wg.Wait()
+}
+
+// 计算结果的校验和. 结果集对比字段名和每一行的原始数据, 其他对比影响行数
+func ResultChecksum(r *mysql.Result) uint32 {
+	if r == nil {
+		return 0
+	}
+
+	h := crc32.NewIEEE()
+	if r.Resultset == nil {
+		fmt.Fprintf(h, "affected:%d", r.AffectedRows)
+		return h.Sum32()
+	}
+
+	for _, f := range r.Fields {
+		h.Write(f.Name)
+		h.Write([]byte{0})
+	}
+	for _, row := range r.RowDatas {
+		h.Write(row)
+	}
+	return h.Sum32()
+}
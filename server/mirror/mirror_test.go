@@ -0,0 +1,183 @@
+package mirror
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/daiguadaidai/dal/mysqldb/pool"
+)
+
+// 模拟影子集群
+type fakeExecutor struct {
+	sync.Mutex
+	queries []string
+	result  *mysql.Result
+}
+
+func (this *fakeExecutor) Execute(db string, query string) (*mysql.Result, error) {
+	this.Lock()
+	this.queries = append(this.queries, db+":"+query)
+	this.Unlock()
+	time.Sleep(time.Millisecond)
+	return this.result, nil
+}
+
+func buildResult(t *testing.T, values [][]interface{}) *mysql.Result {
+	rs, err := mysql.BuildSimpleResultset([]string{"id", "name"}, values, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mysql.Result{Resultset: rs}
+}
+
+func Test_Mirror(t *testing.T) {
+	primary := buildResult(t, [][]interface{}{{1, "a"}, {2, "b"}})
+	executor := &fakeExecutor{result: buildResult(t, [][]interface{}{{1, "a"}, {2, "b"}})}
+
+	m := NewMirror(Config{Enable: true, SampleRate: 1, Workers: 2}, executor)
+	m.Submit("db1", "SELECT id, name FROM t", primary, nil, time.Millisecond)
+	m.Submit("db1", "UPDATE t SET name = 'c'", &mysql.Result{AffectedRows: 1}, nil, time.Millisecond)
+	m.Close()
+	m.Submit("db1", "SELECT id, name FROM t", primary, nil, time.Millisecond)
+
+	stats := m.Stats()
+	if stats.Submitted != 1 || stats.Replayed != 1 {
+		t.Fatalf("只应该回放一条读语句(写语句默认不镜像, 关闭后不再提交): %s", stats)
+	}
+	if len(executor.queries) != 1 || executor.queries[0] != "db1:SELECT id, name FROM t" {
+		t.Fatalf("回放的语句不对: %v", executor.queries)
+	}
+}
+
+func Test_Mirror_WithDML(t *testing.T) {
+	executor := &fakeExecutor{}
+	m := NewMirror(Config{Enable: true, SampleRate: 1}, executor)
+	m.Submit("db1", "WITH c AS (SELECT id FROM t2) DELETE FROM t WHERE id IN (SELECT id FROM c)", &mysql.Result{}, nil, 0)
+	m.Submit("db1", "with c as (select 1) update t set a = 1", &mysql.Result{}, nil, 0)
+	m.Submit("db1", "select 1; delete from t", &mysql.Result{}, nil, 0)
+	m.Submit("db1", "with c as (select 1) select * from c", &mysql.Result{}, nil, 0)
+	m.Submit("db1", "select * from t into outfile '/tmp/t.txt'", &mysql.Result{}, nil, 0)
+	m.Submit("db1", "select a from t limit 1 INTO DUMPFILE '/tmp/a'", &mysql.Result{}, nil, 0)
+	m.Close()
+
+	if len(executor.queries) != 1 || executor.queries[0] != "db1:with c as (select 1) select * from c" {
+		t.Fatalf("没有开启写镜像, WITH 开头的写语句, 包含写语句的多语句和写文件的语句不应该回放: %v", executor.queries)
+	}
+}
+
+func Test_Mirror_Mismatch(t *testing.T) {
+	primary := buildResult(t, [][]interface{}{{1, "a"}, {2, "b"}})
+	executor := &fakeExecutor{result: buildResult(t, [][]interface{}{{1, "a"}})}
+
+	m := NewMirror(Config{Enable: true, SampleRate: 1, MirrorWrites: true}, executor)
+	m.Submit("", "select id, name from t", primary, nil, time.Millisecond)
+	m.Submit("", "delete from t where id = 3", &mysql.Result{AffectedRows: 1}, nil, time.Millisecond)
+	m.Close()
+
+	stats := m.Stats()
+	if stats.Replayed != 2 {
+		t.Fatalf("开启写镜像后应该回放两条语句: %s", stats)
+	}
+	if stats.Mismatches != 2 {
+		t.Fatalf("两条语句结果都不一致: %s", stats)
+	}
+}
+
+func Test_Mirror_Sample(t *testing.T) {
+	executor := &fakeExecutor{}
+	m := NewMirror(Config{Enable: true, SampleRate: 0}, executor)
+	for i := 0; i < 100; i++ {
+		m.Submit("", "select 1", nil, nil, 0)
+	}
+	m.Close()
+
+	if stats := m.Stats(); stats.Submitted != 0 {
+		t.Fatalf("采样比例为0不应该回放: %s", stats)
+	}
+}
+
+func Test_ResultChecksum(t *testing.T) {
+	r1 := buildResult(t, [][]interface{}{{1, "a"}})
+	r2 := buildResult(t, [][]interface{}{{1, "a"}})
+	r3 := buildResult(t, [][]interface{}{{1, "b"}})
+
+	if ResultChecksum(r1) != ResultChecksum(r2) {
+		t.Fatal("相同结果集校验和应该相同")
+	}
+	if ResultChecksum(r1) == ResultChecksum(r3) {
+		t.Fatal("不同结果集校验和应该不同")
+	}
+	if ResultChecksum(&mysql.Result{AffectedRows: 1}) == ResultChecksum(&mysql.Result{AffectedRows: 2}) {
+		t.Fatal("影响行数不同校验和应该不同")
+	}
+}
+
+// 记录每个语句执行时的库
+type dbHandler struct {
+	server.EmptyHandler
+	db      string
+	queries chan string
+}
+
+func (this *dbHandler) UseDB(db string) error {
+	this.db = db
+	return nil
+}
+
+func (this *dbHandler) HandleQuery(query string) (*mysql.Result, error) {
+	if !strings.HasPrefix(query, "SET ") {
+		this.queries <- this.db + ":" + query
+	}
+	return &mysql.Result{}, nil
+}
+
+func Test_PoolExecutor_RestoreDB(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	queries := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewConn(conn, "root", "123", &dbHandler{queries: queries})
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	p, err := pool.Open(addr.IP.String(), uint16(addr.Port), "root", "123", "db0", "utf8mb4", true, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	executor := NewPoolExecutor(p)
+	if _, err = executor.Execute("db1", "select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = executor.Execute("", "select 2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"db1:select 1", "db0:select 2"} {
+		if query := <-queries; query != expected {
+			t.Fatalf("期望: %s, 实际: %s", expected, query)
+		}
+	}
+}
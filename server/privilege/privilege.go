@@ -0,0 +1,352 @@
+package privilege
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+	"github.com/daiguadaidai/dal/server/sqlutil"
+)
+
+// 权限
+type Priv uint8
+
+const (
+	PRIV_SELECT Priv = 1 << iota
+	PRIV_INSERT
+	PRIV_UPDATE
+	PRIV_DELETE
+	PRIV_DDL
+	PRIV_OTHER // 其他语句, 例如 CALL, KILL, FLUSH
+
+	PRIV_ALL = PRIV_SELECT | PRIV_INSERT | PRIV_UPDATE | PRIV_DELETE | PRIV_DDL | PRIV_OTHER
+)
+
+const GRANT_ANY = "*" // 授权对象中代表所有库或所有表
+
+var privNames = map[string]Priv{
+	"select": PRIV_SELECT,
+	"insert": PRIV_INSERT,
+	"update": PRIV_UPDATE,
+	"delete": PRIV_DELETE,
+	"ddl":    PRIV_DDL,
+	"other":  PRIV_OTHER,
+	"all":    PRIV_ALL,
+}
+
+// 错误信息中使用的命令名
+func (this Priv) String() string {
+	switch this {
+	case PRIV_SELECT:
+		return "SELECT"
+	case PRIV_INSERT:
+		return "INSERT"
+	case PRIV_UPDATE:
+		return "UPDATE"
+	case PRIV_DELETE:
+		return "DELETE"
+	case PRIV_DDL:
+		return "DDL"
+	}
+	return "OTHER"
+}
+
+// 前端用户的权限配置, 和后端 MySQL 的权限无关(后端链接使用的是同一个账号).
+// [privilege]
+// strict = true
+// [[privilege.users]]
+// user = "app"
+// [[privilege.users.grants]]
+// on = "db1.*"
+// privileges = ["select", "insert", "update", "delete"]
+// [[privilege.users.grants]]
+// on = "db2.t_log"
+// privileges = ["select"]
+type Config struct {
+	Strict bool         `toml:"strict"` // 没有配置权限的用户拒绝所有语句, 否则不做限制
+	Users  []UserConfig `toml:"users"`
+}
+
+type UserConfig struct {
+	User   string  `toml:"user"`
+	Grants []Grant `toml:"grants"`
+}
+
+type Grant struct {
+	On         string   `toml:"on"`         // db.table, * 代表所有: *.*, db1.*
+	Privileges []string `toml:"privileges"` // select, insert, update, delete, ddl, other, all
+}
+
+type grant struct {
+	db    string
+	table string
+	privs Priv
+}
+
+func (this *grant) match(db string, table string) bool {
+	return (this.db == GRANT_ANY || this.db == db) && (this.table == GRANT_ANY || this.table == table)
+}
+
+// 权限检查, 实现了 server.CommandChecker, 通过 server.Server.SetCommandChecker 设置.
+// 根据语句类型和语句中的表检查权限, WITH 语句按照 CTE 后面的主语句检查, 可执行注释 /*! */ 中的内容当作 SQL 检查:
+//   - SELECT/TABLE: 所有表的 SELECT 权限
+//   - INSERT/UPDATE/DELETE: 修改的表需要对应的权限(REPLACE 需要 INSERT 和 DELETE), 只读的表需要 SELECT 权限
+//   - DDL: 修改的表的 DDL 权限, 库级别的 DDL 需要 db.* 的 DDL 权限
+//   - SHOW CREATE TABLE/SHOW COLUMNS/DESC/EXPLAIN: 查看的表的 SELECT 权限, 其他 SHOW 语句不检查
+//   - SET: 子查询中的表的 SELECT 权限
+//   - 事务语句: 不检查
+//   - 解析不了的语句, 有解析不了的表引用的语句: 拒绝
+//   - HANDLER: 表的 SELECT 权限
+//   - 其他语句: 当前库的 OTHER 权限, 语句中的表的 SELECT 权限
+type Checker struct {
+	sync.RWMutex
+	strict bool
+	users  map[string][]*grant
+}
+
+var _ server.CommandChecker = (*Checker)(nil)
+
+func NewChecker(cfg Config) (*Checker, error) {
+	c := new(Checker)
+	if err := c.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 修改配置, 配置错误时保留原来的配置
+func (this *Checker) SetConfig(cfg Config) error {
+	users := make(map[string][]*grant, len(cfg.Users))
+	for _, u := range cfg.Users {
+		grants := make([]*grant, 0, len(u.Grants))
+		for _, g := range u.Grants {
+			items := strings.Split(strings.ToLower(g.On), ".")
+			if len(items) != 2 || len(items[0]) == 0 || len(items[1]) == 0 {
+				return fmt.Errorf("用户 %s 的权限对象 %s 需要是 db.table 格式", u.User, g.On)
+			}
+
+			privs := Priv(0)
+			for _, name := range g.Privileges {
+				p, ok := privNames[strings.ToLower(name)]
+				if !ok {
+					return fmt.Errorf("用户 %s 的权限 %s 不支持", u.User, name)
+				}
+				privs |= p
+			}
+			grants = append(grants, &grant{db: items[0], table: items[1], privs: privs})
+		}
+		users[u.User] = append(users[u.User], grants...)
+	}
+
+	this.Lock()
+	this.strict = cfg.Strict
+	this.users = users
+	this.Unlock()
+
+	return nil
+}
+
+// 获取用户的权限, 没有配置权限返回 false
+func (this *Checker) grants(user string) ([]*grant, bool) {
+	this.RLock()
+	defer this.RUnlock()
+	grants, ok := this.users[user]
+	if !ok && !this.strict {
+		return nil, false
+	}
+	return grants, true
+}
+
+func (this *Checker) CheckCommand(c *server.Conn, cmd byte, data []byte) error {
+	switch cmd {
+	case mysql.COM_QUERY:
+		// 没有协商 CLIENT_MULTI_STATEMENTS 的客户端也可能发送多语句, 后端链接开启多语句时会全部执行
+		return this.CheckStatements(c.GetUser(), c.RemoteHost(), c.GetDB(), string(data))
+	case mysql.COM_STMT_PREPARE:
+		return this.Check(c.GetUser(), c.RemoteHost(), c.GetDB(), string(data))
+	case mysql.COM_INIT_DB:
+		return this.CheckDB(c.GetUser(), c.RemoteHost(), string(data))
+	case mysql.COM_FIELD_LIST:
+		table := data
+		if i := bytes.IndexByte(data, 0x00); i >= 0 {
+			table = data[:i]
+		}
+		return this.checkTables(c.GetUser(), c.RemoteHost(), PRIV_SELECT,
+			[]string{strings.ToLower(c.GetDB()) + "." + strings.ToLower(string(table))})
+	}
+	return nil
+}
+
+// 检查是否可以使用数据库, 有该库任意一个权限就可以使用
+func (this *Checker) CheckDB(user string, host string, db string) error {
+	grants, ok := this.grants(user)
+	if !ok {
+		return nil
+	}
+
+	db = strings.ToLower(db)
+	for _, g := range grants {
+		if g.privs != 0 && (g.db == GRANT_ANY || g.db == db) {
+			return nil
+		}
+	}
+	return mysql.NewDefaultError(mysql.ER_DBACCESS_DENIED_ERROR, user, host, db)
+}
+
+// 检查多语句(CLIENT_MULTI_STATEMENTS)中的每一个语句, 有一个没有权限就拒绝整个查询.
+// USE 之后的语句使用新的库检查
+func (this *Checker) CheckStatements(user string, host string, db string, query string) error {
+	stmts := sqlutil.SplitStatements(query)
+	if len(stmts) == 0 {
+		return this.Check(user, host, db, query)
+	}
+
+	for _, stmt := range stmts {
+		if err := this.Check(user, host, db, stmt); err != nil {
+			return err
+		}
+		if sqlutil.GetStmtType(stmt) == sqlutil.STMT_USE {
+			db = useTarget(stmt)
+		}
+	}
+	return nil
+}
+
+// USE db 语句中的库名, 格式不对返回空
+func useTarget(query string) string {
+	fields := strings.Fields(sqlutil.Normalize(query))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "use") {
+		return ""
+	}
+	return strings.Trim(fields[1], "`")
+}
+
+// 检查语句的权限, db 是当前使用的数据库. 只检查一个语句, 多语句使用 CheckStatements
+func (this *Checker) Check(user string, host string, db string, query string) error {
+	if _, ok := this.grants(user); !ok {
+		return nil
+	}
+
+	stmtType := sqlutil.GetStmtType(query)
+	switch stmtType {
+	case sqlutil.STMT_UNKNOWN:
+		// 解析不了的语句直接拒绝
+		return mysql.NewDefaultError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, PRIV_OTHER.String())
+	case sqlutil.STMT_BEGIN, sqlutil.STMT_COMMIT, sqlutil.STMT_ROLLBACK:
+		return nil
+	case sqlutil.STMT_SHOW:
+		// EXPLAIN 的语句中有解析不了的表引用时拒绝
+		if _, ok := sqlutil.ExtractTablesStrict(query, db); !ok {
+			return mysql.NewDefaultError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, PRIV_SELECT.String())
+		}
+		return this.checkTables(user, host, PRIV_SELECT, sqlutil.ExtractShowTables(query, db))
+	case sqlutil.STMT_SET:
+		// SET @x = (SELECT ...)
+		return this.checkQueryTables(user, host, PRIV_SELECT, query, db)
+	case sqlutil.STMT_USE:
+		return this.CheckDB(user, host, useTarget(query))
+	case sqlutil.STMT_SELECT:
+		return this.checkQueryTables(user, host, PRIV_SELECT, query, db)
+	}
+
+	var targetPriv Priv
+	switch stmtType {
+	case sqlutil.STMT_INSERT:
+		targetPriv = PRIV_INSERT
+	case sqlutil.STMT_REPLACE:
+		targetPriv = PRIV_INSERT | PRIV_DELETE
+	case sqlutil.STMT_UPDATE:
+		targetPriv = PRIV_UPDATE
+	case sqlutil.STMT_DELETE:
+		targetPriv = PRIV_DELETE
+	case sqlutil.STMT_DDL:
+		targetPriv = PRIV_DDL
+	default:
+		switch sqlutil.MainKeyword(query) {
+		case "select", "(": // SELECT ... FOR UPDATE
+			return this.checkQueryTables(user, host, PRIV_SELECT, query, db)
+		case "load":
+			targetPriv = PRIV_INSERT
+		case "savepoint", "release":
+			return nil
+		case "handler": // HANDLER t OPEN/READ 读取表中的数据
+			return this.checkQueryTables(user, host, PRIV_SELECT, query, db)
+		default:
+			// DO (SELECT ...), ANALYZE TABLE t 等语句中的表也需要 SELECT 权限
+			if err := this.checkTables(user, host, PRIV_OTHER, []string{strings.ToLower(db) + "." + GRANT_ANY}); err != nil {
+				return err
+			}
+			return this.checkQueryTables(user, host, PRIV_SELECT, query, db)
+		}
+	}
+
+	tables, ok := sqlutil.ExtractTablesStrict(query, db)
+	if !ok {
+		return mysql.NewDefaultError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, PRIV_SELECT.String())
+	}
+	targets := sqlutil.ExtractTargetTables(query, db)
+	if err := this.checkTables(user, host, targetPriv, targets); err != nil {
+		return err
+	}
+
+	// 只读的表
+	isTarget := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		isTarget[t] = true
+	}
+	var reads []string
+	for _, t := range tables {
+		if !isTarget[t] {
+			reads = append(reads, t)
+		}
+	}
+	return this.checkTables(user, host, PRIV_SELECT, reads)
+}
+
+// 检查语句中所有表的权限, 有解析不了的表引用时拒绝, 避免漏掉表
+func (this *Checker) checkQueryTables(user string, host string, privs Priv, query string, db string) error {
+	tables, ok := sqlutil.ExtractTablesStrict(query, db)
+	if !ok {
+		return mysql.NewDefaultError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, privs.String())
+	}
+	return this.checkTables(user, host, privs, tables)
+}
+
+// tables 是 db.table 格式, db.* 代表库级别的权限
+func (this *Checker) checkTables(user string, host string, privs Priv, tables []string) error {
+	grants, ok := this.grants(user)
+	if !ok {
+		return nil
+	}
+
+	for _, name := range tables {
+		i := strings.LastIndexByte(name, '.')
+		db, table := name[:i], name[i+1:]
+
+		var granted Priv
+		for _, g := range grants {
+			// 库级别的权限只能由 db.* 或者 *.* 授予
+			if table == GRANT_ANY && g.table != GRANT_ANY {
+				continue
+			}
+			if g.match(db, table) {
+				granted |= g.privs
+			}
+		}
+
+		for p := PRIV_SELECT; p <= PRIV_OTHER; p <<= 1 {
+			if privs&p == 0 || granted&p != 0 {
+				continue
+			}
+			if table == GRANT_ANY {
+				return mysql.NewDefaultError(mysql.ER_DBACCESS_DENIED_ERROR, user, host, db)
+			}
+			return mysql.NewDefaultError(mysql.ER_TABLEACCESS_DENIED_ERROR, p.String(), user, host, table)
+		}
+	}
+
+	return nil
+}
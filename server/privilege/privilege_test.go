@@ -0,0 +1,235 @@
+package privilege
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+func newTestChecker(t *testing.T, strict bool) *Checker {
+	c, err := NewChecker(Config{
+		Strict: strict,
+		Users: []UserConfig{
+			{User: "app", Grants: []Grant{
+				{On: "db1.*", Privileges: []string{"select", "insert", "update", "delete"}},
+				{On: "db2.t_log", Privileges: []string{"select"}},
+			}},
+			{User: "admin", Grants: []Grant{{On: "*.*", Privileges: []string{"all"}}}},
+			{User: "reader", Grants: []Grant{{On: "db1.*", Privileges: []string{"select", "other"}}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func errorCode(err error) uint16 {
+	if myErr, ok := err.(*mysql.MyError); ok {
+		return myErr.Code
+	}
+	return 0
+}
+
+func Test_Checker_Check(t *testing.T) {
+	c := newTestChecker(t, true)
+
+	cases := []struct {
+		user  string
+		query string
+		code  uint16 // 0 代表有权限
+	}{
+		{"app", "select * from t1 join db2.t_log l on 1", 0},
+		{"app", "select * from db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "insert into t1 select * from db2.t_log", 0},
+		{"app", "insert into db2.t_log values (1)", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "update t1 set a = (select max(a) from db2.t_user)", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "delete from t1 where id = 1", 0},
+		{"app", "replace into t1 values (1)", 0},
+		{"app", "select * from t1 for update", 0},
+		{"app", "alter table t1 add column c int", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "create database db3", mysql.ER_DBACCESS_DENIED_ERROR},
+		{"app", "call p1()", mysql.ER_DBACCESS_DENIED_ERROR},
+		{"app", "show tables", 0},
+		{"app", "begin", 0},
+		{"app", "use db2", 0},
+		{"app", "use `db3`", mysql.ER_DBACCESS_DENIED_ERROR},
+		{"admin", "drop database db3", 0},
+		{"admin", "kill 10", 0},
+		{"nobody", "select 1", 0},
+		{"nobody", "select * from t1", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "set autocommit = 1", 0},
+		{"app", "desc t1", 0},
+		{"app", "show create table db2.t_log", 0},
+		{"app", "show tables from db2", 0},
+		{"app", "with c as (select 1) select * from t1", 0},
+		// 可执行注释中的内容, CTE 后面的语句, 子查询和查看表结构的语句都需要检查
+		{"app", "/*!50000 DELETE FROM db2.t_user */", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "SELECT * FROM t1 /*!, db2.t_user */", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "WITH c AS (SELECT 1) DELETE FROM db2.t_log", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "WITH c AS (SELECT id FROM t1) UPDATE db2.t_log SET a = 1", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "SET @x = (SELECT pw FROM db2.t_user)", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "DESC db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "EXPLAIN SELECT * FROM db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "SHOW CREATE TABLE db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "SHOW FULL COLUMNS FROM t_user IN db2", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "SHOW INDEX FROM db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "/*!50000 use db3 */", mysql.ER_DBACCESS_DENIED_ERROR},
+		{"app", ";", mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR},
+		// PARTITION, 索引提示, STRAIGHT_JOIN 和表函数后面的表也需要检查, 解析不了的表引用拒绝
+		{"app", "select * from db1.t partition (p0), db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "select * from db1.t force index(i), db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "select * from db1.t straight_join db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "select * from json_table('[1]', '$[*]' columns (a int path '$')) j, db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "select * from db1.t partition (p0), db2.t_log", 0},
+		{"app", "select * from t1,", mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR},
+		{"app", "delete from t1 where id in (select id from (t2", mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR},
+		// 没有分类的语句中的表也需要检查
+		{"app", "TABLE db2.t_user", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "table db2.t_log", 0},
+		{"app", "HANDLER db2.t_user OPEN", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "HANDLER db2.t_user READ FIRST", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"app", "handler t1 read first", 0},
+		{"reader", "DO (SELECT pw FROM db2.t_user)", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"reader", "do (select a from t1)", 0},
+		{"reader", "HANDLER db2.t_user OPEN", mysql.ER_TABLEACCESS_DENIED_ERROR},
+	}
+	for _, cs := range cases {
+		err := c.Check(cs.user, "127.0.0.1", "db1", cs.query)
+		if errorCode(err) != cs.code {
+			t.Fatalf("user: %s, sql: %s, 期望错误码: %d, 实际: %v", cs.user, cs.query, cs.code, err)
+		}
+	}
+
+	err := c.Check("app", "127.0.0.1", "db1", "select * from db2.t_user")
+	if !strings.Contains(err.Error(), "SELECT command denied to user 'app'@'127.0.0.1' for table 't_user'") {
+		t.Fatalf("错误信息不对: %v", err)
+	}
+
+	// 非严格模式下没有配置的用户不做限制
+	c = newTestChecker(t, false)
+	if err = c.Check("nobody", "127.0.0.1", "db1", "drop table t1"); err != nil {
+		t.Fatalf("非严格模式不应该限制. %v", err)
+	}
+}
+
+func Test_Checker_CheckStatements(t *testing.T) {
+	c := newTestChecker(t, true)
+
+	cases := []struct {
+		query string
+		code  uint16
+	}{
+		{"select * from t1; insert into t1 values (1);", 0},
+		{"select * from t1; use db2; select * from t_log", 0},
+		{"SELECT * FROM t1; DELETE FROM db2.t_log", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"use db2; delete from t_log", mysql.ER_TABLEACCESS_DENIED_ERROR},
+		{"select ';'; /* ; */ select * from t1 -- ;", 0},
+		{";", mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR},
+	}
+	for _, cs := range cases {
+		err := c.CheckStatements("app", "127.0.0.1", "db1", cs.query)
+		if errorCode(err) != cs.code {
+			t.Fatalf("sql: %s, 期望错误码: %d, 实际: %v", cs.query, cs.code, err)
+		}
+	}
+}
+
+func Test_Checker_Config(t *testing.T) {
+	if _, err := NewChecker(Config{Users: []UserConfig{{User: "a", Grants: []Grant{{On: "db1", Privileges: []string{"select"}}}}}}); err == nil {
+		t.Fatal("错误的授权对象应该报错")
+	}
+	if _, err := NewChecker(Config{Users: []UserConfig{{User: "a", Grants: []Grant{{On: "db1.*", Privileges: []string{"grant"}}}}}}); err == nil {
+		t.Fatal("不支持的权限应该报错")
+	}
+}
+
+type testHandler struct {
+	server.EmptyHandler
+}
+
+func (h testHandler) HandleQuery(query string) (*mysql.Result, error) {
+	return &mysql.Result{}, nil
+}
+
+func (h testHandler) HandleMultiQuery(query string) ([]*mysql.Result, error) {
+	return []*mysql.Result{{}}, nil
+}
+
+func Test_Checker_Conn(t *testing.T) {
+	serverConf := server.NewDefaultServer()
+	serverConf.SetCommandChecker(newTestChecker(t, true))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	provider := server.NewInMemoryProvider()
+	provider.AddUser("app", "123")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c, err := server.NewCustomizedConn(conn, serverConf, provider, testHandler{})
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	if _, err = client.Connect(l.Addr().String(), "app", "123", "db3"); err == nil || !strings.Contains(err.Error(), "to database 'db3'") {
+		t.Fatalf("没有权限的库应该登录失败. %v", err)
+	}
+
+	conn, err := client.Connect(l.Addr().String(), "app", "123", "db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Execute("insert into t1 values (1)"); err != nil {
+		t.Fatalf("有权限的语句应该成功. %v", err)
+	}
+	if _, err = conn.Execute("drop table t1"); err == nil || !strings.Contains(err.Error(), "1142") {
+		t.Fatalf("没有权限的语句应该失败. %v", err)
+	}
+	// 没有开启多语句的客户端发送的多语句也需要每个语句都检查
+	if _, err = conn.Execute("select * from t1; drop table t1"); err == nil || !strings.Contains(err.Error(), "1142") {
+		t.Fatalf("没有开启多语句时第二个语句也需要检查. %v", err)
+	}
+	if err = conn.UseDB("db3"); err == nil {
+		t.Fatal("没有权限的库应该切换失败")
+	}
+	if err = conn.UseDB("db2"); err != nil {
+		t.Fatalf("有权限的库应该切换成功. %v", err)
+	}
+	if _, err = conn.Execute("select * from t1"); err == nil {
+		t.Fatal("切换库之后应该检查新库的权限")
+	}
+
+	// 多语句中的每个语句都需要检查
+	multi, err := client.Connect(l.Addr().String(), "app", "123", "db1", func(c *client.Conn) { c.UseMultiStatements() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer multi.Close()
+
+	if _, err = multi.Execute("select * from t1; delete from db2.t_log"); err == nil || !strings.Contains(err.Error(), "1142") {
+		t.Fatalf("第二个语句没有权限应该失败. %v", err)
+	}
+	if _, err = multi.Execute("select * from t1; insert into t1 values (1)"); err != nil {
+		t.Fatalf("有权限的语句应该成功. %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	PROXY_V1_MAX_LENGTH = 107 // 包括结尾的 \r\n
+	PROXY_V2_HEADER_LEN = 16
+
+	PROXY_CMD_LOCAL = 0x0 // 负载均衡自己的连接(例如健康检查), 使用 socket 的地址
+	PROXY_CMD_PROXY = 0x1
+
+	proxyFamilyUnspec = 0x0
+	proxyFamilyInet   = 0x1
+	proxyFamilyInet6  = 0x2
+	proxyFamilyUnix   = 0x3
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// PROXY protocol 头信息
+type Header struct {
+	Version int // 1 或 2
+	Command int // PROXY_CMD_LOCAL, PROXY_CMD_PROXY
+	// 真实的客户端地址和客户端连接的地址.
+	// LOCAL 命令, v1 的 UNKNOWN 以及不支持的地址类型为 nil
+	SrcAddr net.Addr
+	DstAddr net.Addr
+}
+
+// 读取 PROXY protocol 头. 不是以 PROXY protocol 头开始时返回 nil, 不会读取任何数据.
+// 只会 Peek 1 个字节判断类型, 不会因为等待客户端数据阻塞(MySQL 协议是服务端先发送数据)
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		prefix, err := r.Peek(len(proxyV1Prefix))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(prefix, proxyV1Prefix) {
+			return nil, nil
+		}
+		return readHeaderV1(r)
+	case proxyV2Signature[0]:
+		signature, err := r.Peek(len(proxyV2Signature))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(signature, proxyV2Signature) {
+			return nil, nil
+		}
+		return readHeaderV2(r)
+	}
+
+	return nil, nil
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\r\n
+// PROXY UNKNOWN\r\n
+func readHeaderV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= PROXY_V1_MAX_LENGTH {
+			return nil, fmt.Errorf("PROXY protocol v1 头超过 %d 字节", PROXY_V1_MAX_LENGTH)
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY protocol v1 头需要以 \\r\\n 结尾")
+	}
+
+	header := &Header{Version: 1, Command: PROXY_CMD_PROXY}
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY protocol v1 头格式错误: %q", string(line))
+	}
+
+	srcIP, dstIP := net.ParseIP(parts[2]), net.ParseIP(parts[3])
+	if srcIP == nil || dstIP == nil || (srcIP.To4() != nil) != (parts[1] == "TCP4") {
+		return nil, fmt.Errorf("PROXY protocol v1 头中的地址错误: %q", string(line))
+	}
+	srcPort, err1 := parsePort(parts[4])
+	dstPort, err2 := parsePort(parts[5])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("PROXY protocol v1 头中的端口错误: %q", string(line))
+	}
+
+	header.SrcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	header.DstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return header, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("端口错误: %s", s)
+	}
+	return int(port), nil
+}
+
+// 12 字节签名 + 版本和命令(1) + 地址类型和协议(1) + 后面的长度(2) + 地址 + TLV
+func readHeaderV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, PROXY_V2_HEADER_LEN)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("PROXY protocol v2 版本错误: %d", fixed[12]>>4)
+	}
+	header := &Header{Version: 2, Command: int(fixed[12] & 0x0f)}
+	if header.Command != PROXY_CMD_LOCAL && header.Command != PROXY_CMD_PROXY {
+		return nil, fmt.Errorf("PROXY protocol v2 命令错误: %d", header.Command)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if header.Command == PROXY_CMD_LOCAL {
+		return header, nil
+	}
+
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	switch family {
+	case proxyFamilyInet, proxyFamilyInet6:
+		size := net.IPv4len
+		if family == proxyFamilyInet6 {
+			size = net.IPv6len
+		}
+		if len(payload) < size*2+4 {
+			return nil, fmt.Errorf("PROXY protocol v2 地址长度不够: %d", len(payload))
+		}
+		srcIP := net.IP(append([]byte(nil), payload[:size]...))
+		dstIP := net.IP(append([]byte(nil), payload[size:size*2]...))
+		srcPort := int(binary.BigEndian.Uint16(payload[size*2:]))
+		dstPort := int(binary.BigEndian.Uint16(payload[size*2+2:]))
+		if transport == 2 { // DGRAM
+			header.SrcAddr = &net.UDPAddr{IP: srcIP, Port: srcPort}
+			header.DstAddr = &net.UDPAddr{IP: dstIP, Port: dstPort}
+		} else {
+			header.SrcAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+			header.DstAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+		}
+	case proxyFamilyUnix:
+		if len(payload) < 216 {
+			return nil, fmt.Errorf("PROXY protocol v2 unix 地址长度不够: %d", len(payload))
+		}
+		header.SrcAddr = &net.UnixAddr{Name: unixPath(payload[:108]), Net: "unix"}
+		header.DstAddr = &net.UnixAddr{Name: unixPath(payload[108:216]), Net: "unix"}
+	case proxyFamilyUnspec:
+	default:
+		return nil, fmt.Errorf("PROXY protocol v2 地址类型错误: %d", family)
+	}
+
+	return header, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
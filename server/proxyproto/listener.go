@@ -0,0 +1,173 @@
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+const (
+	PROXY_DEFAULT_HEADER_TIMEOUT_MS = 3000
+)
+
+// PROXY protocol 配置, dal 部署在 L4 负载均衡(HAProxy, LVS, 云厂商的 LB)后面时开启,
+// 否则所有客户端的地址都是负载均衡的地址.
+// [proxy_protocol]
+// enable = true
+// trusted = ["10.0.0.0/8", "192.168.1.10"]
+// required = true
+// header_timeout_ms = 3000
+type Config struct {
+	Enable          bool     `toml:"enable"`
+	Trusted         []string `toml:"trusted"`           // 信任的负载均衡地址, IP 或者 CIDR. 只有这些来源的 PROXY protocol 头才会被使用
+	Required        bool     `toml:"required"`          // 信任的来源必须发送 PROXY protocol 头
+	HeaderTimeoutMS int64    `toml:"header_timeout_ms"` // 读取 PROXY protocol 头的超时时间
+}
+
+// 解析 PROXY protocol 头的 Listener, Accept 返回的连接 RemoteAddr 是真实的客户端地址.
+// 在 server.NewCustomizedConn 之前使用:
+//
+//	l, _ := proxyproto.NewListener(tcpListener, cfg)
+//	conn, _ := l.Accept()
+//	c, _ := server.NewCustomizedConn(conn, serverConf, provider, handler)
+//	c.RemoteAddr() // 真实的客户端地址
+type Listener struct {
+	net.Listener
+	trusted       []*net.IPNet
+	required      bool
+	headerTimeout time.Duration
+}
+
+func NewListener(l net.Listener, cfg Config) (*Listener, error) {
+	if len(cfg.Trusted) == 0 {
+		return nil, fmt.Errorf("开启 PROXY protocol 需要配置信任的来源(trusted)")
+	}
+
+	pl := &Listener{
+		Listener:      l,
+		required:      cfg.Required,
+		headerTimeout: time.Duration(cfg.HeaderTimeoutMS) * time.Millisecond,
+	}
+	if pl.headerTimeout <= 0 {
+		pl.headerTimeout = PROXY_DEFAULT_HEADER_TIMEOUT_MS * time.Millisecond
+	}
+
+	for _, t := range cfg.Trusted {
+		if !strings.Contains(t, "/") {
+			if strings.Contains(t, ":") {
+				t += "/128"
+			} else {
+				t += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("PROXY protocol 信任的来源 %s 格式错误. %s", t, err.Error())
+		}
+		pl.trusted = append(pl.trusted, ipNet)
+	}
+
+	return pl, nil
+}
+
+func (this *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range this.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// 不信任的来源返回原始的连接, 它们发送的 PROXY protocol 头会导致 MySQL 握手失败.
+// 信任的来源在第一次读取数据或者获取地址时才解析 PROXY protocol 头, 不会阻塞 Accept.
+func (this *Listener) Accept() (net.Conn, error) {
+	conn, err := this.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !this.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &Conn{
+		Conn:          conn,
+		reader:        bufio.NewReader(conn),
+		required:      this.required,
+		headerTimeout: this.headerTimeout,
+	}, nil
+}
+
+// 带有 PROXY protocol 头的连接
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	required      bool
+	headerTimeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+func (this *Conn) readHeader() {
+	this.once.Do(func() {
+		this.Conn.SetReadDeadline(time.Now().Add(this.headerTimeout))
+		defer this.Conn.SetReadDeadline(time.Time{})
+
+		this.header, this.err = ReadHeader(this.reader)
+		if this.err == nil && this.header == nil && this.required {
+			this.err = fmt.Errorf("来自 %s 的连接没有 PROXY protocol 头", this.Conn.RemoteAddr().String())
+		}
+		if this.err != nil {
+			seelog.Warnf("读取 %s 的 PROXY protocol 头失败. %s", this.Conn.RemoteAddr().String(), this.err.Error())
+		}
+	})
+}
+
+func (this *Conn) Read(b []byte) (int, error) {
+	this.readHeader()
+	if this.err != nil {
+		return 0, this.err
+	}
+	return this.reader.Read(b)
+}
+
+// 真实的客户端地址, 没有 PROXY protocol 头(或者是 LOCAL 命令)时返回 socket 的地址
+func (this *Conn) RemoteAddr() net.Addr {
+	this.readHeader()
+	if this.header != nil && this.header.SrcAddr != nil {
+		return this.header.SrcAddr
+	}
+	return this.Conn.RemoteAddr()
+}
+
+// 客户端连接的地址(负载均衡监听的地址)
+func (this *Conn) LocalAddr() net.Addr {
+	this.readHeader()
+	if this.header != nil && this.header.DstAddr != nil {
+		return this.header.DstAddr
+	}
+	return this.Conn.LocalAddr()
+}
+
+// 负载均衡的地址
+func (this *Conn) ProxyAddr() net.Addr {
+	return this.Conn.RemoteAddr()
+}
+
+// PROXY protocol 头, 没有时返回 nil
+func (this *Conn) Header() (*Header, error) {
+	this.readHeader()
+	return this.header, this.err
+}
@@ -0,0 +1,219 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/client"
+	"github.com/daiguadaidai/dal/go-mysql/server"
+)
+
+func Test_ReadHeader_V1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 10.0.0.1 56324 3306\r\nrest"))
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 1 || header.SrcAddr.String() != "192.168.0.1:56324" || header.DstAddr.String() != "10.0.0.1:3306" {
+		t.Fatalf("解析结果不对: %+v", header)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+		t.Fatalf("PROXY protocol 头后面的数据不对: %s", rest)
+	}
+
+	header, err = ReadHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n")))
+	if err != nil || header.SrcAddr != nil {
+		t.Fatalf("UNKNOWN 没有地址: %+v, %v", header, err)
+	}
+
+	for _, bad := range []string{
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n",
+		"PROXY TCP4 ::1 ::1 1 2\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324 70000\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 1 2\n",
+		"PROXY " + strings.Repeat("x", 200),
+	} {
+		if _, err = ReadHeader(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Fatalf("错误的头应该报错: %q", bad)
+		}
+	}
+
+	// 普通的 MySQL 握手包
+	header, err = ReadHeader(bufio.NewReader(strings.NewReader("\x20\x00\x00\x01")))
+	if err != nil || header != nil {
+		t.Fatalf("没有 PROXY protocol 头应该返回 nil: %+v, %v", header, err)
+	}
+}
+
+func headerV2(command byte, family byte, addrs []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(0x20 | command)
+	buf.WriteByte(family)
+	binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+func Test_ReadHeader_V2(t *testing.T) {
+	addrs := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x0c, 0xea}
+	addrs = append(addrs, 0x04, 0x00, 0x01, 0x00) // TLV 忽略
+	r := bufio.NewReader(bytes.NewReader(append(headerV2(PROXY_CMD_PROXY, 0x11, addrs), "rest"...)))
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.SrcAddr.String() != "192.168.0.1:56324" || header.DstAddr.String() != "10.0.0.1:3306" {
+		t.Fatalf("解析结果不对: %+v", header)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+		t.Fatalf("PROXY protocol 头后面的数据不对: %s", rest)
+	}
+
+	addrs6 := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	addrs6 = append(addrs6, 0x00, 0x50, 0x0c, 0xea)
+	header, err = ReadHeader(bufio.NewReader(bytes.NewReader(headerV2(PROXY_CMD_PROXY, 0x21, addrs6))))
+	if err != nil || header.SrcAddr.String() != "[2001:db8::1]:80" {
+		t.Fatalf("解析 IPv6 结果不对: %+v, %v", header, err)
+	}
+
+	header, err = ReadHeader(bufio.NewReader(bytes.NewReader(headerV2(PROXY_CMD_LOCAL, 0x00, nil))))
+	if err != nil || header.Command != PROXY_CMD_LOCAL || header.SrcAddr != nil {
+		t.Fatalf("LOCAL 命令没有地址: %+v, %v", header, err)
+	}
+
+	if _, err = ReadHeader(bufio.NewReader(bytes.NewReader(headerV2(PROXY_CMD_PROXY, 0x11, addrs[:8])))); err == nil {
+		t.Fatal("地址长度不够应该报错")
+	}
+}
+
+func newTestListener(t *testing.T, cfg Config) *Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := NewListener(l, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pl.Close() })
+	return pl
+}
+
+func Test_Listener(t *testing.T) {
+	if _, err := NewListener(nil, Config{}); err == nil {
+		t.Fatal("没有配置信任的来源应该报错")
+	}
+	if _, err := NewListener(nil, Config{Trusted: []string{"10.0.0.300"}}); err == nil {
+		t.Fatal("错误的信任来源应该报错")
+	}
+
+	cases := []struct {
+		trusted  string
+		required bool
+		send     string
+		addr     string // 空代表读取失败
+	}{
+		{"127.0.0.1", false, "PROXY TCP4 192.168.0.1 10.0.0.1 56324 3306\r\nhello", "192.168.0.1:56324"},
+		{"127.0.0.0/8", false, "hello", "127.0.0.1"},
+		{"127.0.0.0/8", true, "hello", ""},
+		// 不信任的来源不解析 PROXY protocol 头
+		{"10.0.0.0/8", false, "PROXY TCP4 192.168.0.1 10.0.0.1 56324 3306\r\nhello", "127.0.0.1"},
+	}
+	for _, c := range cases {
+		l := newTestListener(t, Config{Trusted: []string{c.trusted}, Required: c.required})
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write([]byte(c.send))
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 5)
+		_, err = io.ReadFull(conn, data)
+		switch {
+		case len(c.addr) == 0:
+			if err == nil {
+				t.Fatalf("%v: 应该读取失败", c)
+			}
+		case err != nil:
+			t.Fatalf("%v: %v", c, err)
+		case !strings.HasPrefix(conn.RemoteAddr().String(), c.addr):
+			t.Fatalf("%v: 客户端地址不对: %s", c, conn.RemoteAddr())
+		case c.trusted == "10.0.0.0/8" && string(data) != "PROXY":
+			t.Fatalf("%v: 不信任的来源应该返回原始数据: %s", c, data)
+		case c.trusted != "10.0.0.0/8" && string(data) != "hello":
+			t.Fatalf("%v: 读取的数据不对: %s", c, data)
+		}
+		conn.Close()
+		client.Close()
+	}
+}
+
+// 模拟负载均衡: 连接 dal 后先发送 PROXY protocol v2 头, 然后转发数据
+func startBalancer(t *testing.T, backend string, src *net.TCPAddr) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			front, err := l.Accept()
+			if err != nil {
+				return
+			}
+			back, err := net.Dial("tcp", backend)
+			if err != nil {
+				front.Close()
+				return
+			}
+			addrs := append(src.IP.To4(), 127, 0, 0, 1, byte(src.Port>>8), byte(src.Port), 0x0c, 0xea)
+			back.Write(headerV2(PROXY_CMD_PROXY, 0x11, addrs))
+			go func() { io.Copy(back, front); back.Close() }()
+			go func() { io.Copy(front, back); front.Close() }()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func Test_Listener_Handshake(t *testing.T) {
+	l := newTestListener(t, Config{Trusted: []string{"127.0.0.1"}, Required: true})
+	addr := startBalancer(t, l.Addr().String(), &net.TCPAddr{IP: net.IPv4(172, 16, 0, 9), Port: 40000})
+
+	hosts := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			hosts <- err.Error()
+			return
+		}
+		c, err := server.NewConn(conn, "root", "", server.EmptyHandler{})
+		if err != nil {
+			hosts <- err.Error()
+			return
+		}
+		hosts <- c.RemoteHost()
+		c.Close()
+	}()
+
+	conn, err := client.Connect(addr, "root", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if host := <-hosts; host != "172.16.0.9" {
+		t.Fatalf("server.Conn 中的客户端地址不对: %s", host)
+	}
+}
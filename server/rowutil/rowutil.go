@@ -0,0 +1,149 @@
+package rowutil
+
+import (
+	"fmt"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// 改写一列的值. value 为原始值的文本形式, 返回新的值, nil 代表 NULL
+type ValueRewriter func(column int, value []byte) ([]byte, error)
+
+// 改写结果集中一行的部分列, columns[i] 为 true 并且不是 NULL 的列会调用 rewrite.
+// 新的值都按字符串编码, 二进制协议需要调用者把对应列的类型改为字符串类型.
+func RewriteRow(fields []*mysql.Field, row mysql.RowData, binary bool, columns []bool, rewrite ValueRewriter) (mysql.RowData, error) {
+	if len(columns) != len(fields) {
+		return nil, fmt.Errorf("需要改写的列数 %d 和结果集的列数 %d 不一致", len(columns), len(fields))
+	}
+	if binary {
+		return rewriteBinaryRow(fields, row, columns, rewrite)
+	}
+	return rewriteTextRow(row, columns, rewrite)
+}
+
+// 文本协议的行: 每一列是 length encoded string, NULL 为 0xfb
+func rewriteTextRow(row mysql.RowData, columns []bool, rewrite ValueRewriter) (mysql.RowData, error) {
+	newRow := make([]byte, 0, len(row))
+	pos := 0
+	for i := range columns {
+		if pos >= len(row) {
+			return nil, mysql.ErrMalformPacket
+		}
+		value, isNull, n, err := mysql.LengthEncodedString(row[pos:])
+		if err != nil {
+			return nil, err
+		}
+
+		if !columns[i] || isNull {
+			newRow = append(newRow, row[pos:pos+n]...)
+			pos += n
+			continue
+		}
+
+		v, err := rewrite(i, value)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			newRow = append(newRow, 0xfb)
+		} else {
+			newRow = append(newRow, mysql.PutLengthEncodedString(v)...)
+		}
+		pos += n
+	}
+	return newRow, nil
+}
+
+// 二进制协议的行: 0x00, NULL bitmap(偏移 2), 非 NULL 的列
+func rewriteBinaryRow(fields []*mysql.Field, row mysql.RowData, columns []bool, rewrite ValueRewriter) (mysql.RowData, error) {
+	bitmapLen := (len(fields) + 7 + 2) >> 3
+	if len(row) < 1+bitmapLen {
+		return nil, mysql.ErrMalformPacket
+	}
+
+	// 需要原始值的文本形式
+	values, err := row.ParseBinary(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	nullBitmap := make([]byte, bitmapLen)
+	copy(nullBitmap, row[1:1+bitmapLen])
+	newRow := make([]byte, 1+bitmapLen, len(row))
+
+	pos := 1 + bitmapLen
+	for i, f := range fields {
+		if row[1+(i+2)>>3]&(1<<(uint(i+2)%8)) > 0 {
+			continue
+		}
+
+		n, err := binaryValueLength(f.Type, row[pos:])
+		if err != nil {
+			return nil, err
+		}
+
+		if !columns[i] {
+			newRow = append(newRow, row[pos:pos+n]...)
+			pos += n
+			continue
+		}
+
+		v, err := rewrite(i, valueBytes(values[i]))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			nullBitmap[(i+2)>>3] |= 1 << (uint(i+2) % 8)
+		} else {
+			newRow = append(newRow, mysql.PutLengthEncodedString(v)...)
+		}
+		pos += n
+	}
+	copy(newRow[1:], nullBitmap)
+
+	return newRow, nil
+}
+
+// 二进制协议中一个值占用的字节数
+func binaryValueLength(tp byte, data []byte) (int, error) {
+	n := 0
+	switch tp {
+	case mysql.MYSQL_TYPE_NULL:
+		return 0, nil
+	case mysql.MYSQL_TYPE_TINY:
+		n = 1
+	case mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_YEAR:
+		n = 2
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT:
+		n = 4
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE:
+		n = 8
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE, mysql.MYSQL_TYPE_DATETIME,
+		mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIME:
+		if len(data) < 1 {
+			return 0, mysql.ErrMalformPacket
+		}
+		n = 1 + int(data[0])
+	default:
+		_, _, m, err := mysql.LengthEncodedString(data)
+		if err != nil {
+			return 0, err
+		}
+		n = m
+	}
+	if len(data) < n {
+		return 0, mysql.ErrMalformPacket
+	}
+	return n, nil
+}
+
+func valueBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
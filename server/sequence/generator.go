@@ -0,0 +1,185 @@
+package sequence
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/mysqldb/pool"
+)
+
+const (
+	SEQUENCE_DEFAULT_TABLE = "dal_sequence"
+	SEQUENCE_DEFAULT_STEP  = 1000
+
+	// snowflake: 41位毫秒时间戳 + 10位 worker id + 12位序号
+	SNOWFLAKE_EPOCH_MS      = 1577836800000 // 2020-01-01 00:00:00 UTC
+	SNOWFLAKE_WORKER_BITS   = 10
+	SNOWFLAKE_SEQUENCE_BITS = 12
+	SNOWFLAKE_MAX_WORKER_ID = 1<<SNOWFLAKE_WORKER_BITS - 1
+	SNOWFLAKE_MAX_SEQUENCE  = 1<<SNOWFLAKE_SEQUENCE_BITS - 1
+	// 时钟回拨在该范围内等待, 超过直接报错
+	SNOWFLAKE_MAX_BACKWARD_MS = 5
+)
+
+// 生成全局唯一 ID
+type Generator interface {
+	Next() (uint64, error)
+}
+
+// 在保存号段的 MySQL 上执行语句
+type Executor interface {
+	Execute(query string, args ...interface{}) (*mysql.Result, error)
+}
+
+// 使用 MySQLPool 作为保存号段的数据库
+type PoolExecutor struct {
+	pool *pool.MySQLPool
+}
+
+func NewPoolExecutor(p *pool.MySQLPool) *PoolExecutor {
+	return &PoolExecutor{pool: p}
+}
+
+func (this *PoolExecutor) Execute(query string, args ...interface{}) (*mysql.Result, error) {
+	conn, err := this.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := conn.Execute(query, args...)
+	this.pool.Report(err)
+	if mysql.ErrorEqual(err, mysql.ErrBadConn) {
+		conn.Close()
+	} else {
+		this.pool.Release(conn)
+	}
+
+	return r, err
+}
+
+// 号段模式. 每次从 MySQL 中申请 step 个 ID 在内存中分配, 用完再申请.
+// 号段表:
+//
+//	CREATE TABLE dal_sequence (
+//	    name    VARCHAR(128) NOT NULL PRIMARY KEY,
+//	    next_id BIGINT UNSIGNED NOT NULL,
+//	    step    INT NOT NULL
+//	)
+//
+// 通过 UPDATE ... SET next_id = LAST_INSERT_ID(next_id + ?) 原子的申请号段, 号段大小使用配置的 step
+// (表中的 step 只记录初始化时的配置), 新的 next_id 在 OK 包的 InsertId 中返回, 不需要事务.
+type SegmentGenerator struct {
+	sync.Mutex
+	executor Executor
+	table    string
+	name     string
+	step     int64
+
+	current uint64 // 下一个分配的 ID
+	max     uint64 // 当前号段的最大值(不包含)
+}
+
+func NewSegmentGenerator(executor Executor, table string, name string, step int64) *SegmentGenerator {
+	if len(table) == 0 {
+		table = SEQUENCE_DEFAULT_TABLE
+	}
+	if step <= 0 {
+		step = SEQUENCE_DEFAULT_STEP
+	}
+	return &SegmentGenerator{executor: executor, table: table, name: name, step: step}
+}
+
+func (this *SegmentGenerator) Next() (uint64, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.current >= this.max {
+		if err := this.allocate(); err != nil {
+			return 0, err
+		}
+	}
+
+	id := this.current
+	this.current++
+	return id, nil
+}
+
+// 申请新的号段, 需要在获取 mutex lock 后使用
+func (this *SegmentGenerator) allocate() error {
+	update := fmt.Sprintf("UPDATE %s SET next_id = LAST_INSERT_ID(next_id + ?) WHERE name = ?", this.table)
+	for i := 0; i < 2; i++ {
+		r, err := this.executor.Execute(update, this.step, this.name)
+		if err != nil {
+			return fmt.Errorf("申请号段 %s 出错. %s", this.name, err.Error())
+		}
+		if r.AffectedRows > 0 {
+			this.max = r.InsertId
+			this.current = r.InsertId - uint64(this.step)
+			return nil
+		}
+
+		// 号段不存在, 从 1 开始
+		insert := fmt.Sprintf("INSERT IGNORE INTO %s(name, next_id, step) VALUES(?, 1, ?)", this.table)
+		if _, err = this.executor.Execute(insert, this.name, this.step); err != nil {
+			return fmt.Errorf("初始化号段 %s 出错. %s", this.name, err.Error())
+		}
+	}
+
+	return fmt.Errorf("申请号段 %s 失败, 号段表中没有该记录", this.name)
+}
+
+// snowflake 模式, 不依赖数据库, 生成的 ID 趋势递增.
+// 不同的 dal 实例需要配置不同的 worker id.
+type SnowflakeGenerator struct {
+	sync.Mutex
+	workerID int64
+	lastMS   int64
+	sequence int64
+	now      func() int64 // 当前毫秒时间戳, 方便测试
+}
+
+func NewSnowflakeGenerator(workerID int64) (*SnowflakeGenerator, error) {
+	if workerID < 0 || workerID > SNOWFLAKE_MAX_WORKER_ID {
+		return nil, fmt.Errorf("snowflake worker id 需要在 0~%d 之间, 当前: %d", SNOWFLAKE_MAX_WORKER_ID, workerID)
+	}
+	return &SnowflakeGenerator{
+		workerID: workerID,
+		now:      func() int64 { return time.Now().UnixNano() / int64(time.Millisecond) },
+	}, nil
+}
+
+func (this *SnowflakeGenerator) Next() (uint64, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	ms := this.now()
+	if ms < this.lastMS {
+		// 时钟回拨
+		if this.lastMS-ms > SNOWFLAKE_MAX_BACKWARD_MS {
+			return 0, fmt.Errorf("时钟回拨了 %dms, 无法生成 snowflake id", this.lastMS-ms)
+		}
+		for ms < this.lastMS {
+			time.Sleep(time.Millisecond)
+			ms = this.now()
+		}
+	}
+
+	if ms == this.lastMS {
+		this.sequence = (this.sequence + 1) & SNOWFLAKE_MAX_SEQUENCE
+		if this.sequence == 0 { // 当前毫秒的序号用完了
+			for ms <= this.lastMS {
+				time.Sleep(100 * time.Microsecond)
+				ms = this.now()
+			}
+		}
+	} else {
+		this.sequence = 0
+	}
+	this.lastMS = ms
+
+	id := (ms-SNOWFLAKE_EPOCH_MS)<<(SNOWFLAKE_WORKER_BITS+SNOWFLAKE_SEQUENCE_BITS) |
+		this.workerID<<SNOWFLAKE_SEQUENCE_BITS | this.sequence
+	return uint64(id), nil
+}
@@ -0,0 +1,168 @@
+package sequence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+	"github.com/daiguadaidai/dal/server/sqlutil"
+)
+
+const (
+	SEQUENCE_MODE_SEGMENT   = "segment"
+	SEQUENCE_MODE_SNOWFLAKE = "snowflake"
+)
+
+// 全局序列配置
+// [sequence]
+// segment_table = "dal_sequence"
+// worker_id = 1
+// [[sequence.columns]]
+// table = "db1.t_order"
+// column = "id"
+// mode = "segment"
+// step = 1000
+type Config struct {
+	SegmentTable string         `toml:"segment_table"` // 号段表, 可以带库名
+	WorkerID     int64          `toml:"worker_id"`     // snowflake 模式的 worker id, 不同 dal 实例需要不同
+	Columns      []ColumnConfig `toml:"columns"`
+}
+
+// 需要自动填充的列
+type ColumnConfig struct {
+	Table  string `toml:"table"`  // db.table
+	Column string `toml:"column"` // 需要填充的列
+	Mode   string `toml:"mode"`   // segment 或 snowflake, 默认 segment
+	Name   string `toml:"name"`   // 号段名称, 默认为 table. 多个表可以共享一个号段
+	Step   int64  `toml:"step"`   // 每次申请的号段大小
+}
+
+type columnSequence struct {
+	column    string
+	generator Generator
+}
+
+// 管理所有表的序列, INSERT 语句没有指定配置的列时自动填充
+type Manager struct {
+	columns map[string]*columnSequence // db.table -> 序列
+}
+
+// executor 为保存号段表的数据库, 只使用 snowflake 模式可以为 nil
+func NewManager(cfg Config, executor Executor) (*Manager, error) {
+	m := &Manager{columns: make(map[string]*columnSequence)}
+
+	var snowflake *SnowflakeGenerator
+	segments := make(map[string]*SegmentGenerator)
+	for _, c := range cfg.Columns {
+		table := strings.ToLower(strings.TrimSpace(c.Table))
+		if strings.IndexByte(table, '.') <= 0 || len(c.Column) == 0 {
+			return nil, fmt.Errorf("序列配置错误, table 需要是 db.table 格式, column 不能为空. table: %s, column: %s",
+				c.Table, c.Column)
+		}
+
+		var generator Generator
+		switch strings.ToLower(c.Mode) {
+		case "", SEQUENCE_MODE_SEGMENT:
+			if executor == nil {
+				return nil, fmt.Errorf("表 %s 使用号段模式, 需要指定号段表所在的数据库", c.Table)
+			}
+			name := c.Name
+			if len(name) == 0 {
+				name = table
+			}
+			segment, ok := segments[name]
+			if !ok {
+				segment = NewSegmentGenerator(executor, cfg.SegmentTable, name, c.Step)
+				segments[name] = segment
+			}
+			generator = segment
+		case SEQUENCE_MODE_SNOWFLAKE:
+			if snowflake == nil {
+				var err error
+				if snowflake, err = NewSnowflakeGenerator(cfg.WorkerID); err != nil {
+					return nil, err
+				}
+			}
+			generator = snowflake
+		default:
+			return nil, fmt.Errorf("表 %s 序列模式 %s 不支持, 只支持 %s 和 %s",
+				c.Table, c.Mode, SEQUENCE_MODE_SEGMENT, SEQUENCE_MODE_SNOWFLAKE)
+		}
+
+		m.columns[table] = &columnSequence{column: c.Column, generator: generator}
+	}
+
+	return m, nil
+}
+
+// INSERT 语句没有指定配置的列时, 填充生成的 ID.
+// 返回改写后的语句和第一行的 ID, 需要作为 OK 包的 InsertId 和 LAST_INSERT_ID() 返回给客户端.
+// 不需要填充时返回原始语句, ID 为 0.
+func (this *Manager) FillInsert(db string, query string) (string, uint64, error) {
+	if len(this.columns) == 0 {
+		return query, 0, nil
+	}
+
+	info, ok := sqlutil.ParseInsert(query)
+	if !ok || info.IsSelect {
+		return query, 0, nil
+	}
+	if len(info.DB) > 0 {
+		db = info.DB
+	}
+	seq, ok := this.columns[strings.ToLower(db)+"."+strings.ToLower(info.Table)]
+	if !ok || info.HasColumn(seq.column) {
+		return query, 0, nil
+	}
+
+	rows := len(info.RowEnds)
+	if info.SetEnd >= 0 {
+		rows = 1
+	} else if info.ColumnsEnd < 0 { // 没有列名列表, 无法添加列
+		rows = 0
+	}
+	if rows == 0 {
+		return query, 0, nil
+	}
+
+	values := make([]string, rows)
+	var firstID uint64
+	for i := range values {
+		id, err := seq.generator.Next()
+		if err != nil {
+			return query, 0, err
+		}
+		if i == 0 {
+			firstID = id
+		}
+		values[i] = strconv.FormatUint(id, 10)
+	}
+
+	newQuery, ok := sqlutil.AddInsertColumn(query, info, seq.column, values)
+	if !ok {
+		return query, 0, nil
+	}
+	return newQuery, firstID, nil
+}
+
+// 是否是查询 LAST_INSERT_ID() 的语句, 需要返回 dal 生成的 ID
+func IsLastInsertIDQuery(query string) bool {
+	fp := sqlutil.Fingerprint(query)
+	return fp == "select last_insert_id()" || strings.HasPrefix(fp, "select last_insert_id() as ")
+}
+
+// 构造 SELECT LAST_INSERT_ID() 的结果
+func LastInsertIDResult(query string, id uint64) (*mysql.Result, error) {
+	name := "LAST_INSERT_ID()"
+	normal := sqlutil.Normalize(query)
+	if idx := strings.Index(strings.ToLower(normal), " as "); idx >= 0 {
+		name = strings.Trim(strings.TrimSpace(normal[idx+4:]), "`'\"")
+	}
+
+	rs, err := mysql.BuildSimpleResultset([]string{name}, [][]interface{}{{id}}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
@@ -0,0 +1,168 @@
+package sequence
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/dal/go-mysql/mysql"
+)
+
+// 模拟号段表
+type fakeExecutor struct {
+	nextIDs map[string]uint64
+	steps   map[string]int64
+	updates int
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{nextIDs: make(map[string]uint64), steps: make(map[string]int64)}
+}
+
+func (this *fakeExecutor) Execute(query string, args ...interface{}) (*mysql.Result, error) {
+	if strings.HasPrefix(query, "UPDATE") {
+		// next_id + ? 使用绑定的 step, next_id + step 使用表中的 step
+		this.updates++
+		name := args[len(args)-1].(string)
+		step := this.steps[name]
+		if strings.Contains(query, "next_id + ?") {
+			step = args[0].(int64)
+		}
+		next, ok := this.nextIDs[name]
+		if !ok {
+			return &mysql.Result{}, nil
+		}
+		next += uint64(step)
+		this.nextIDs[name] = next
+		return &mysql.Result{AffectedRows: 1, InsertId: next}, nil
+	}
+
+	// INSERT IGNORE
+	name := args[0].(string)
+	if _, ok := this.nextIDs[name]; !ok {
+		this.nextIDs[name] = 1
+		this.steps[name] = args[1].(int64)
+	}
+	return &mysql.Result{AffectedRows: 1}, nil
+}
+
+func Test_SegmentGenerator(t *testing.T) {
+	executor := newFakeExecutor()
+	g := NewSegmentGenerator(executor, "", "t", 3)
+
+	for i := uint64(1); i <= 7; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != i {
+			t.Fatalf("第 %d 个 ID 应该是 %d, 实际: %d", i, i, id)
+		}
+	}
+	// 号段 [1,4) [4,7) [7,10)
+	if executor.updates != 4 {
+		t.Fatalf("应该申请3次号段(第一次号段不存在), 实际 UPDATE 了 %d 次", executor.updates)
+	}
+}
+
+func Test_SegmentGenerator_StepMismatch(t *testing.T) {
+	// 表中的 step 和配置的不一样时使用配置的 step
+	executor := newFakeExecutor()
+	executor.nextIDs["t"], executor.steps["t"] = 1, 10
+	g := NewSegmentGenerator(executor, "", "t", 3)
+
+	for i := uint64(1); i <= 7; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != i {
+			t.Fatalf("第 %d 个 ID 应该是 %d, 实际: %d", i, i, id)
+		}
+	}
+	if executor.nextIDs["t"] != 10 {
+		t.Fatalf("申请了3个号段后 next_id 应该是 10, 实际: %d", executor.nextIDs["t"])
+	}
+}
+
+func Test_SnowflakeGenerator(t *testing.T) {
+	if _, err := NewSnowflakeGenerator(SNOWFLAKE_MAX_WORKER_ID + 1); err == nil {
+		t.Fatal("worker id 超出范围应该报错")
+	}
+
+	g, err := NewSnowflakeGenerator(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := int64(SNOWFLAKE_EPOCH_MS + 1000)
+	g.now = func() int64 { return ms }
+
+	var last uint64
+	for i := 0; i < 10; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("ID 应该递增, 上一个: %d, 当前: %d", last, id)
+		}
+		if (id>>SNOWFLAKE_SEQUENCE_BITS)&SNOWFLAKE_MAX_WORKER_ID != 3 {
+			t.Fatalf("ID 中的 worker id 不对: %d", id)
+		}
+		last = id
+	}
+
+	ms -= SNOWFLAKE_MAX_BACKWARD_MS + 1
+	if _, err = g.Next(); err == nil {
+		t.Fatal("时钟回拨超过范围应该报错")
+	}
+}
+
+func Test_Manager_FillInsert(t *testing.T) {
+	cfg := Config{
+		WorkerID: 1,
+		Columns: []ColumnConfig{
+			{Table: "db1.t_order", Column: "id", Step: 100},
+			{Table: "db1.t_log", Column: "log_id", Mode: SEQUENCE_MODE_SNOWFLAKE},
+		},
+	}
+	m, err := NewManager(cfg, newFakeExecutor())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, id, err := m.FillInsert("db1", "insert into t_order(name) values('a'), ('b')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "insert into t_order(name, `id`) values('a', 1), ('b', 2)" || id != 1 {
+		t.Fatalf("填充 ID 不对: %s, id: %d", query, id)
+	}
+
+	query, id, err = m.FillInsert("db2", "insert into db1.t_log set msg = 'x'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == 0 || !strings.Contains(query, "`log_id` = ") {
+		t.Fatalf("snowflake 填充 ID 不对: %s, id: %d", query, id)
+	}
+
+	// 已经指定了列, 或者没有配置的表不填充
+	for _, q := range []string{"insert into t_order(id, name) values(9, 'a')", "insert into t2(name) values('a')"} {
+		if query, id, _ = m.FillInsert("db1", q); query != q || id != 0 {
+			t.Fatalf("不应该填充: %s, id: %d", query, id)
+		}
+	}
+}
+
+func Test_LastInsertID(t *testing.T) {
+	if !IsLastInsertIDQuery("SELECT LAST_INSERT_ID()") || !IsLastInsertIDQuery("select last_insert_id() AS Id") {
+		t.Fatal("应该是查询 LAST_INSERT_ID 的语句")
+	}
+	r, err := LastInsertIDResult("select last_insert_id() AS Id", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Fields[0].Name) != "Id" {
+		t.Fatalf("列名不对: %s", r.Fields[0].Name)
+	}
+}
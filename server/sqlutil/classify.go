@@ -0,0 +1,309 @@
+package sqlutil
+
+import (
+	"strings"
+)
+
+// 语句类型
+type StmtType int
+
+const (
+	STMT_UNKNOWN StmtType = iota
+	STMT_SELECT
+	STMT_INSERT
+	STMT_REPLACE
+	STMT_UPDATE
+	STMT_DELETE
+	STMT_DDL
+	STMT_SHOW
+	STMT_SET
+	STMT_USE
+	STMT_BEGIN
+	STMT_COMMIT
+	STMT_ROLLBACK
+	STMT_OTHER
+)
+
+var stmtTypeNames = map[StmtType]string{
+	STMT_UNKNOWN:  "UNKNOWN",
+	STMT_SELECT:   "SELECT",
+	STMT_INSERT:   "INSERT",
+	STMT_REPLACE:  "REPLACE",
+	STMT_UPDATE:   "UPDATE",
+	STMT_DELETE:   "DELETE",
+	STMT_DDL:      "DDL",
+	STMT_SHOW:     "SHOW",
+	STMT_SET:      "SET",
+	STMT_USE:      "USE",
+	STMT_BEGIN:    "BEGIN",
+	STMT_COMMIT:   "COMMIT",
+	STMT_ROLLBACK: "ROLLBACK",
+	STMT_OTHER:    "OTHER",
+}
+
+func (this StmtType) String() string {
+	if name, ok := stmtTypeNames[this]; ok {
+		return name
+	}
+	return stmtTypeNames[STMT_UNKNOWN]
+}
+
+// 是否是只读语句
+func (this StmtType) IsRead() bool {
+	return this == STMT_SELECT || this == STMT_SHOW
+}
+
+// 是否是修改数据的语句
+func (this StmtType) IsWrite() bool {
+	switch this {
+	case STMT_INSERT, STMT_REPLACE, STMT_UPDATE, STMT_DELETE, STMT_DDL:
+		return true
+	}
+	return false
+}
+
+// 通过主语句的第一个关键字获取语句类型, WITH 语句按照 CTE 后面的语句判断
+func GetStmtType(sql string) StmtType {
+	sql = expandExecutableComments(sql)
+	switch MainKeyword(sql) {
+	case "select", "(", "table":
+		if isSelectForUpdate(sql) {
+			return STMT_OTHER
+		}
+		return STMT_SELECT
+	case "insert":
+		return STMT_INSERT
+	case "replace":
+		return STMT_REPLACE
+	case "update":
+		return STMT_UPDATE
+	case "delete":
+		return STMT_DELETE
+	case "create", "alter", "drop", "truncate", "rename":
+		return STMT_DDL
+	case "show", "desc", "describe", "explain":
+		return STMT_SHOW
+	case "set":
+		return STMT_SET
+	case "use":
+		return STMT_USE
+	case "begin", "start":
+		return STMT_BEGIN
+	case "commit":
+		return STMT_COMMIT
+	case "rollback":
+		return STMT_ROLLBACK
+	case "":
+		return STMT_UNKNOWN
+	}
+	return STMT_OTHER
+}
+
+// 按照分号拆分多语句(CLIENT_MULTI_STATEMENTS), 引号和注释中的分号不拆分, 去掉只有空白和注释的语句.
+// 可执行注释 /*! */ 中的内容当作 SQL, 返回的语句去掉了可执行注释的标记, 只用于检查语句
+func SplitStatements(sql string) []string {
+	sql = expandExecutableComments(sql)
+
+	var stmts []string
+	add := func(stmt string) {
+		if len(skipLeadingComments(stmt)) > 0 {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	n := len(sql)
+	start := 0
+	for i := 0; i < n; i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuote(sql, i, ch)
+		case ch == '#' || (ch == '-' && i+2 < n && sql[i+1] == '-' && isSpace(sql[i+2])):
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < n && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+		case ch == ';':
+			add(sql[start:i])
+			start = i + 1
+		}
+	}
+	if start < n {
+		add(sql[start:])
+	}
+	return stmts
+}
+
+// 获取 SQL 的第一个关键字(小写), 会跳过开头的空白和注释, 可执行注释 /*! */ 中的内容当作 SQL
+func FirstKeyword(sql string) string {
+	sql = skipLeadingComments(expandExecutableComments(sql))
+	if len(sql) == 0 {
+		return ""
+	}
+	if sql[0] == '(' {
+		return "("
+	}
+
+	end := 0
+	for end < len(sql) && isIdentChar(sql[end]) {
+		end++
+	}
+	return strings.ToLower(sql[:end])
+}
+
+// 获取主语句的第一个关键字(小写), WITH 语句返回 CTE 后面的语句的关键字, 例如:
+// WITH c AS (SELECT ...) DELETE FROM t 返回 delete. 解析不了的 WITH 语句返回空
+func MainKeyword(sql string) string {
+	keyword := FirstKeyword(sql)
+	if keyword != "with" {
+		return keyword
+	}
+
+	tokens := tokenize(sql)
+	i := mainStmtStart(tokens)
+	if i >= len(tokens) {
+		return ""
+	}
+	if tokens[i].kind == tokenPunct && tokens[i].value == "(" {
+		return "("
+	}
+	if tokens[i].kind != tokenIdent {
+		return ""
+	}
+	return strings.ToLower(tokens[i].value)
+}
+
+// WITH [RECURSIVE] name [(col, ...)] AS (subquery) [, ...] 后面主语句开始的位置.
+// 不是 WITH 语句返回 0, 解析不了返回 len(tokens)
+func mainStmtStart(tokens []token) int {
+	isKeyword := func(i int, keyword string) bool {
+		return i < len(tokens) && tokens[i].kind == tokenIdent && strings.EqualFold(tokens[i].value, keyword)
+	}
+	isPunct := func(i int, p string) bool {
+		return i < len(tokens) && tokens[i].kind == tokenPunct && tokens[i].value == p
+	}
+	// 跳过括号, 返回 ) 后面的位置
+	skipParens := func(i int) int {
+		depth := 0
+		for ; i < len(tokens); i++ {
+			if isPunct(i, "(") {
+				depth++
+			} else if isPunct(i, ")") {
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(tokens)
+	}
+
+	if !isKeyword(0, "with") {
+		return 0
+	}
+	i := 1
+	if isKeyword(i, "recursive") {
+		i++
+	}
+	for i < len(tokens) {
+		i++ // CTE 名字
+		if isPunct(i, "(") {
+			i = skipParens(i)
+		}
+		if !isKeyword(i, "as") || !isPunct(i+1, "(") {
+			return len(tokens)
+		}
+		i = skipParens(i + 1)
+		if !isPunct(i, ",") {
+			return i
+		}
+		i++
+	}
+	return len(tokens)
+}
+
+// 可执行注释 /*!50700 ... */ 和 /*M! ... */ 中的内容 MySQL 会当作 SQL 执行(版本号比服务端大时除外, 这里都当作 SQL).
+// 把注释的开始(包括版本号)和结束替换成空格, 长度不变, 解析出的位置还可以对应原来的 SQL
+func expandExecutableComments(sql string) string {
+	if !strings.Contains(sql, "/*!") && !strings.Contains(sql, "/*M!") {
+		return sql
+	}
+
+	buf := []byte(sql)
+	n := len(buf)
+	inComment := false
+	for i := 0; i < n; i++ {
+		ch := buf[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuote(sql, i, ch)
+		case ch == '#' || (ch == '-' && i+2 < n && buf[i+1] == '-' && isSpace(buf[i+2])):
+			for i < n && buf[i] != '\n' {
+				i++
+			}
+		case inComment && ch == '*' && i+1 < n && buf[i+1] == '/':
+			buf[i], buf[i+1] = ' ', ' '
+			i++
+			inComment = false
+		case ch == '/' && i+1 < n && buf[i+1] == '*':
+			j := i + 2
+			if j+1 < n && buf[j] == 'M' && buf[j+1] == '!' {
+				j++
+			}
+			if !inComment && j < n && buf[j] == '!' {
+				j++
+				for j < n && isDigit(buf[j]) {
+					j++
+				}
+				for k := i; k < j; k++ {
+					buf[k] = ' '
+				}
+				inComment = true
+				i = j - 1
+				break
+			}
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return string(buf)
+			}
+			i += end + 3
+		}
+	}
+	return string(buf)
+}
+
+// 跳过开头的空白和注释
+func skipLeadingComments(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		switch {
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql, "*/")
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+2:]
+		case strings.HasPrefix(sql, "#"), strings.HasPrefix(sql, "-- "):
+			end := strings.IndexByte(sql, '\n')
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+1:]
+		default:
+			return sql
+		}
+	}
+}
+
+// select ... for update / lock in share mode 需要当作写语句处理
+func isSelectForUpdate(sql string) bool {
+	fp := Fingerprint(sql)
+	return strings.HasSuffix(fp, " for update") || strings.HasSuffix(fp, " lock in share mode") ||
+		strings.HasSuffix(fp, " for share")
+}
@@ -0,0 +1,60 @@
+package sqlutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_GetStmtType(t *testing.T) {
+	cases := []struct {
+		sql      string
+		stmtType StmtType
+	}{
+		{"SELECT * FROM t", STMT_SELECT},
+		{"/* comment */ select 1", STMT_SELECT},
+		{"-- comment\nSHOW TABLES", STMT_SHOW},
+		{"select * from t where id = 1 for update", STMT_OTHER},
+		{"Insert into t values(1)", STMT_INSERT},
+		{"update t set a = 1", STMT_UPDATE},
+		{"ALTER TABLE t ADD COLUMN c INT", STMT_DDL},
+		{"start transaction", STMT_BEGIN},
+		{"", STMT_UNKNOWN},
+		{"/*!50000 DELETE FROM t */", STMT_DELETE},
+		{"/*M!100000 update t set a = 1 */", STMT_UPDATE},
+		{"select * from t /*! for update */", STMT_OTHER},
+		{"WITH c AS (SELECT 1) DELETE FROM t", STMT_DELETE},
+		{"with recursive c(n) as (select 1 union all select n + 1 from c), d as (select 2) update t set a = 1", STMT_UPDATE},
+		{"with c as (select 1) select * from c", STMT_SELECT},
+		{"with c", STMT_UNKNOWN},
+		{"TABLE t ORDER BY id", STMT_SELECT},
+	}
+
+	for _, c := range cases {
+		if stmtType := GetStmtType(c.sql); stmtType != c.stmtType {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.stmtType, stmtType)
+		}
+	}
+}
+
+func Test_SplitStatements(t *testing.T) {
+	cases := []struct {
+		sql   string
+		stmts string
+	}{
+		{"select 1", "select 1"},
+		{"select 1; delete from t;", "select 1|delete from t"},
+		{"select ';' from t -- a;b\n; /* ; */ update t set a = 1; -- end", "select ';' from t -- a;b|/* ; */ update t set a = 1"},
+		{"select 1 /*!; delete from t */", "select 1|delete from t"},
+		{";", ""},
+	}
+
+	for _, c := range cases {
+		var stmts []string
+		for _, stmt := range SplitStatements(c.sql) {
+			stmts = append(stmts, strings.TrimSpace(stmt))
+		}
+		if joined := strings.Join(stmts, "|"); joined != c.stmts {
+			t.Errorf("sql: %s, 期望: %q, 实际: %q", c.sql, c.stmts, joined)
+		}
+	}
+}
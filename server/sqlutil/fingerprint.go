@@ -0,0 +1,196 @@
+package sqlutil
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+)
+
+// 获取 SQL 指纹
+// 1. 去掉注释
+// 2. 字符串, 数字, 16进制值替换成 ?
+// 3. 多个空白合并为一个空格, 关键字转为小写, 去掉结尾的分号
+// 4. IN (?, ?, ?) 和 VALUES (?, ?), (?, ?) 这类列表合并成一个
+// 例如: SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'a'
+// 指纹: select * from t where id in(?+) and name = ?
+func Fingerprint(sql string) string {
+	buf := make([]byte, 0, len(sql))
+	n := len(sql)
+
+	lastIsSpace := true
+	appendSpace := func() {
+		if !lastIsSpace {
+			buf = append(buf, ' ')
+			lastIsSpace = true
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"': // 字符串
+			i = skipQuote(sql, i, ch)
+			buf = append(buf, '?')
+			lastIsSpace = false
+		case ch == '`': // 标识符, 原样保留
+			end := skipQuote(sql, i, ch)
+			buf = append(buf, sql[i:minInt(end+1, n)]...)
+			i = end
+			lastIsSpace = false
+		case ch == '#' || (ch == '-' && i+2 < n && sql[i+1] == '-' && isSpace(sql[i+2])): // 单行注释
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+			appendSpace()
+		case ch == '/' && i+1 < n && sql[i+1] == '*': // 多行注释
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+			appendSpace()
+		case isSpace(ch):
+			appendSpace()
+		case isDigit(ch) && (i == 0 || !isIdentChar(sql[i-1])): // 数字
+			i = skipNumber(sql, i)
+			buf = append(buf, '?')
+			lastIsSpace = false
+		default:
+			if ch >= 'A' && ch <= 'Z' {
+				ch += 'a' - 'A'
+			}
+			buf = append(buf, ch)
+			lastIsSpace = false
+		}
+	}
+
+	fp := strings.TrimRight(strings.TrimSpace(string(buf)), "; ")
+	fp = collapseLists(fp)
+
+	return fp
+}
+
+// 获取 SQL 指纹的 md5 值, 方便作为 key 使用
+func FingerprintID(sql string) string {
+	sum := md5.Sum([]byte(Fingerprint(sql)))
+	return hex.EncodeToString(sum[:])
+}
+
+// 合并 in(?, ?) 和 values(?, ?), (?, ?)
+func collapseLists(fp string) string {
+	var sb strings.Builder
+	sb.Grow(len(fp))
+
+	for len(fp) > 0 {
+		start := strings.Index(fp, "(?")
+		if start < 0 {
+			sb.WriteString(fp)
+			break
+		}
+
+		end := start + 2
+		for end < len(fp) && (fp[end] == '?' || fp[end] == ',' || fp[end] == ' ') {
+			end++
+		}
+		if end >= len(fp) || fp[end] != ')' {
+			sb.WriteString(fp[:start+2])
+			fp = fp[start+2:]
+			continue
+		}
+
+		prefix := strings.TrimRight(fp[:start], " ")
+		if strings.HasSuffix(prefix, " in") || strings.HasSuffix(prefix, " values") {
+			sb.WriteString(prefix)
+			sb.WriteString("(?+)")
+			fp = fp[end+1:]
+			// values 后面的多个 (?,?) 合并掉
+			for {
+				rest := strings.TrimLeft(fp, " ")
+				if !strings.HasPrefix(rest, ",") {
+					break
+				}
+				rest = strings.TrimLeft(rest[1:], " ")
+				if !strings.HasPrefix(rest, "(?") {
+					break
+				}
+				close := strings.IndexByte(rest, ')')
+				if close < 0 || strings.Trim(rest[1:close], "?, ") != "" {
+					break
+				}
+				fp = rest[close+1:]
+			}
+			continue
+		}
+
+		sb.WriteString(fp[:end+1])
+		fp = fp[end+1:]
+	}
+
+	return sb.String()
+}
+
+// 跳过引号中的内容, 返回结束引号的位置
+func skipQuote(sql string, start int, quote byte) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote { // '' 转义
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(sql) - 1
+}
+
+// 跳过数字(包括小数, 科学计数, 16进制), 返回数字最后一个字符的位置
+func skipNumber(sql string, start int) int {
+	i := start
+	if i+1 < len(sql) && sql[i] == '0' && (sql[i+1] == 'x' || sql[i+1] == 'X') {
+		i += 2
+		for i < len(sql) && isHexDigit(sql[i]) {
+			i++
+		}
+		return i - 1
+	}
+
+	for i < len(sql) {
+		ch := sql[i]
+		if isDigit(ch) || ch == '.' {
+			i++
+		} else if (ch == 'e' || ch == 'E') && i+1 < len(sql) &&
+			(isDigit(sql[i+1]) || sql[i+1] == '-' || sql[i+1] == '+') {
+			i += 2
+		} else {
+			break
+		}
+	}
+	return i - 1
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func isIdentChar(ch byte) bool {
+	return isDigit(ch) || ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,29 @@
+package sqlutil
+
+import (
+	"testing"
+)
+
+func Test_Fingerprint(t *testing.T) {
+	cases := []struct {
+		sql string
+		fp  string
+	}{
+		{"SELECT * FROM t WHERE id = 1", "select * from t where id = ?"},
+		{"select  *\n from t where name='a''b' and x = \"c\"", "select * from t where name=? and x = ?"},
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in(?+)"},
+		{"INSERT INTO t1(a, b) VALUES (1, 'a'), (2, 'b')", "insert into t1(a, b) values(?+)"},
+		{"select * from `T1` where c2 = 0x1F /* hint */ -- comment", "select * from `T1` where c2 = ?"},
+		{"select 1.5e10, -3 from dual # end", "select ?, -? from dual"},
+	}
+
+	for _, c := range cases {
+		if fp := Fingerprint(c.sql); fp != c.fp {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.fp, fp)
+		}
+	}
+
+	if FingerprintID("select * from t where id = 1") != FingerprintID("SELECT * FROM t WHERE id = 2") {
+		t.Error("相同指纹的SQL, ID应该相同")
+	}
+}
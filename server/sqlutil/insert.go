@@ -0,0 +1,197 @@
+package sqlutil
+
+import (
+	"sort"
+	"strings"
+)
+
+// INSERT/REPLACE 语句的解析结果, 位置都是在原始 SQL 中的位置
+type InsertInfo struct {
+	DB         string // 语句中指定的库名, 没有指定为空
+	Table      string
+	Columns    []string // 指定的列名, 没有指定为空
+	ColumnsEnd int      // 列名列表结束括号的位置, 没有列名列表为 -1
+	RowEnds    []int    // VALUES 语法中每一行结束括号的位置
+	SetEnd     int      // SET 语法中最后一个赋值结束的位置, 不是 SET 语法为 -1
+	IsSelect   bool     // INSERT ... SELECT 语法
+}
+
+// 是否指定了某一列
+func (this *InsertInfo) HasColumn(column string) bool {
+	for _, c := range this.Columns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析 INSERT/REPLACE 语句, 支持 VALUES, SET, SELECT 三种语法. 不是 INSERT/REPLACE 语句返回 false
+func ParseInsert(sql string) (*InsertInfo, bool) {
+	tokens := tokenize(sql)
+	info := &InsertInfo{ColumnsEnd: -1, SetEnd: -1}
+
+	isKeyword := func(i int, keywords ...string) bool {
+		if i >= len(tokens) || tokens[i].kind != tokenIdent {
+			return false
+		}
+		for _, k := range keywords {
+			if strings.EqualFold(tokens[i].value, k) {
+				return true
+			}
+		}
+		return false
+	}
+	isPunct := func(i int, p string) bool {
+		return i < len(tokens) && tokens[i].kind == tokenPunct && tokens[i].value == p
+	}
+	isIdent := func(i int) bool {
+		return i < len(tokens) && (tokens[i].kind == tokenIdent || tokens[i].kind == tokenQuoted)
+	}
+	// 找到匹配的结束括号
+	matchParen := func(i int) int {
+		depth := 0
+		for ; i < len(tokens); i++ {
+			if isPunct(i, "(") {
+				depth++
+			} else if isPunct(i, ")") {
+				depth--
+				if depth == 0 {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	i := 0
+	if !isKeyword(i, "insert", "replace") {
+		return nil, false
+	}
+	i++
+	for isKeyword(i, "low_priority", "delayed", "high_priority", "ignore") {
+		i++
+	}
+	if isKeyword(i, "into") {
+		i++
+	}
+
+	if !isIdent(i) {
+		return nil, false
+	}
+	info.Table = tokens[i].value
+	i++
+	if isPunct(i, ".") && isIdent(i+1) {
+		info.DB, info.Table = info.Table, tokens[i+1].value
+		i += 2
+	}
+
+	if isKeyword(i, "partition") {
+		if i = matchParen(i + 1); i < 0 {
+			return nil, false
+		}
+		i++
+	}
+
+	// 列名列表, 需要和 INSERT ... (SELECT ...) 区分
+	if isPunct(i, "(") && !isKeyword(i+1, "select", "with") {
+		end := matchParen(i)
+		if end < 0 {
+			return nil, false
+		}
+		for j := i + 1; j < end; j++ {
+			if isIdent(j) {
+				info.Columns = append(info.Columns, tokens[j].value)
+			}
+		}
+		info.ColumnsEnd = tokens[end].start
+		i = end + 1
+	}
+
+	switch {
+	case isKeyword(i, "values", "value"):
+		i++
+		for isPunct(i, "(") {
+			end := matchParen(i)
+			if end < 0 {
+				return nil, false
+			}
+			info.RowEnds = append(info.RowEnds, tokens[end].start)
+			i = end + 1
+			if !isPunct(i, ",") {
+				break
+			}
+			i++
+		}
+	case isKeyword(i, "set"):
+		depth := 0
+		for i++; i < len(tokens); i++ {
+			if isPunct(i, "(") {
+				depth++
+			} else if isPunct(i, ")") {
+				depth--
+			} else if depth == 0 && (isKeyword(i, "on") || isPunct(i, ";")) {
+				break
+			}
+			info.SetEnd = tokens[i].end
+		}
+	case isKeyword(i, "select", "with", "table") || isPunct(i, "("):
+		info.IsSelect = true
+	default:
+		return nil, false
+	}
+
+	return info, true
+}
+
+// 在 INSERT 语句中添加一列, values 为每一行的值(SET 语法只使用第一个值).
+// 语句中已经有该列或者是 INSERT ... SELECT 时返回 false
+func AddInsertColumn(sql string, info *InsertInfo, column string, values []string) (string, bool) {
+	if info.IsSelect || info.HasColumn(column) {
+		return sql, false
+	}
+
+	type insertion struct {
+		pos  int
+		text string
+	}
+	var insertions []insertion
+
+	quoted := "`" + strings.Replace(column, "`", "``", -1) + "`"
+	// 括号中没有内容的不需要加逗号
+	withComma := func(pos int, text string) insertion {
+		if strings.HasSuffix(strings.TrimRight(sql[:pos], " \t\r\n"), "(") {
+			return insertion{pos: pos, text: text}
+		}
+		return insertion{pos: pos, text: ", " + text}
+	}
+
+	if info.SetEnd >= 0 {
+		if len(values) < 1 {
+			return sql, false
+		}
+		insertions = append(insertions, insertion{pos: info.SetEnd, text: ", " + quoted + " = " + values[0]})
+	} else {
+		// VALUES 语法没有列名列表时, 不知道值和列的对应关系
+		if info.ColumnsEnd < 0 || len(values) != len(info.RowEnds) {
+			return sql, false
+		}
+		insertions = append(insertions, withComma(info.ColumnsEnd, quoted))
+		for i, pos := range info.RowEnds {
+			insertions = append(insertions, withComma(pos, values[i]))
+		}
+	}
+
+	sort.Slice(insertions, func(i, j int) bool { return insertions[i].pos < insertions[j].pos })
+
+	var sb strings.Builder
+	last := 0
+	for _, ins := range insertions {
+		sb.WriteString(sql[last:ins.pos])
+		sb.WriteString(ins.text)
+		last = ins.pos
+	}
+	sb.WriteString(sql[last:])
+
+	return sb.String(), true
+}
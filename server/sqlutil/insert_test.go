@@ -0,0 +1,53 @@
+package sqlutil
+
+import (
+	"testing"
+)
+
+func Test_ParseInsert(t *testing.T) {
+	info, ok := ParseInsert("INSERT IGNORE INTO `db1`.t (name, `age`) VALUES ('a)', 1), (f(1, 2), 2) ON DUPLICATE KEY UPDATE age = 1")
+	if !ok {
+		t.Fatal("应该解析成功")
+	}
+	if info.DB != "db1" || info.Table != "t" || len(info.Columns) != 2 || info.Columns[1] != "age" || len(info.RowEnds) != 2 {
+		t.Fatalf("解析结果不对: %+v", info)
+	}
+
+	if info, ok = ParseInsert("insert into t select * from t2"); !ok || !info.IsSelect {
+		t.Fatalf("INSERT ... SELECT 解析结果不对: %+v", info)
+	}
+	if info, ok = ParseInsert("replace t set a = (1), b = 'x' on duplicate key update a = 2"); !ok || info.SetEnd < 0 {
+		t.Fatalf("SET 语法解析结果不对: %+v", info)
+	}
+	if _, ok = ParseInsert("update t set a = 1"); ok {
+		t.Fatal("不是 INSERT 语句")
+	}
+}
+
+func Test_AddInsertColumn(t *testing.T) {
+	cases := []struct {
+		sql    string
+		values []string
+		result string
+		ok     bool
+	}{
+		{"insert into t(name) values('a'), ('b')", []string{"1", "2"},
+			"insert into t(name, `id`) values('a', 1), ('b', 2)", true},
+		{"insert into t() values()", []string{"1"}, "insert into t(`id`) values(1)", true},
+		{"insert into t set name = 'a' on duplicate key update name = 'b'", []string{"1"},
+			"insert into t set name = 'a', `id` = 1 on duplicate key update name = 'b'", true},
+		{"insert into t(id, name) values(5, 'a')", []string{"1"}, "insert into t(id, name) values(5, 'a')", false},
+		{"insert into t values(5, 'a')", []string{"1"}, "insert into t values(5, 'a')", false},
+	}
+
+	for _, c := range cases {
+		info, ok := ParseInsert(c.sql)
+		if !ok {
+			t.Fatalf("sql: %s, 解析失败", c.sql)
+		}
+		result, ok := AddInsertColumn(c.sql, info, "id", c.values)
+		if result != c.result || ok != c.ok {
+			t.Errorf("sql: %s, 期望: %s(%v), 实际: %s(%v)", c.sql, c.result, c.ok, result, ok)
+		}
+	}
+}
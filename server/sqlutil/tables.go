@@ -0,0 +1,543 @@
+package sqlutil
+
+import (
+	"strings"
+)
+
+// 规范化 SQL, 用于作为 key
+// 1. 去掉注释
+// 2. 引号外的多个空白合并为一个空格
+// 3. 去掉首尾空白和结尾的分号
+// 和 Fingerprint 不同, 这里会保留字面值和大小写, 不同参数的语句得到不同的结果
+func Normalize(sql string) string {
+	buf := make([]byte, 0, len(sql))
+	n := len(sql)
+
+	lastIsSpace := true
+	appendSpace := func() {
+		if !lastIsSpace {
+			buf = append(buf, ' ')
+			lastIsSpace = true
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := skipQuote(sql, i, ch)
+			buf = append(buf, sql[i:minInt(end+1, n)]...)
+			i = end
+			lastIsSpace = false
+		case ch == '#' || (ch == '-' && i+2 < n && sql[i+1] == '-' && isSpace(sql[i+2])):
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+			appendSpace()
+		case ch == '/' && i+1 < n && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+			appendSpace()
+		case isSpace(ch):
+			appendSpace()
+		default:
+			buf = append(buf, ch)
+			lastIsSpace = false
+		}
+	}
+
+	return strings.TrimRight(strings.TrimSpace(string(buf)), "; ")
+}
+
+// SQL 中是否有指定的注释 hint, 例如: select /*+ dal_cache */ * from t
+// hint 不区分大小写
+func HasHint(sql string, hint string) bool {
+	hint = strings.ToLower(hint)
+	n := len(sql)
+	for i := 0; i < n; i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuote(sql, i, ch)
+		case ch == '/' && i+1 < n && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = n - i - 2
+			}
+			if strings.Contains(strings.ToLower(sql[i+2:i+2+end]), hint) {
+				return true
+			}
+			i += end + 3
+		}
+	}
+	return false
+}
+
+// 词法单元类型
+const (
+	tokenIdent  = iota // 标识符或关键字
+	tokenQuoted        // `xxx` 标识符
+	tokenString        // 字符串
+	tokenNumber
+	tokenPunct // 标点和运算符
+)
+
+type token struct {
+	kind  int
+	value string // 标识符已经去掉了反引号
+	start int    // 在 SQL 中的开始位置
+	end   int    // 在 SQL 中的结束位置(不包含)
+}
+
+// 简单的词法分析, 跳过注释和空白, 可执行注释 /*! */ 中的内容当作 SQL
+func tokenize(sql string) []token {
+	sql = expandExecutableComments(sql)
+	var tokens []token
+	n := len(sql)
+	for i := 0; i < n; i++ {
+		ch := sql[i]
+		switch {
+		case isSpace(ch):
+		case ch == '#' || (ch == '-' && i+2 < n && sql[i+1] == '-' && isSpace(sql[i+2])):
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < n && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+		case ch == '`':
+			end := skipQuote(sql, i, ch)
+			tokens = append(tokens, token{kind: tokenQuoted, value: strings.Replace(sql[i+1:minInt(end, n)], "``", "`", -1),
+				start: i, end: minInt(end+1, n)})
+			i = end
+		case ch == '\'' || ch == '"':
+			end := skipQuote(sql, i, ch)
+			tokens = append(tokens, token{kind: tokenString, value: sql[i:minInt(end+1, n)], start: i, end: minInt(end+1, n)})
+			i = end
+		case isDigit(ch):
+			end := skipNumber(sql, i)
+			tokens = append(tokens, token{kind: tokenNumber, value: sql[i : end+1], start: i, end: end + 1})
+			i = end
+		case isIdentChar(ch):
+			end := i
+			for end < n && isIdentChar(sql[end]) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: sql[i:end], start: i, end: end})
+			i = end - 1
+		default:
+			tokens = append(tokens, token{kind: tokenPunct, value: sql[i : i+1], start: i, end: i + 1})
+		}
+	}
+	return tokens
+}
+
+// 不能作为表名的关键字(没有反引号时)
+var tableRefStopWords = map[string]bool{
+	"where": true, "group": true, "order": true, "limit": true, "having": true,
+	"join": true, "left": true, "right": true, "inner": true, "outer": true, "cross": true,
+	"natural": true, "straight_join": true, "on": true, "using": true, "union": true,
+	"for": true, "lock": true, "set": true, "values": true, "value": true, "select": true,
+	"partition": true, "force": true, "use": true, "ignore": true, "window": true,
+	"into": true, "as": true, "from": true, "procedure": true, "duplicate": true,
+	"table": true, "to": true,
+}
+
+// 表引用列表中, 表后面出现这些关键字说明表引用列表结束了
+var tableListEndWords = []string{
+	"where", "group", "order", "limit", "having", "window", "union", "except", "intersect",
+	"for", "lock", "into", "set", "select", "values", "value", "procedure", "returning", "duplicate", "from",
+}
+
+// 表引用的解析状态
+const (
+	tableRefNone   = iota // 不在表引用中
+	tableRefJoin          // FROM/UPDATE/USING 后面逗号分隔或者 JOIN 的表, 表后面可以有别名, PARTITION, 索引提示和 ON/USING 条件
+	tableRefNames         // TABLE 后面逗号分隔的表名
+	tableRefSingle        // INTO/HANDLER 后面的一个表
+)
+
+// 每一层括号的解析状态
+type tableRefFrame struct {
+	mode   int
+	expect bool // 下一个 token 应该是表
+	factor bool // 括号本身是一个表引用, 例如 (SELECT ...) AS x 和 (t1 JOIN t2)
+}
+
+// 获取 SQL 中使用的所有表, 返回 db.table 格式(小写), 没有指定库名的使用 defaultDB.
+// 只是基于关键字(FROM, JOIN, UPDATE, INTO, TABLE, USING, HANDLER)的简单解析, 子查询中的表也会被找出来.
+// 可能会多找出一些表. 有解析不了的表引用时返回的表可能不全, 需要完整的表时使用 ExtractTablesStrict
+func ExtractTables(sql string, defaultDB string) []string {
+	tables, _ := extractTables(tokenize(sql), defaultDB)
+	return tables
+}
+
+// 和 ExtractTables 一样, ok 为 false 说明有解析不了的表引用, 返回的表可能不全,
+// 权限检查和缓存需要按照解析失败处理
+func ExtractTablesStrict(sql string, defaultDB string) (tables []string, ok bool) {
+	return extractTables(tokenize(sql), defaultDB)
+}
+
+func extractTables(tokens []token, defaultDB string) ([]string, bool) {
+	defaultDB = strings.ToLower(defaultDB)
+
+	seen := make(map[string]bool)
+	var tables []string
+	addTable := func(db, table string) {
+		if len(db) == 0 {
+			db = defaultDB
+		}
+		name := strings.ToLower(db) + "." + strings.ToLower(table)
+		if table == "" || strings.EqualFold(table, "dual") || seen[name] {
+			return
+		}
+		seen[name] = true
+		tables = append(tables, name)
+	}
+
+	isIdent := func(i int) bool {
+		return i < len(tokens) && (tokens[i].kind == tokenIdent || tokens[i].kind == tokenQuoted)
+	}
+	isPunct := func(i int, p string) bool {
+		return i >= 0 && i < len(tokens) && tokens[i].kind == tokenPunct && tokens[i].value == p
+	}
+	isKeyword := func(i int, keywords ...string) bool {
+		if i < 0 || i >= len(tokens) || tokens[i].kind != tokenIdent {
+			return false
+		}
+		for _, k := range keywords {
+			if strings.EqualFold(tokens[i].value, k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	stack := []*tableRefFrame{{}}
+	for i := 0; i < len(tokens); i++ {
+		f := stack[len(stack)-1]
+
+		if isPunct(i, "(") {
+			// 需要表的位置出现括号是派生表或者括号中的 JOIN, 其他的是表达式, 函数参数, PARTITION (p0), 索引提示等
+			factor := f.expect && f.mode == tableRefJoin
+			f.expect = false
+			if f.mode == tableRefSingle {
+				f.mode = tableRefNone
+			}
+			if factor {
+				stack = append(stack, &tableRefFrame{mode: tableRefJoin, expect: true, factor: true})
+			} else {
+				stack = append(stack, &tableRefFrame{})
+			}
+			continue
+		}
+		if isPunct(i, ")") {
+			if len(stack) == 1 || (f.expect && f.mode != tableRefSingle) {
+				return tables, false
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if f.expect {
+			switch {
+			case f.factor && isPunct(i-1, "(") && isKeyword(i, "select", "with", "values", "table"):
+				// 括号中是子查询, 按照普通的语句解析
+				f.mode, f.expect = tableRefNone, false
+			case isKeyword(i, "use", "force", "ignore") && isKeyword(i+1, "index", "key"):
+				// 逗号分隔的多个索引提示, 属于前一个表
+				f.expect = false
+				continue
+			case isKeyword(i, "lateral", "low_priority", "ignore", "if", "not", "exists") ||
+				(f.mode == tableRefSingle && isKeyword(i, "table")):
+				continue
+			case f.mode == tableRefJoin && isKeyword(i, "json_table") && isPunct(i+1, "("):
+				// 表函数, 参数中的子查询按照普通的语句解析
+				f.expect = false
+				continue
+			case f.mode == tableRefSingle && (isKeyword(i, "outfile", "dumpfile") || !isIdent(i)):
+				// SELECT ... INTO @var/OUTFILE/DUMPFILE
+				f.mode, f.expect = tableRefNone, false
+				continue
+			case isIdent(i):
+				if tokens[i].kind == tokenIdent && tableRefStopWords[strings.ToLower(tokens[i].value)] {
+					return tables, false
+				}
+				db, table := "", tokens[i].value
+				if isPunct(i+1, ".") && isIdent(i+2) {
+					db, table = table, tokens[i+2].value
+					i += 2
+				}
+				addTable(db, table)
+				f.expect = false
+				if f.mode == tableRefSingle {
+					f.mode = tableRefNone
+				}
+				continue
+			case isPunct(i, ",") || isPunct(i, ";"):
+				return tables, false
+			default:
+				// 字符串或者数字, 例如 SUBSTRING(s FROM 2), REVOKE ... FROM 'u'@'%', 不是表
+				f.mode, f.expect = tableRefNone, false
+				continue
+			}
+		}
+
+		// 表后面的内容
+		switch f.mode {
+		case tableRefJoin:
+			switch {
+			case isPunct(i, ","), isKeyword(i, "join", "straight_join"):
+				f.expect = true
+				continue
+			case isKeyword(i, "for") && isKeyword(i+1, "join"):
+				// 索引提示 USE INDEX FOR JOIN (...)
+				i++
+				continue
+			case isKeyword(i, "for") && isKeyword(i+1, "order", "group"):
+				// 索引提示 USE INDEX FOR ORDER BY (...)
+				i += 2
+				continue
+			case isKeyword(i, "using") && !isPunct(i+1, "("):
+				// DELETE FROM t1 USING t1, t2
+				f.expect = true
+				continue
+			case isKeyword(i, tableListEndWords...) || isPunct(i, ";"):
+				f.mode = tableRefNone
+			default:
+				// 别名, PARTITION, 索引提示, ON 条件
+				continue
+			}
+		case tableRefNames:
+			if isPunct(i, ",") {
+				f.expect = true
+				continue
+			}
+			f.mode = tableRefNone
+		}
+
+		switch {
+		case isKeyword(i, "from"), isKeyword(i, "join"):
+			f.mode, f.expect = tableRefJoin, true
+		case isKeyword(i, "update") && !isKeyword(i-1, "for", "key") &&
+			!isKeyword(i+1, "on") && !isPunct(i+1, ",") && !isPunct(i+1, "("):
+			// 不包括 FOR UPDATE, ON DUPLICATE KEY UPDATE 和 GRANT UPDATE ON
+			f.mode, f.expect = tableRefJoin, true
+		case isKeyword(i, "table"):
+			f.mode, f.expect = tableRefNames, true
+		case isKeyword(i, "into"), i == 0 && isKeyword(i, "handler"):
+			f.mode, f.expect = tableRefSingle, true
+		}
+	}
+
+	if f := stack[len(stack)-1]; len(stack) != 1 || (f.expect && f.mode != tableRefSingle) {
+		return tables, false
+	}
+	return tables, true
+}
+
+// 获取写语句(INSERT/REPLACE/UPDATE/DELETE/LOAD DATA/DDL)修改的表, 返回 db.table 格式(小写).
+// 库级别的 DDL(CREATE/ALTER/DROP DATABASE) 和找不到表的 DDL(例如 CREATE VIEW) 返回 db.*.
+// 多表 UPDATE/DELETE 返回 SET/WHERE 之前的所有表, 可能会多出只读的表. 不是写语句返回空.
+// WITH 语句按照 CTE 后面的主语句处理.
+func ExtractTargetTables(sql string, defaultDB string) []string {
+	tokens := tokenize(sql)
+	tokens = tokens[mainStmtStart(tokens):]
+	if len(tokens) == 0 || tokens[0].kind != tokenIdent {
+		return nil
+	}
+
+	isKeyword := func(i int, keywords ...string) bool {
+		if i >= len(tokens) || tokens[i].kind != tokenIdent {
+			return false
+		}
+		for _, k := range keywords {
+			if strings.EqualFold(tokens[i].value, k) {
+				return true
+			}
+		}
+		return false
+	}
+	// 从 start 开始第一个不在括号中的关键字的位置
+	clauseEnd := func(start int, keywords ...string) int {
+		depth := 0
+		for i := start; i < len(tokens); i++ {
+			if tokens[i].kind == tokenPunct && tokens[i].value == "(" {
+				depth++
+			} else if tokens[i].kind == tokenPunct && tokens[i].value == ")" {
+				depth--
+			} else if depth == 0 && isKeyword(i, keywords...) {
+				return i
+			}
+		}
+		return len(tokens)
+	}
+	// tokens[start:end] 中逗号分隔或者 JOIN 的表
+	tablesIn := func(start int, end int) []string {
+		if start >= end {
+			return nil
+		}
+		clause := append([]token{{kind: tokenIdent, value: "from"}}, tokens[start:end]...)
+		tables, _ := extractTables(clause, defaultDB)
+		return tables
+	}
+	dbLevel := func(db string) []string {
+		if len(db) == 0 {
+			db = defaultDB
+		}
+		return []string{strings.ToLower(db) + ".*"}
+	}
+	// 跳过修饰关键字
+	skip := func(i int, keywords ...string) int {
+		for isKeyword(i, keywords...) {
+			i++
+		}
+		return i
+	}
+
+	switch strings.ToLower(tokens[0].value) {
+	case "insert", "replace":
+		i := skip(1, "low_priority", "delayed", "high_priority", "ignore", "into")
+		return tablesIn(i, clauseEnd(i, "partition", "values", "value", "set", "select", "with", "table"))
+	case "update":
+		i := skip(1, "low_priority", "ignore")
+		return tablesIn(i, clauseEnd(i, "set"))
+	case "delete":
+		i := clauseEnd(0, "from")
+		if i == len(tokens) {
+			return nil
+		}
+		return tablesIn(i+1, clauseEnd(i+1, "using", "where", "order", "limit"))
+	case "load":
+		i := clauseEnd(0, "into")
+		if isKeyword(i+1, "table") {
+			i++
+		}
+		return tablesIn(i+1, clauseEnd(i+1, "partition", "character", "fields", "columns", "lines", "ignore", "set"))
+	case "truncate":
+		i := skip(1, "table")
+		return tablesIn(i, len(tokens))
+	case "rename":
+		// RENAME TABLE a TO b, c TO d
+		var tables []string
+		start := skip(1, "table")
+		for i := start; i <= len(tokens); i++ {
+			if i == len(tokens) || isKeyword(i, "to") || (tokens[i].kind == tokenPunct && tokens[i].value == ",") {
+				tables = append(tables, tablesIn(start, i)...)
+				start = i + 1
+			}
+		}
+		return tables
+	case "create", "alter", "drop":
+		i := skip(1, "or", "replace", "temporary", "online", "offline", "ignore", "unique", "fulltext", "spatial")
+		if isKeyword(i, "database", "schema") {
+			i = skip(i+1, "if", "not", "exists")
+			if i < len(tokens) && (tokens[i].kind == tokenIdent || tokens[i].kind == tokenQuoted) {
+				return dbLevel(tokens[i].value)
+			}
+			return dbLevel("")
+		}
+		if isKeyword(i, "table") {
+			i = skip(i+1, "if", "not", "exists")
+			tables := tablesIn(i, clauseEnd(i, "like", "as", "select", "add", "drop", "modify", "change",
+				"rename", "engine", "partition", "default", "charset", "comment"))
+			// ALTER TABLE t RENAME [TO|AS] t2 也需要新表的权限
+			if rename := clauseEnd(i, "rename"); rename < len(tokens) && !isKeyword(rename+1, "column", "index", "key") {
+				j := skip(rename+1, "to", "as")
+				tables = append(tables, tablesIn(j, j+1+skipDotted(tokens, j))...)
+			}
+			return tables
+		}
+		if isKeyword(i, "index") {
+			// CREATE INDEX idx ON t(...), DROP INDEX idx ON t
+			on := clauseEnd(i, "on")
+			if on < len(tokens) {
+				return tablesIn(on+1, on+2+skipDotted(tokens, on+1))
+			}
+		}
+		return dbLevel("")
+	}
+	return nil
+}
+
+// 获取 SHOW/DESC/EXPLAIN 语句查看的表, 返回 db.table 格式(小写), 其他的 SHOW 语句返回空:
+//   - SHOW CREATE TABLE/VIEW t
+//   - SHOW [FULL] COLUMNS/FIELDS/INDEX/INDEXES/KEYS FROM|IN t [FROM|IN db]
+//   - DESC/DESCRIBE/EXPLAIN t
+//   - EXPLAIN 其他语句: 语句中使用的所有表
+func ExtractShowTables(sql string, defaultDB string) []string {
+	tokens := tokenize(sql)
+
+	isKeyword := func(i int, keywords ...string) bool {
+		if i >= len(tokens) || tokens[i].kind != tokenIdent {
+			return false
+		}
+		for _, k := range keywords {
+			if strings.EqualFold(tokens[i].value, k) {
+				return true
+			}
+		}
+		return false
+	}
+	isName := func(i int) bool {
+		return i < len(tokens) && (tokens[i].kind == tokenIdent || tokens[i].kind == tokenQuoted)
+	}
+	tableAt := func(i int, db string) []string {
+		if !isName(i) {
+			return nil
+		}
+		table := tokens[i].value
+		if i+2 < len(tokens) && tokens[i+1].kind == tokenPunct && tokens[i+1].value == "." && isName(i+2) {
+			db, table = table, tokens[i+2].value
+		}
+		if len(db) == 0 {
+			db = defaultDB
+		}
+		return []string{strings.ToLower(db) + "." + strings.ToLower(table)}
+	}
+
+	switch {
+	case isKeyword(0, "show"):
+		i := 1
+		if isKeyword(i, "create") && isKeyword(i+1, "table", "view") {
+			return tableAt(i+2, "")
+		}
+		for isKeyword(i, "full", "extended") {
+			i++
+		}
+		if !isKeyword(i, "columns", "fields", "index", "indexes", "keys") || !isKeyword(i+1, "from", "in") {
+			return nil
+		}
+		i += 2
+		db := ""
+		if next := i + 1 + skipDotted(tokens, i); isKeyword(next, "from", "in") && isName(next+1) {
+			db = tokens[next+1].value
+		}
+		return tableAt(i, db)
+	case isKeyword(0, "desc", "describe", "explain"):
+		// DESC t [col], 其他的是 EXPLAIN [FORMAT = xx] 语句
+		if isName(1) && !isKeyword(1, "select", "insert", "replace", "update", "delete", "with", "table",
+			"format", "analyze", "extended", "partitions", "for") {
+			return tableAt(1, "")
+		}
+		tables, _ := extractTables(tokens, defaultDB)
+		return tables
+	}
+	return nil
+}
+
+// db.table 占用的 token 数减 1
+func skipDotted(tokens []token, i int) int {
+	if i+2 < len(tokens) && tokens[i+1].kind == tokenPunct && tokens[i+1].value == "." {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package sqlutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Normalize(t *testing.T) {
+	cases := []struct {
+		sql    string
+		normal string
+	}{
+		{"  SELECT *\n\tFROM t  WHERE name = 'a  b';", "SELECT * FROM t WHERE name = 'a  b'"},
+		{"select /*+ dal_cache */ 1 -- comment\n", "select 1"},
+		{"select `a  b` from t", "select `a  b` from t"},
+	}
+
+	for _, c := range cases {
+		if normal := Normalize(c.sql); normal != c.normal {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.normal, normal)
+		}
+	}
+}
+
+func Test_HasHint(t *testing.T) {
+	if !HasHint("select /*+ DAL_CACHE */ * from t", "dal_cache") {
+		t.Error("应该找到 hint")
+	}
+	if HasHint("select '/* dal_cache */' from t", "dal_cache") {
+		t.Error("字符串中的内容不是 hint")
+	}
+}
+
+func Test_ExtractTables(t *testing.T) {
+	cases := []struct {
+		sql    string
+		tables string
+	}{
+		{"select * from t where id = 1", "db1.t"},
+		{"SELECT a.id FROM `Db2`.`T1` AS a JOIN t2 b ON a.id = b.id", "db2.t1,db1.t2"},
+		{"select * from t1, db3.t2 x, t3 where 1", "db1.t1,db3.t2,db1.t3"},
+		{"select * from (select id from t1) a left join t2 on 1", "db1.t1,db1.t2"},
+		{"select id from t1 where id in (select id from t2)", "db1.t1,db1.t2"},
+		{"insert into t1(a) select a from t2", "db1.t1,db1.t2"},
+		{"update t1 set a = 1", "db1.t1"},
+		{"delete from t1 where a = 'from t9'", "db1.t1"},
+		{"alter table t1 add column c int", "db1.t1"},
+		{"select 1 from dual", ""},
+		{"select now()", ""},
+		{"select * from t1 /*!50000 , t2 */", "db1.t1,db1.t2"},
+		{"select '/*!50000 , t3 */' from t1", "db1.t1"},
+		// PARTITION, 索引提示, STRAIGHT_JOIN, 表函数和派生表后面的表
+		{"select * from db1.t partition (p0), db2.t", "db1.t,db2.t"},
+		{"select * from db1.t force index(i), db2.t", "db1.t,db2.t"},
+		{"select * from t1 use index for order by (i), ignore key for join (j) , db2.t", "db1.t1,db2.t"},
+		{"select * from db1.t straight_join db2.t", "db1.t,db2.t"},
+		{"select * from json_table('[1]', '$[*]' columns (a int path '$')) j, db2.t", "db2.t"},
+		{"select * from (select id from t1) a, db2.t", "db1.t1,db2.t"},
+		{"select * from (t1, t2) join t3 using (id), t4", "db1.t1,db1.t2,db1.t3,db1.t4"},
+		{"select * from t1 join t2 on t1.id = t2.id, t3", "db1.t1,db1.t2,db1.t3"},
+		{"delete from t1 using t1, t2 where t1.id = t2.id", "db1.t1,db1.t2"},
+		{"select a into @a, @b from t1 for update", "db1.t1"},
+		{"insert into t1(a) values (1) on duplicate key update a = 2, b = 3", "db1.t1"},
+		{"handler db2.t open", "db2.t"},
+		{"table db2.t", "db2.t"},
+	}
+
+	for _, c := range cases {
+		if tables := strings.Join(ExtractTables(c.sql, "db1"), ","); tables != c.tables {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.tables, tables)
+		}
+	}
+}
+
+func Test_ExtractTablesStrict(t *testing.T) {
+	cases := []struct {
+		sql string
+		ok  bool
+	}{
+		{"select * from db1.t straight_join db2.t", true},
+		{"select substring(a from 2) from t1", true},
+		{"revoke select on db1.* from 'u'@'%'", true},
+		{"select * from", false},
+		{"select * from t1,", false},
+		{"select * from t1 join where", false},
+		{"select * from (t1", false},
+		{"select * from t1)", false},
+	}
+
+	for _, c := range cases {
+		if _, ok := ExtractTablesStrict(c.sql, "db1"); ok != c.ok {
+			t.Errorf("sql: %s, 期望: %v, 实际: %v", c.sql, c.ok, ok)
+		}
+	}
+}
+
+func Test_ExtractTargetTables(t *testing.T) {
+	cases := []struct {
+		sql    string
+		tables string
+	}{
+		{"insert into t1(a) select a from t2", "db1.t1"},
+		{"INSERT IGNORE INTO db2.t1 VALUES (1)", "db2.t1"},
+		{"replace t1 set a = 1", "db1.t1"},
+		{"update t1 a join t2 b on a.id = b.id set a.x = b.x where b.id in (select id from t3)", "db1.t1,db1.t2"},
+		{"update low_priority t1 set a = (select max(a) from t2)", "db1.t1"},
+		{"delete from t1 where id in (select id from t2)", "db1.t1"},
+		{"delete a from t1 a join t2 b on a.id = b.id where b.x = 1", "db1.t1,db1.t2"},
+		{"delete from t1 using t1 join t2 where t1.id = t2.id", "db1.t1"},
+		{"load data local infile 'a.csv' into table db2.t1 fields terminated by ','", "db2.t1"},
+		{"truncate t1", "db1.t1"},
+		{"truncate table db2.t1", "db2.t1"},
+		{"rename table t1 to t1_old, db2.t2 to t2", "db1.t1,db1.t1_old,db2.t2,db1.t2"},
+		{"create table if not exists t1 (id int)", "db1.t1"},
+		{"create table t1 like db2.t2", "db1.t1"},
+		{"alter table t1 add column c int", "db1.t1"},
+		{"alter table t1 rename to db2.t9", "db1.t1,db2.t9"},
+		{"alter table t1 rename column a to b", "db1.t1"},
+		{"drop table if exists t1, t2", "db1.t1,db1.t2"},
+		{"create database if not exists `Db3`", "db3.*"},
+		{"drop schema db3", "db3.*"},
+		{"create index idx_a on db2.t1(a)", "db2.t1"},
+		{"create view v1 as select * from t1", "db1.*"},
+		{"select * from t1", ""},
+		{"with c as (select id from t2) delete from t1 where id in (select id from c)", "db1.t1"},
+		{"/*!50000 update db2.t1 set a = 1 */", "db2.t1"},
+	}
+
+	for _, c := range cases {
+		if tables := strings.Join(ExtractTargetTables(c.sql, "db1"), ","); tables != c.tables {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.tables, tables)
+		}
+	}
+}
+
+func Test_ExtractShowTables(t *testing.T) {
+	cases := []struct {
+		sql    string
+		tables string
+	}{
+		{"show create table db2.t1", "db2.t1"},
+		{"SHOW CREATE VIEW `v1`", "db1.v1"},
+		{"show full columns from t1 in db2", "db2.t1"},
+		{"show index from t1", "db1.t1"},
+		{"desc t1", "db1.t1"},
+		{"describe db2.t1 a", "db2.t1"},
+		{"explain select * from t1 join t2 on 1", "db1.t1,db1.t2"},
+		{"explain format = json delete from t1", "db1.t1"},
+		{"show tables from db2", ""},
+		{"show variables like 'a%'", ""},
+	}
+
+	for _, c := range cases {
+		if tables := strings.Join(ExtractShowTables(c.sql, "db1"), ","); tables != c.tables {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.tables, tables)
+		}
+	}
+}
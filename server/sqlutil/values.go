@@ -0,0 +1,253 @@
+package sqlutil
+
+import (
+	"strings"
+)
+
+// SQL 中和某一列比较或者赋值的字面值/占位符
+type ColumnValue struct {
+	Qualifier  string // 列名前面的表名或别名, 没有为空
+	Column     string
+	Start      int    // 值在 SQL 中的开始位置
+	End        int    // 值在 SQL 中的结束位置(不包含)
+	Value      string // 字面值, 字符串已经去掉引号和转义
+	IsString   bool   // 字面值是否是字符串
+	IsNull     bool   // 字面值是 NULL
+	Compare    bool   // 是比较条件(c = v, c IN (...)), 不是赋值(INSERT VALUES, SET c = v)
+	ParamIndex int    // 占位符 ? 的序号(从 0 开始), 不是占位符为 -1
+}
+
+// 找出 SQL 中和列相关的字面值和占位符:
+//  1. INSERT INTO t(c1, c2) VALUES(v1, v2), ...
+//  2. c = v, v = c (包括 UPDATE ... SET c = v 和 WHERE c = v)
+//  3. c [NOT] IN (v1, v2, ...)
+//
+// 值只能是单个字符串, 数字, NULL 或者 ?, 表达式会被忽略.
+func FindColumnValues(sql string) []ColumnValue {
+	tokens := tokenize(sql)
+
+	// 占位符的序号
+	params := make(map[int]int)
+	for i, t := range tokens {
+		if t.kind == tokenPunct && t.value == "?" {
+			params[i] = len(params)
+		}
+	}
+
+	isPunct := func(i int, p string) bool {
+		return i >= 0 && i < len(tokens) && tokens[i].kind == tokenPunct && tokens[i].value == p
+	}
+	isIdent := func(i int) bool {
+		return i >= 0 && i < len(tokens) && (tokens[i].kind == tokenIdent || tokens[i].kind == tokenQuoted)
+	}
+	isKeyword := func(i int, k string) bool {
+		return i >= 0 && i < len(tokens) && tokens[i].kind == tokenIdent && strings.EqualFold(tokens[i].value, k)
+	}
+	// 单个值, 后面不能跟着运算符
+	valueAt := func(i int) (ColumnValue, bool) {
+		if i < 0 || i >= len(tokens) {
+			return ColumnValue{}, false
+		}
+		for _, op := range []string{"+", "-", "*", "/", "%", "|", "&", "^", ".", "("} {
+			if isPunct(i+1, op) {
+				return ColumnValue{}, false
+			}
+		}
+		if isPunct(i-1, "-") || isPunct(i-1, "+") || isPunct(i-1, "~") {
+			return ColumnValue{}, false
+		}
+
+		t := tokens[i]
+		v := ColumnValue{Start: t.start, End: t.end, ParamIndex: -1}
+		switch {
+		case t.kind == tokenString:
+			v.IsString = true
+			v.Value = Unquote(t.value)
+		case t.kind == tokenNumber:
+			v.Value = t.value
+		case t.kind == tokenIdent && strings.EqualFold(t.value, "null"):
+			v.IsNull = true
+		case t.kind == tokenPunct && t.value == "?":
+			v.ParamIndex = params[i]
+		default:
+			return ColumnValue{}, false
+		}
+		return v, true
+	}
+	// 以 i 结尾的列名, 返回列名和表名
+	columnAt := func(i int) (string, string, bool) {
+		if !isIdent(i) || (tokens[i].kind == tokenIdent && strings.EqualFold(tokens[i].value, "null")) {
+			return "", "", false
+		}
+		if isPunct(i-1, ".") && isIdent(i-2) {
+			return tokens[i-2].value, tokens[i].value, true
+		}
+		return "", tokens[i].value, true
+	}
+
+	var values []ColumnValue
+
+	// INSERT ... VALUES
+	if info, ok := ParseInsert(sql); ok && len(info.RowEnds) > 0 && len(info.Columns) > 0 {
+		i := 0
+		for i < len(tokens) && tokens[i].start <= info.ColumnsEnd {
+			i++
+		}
+		for i < len(tokens) && !isKeyword(i, "values") && !isKeyword(i, "value") {
+			i++
+		}
+		i++
+		for isPunct(i, "(") {
+			// 每个值只能是一个 token
+			for col := 0; i < len(tokens) && !isPunct(i, ")"); col++ {
+				i++
+				if col < len(info.Columns) && (isPunct(i+1, ",") || isPunct(i+1, ")")) {
+					if v, ok := valueAt(i); ok {
+						v.Column = info.Columns[col]
+						values = append(values, v)
+					}
+				}
+				// 跳到下一个值
+				for depth := 0; i < len(tokens); i++ {
+					if isPunct(i, "(") {
+						depth++
+					} else if isPunct(i, ")") {
+						if depth == 0 {
+							break
+						}
+						depth--
+					} else if isPunct(i, ",") && depth == 0 {
+						break
+					}
+				}
+			}
+			i++
+			if !isPunct(i, ",") {
+				break
+			}
+			i++
+		}
+	}
+
+	inSet := false // 是否在 SET 赋值列表中
+	for i := range tokens {
+		if tokens[i].kind == tokenIdent {
+			switch strings.ToLower(tokens[i].value) {
+			case "set":
+				inSet = true
+			case "update":
+				inSet = isKeyword(i-1, "key") // ON DUPLICATE KEY UPDATE
+			case "where", "having", "on", "from", "select", "join", "group", "order", "limit":
+				inSet = false
+			}
+		}
+
+		switch {
+		case isPunct(i, "="):
+			// 排除 <=, >=, !=, :=, <=>
+			if i > 0 && tokens[i-1].end == tokens[i].start &&
+				(isPunct(i-1, "<") || isPunct(i-1, ">") || isPunct(i-1, "!") || isPunct(i-1, ":")) {
+				continue
+			}
+			if isPunct(i+1, ">") && tokens[i+1].start == tokens[i].end {
+				continue
+			}
+			if qualifier, column, ok := columnAt(i - 1); ok {
+				if v, ok := valueAt(i + 1); ok {
+					v.Qualifier, v.Column, v.Compare = qualifier, column, !inSet
+					values = append(values, v)
+					continue
+				}
+			}
+			if v, ok := valueAt(i - 1); ok {
+				j := i + 1
+				if isPunct(j+1, ".") {
+					j += 2
+				}
+				if qualifier, column, ok := columnAt(j); ok && !isPunct(j+1, ".") && !isPunct(j+1, "(") {
+					v.Qualifier, v.Column, v.Compare = qualifier, column, true
+					values = append(values, v)
+				}
+			}
+		case isKeyword(i, "in") && isPunct(i+1, "("):
+			j := i - 1
+			if isKeyword(j, "not") {
+				j--
+			}
+			qualifier, column, ok := columnAt(j)
+			if !ok {
+				continue
+			}
+			var list []ColumnValue
+			k := i + 2
+			for ; k < len(tokens); k += 2 {
+				v, ok := valueAt(k)
+				if !ok {
+					list = nil
+					break
+				}
+				v.Qualifier, v.Column, v.Compare = qualifier, column, true
+				list = append(list, v)
+				if isPunct(k+1, ")") {
+					break
+				}
+				if !isPunct(k+1, ",") {
+					list = nil
+					break
+				}
+			}
+			values = append(values, list...)
+		}
+	}
+
+	return values
+}
+
+// 去掉字符串字面值的引号和转义
+func Unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	quote := s[0]
+	if (quote != '\'' && quote != '"') || s[len(s)-1] != quote {
+		return s
+	}
+	s = s[1 : len(s)-1]
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == quote && i+1 < len(s) && s[i+1] == quote {
+			sb.WriteByte(quote)
+			i++
+			continue
+		}
+		if ch != '\\' || i+1 >= len(s) {
+			sb.WriteByte(ch)
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case '0':
+			sb.WriteByte(0)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'Z':
+			sb.WriteByte(26)
+		case '%', '_': // LIKE 中的转义保留反斜杠
+			sb.WriteByte('\\')
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,66 @@
+package sqlutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_FindColumnValues(t *testing.T) {
+	cases := []struct {
+		sql    string
+		values string
+	}{
+		{"insert into t(name, age, phone) values('a', 1 + 1, ?), ('b''c', 2, '138')",
+			"name='a',phone=?0,name='b'c',age=2,phone='138'"},
+		{"update t set phone = ?, name = 'x' where u.phone = '138' and age >= 3",
+			"phone=?0,name='x',u.phone=='138'"},
+		{"select * from t where phone in ('a', 'b') and name not in (?) and id in (select id from t2)",
+			"phone=='a',phone=='b',name==?0"},
+		{"select * from t where 'a' = phone and name = concat('a', 'b') and age <=> 1",
+			"phone=='a'"},
+		{"insert into t(name) values('a') on duplicate key update name = 'b'",
+			"name='a',name='b'"},
+	}
+
+	for _, c := range cases {
+		var parts []string
+		for _, v := range FindColumnValues(c.sql) {
+			column := v.Column
+			if len(v.Qualifier) > 0 {
+				column = v.Qualifier + "." + column
+			}
+			op := "="
+			if v.Compare {
+				op = "=="
+			}
+			value := v.Value
+			if v.ParamIndex >= 0 {
+				value = fmt.Sprintf("?%d", v.ParamIndex)
+			} else if v.IsString {
+				value = "'" + value + "'"
+			}
+			if v.ParamIndex >= 0 && c.sql[v.Start:v.End] != "?" {
+				t.Fatalf("sql: %s, 占位符位置不对: %d~%d", c.sql, v.Start, v.End)
+			}
+			parts = append(parts, column+op+value)
+		}
+		if values := strings.Join(parts, ","); values != c.values {
+			t.Errorf("sql: %s, 期望: %s, 实际: %s", c.sql, c.values, values)
+		}
+	}
+}
+
+func Test_Unquote(t *testing.T) {
+	cases := map[string]string{
+		`'a''b'`:   "a'b",
+		`"a\"b\n"`: "a\"b\n",
+		`'a\%'`:    `a\%`,
+		`'\\'`:     `\`,
+	}
+	for s, expect := range cases {
+		if v := Unquote(s); v != expect {
+			t.Errorf("%s, 期望: %q, 实际: %q", s, expect, v)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/dal/mysqldb/cluster"
+	"github.com/daiguadaidai/dal/mysqldb/ha"
+)
+
+// 集群切换请求
+// POST /cluster/switchover
+//
+//	{
+//	    "cluster": "order",
+//	    "master": "10.0.1.1:3306",
+//	    "slaves": ["10.0.1.2:3306"],
+//	    "wait_gtid": true,
+//	    "drain_timeout_ms": 3000,
+//	    "gtid_timeout_ms": 10000
+//	}
+type SwitchoverRequest struct {
+	Cluster string   `json:"cluster"`
+	Master  string   `json:"master"`
+	Slaves  []string `json:"slaves"`
+	cluster.SwitchoverConfig
+}
+
+// 计划内主从切换请求, 旧 master 设置只读后提升 slave, master 为空时选择数据最新的 slave
+// POST /cluster/planned_switchover
+//
+//	{
+//	    "cluster": "order",
+//	    "master": "10.0.0.2:3306"
+//	}
+type PlannedSwitchoverRequest struct {
+	Cluster string `json:"cluster"`
+	Master  string `json:"master"`
+}
+
+type SwitchoverResponse struct {
+	Cluster   string `json:"cluster"`
+	Topology  string `json:"topology,omitempty"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// 注册集群管理的接口
+func RegisterClusterHandlers(mux *http.ServeMux, m *cluster.Manager) {
+	mux.HandleFunc("/cluster/switchover", switchoverHandler(m))
+}
+
+func switchoverHandler(m *cluster.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := new(SwitchoverRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeJSON(w, http.StatusBadRequest, &SwitchoverResponse{Error: "请求格式错误. " + err.Error()})
+			return
+		}
+		if len(req.Cluster) == 0 || len(req.Master) == 0 {
+			writeJSON(w, http.StatusBadRequest, &SwitchoverResponse{Cluster: req.Cluster, Error: "需要指定 cluster 和 master"})
+			return
+		}
+
+		seelog.Infof("开始切换集群 %s, master: %s, slaves: %v", req.Cluster, req.Master, req.Slaves)
+		start := time.Now()
+		t, err := m.Switchover(req.Cluster, req.Master, req.Slaves, req.SwitchoverConfig)
+		resp := &SwitchoverResponse{Cluster: req.Cluster, ElapsedMS: int64(time.Since(start) / time.Millisecond)}
+		if err != nil {
+			seelog.Errorf("切换集群 %s 失败. %s", req.Cluster, err.Error())
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, resp)
+			return
+		}
+		resp.Topology = t.String()
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// 注册高可用的接口, monitors 的 key 是集群名
+func RegisterHAHandlers(mux *http.ServeMux, monitors map[string]*ha.Monitor) {
+	mux.HandleFunc("/cluster/planned_switchover", plannedSwitchoverHandler(monitors))
+}
+
+func plannedSwitchoverHandler(monitors map[string]*ha.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := new(PlannedSwitchoverRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeJSON(w, http.StatusBadRequest, &SwitchoverResponse{Error: "请求格式错误. " + err.Error()})
+			return
+		}
+		m, ok := monitors[req.Cluster]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, &SwitchoverResponse{Cluster: req.Cluster, Error: "集群不存在或者没有开启高可用"})
+			return
+		}
+
+		seelog.Infof("开始计划内切换集群 %s, 新 master: %s", req.Cluster, req.Master)
+		start := time.Now()
+		t, err := m.Switchover(req.Master)
+		resp := &SwitchoverResponse{Cluster: req.Cluster, ElapsedMS: int64(time.Since(start) / time.Millisecond)}
+		if err != nil {
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, resp)
+			return
+		}
+		resp.Topology = t.String()
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		seelog.Warnf("返回结果出错. %s", err.Error())
+	}
+}